@@ -43,3 +43,28 @@ def chat_completion(api_keys:dict, system_prompt, model, messages, temperature,
         llm_response = json.dumps(llm_response)
 
     return llm_response
+
+def chat_completion_stream(api_keys:dict, system_prompt, model, messages, temperature, timeout, max_tokens, response_format, tools):
+    """
+    Yields ("delta", text) tuples while the model produces the response,
+    followed by a single ("response", llm_response) tuple with the complete response.
+    """
+    if response_format is not None and response_format != {}:
+        # structured outputs are validated on the complete response, so they are not streamed
+        yield "response", chat_completion(api_keys, system_prompt, model, messages, temperature, timeout, max_tokens, response_format, tools)
+        return
+
+    client = get_client(api_keys["anthropic"])
+    with client.messages.stream(
+        model=model,
+        system=system_prompt if system_prompt else NOT_GIVEN,
+        messages=messages,
+        temperature=temperature,
+        timeout=timeout,
+        max_tokens=max_tokens,
+        tools=tools if tools else NOT_GIVEN
+    ) as stream:
+        for text in stream.text_stream:
+            yield "delta", text
+        response = stream.get_final_message()
+    yield "response", response.model_dump_json()
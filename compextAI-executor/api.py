@@ -1,10 +1,11 @@
 import fastapi
 import uvicorn
 import os
-from fastapi.responses import JSONResponse
+from fastapi.responses import JSONResponse, StreamingResponse
 from pydantic import BaseModel
 import openai_models as openai
 import anthropic_models as anthropic
+import gemini_models as gemini
 import json
 import litellm_base as litellm
 app = fastapi.FastAPI()
@@ -47,6 +48,31 @@ def chat_completion_anthropic(request: ChatCompletionRequest):
         print(e)
         return JSONResponse(status_code=500, content={"error": str(e)})
     
+class GeminiChatCompletionRequest(BaseModel):
+    """
+    Request body for the gemini chat completion endpoint, the contents, tools
+    and generation config are in the gemini format.
+    """
+    api_keys: dict
+    model: str
+    contents: list[dict]
+    system_instruction: dict = None
+    tools: list[dict] = None
+    generation_config: dict = None
+    timeout: int = 600
+
+@app.post("/chatcompletion/gemini")
+def chat_completion_gemini(request: GeminiChatCompletionRequest):
+    try:
+        response = gemini.chat_completion(request.api_keys, request.model, request.contents, request.system_instruction, request.tools, request.generation_config, request.timeout)
+        return JSONResponse(status_code=200, content=json.loads(response))
+    except gemini.GeminiError as e:
+        print(e)
+        return JSONResponse(status_code=e.status_code, content={"error": str(e)})
+    except Exception as e:
+        print(e)
+        return JSONResponse(status_code=500, content={"error": str(e)})
+
 @app.post("/chatcompletion/litellm")
 def chat_completion_litellm(request: ChatCompletionRequest):
     try:
@@ -56,6 +82,39 @@ def chat_completion_litellm(request: ChatCompletionRequest):
         print(e)
         return JSONResponse(status_code=500, content={"error": str(e)})
 
+def stream_events(chunks):
+    """
+    Converts the (type, data) tuples produced by the chat_completion_stream functions
+    into server sent events, which are consumed by the compext server.
+    """
+    try:
+        for event_type, data in chunks:
+            if event_type == "delta":
+                event = {"type": "delta", "content": data}
+            else:
+                event = {"type": "response", "response": json.loads(data)}
+            yield f"data: {json.dumps(event)}\n\n"
+    except Exception as e:
+        print(e)
+        status_code = getattr(e, "status_code", 500)
+        yield f"data: {json.dumps({'type': 'error', 'status_code': status_code, 'error': str(e)})}\n\n"
+
+@app.post("/chatcompletion/openai/stream")
+def chat_completion_openai_stream(request: ChatCompletionRequest):
+    return StreamingResponse(stream_events(openai.chat_completion_stream(request.api_keys, request.model, request.messages, request.temperature, request.timeout, request.max_completion_tokens, request.response_format, request.tools)), media_type="text/event-stream")
+
+@app.post("/chatcompletion/anthropic/stream")
+def chat_completion_anthropic_stream(request: ChatCompletionRequest):
+    return StreamingResponse(stream_events(anthropic.chat_completion_stream(request.api_keys, request.system_prompt, request.model, request.messages, request.temperature, request.timeout, request.max_tokens, request.response_format, request.tools)), media_type="text/event-stream")
+
+@app.post("/chatcompletion/gemini/stream")
+def chat_completion_gemini_stream(request: GeminiChatCompletionRequest):
+    return StreamingResponse(stream_events(gemini.chat_completion_stream(request.api_keys, request.model, request.contents, request.system_instruction, request.tools, request.generation_config, request.timeout)), media_type="text/event-stream")
+
+@app.post("/chatcompletion/litellm/stream")
+def chat_completion_litellm_stream(request: ChatCompletionRequest):
+    return StreamingResponse(stream_events(litellm.chat_completion_stream(request.api_keys, request.model, request.messages, request.temperature, request.timeout, request.max_completion_tokens, request.response_format, request.tools)), media_type="text/event-stream")
+
 if __name__ == "__main__":
     port = 8889
     if os.getenv("SERVER_PORT"):
@@ -0,0 +1,101 @@
+import json
+import os
+import requests
+import google.auth.transport.requests
+from google.oauth2 import service_account
+
+# region of the vertex ai endpoint the gemini models are called on
+GEMINI_LOCATION = os.getenv("GEMINI_LOCATION", "us-central1")
+SCOPES = ["https://www.googleapis.com/auth/cloud-platform"]
+
+class GeminiError(Exception):
+    """
+    Error returned by vertex ai, the status code is forwarded to the compext server
+    so it can retry the rate limited and unavailable calls.
+    """
+    def __init__(self, status_code, message):
+        super().__init__(message)
+        self.status_code = status_code
+
+def get_credentials(api_keys:dict):
+    creds = api_keys.get("google_service_account_creds")
+    if not creds:
+        raise GeminiError(400, "google service account credentials are required")
+    if isinstance(creds, str):
+        creds = json.loads(creds)
+    credentials = service_account.Credentials.from_service_account_info(creds, scopes=SCOPES)
+    credentials.refresh(google.auth.transport.requests.Request())
+    return credentials, creds["project_id"]
+
+def get_model_url(project_id, model, method):
+    return f"https://{GEMINI_LOCATION}-aiplatform.googleapis.com/v1/projects/{project_id}/locations/{GEMINI_LOCATION}/publishers/google/models/{model}:{method}"
+
+def get_request_body(contents, system_instruction, tools, generation_config):
+    """
+    The contents, tools and generation config are sent by the compext server in the gemini format.
+    """
+    body = {"contents": contents}
+    if system_instruction:
+        body["systemInstruction"] = system_instruction
+    if tools:
+        body["tools"] = tools
+    if generation_config:
+        body["generationConfig"] = generation_config
+    return body
+
+def post(api_keys:dict, model, method, body, timeout, stream=False):
+    credentials, project_id = get_credentials(api_keys)
+    response = requests.post(
+        get_model_url(project_id, model, method),
+        headers={"Authorization": f"Bearer {credentials.token}"},
+        json=body,
+        timeout=timeout,
+        stream=stream
+    )
+    if response.status_code != 200:
+        raise GeminiError(response.status_code, response.text)
+    return response
+
+def chat_completion(api_keys:dict, model, contents, system_instruction, tools, generation_config, timeout):
+    body = get_request_body(contents, system_instruction, tools, generation_config)
+    response = post(api_keys, model, "generateContent", body, timeout)
+    return response.text
+
+def chat_completion_stream(api_keys:dict, model, contents, system_instruction, tools, generation_config, timeout):
+    """
+    Yields ("delta", text) tuples while the model produces the response,
+    followed by a single ("response", llm_response) tuple with the complete response.
+    """
+    body = get_request_body(contents, system_instruction, tools, generation_config)
+    response = post(api_keys, model, "streamGenerateContent?alt=sse", body, timeout, stream=True)
+
+    # the chunks are merged into a single generateContent response
+    parts = []
+    complete_response = {}
+    candidate = {}
+    for line in response.iter_lines(decode_unicode=True):
+        if not line or not line.startswith("data:"):
+            continue
+        chunk = json.loads(line[len("data:"):].strip())
+        for key in ["responseId", "modelVersion", "usageMetadata", "promptFeedback"]:
+            if key in chunk:
+                complete_response[key] = chunk[key]
+        candidates = chunk.get("candidates") or []
+        if not candidates:
+            continue
+        for key, value in candidates[0].items():
+            if key != "content":
+                candidate[key] = value
+        for part in candidates[0].get("content", {}).get("parts", []):
+            if "text" in part and not part.get("thought"):
+                yield "delta", part["text"]
+            # consecutive text chunks are joined, the other parts are kept as they are
+            if "text" in part and parts and "text" in parts[-1] and parts[-1].get("thought") == part.get("thought"):
+                parts[-1]["text"] += part["text"]
+            else:
+                parts.append(part)
+
+    if candidate or parts:
+        candidate["content"] = {"role": "model", "parts": parts}
+        complete_response["candidates"] = [candidate]
+    yield "response", json.dumps(complete_response)
@@ -126,9 +126,7 @@ router = Router(
     cooldown_time=3600
 )
 
-def chat_completion(api_keys:dict, model_name:str, messages:list, temperature:float, timeout:int, max_completion_tokens:int, response_format:dict, tools:list[dict]):
-    router.set_model_list(get_model_list(api_keys))
-
+def trim_messages(model_name:str, messages:list):
     model_info = get_model_info_from_model_name(model_name)
     max_allowed_input_tokens = model_info["max_input_tokens"]
 
@@ -145,6 +143,12 @@ def chat_completion(api_keys:dict, model_name:str, messages:list, temperature:fl
                 messages = messages[user_msg_indices[1]:]
         else:
             break
+    return messages
+
+def chat_completion(api_keys:dict, model_name:str, messages:list, temperature:float, timeout:int, max_completion_tokens:int, response_format:dict, tools:list[dict]):
+    router.set_model_list(get_model_list(api_keys))
+
+    messages = trim_messages(model_name, messages)
 
     response = router.completion(
         model=model_name,
@@ -157,3 +161,34 @@ def chat_completion(api_keys:dict, model_name:str, messages:list, temperature:fl
     )
 
     return response.model_dump_json()
+
+def chat_completion_stream(api_keys:dict, model_name:str, messages:list, temperature:float, timeout:int, max_completion_tokens:int, response_format:dict, tools:list[dict]):
+    """
+    Yields ("delta", text) tuples while the model produces the response,
+    followed by a single ("response", llm_response) tuple with the complete response.
+    """
+    router.set_model_list(get_model_list(api_keys))
+
+    messages = trim_messages(model_name, messages)
+
+    response = router.completion(
+        model=model_name,
+        messages=messages,
+        temperature=temperature,
+        timeout=timeout,
+        max_completion_tokens=max_completion_tokens if max_completion_tokens else None,
+        response_format=response_format if response_format else None,
+        tools=tools if tools else None,
+        stream=True,
+        stream_options={"include_usage": True}
+    )
+
+    chunks = []
+    for chunk in response:
+        chunks.append(chunk)
+        delta = chunk.choices[0].delta.content if chunk.choices else None
+        if delta:
+            yield "delta", delta
+
+    complete_response = litellm.stream_chunk_builder(chunks, messages=messages)
+    yield "response", complete_response.model_dump_json()
@@ -45,3 +45,29 @@ def chat_completion(api_keys:dict, model:str, messages:list, temperature:float,
         llm_response["choices"][0]["message"]["content"] = answer.model_dump_json()
         llm_response = json.dumps(llm_response)
     return llm_response
+
+def chat_completion_stream(api_keys:dict, model:str, messages:list, temperature:float, timeout:int, max_completion_tokens:int, response_format:dict, tools:list[dict]):
+    """
+    Yields ("delta", text) tuples while the model produces the response,
+    followed by a single ("response", llm_response) tuple with the complete response.
+    """
+    if response_format is not None and response_format != {}:
+        # structured outputs are validated on the complete response, so they are not streamed
+        yield "response", chat_completion(api_keys, model, messages, temperature, timeout, max_completion_tokens, response_format, tools)
+        return
+
+    client = get_client(api_keys["openai"])
+    with client.beta.chat.completions.stream(
+        model=model,
+        messages=messages,
+        temperature=temperature,
+        timeout=timeout,
+        max_completion_tokens=max_completion_tokens,
+        tools=tools if tools else NOT_GIVEN,
+        stream_options={"include_usage": True}
+    ) as stream:
+        for event in stream:
+            if event.type == "content.delta":
+                yield "delta", event.delta
+        response = stream.get_final_completion()
+    yield "response", response.model_dump_json()
@@ -1,10 +1,33 @@
 package constants
 
 const (
-	THREAD_ID_PREFIX                           = "compext_thread_"
-	MESSAGE_ID_PREFIX                          = "compext_message_"
-	THREAD_EXECUTION_ID_PREFIX                 = "compext_thread_execution_"
-	THREAD_EXECUTION_PARAMS_ID_PREFIX          = "compext_thread_execution_params_"
-	THREAD_EXECUTION_PARAMS_TEMPLATE_ID_PREFIX = "compext_thread_execution_params_template_"
-	PROJECT_ID_PREFIX                          = "compext_project_"
+	THREAD_ID_PREFIX                                    = "compext_thread_"
+	MESSAGE_ID_PREFIX                                   = "compext_message_"
+	THREAD_EXECUTION_ID_PREFIX                          = "compext_thread_execution_"
+	THREAD_EXECUTION_PARAMS_ID_PREFIX                   = "compext_thread_execution_params_"
+	THREAD_EXECUTION_PARAMS_TEMPLATE_ID_PREFIX          = "compext_thread_execution_params_template_"
+	THREAD_EXECUTION_PARAMS_TEMPLATE_REVISION_ID_PREFIX = "compext_thread_execution_params_template_revision_"
+	PROJECT_ID_PREFIX                                   = "compext_project_"
+	PROJECT_BUDGET_ID_PREFIX                            = "compext_project_budget_"
+	BUDGET_EVENT_ID_PREFIX                              = "compext_budget_event_"
+	WEBHOOK_ID_PREFIX                                   = "compext_webhook_"
+	WEBHOOK_DELIVERY_ID_PREFIX                          = "compext_webhook_delivery_"
+	WEBHOOK_SECRET_PREFIX                               = "whsec_"
+	API_TOKEN_ID_PREFIX                                 = "compext_api_token_"
+	PROVIDER_CREDENTIAL_ID_PREFIX                       = "compext_provider_credential_"
+	ORGANIZATION_ID_PREFIX                              = "compext_organization_"
+	ORGANIZATION_MEMBER_ID_PREFIX                       = "compext_organization_member_"
+	PROJECT_MEMBER_ID_PREFIX                            = "compext_project_member_"
+	INVITATION_ID_PREFIX                                = "compext_invitation_"
+	AUDIT_EVENT_ID_PREFIX                               = "compext_audit_event_"
+	PROMOTION_ID_PREFIX                                 = "compext_promotion_"
+	PROMOTION_RULE_ID_PREFIX                            = "compext_promotion_rule_"
+	PROJECT_TOOL_ID_PREFIX                              = "compext_project_tool_"
+	RESPONSE_CACHE_ENTRY_ID_PREFIX                      = "compext_response_cache_entry_"
+	BATCH_ID_PREFIX                                     = "compext_batch_"
+	DATASET_ID_PREFIX                                   = "compext_dataset_"
+	DATASET_ITEM_ID_PREFIX                              = "compext_dataset_item_"
+	EVAL_RUN_ID_PREFIX                                  = "compext_eval_run_"
+	// api tokens are formatted as <API_TOKEN_KEY_PREFIX><prefix>_<secret>
+	API_TOKEN_KEY_PREFIX = "cpx_"
 )
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// attempts at generating an api token with a prefix which is not in use
+	MAX_API_TOKEN_ATTEMPTS = 3
+
+	LOGIN_API_TOKEN_NAME        = "login"
+	LOGIN_API_TOKEN_EXPIRY_DAYS = 30
+	// name of the api tokens migrated from the plain text tokens of the users
+	LEGACY_API_TOKEN_NAME = "account token"
+)
+
+// CreateAPIToken creates an api token for the user. The token is only returned here,
+// the database only keeps its prefix and a hash of it.
+func CreateAPIToken(db *gorm.DB, req *CreateAPITokenRequest) (*models.APIToken, string, error) {
+	for attempt := 1; ; attempt++ {
+		token, prefix, err := utils.GenerateAPIToken()
+		if err != nil {
+			logger.GetLogger().Errorf("Error generating api token: %v", err)
+			return nil, "", err
+		}
+
+		apiToken := &models.APIToken{
+			UserID:       req.UserID,
+			Name:         req.Name,
+			Prefix:       prefix,
+			HashedSecret: utils.HashAPIToken(token),
+			Scopes:       req.Scopes,
+			ProjectID:    req.ProjectID,
+			Login:        req.Login,
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			apiToken.ExpiresAt = &expiresAt
+		}
+
+		// created in a nested transaction, so a failed insert can be retried in the transaction of the caller
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return models.CreateAPIToken(tx, apiToken)
+		})
+		if err != nil {
+			// the prefixes are random, a new token is generated when the prefix is taken
+			if _, prefixErr := models.GetAPITokenByPrefix(db, prefix); prefixErr == nil && attempt < MAX_API_TOKEN_ATTEMPTS {
+				logger.GetLogger().Warnf("API token prefix already in use, generating a new token")
+				continue
+			}
+			logger.GetLogger().Errorf("Error creating api token: %v", err)
+			return nil, "", err
+		}
+
+		return apiToken, token, nil
+	}
+}
+
+// createLoginAPIToken issues the admin token returned when the user signs up, logs in or changes its password
+func createLoginAPIToken(db *gorm.DB, userID uint) (string, error) {
+	_, token, err := CreateAPIToken(db, &CreateAPITokenRequest{
+		UserID:        userID,
+		Name:          LOGIN_API_TOKEN_NAME,
+		Scopes:        []string{models.APITokenScope_ADMIN},
+		ExpiresInDays: LOGIN_API_TOKEN_EXPIRY_DAYS,
+		Login:         true,
+	})
+	return token, err
+}
+
+// MigrateLegacyAPITokens moves the plain text tokens of the users created before the api tokens
+// to the api tokens, as hashed admin tokens. The users keep using them until the password changes.
+func MigrateLegacyAPITokens(db *gorm.DB) error {
+	users, err := models.GetUsersWithLegacyAPIToken(db)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		token := *user.LegacyAPIToken
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := models.CreateAPIToken(tx, &models.APIToken{
+				UserID:       user.ID,
+				Name:         LEGACY_API_TOKEN_NAME,
+				Prefix:       utils.LegacyAPITokenPrefix(token),
+				HashedSecret: utils.HashAPIToken(token),
+				Scopes:       models.StringList{models.APITokenScope_ADMIN},
+				Login:        true,
+			}); err != nil {
+				return err
+			}
+			return models.ClearUserLegacyAPIToken(tx, user.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(users) > 0 {
+		logger.GetLogger().Infof("Migrated the api tokens of %d users", len(users))
+	}
+	return nil
+}
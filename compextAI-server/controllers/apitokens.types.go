@@ -0,0 +1,12 @@
+package controllers
+
+type CreateAPITokenRequest struct {
+	UserID    uint
+	Name      string
+	Scopes    []string
+	ProjectID string
+	// the token never expires if 0
+	ExpiresInDays int
+	// issued by a login, see models.APIToken
+	Login bool
+}
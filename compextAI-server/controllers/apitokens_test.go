@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/burnerlee/compextAI/internal/testdb"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+)
+
+func TestMigrateLegacyAPITokens(t *testing.T) {
+	db := testdb.Open(t, &models.User{}, &models.APIToken{})
+
+	legacyToken := "legacy-user-token"
+	user := &models.User{Username: "legacy", Email: "legacy@example.com", Password: "hash", LegacyAPIToken: &legacyToken}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatalf("error creating the user: %v", err)
+	}
+	if err := models.CreateUser(db, &models.User{Username: "migrated", Email: "migrated@example.com", Password: "hash"}); err != nil {
+		t.Fatalf("error creating the user: %v", err)
+	}
+
+	// running the migration again must not create the token twice
+	for i := 0; i < 2; i++ {
+		if err := MigrateLegacyAPITokens(db); err != nil {
+			t.Fatalf("error migrating the tokens, run %d: %v", i+1, err)
+		}
+	}
+
+	apiTokens, err := models.GetUserAPITokens(db, user.ID)
+	if err != nil {
+		t.Fatalf("error getting the tokens: %v", err)
+	}
+	if len(apiTokens) != 1 {
+		t.Fatalf("expected a single migrated token, got %d", len(apiTokens))
+	}
+	apiToken := apiTokens[0]
+	if apiToken.Prefix != utils.LegacyAPITokenPrefix(legacyToken) || apiToken.HashedSecret != utils.HashAPIToken(legacyToken) {
+		t.Fatalf("expected the token to be stored hashed under its legacy prefix, got %+v", apiToken)
+	}
+	if !apiToken.HasScope(models.APITokenScope_ADMIN) || !apiToken.Login || !apiToken.IsActive() {
+		t.Fatalf("expected an active admin login token, got %+v", apiToken)
+	}
+
+	migrated, err := models.GetUserByID(db, user.ID)
+	if err != nil {
+		t.Fatalf("error getting the user: %v", err)
+	}
+	if migrated.LegacyAPIToken != nil {
+		t.Fatalf("expected the plain text token to be cleared, got %s", *migrated.LegacyAPIToken)
+	}
+
+	var count int64
+	if err := db.Model(&models.APIToken{}).Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("expected no token for the users without a legacy token, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/internal/batch"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// number of executions of a batch queued or running at the same time when the batch does not set it
+	DEFAULT_BATCH_CONCURRENCY = 10
+	MAX_BATCH_CONCURRENCY     = 100
+	MAX_BATCH_ITEMS           = 10000
+)
+
+var (
+	// ErrBatchFinished is returned when cancelling a batch which is already completed or cancelled
+	ErrBatchFinished = errors.New("batch is already finished")
+)
+
+// CreateBatch creates a batch of the threads or message sets of the request, its items
+// are submitted in the background by the batch scheduler, see ScheduleBatch
+func CreateBatch(db *gorm.DB, req *CreateBatchRequest) (*models.Batch, error) {
+	batchModel, items, err := newBatch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	batchModel, err = models.CreateBatch(db, batchModel, items)
+	if err != nil {
+		logger.GetLogger().Errorf("Error creating batch: %v", err)
+		return nil, err
+	}
+
+	logger.GetLogger().Infof("Batch created: %s: %d items", batchModel.Identifier, batchModel.Total)
+	batch.Notify()
+
+	return batchModel, nil
+}
+
+func newBatch(req *CreateBatchRequest) (*models.Batch, []*models.BatchItem, error) {
+	optionsJson, err := json.Marshal(req.Options)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling batch options: %v", err)
+		return nil, nil, err
+	}
+
+	items := make([]*models.BatchItem, 0, len(req.ThreadIDs)+len(req.MessageSets))
+	for _, threadID := range req.ThreadIDs {
+		items = append(items, &models.BatchItem{
+			ThreadID: threadID,
+		})
+	}
+	for _, messages := range req.MessageSets {
+		jobMessages := make([]*jobMessage, 0, len(messages))
+		for _, message := range messages {
+			jobMessages = append(jobMessages, newJobMessage(message))
+		}
+		messagesJson, err := json.Marshal(jobMessages)
+		if err != nil {
+			logger.GetLogger().Errorf("Error marshalling batch messages: %v", err)
+			return nil, nil, err
+		}
+		items = append(items, &models.BatchItem{
+			Messages: messagesJson,
+		})
+	}
+
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_BATCH_CONCURRENCY
+	}
+
+	return &models.Batch{
+		UserID:                  req.UserID,
+		ProjectID:               req.ProjectID,
+		ThreadExecutionParamsID: req.ThreadExecutionParamsID,
+		TemplateID:              req.TemplateID,
+		EvalRunID:               req.EvalRunID,
+		Concurrency:             concurrency,
+		Options:                 optionsJson,
+	}, items, nil
+}
+
+func GetBatchStatus(db *gorm.DB, batchModel *models.Batch) (*BatchStatus, error) {
+	progress, err := models.GetBatchProgress(db, batchModel.Identifier)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting batch progress: %s: %v", batchModel.Identifier, err)
+		return nil, err
+	}
+	// the items of a cancelled batch are never submitted
+	if batchModel.Status == models.BatchStatus_CANCELLED {
+		progress.Cancelled += progress.Pending
+		progress.Pending = 0
+	}
+	return &BatchStatus{
+		Batch:    batchModel,
+		Progress: progress,
+	}, nil
+}
+
+// GetBatchResults returns up to limit results of the batch after the given index, in the order of the items
+func GetBatchResults(db *gorm.DB, batchModel *models.Batch, afterIndex, limit int) ([]*models.BatchResult, error) {
+	results, err := models.GetBatchResults(db, batchModel.Identifier, afterIndex, limit)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting batch results: %s: %v", batchModel.Identifier, err)
+		return nil, err
+	}
+	if batchModel.Status == models.BatchStatus_CANCELLED {
+		for _, result := range results {
+			if result.Status == models.BatchItemStatus_PENDING {
+				result.Status = models.BatchStatus_CANCELLED
+			}
+		}
+	}
+	return results, nil
+}
+
+// ScheduleBatch submits the pending items of the batch while less than its concurrency are queued
+// or running, and completes the batch once all of its items are finished. It is called by the batch
+// scheduler holding the lease of the batch.
+func ScheduleBatch(ctx context.Context, db *gorm.DB, batchModel *models.Batch) {
+	active, err := models.CountActiveBatchItems(db, batchModel.Identifier)
+	if err != nil {
+		logger.GetLogger().Errorf("Error counting active batch items: %s: %v", batchModel.Identifier, err)
+		return
+	}
+
+	if free := batchModel.Concurrency - int(active); free > 0 {
+		items, err := models.GetPendingBatchItems(db, batchModel.Identifier, free)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting pending batch items: %s: %v", batchModel.Identifier, err)
+			return
+		}
+		if len(items) > 0 {
+			submitBatchItems(ctx, db, batchModel, items)
+		}
+	}
+
+	current, err := models.GetBatch(db, batchModel.Identifier)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting batch: %s: %v", batchModel.Identifier, err)
+		return
+	}
+	if current.Status == models.BatchStatus_CANCELLED {
+		// the batch was cancelled while its items were submitted
+		cancelBatchExecutions(db, current.Identifier)
+		return
+	}
+
+	progress, err := models.GetBatchProgress(db, batchModel.Identifier)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting batch progress: %s: %v", batchModel.Identifier, err)
+		return
+	}
+	if progress.Finished() {
+		if _, err := models.FinishBatch(db, batchModel.Identifier, models.BatchStatus_COMPLETED); err != nil {
+			logger.GetLogger().Errorf("Error completing batch: %s: %v", batchModel.Identifier, err)
+			return
+		}
+		logger.GetLogger().Infof("Batch completed: %s: completed: %d, failed: %d", batchModel.Identifier, progress.Completed, progress.Failed)
+	}
+}
+
+// submitBatchItems creates the executions of the items, an item whose execution can not be
+// created is failed with the error rather than retried
+func submitBatchItems(ctx context.Context, db *gorm.DB, batchModel *models.Batch, items []*models.BatchItem) {
+	var options BatchOptions
+	threadExecutionParams, prepareErr := getBatchThreadExecutionParams(db, batchModel)
+	if prepareErr == nil {
+		prepareErr = json.Unmarshal(batchModel.Options, &options)
+	}
+	var projectTools []*models.ProjectTool
+	if prepareErr == nil && len(options.ProjectTools) > 0 {
+		projectTools, prepareErr = models.GetProjectToolsByName(db, batchModel.ProjectID, options.ProjectTools)
+	}
+	if prepareErr != nil {
+		logger.GetLogger().Errorf("Error preparing batch items: %s: %v", batchModel.Identifier, prepareErr)
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return
+		}
+		threadExecutionID, err := "", prepareErr
+		if err == nil {
+			threadExecutionID, err = submitBatchItem(db, batchModel, threadExecutionParams, &options, projectTools, item)
+		}
+		submissionErr := ""
+		if err != nil {
+			logger.GetLogger().Warnf("Error submitting batch item: %s: %d: %v", batchModel.Identifier, item.Index, err)
+			submissionErr = err.Error()
+		}
+		if err := models.UpdateBatchItemSubmission(db, item.ID, threadExecutionID, submissionErr); err != nil {
+			logger.GetLogger().Errorf("Error updating batch item: %s: %d: %v", batchModel.Identifier, item.Index, err)
+		}
+	}
+}
+
+// getBatchThreadExecutionParams returns the execution params the items of the batch are executed with,
+// the batches of a template are executed with the template alone
+func getBatchThreadExecutionParams(db *gorm.DB, batchModel *models.Batch) (*models.ThreadExecutionParams, error) {
+	if batchModel.TemplateID != "" {
+		return &models.ThreadExecutionParams{
+			ProjectID:  batchModel.ProjectID,
+			TemplateID: batchModel.TemplateID,
+		}, nil
+	}
+	return models.GetThreadExecutionParamsByID(db, batchModel.ThreadExecutionParamsID)
+}
+
+func submitBatchItem(db *gorm.DB, batchModel *models.Batch, threadExecutionParams *models.ThreadExecutionParams, options *BatchOptions, projectTools []*models.ProjectTool, item *models.BatchItem) (string, error) {
+	threadID := item.ThreadID
+	var messages []*models.Message
+	if threadID == "" {
+		threadID = constants.THREAD_IDENTIFIER_FOR_NULL_THREAD
+		var jobMessages []*jobMessage
+		if err := json.Unmarshal(item.Messages, &jobMessages); err != nil {
+			return "", fmt.Errorf("error unmarshalling messages: %v", err)
+		}
+		for _, message := range jobMessages {
+			messages = append(messages, message.toMessage())
+		}
+	}
+
+	templateID, variantName := threadExecutionParams.TemplateID, ""
+	if variant := SelectTemplateVariant(threadExecutionParams, threadID); variant != nil {
+		templateID, variantName = variant.TemplateID, variant.Name
+	}
+	// copied, ExecuteThread appends the project tools to them
+	tools := append([]*models.ExecutionTool{}, options.Tools...)
+
+	threadExecution, err := ExecuteThread(db, &ExecuteThreadRequest{
+		UserID:                         batchModel.UserID,
+		ThreadID:                       threadID,
+		ThreadExecutionParamTemplateID: templateID,
+		TemplateRevisionID:             PinnedTemplateRevisionID(threadExecutionParams, templateID),
+		FallbackTemplateIDs:            threadExecutionParams.FallbackTemplateIDs,
+		AppendAssistantResponse:        options.AppendAssistantResponse,
+		ThreadExecutionSystemPrompt:    options.SystemPrompt,
+		Messages:                       messages,
+		FetchMessagesFromThread:        true,
+		ProjectID:                      batchModel.ProjectID,
+		Environment:                    threadExecutionParams.Environment,
+		Metadata:                       options.Metadata,
+		Tools:                          tools,
+		Variables:                      options.Variables,
+		ThreadExecutionParamsID:        threadExecutionParams.Identifier,
+		Variant:                        variantName,
+		ProjectTools:                   projectTools,
+		MaxToolSteps:                   options.MaxToolSteps,
+		Cache:                          options.Cache,
+		CacheTTL:                       time.Duration(options.CacheTTL) * time.Second,
+		BatchID:                        batchModel.Identifier,
+	})
+	if err != nil {
+		return "", err
+	}
+	return threadExecution.(*models.ThreadExecution).Identifier, nil
+}
+
+// CancelBatch stops submitting the items of the batch and cancels its queued and running executions
+func CancelBatch(db *gorm.DB, batchID string) error {
+	cancelled, err := models.FinishBatch(db, batchID, models.BatchStatus_CANCELLED)
+	if err != nil {
+		logger.GetLogger().Errorf("Error cancelling batch: %s: %v", batchID, err)
+		return err
+	}
+	if !cancelled {
+		return ErrBatchFinished
+	}
+
+	logger.GetLogger().Infof("Batch cancelled: %s", batchID)
+	cancelBatchExecutions(db, batchID)
+	return nil
+}
+
+func cancelBatchExecutions(db *gorm.DB, batchID string) {
+	executionIDs, err := models.GetActiveBatchExecutionIDs(db, batchID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting active batch executions: %s: %v", batchID, err)
+		return
+	}
+	for _, executionID := range executionIDs {
+		// executions which finished meanwhile are left as they are
+		if err := CancelThreadExecution(db, executionID); err != nil && !errors.Is(err, ErrThreadExecutionFinished) {
+			logger.GetLogger().Errorf("Error cancelling batch execution: %s: %s: %v", batchID, executionID, err)
+		}
+	}
+}
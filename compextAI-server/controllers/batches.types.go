@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+// BatchOptions are the execution options shared by the items of a batch, they are stored on the batch
+type BatchOptions struct {
+	SystemPrompt            string                  `json:"system_prompt"`
+	AppendAssistantResponse bool                    `json:"append_assistant_response"`
+	Tools                   []*models.ExecutionTool `json:"tools"`
+	Metadata                json.RawMessage         `json:"metadata"`
+	Variables               map[string]interface{}  `json:"variables"`
+	// names of the project tools run by the server, resolved when the items are submitted
+	ProjectTools []string `json:"project_tools"`
+	MaxToolSteps int      `json:"max_tool_steps"`
+	Cache        bool     `json:"cache"`
+	// in seconds
+	CacheTTL int `json:"cache_ttl"`
+}
+
+type CreateBatchRequest struct {
+	UserID                  uint
+	ProjectID               string
+	ThreadExecutionParamsID string
+	// executes the items with the template instead of the execution params
+	TemplateID string
+	EvalRunID  string
+	// a batch executes either threads or inline message sets
+	ThreadIDs   []string
+	MessageSets [][]*models.Message
+	Concurrency int
+	Options     *BatchOptions
+}
+
+// BatchStatus is a batch with the progress of its items
+type BatchStatus struct {
+	*models.Batch
+	Progress *models.BatchProgress `json:"progress"`
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrBudgetExceeded is returned when an execution is refused because a budget reached its hard cap
+	ErrBudgetExceeded = errors.New("budget exceeded")
+)
+
+// CheckProjectBudgets evaluates the budgets which apply to an execution of the project in the environment.
+// Threshold events are emitted once per period, and ErrBudgetExceeded is returned if a hard cap is reached.
+func CheckProjectBudgets(db *gorm.DB, projectID, environment string) error {
+	budgets, err := GetProjectBudgetStatuses(db, projectID, environment)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range budgets {
+		budget := &status.ProjectBudget
+		if status.HardCapReached {
+			emitBudgetEvent(db, budget, models.BudgetEventType_HARD_CAP_REACHED, status)
+			scope := "project"
+			if budget.Environment != "" {
+				scope = fmt.Sprintf("environment %s", budget.Environment)
+			}
+			return fmt.Errorf("%w: the %s budget of the %s is capped at %v for %s, current usage is %v",
+				ErrBudgetExceeded, budget.Type, scope, budget.MonthlyLimit*budget.HardThreshold, status.Period, status.Usage)
+		}
+		if status.SoftCapReached {
+			emitBudgetEvent(db, budget, models.BudgetEventType_SOFT_CAP_REACHED, status)
+		}
+	}
+	return nil
+}
+
+// GetProjectBudgetStatuses returns the budgets of the project with their usage in the current period.
+// If environment is not empty, only the budgets which apply to the environment are returned.
+func GetProjectBudgetStatuses(db *gorm.DB, projectID, environment string) ([]*ProjectBudgetStatus, error) {
+	var budgets []models.ProjectBudget
+	var err error
+	if environment != "" {
+		budgets, err = models.GetApplicableProjectBudgets(db, projectID, environment)
+	} else {
+		budgets, err = models.GetProjectBudgets(db, projectID)
+	}
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting project budgets: %s: %v", projectID, err)
+		return nil, err
+	}
+
+	period, periodStart := models.GetBudgetPeriod(time.Now())
+
+	statuses := make([]*ProjectBudgetStatus, 0, len(budgets))
+	for _, budget := range budgets {
+		usage, err := models.GetBudgetUsage(db, &budget, periodStart)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting budget usage: %s: %v", budget.Identifier, err)
+			return nil, err
+		}
+		statuses = append(statuses, &ProjectBudgetStatus{
+			ProjectBudget:  budget,
+			Period:         period,
+			Usage:          usage,
+			SoftCapReached: usage >= budget.MonthlyLimit*budget.SoftThreshold,
+			HardCapReached: usage >= budget.MonthlyLimit*budget.HardThreshold,
+		})
+	}
+	return statuses, nil
+}
+
+func emitBudgetEvent(db *gorm.DB, budget *models.ProjectBudget, eventType string, status *ProjectBudgetStatus) {
+	emit, err := models.MarkProjectBudgetNotified(db, budget.Identifier, eventType, status.Period)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marking project budget notified: %s: %v", budget.Identifier, err)
+		return
+	}
+	if !emit {
+		return
+	}
+
+	logger.GetLogger().Warnf("Project budget %s: %s: project: %s, environment: %s, usage: %v, limit: %v",
+		eventType, budget.Identifier, budget.ProjectID, budget.Environment, status.Usage, budget.MonthlyLimit)
+
+	if err := models.CreateBudgetEvent(db, &models.BudgetEvent{
+		ProjectID:   budget.ProjectID,
+		BudgetID:    budget.Identifier,
+		Environment: budget.Environment,
+		Type:        eventType,
+		Period:      status.Period,
+		Usage:       status.Usage,
+		Limit:       budget.MonthlyLimit,
+	}); err != nil {
+		logger.GetLogger().Errorf("Error creating budget event: %s: %v", budget.Identifier, err)
+	}
+}
@@ -0,0 +1,11 @@
+package controllers
+
+import "github.com/burnerlee/compextAI/models"
+
+type ProjectBudgetStatus struct {
+	models.ProjectBudget
+	Period         string  `json:"period"`
+	Usage          float64 `json:"usage"`
+	SoftCapReached bool    `json:"soft_cap_reached"`
+	HardCapReached bool    `json:"hard_cap_reached"`
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/batch"
+	"github.com/burnerlee/compextAI/internal/cache"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// longest time a response can be cached for
+	MAX_RESPONSE_CACHE_TTL = 30 * 24 * time.Hour
+
+	// bumped when the request hashed into the keys changes, so the old entries are not used
+	responseCacheKeyVersion = 1
+)
+
+// responseCacheRequest is everything sent to the provider which changes its response,
+// the cache key of an execution is its hash
+type responseCacheRequest struct {
+	Version             int                     `json:"version"`
+	Model               string                  `json:"model"`
+	UseLiteLLM          bool                    `json:"use_litellm"`
+	Temperature         float64                 `json:"temperature"`
+	TopP                float64                 `json:"top_p"`
+	MaxTokens           int                     `json:"max_tokens"`
+	MaxCompletionTokens int                     `json:"max_completion_tokens"`
+	MaxOutputTokens     int                     `json:"max_output_tokens"`
+	ResponseFormat      json.RawMessage         `json:"response_format"`
+	SystemPrompt        string                  `json:"system_prompt"`
+	Messages            []*responseCacheMessage `json:"messages"`
+	Tools               json.RawMessage         `json:"tools"`
+}
+
+type responseCacheMessage struct {
+	Role         string          `json:"role"`
+	ContentMap   json.RawMessage `json:"content_map"`
+	ToolCallID   string          `json:"tool_call_id"`
+	ToolCalls    json.RawMessage `json:"tool_calls"`
+	FunctionCall json.RawMessage `json:"function_call"`
+}
+
+// responseCacheKey hashes the request the template makes with the rendered system prompt and messages
+func responseCacheKey(template *models.ThreadExecutionParamsTemplate, systemPrompt string, messages []*jobMessage, toolsJson json.RawMessage) (string, error) {
+	request := &responseCacheRequest{
+		Version:             responseCacheKeyVersion,
+		Model:               template.Model,
+		UseLiteLLM:          template.UseLiteLLM,
+		Temperature:         template.Temperature,
+		TopP:                template.TopP,
+		MaxTokens:           template.MaxTokens,
+		MaxCompletionTokens: template.MaxCompletionTokens,
+		MaxOutputTokens:     template.MaxOutputTokens,
+		ResponseFormat:      template.ResponseFormat,
+		SystemPrompt:        systemPrompt,
+		Messages:            make([]*responseCacheMessage, 0, len(messages)),
+		Tools:               toolsJson,
+	}
+	// the metadata of the messages is not sent to the provider
+	for _, message := range messages {
+		request.Messages = append(request.Messages, &responseCacheMessage{
+			Role:         message.Role,
+			ContentMap:   message.ContentMap,
+			ToolCallID:   message.ToolCallID,
+			ToolCalls:    message.ToolCalls,
+			FunctionCall: message.FunctionCall,
+		})
+	}
+
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(requestJson)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// getCachedResponse returns the cached response for the key, errors of the cache are treated as misses
+func getCachedResponse(projectID, key string) *cache.Entry {
+	entry, err := cache.Get(context.Background(), projectID, key)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting cached response: %s: %v", projectID, err)
+		return nil
+	}
+	return entry
+}
+
+// completeThreadExecutionFromCache completes an execution created with a cache hit, as if the
+// template had returned the cached response. No tokens are used, so no usage is recorded.
+func completeThreadExecutionFromCache(db *gorm.DB, threadExecution *models.ThreadExecution, template *models.ThreadExecutionParamsTemplate, systemPrompt string, entry *cache.Entry, appendAssistantResponse bool) {
+	defer enqueueThreadExecutionWebhooks(db, threadExecution.Identifier)
+	if threadExecution.BatchID != "" {
+		defer batch.Notify()
+	}
+	// the execution is created with a lease held by the request, see ExecuteThread
+	defer func() {
+		if err := models.ReleaseThreadExecutionLease(db, threadExecution.Identifier, threadExecution.LeaseOwner); err != nil {
+			logger.GetLogger().Errorf("Error releasing thread execution lease: %s: %v", threadExecution.Identifier, err)
+		}
+	}()
+
+	chatProvider, err := getChatProvider(template)
+	if err != nil {
+		handleThreadExecutionError(db, threadExecution, err)
+		return
+	}
+	var response interface{}
+	if err := json.Unmarshal(entry.Response, &response); err != nil {
+		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error unmarshalling cached response: %v", err))
+		return
+	}
+	result := &templateExecution{
+		template:     template,
+		chatProvider: chatProvider,
+		response:     response,
+		systemPrompt: systemPrompt,
+		cacheHit:     true,
+	}
+	result.structuredOutput, _ = getStructuredOutput(template.ResponseFormat)
+
+	handleThreadExecutionSuccess(db, threadExecution, result, appendAssistantResponse)
+}
+
+// cacheThreadExecutionResponse caches the response of a completed execution for the executions
+// of its project made with the same request
+func cacheThreadExecutionResponse(db *gorm.DB, threadExecutionID, key string, ttl time.Duration, result *templateExecution) {
+	// the response may have been rejected, e.g. for not matching the response format
+	threadExecution, err := models.GetThreadExecutionByID(db, threadExecutionID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting thread execution: %s: %v", threadExecutionID, err)
+		return
+	}
+	if threadExecution.Status != models.ThreadExecutionStatus_COMPLETED {
+		return
+	}
+
+	responseJson, err := json.Marshal(result.response)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling response to cache: %s: %v", threadExecutionID, err)
+		return
+	}
+	if err := cache.Put(context.Background(), &cache.Entry{
+		ProjectID:         threadExecution.ProjectID,
+		Key:               key,
+		Model:             result.template.Model,
+		Response:          responseJson,
+		ThreadExecutionID: threadExecutionID,
+		ExpiresAt:         time.Now().Add(ttl),
+	}); err != nil {
+		logger.GetLogger().Errorf("Error caching response: %s: %v", threadExecutionID, err)
+	}
+}
+
+// InvalidateProjectResponseCache removes the cached responses of the project
+func InvalidateProjectResponseCache(ctx context.Context, projectID string) error {
+	return cache.Invalidate(ctx, projectID)
+}
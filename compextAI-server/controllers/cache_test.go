@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+// cacheKeyRequest holds the arguments of responseCacheKey
+type cacheKeyRequest struct {
+	template     *models.ThreadExecutionParamsTemplate
+	systemPrompt string
+	messages     []*jobMessage
+	tools        json.RawMessage
+}
+
+func newCacheKeyRequest() *cacheKeyRequest {
+	return &cacheKeyRequest{
+		template: &models.ThreadExecutionParamsTemplate{
+			Model:          "gpt-4o",
+			Temperature:    0.5,
+			MaxTokens:      100,
+			ResponseFormat: json.RawMessage(`{"type":"json_object"}`),
+		},
+		systemPrompt: "You are a weather assistant.",
+		messages: []*jobMessage{
+			{Role: "user", ContentMap: json.RawMessage(`{"content":"What is the weather in Paris?"}`)},
+			{Role: "assistant", ContentMap: json.RawMessage(`{"content":"Sunny."}`), Metadata: json.RawMessage(`{"usage":{"prompt_tokens":10}}`)},
+		},
+		tools: json.RawMessage(`[{"name":"get_weather"}]`),
+	}
+}
+
+func (r *cacheKeyRequest) key(t *testing.T) string {
+	t.Helper()
+	key, err := responseCacheKey(r.template, r.systemPrompt, r.messages, r.tools)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	key := newCacheKeyRequest().key(t)
+	// the keys of the cached entries must not change, bump responseCacheKeyVersion when the hashed request changes
+	if want := "9bad409d9cc33d56a20260c28ecf61b1f6b79f6699dfe502573b57e9064a6d1f"; key != want {
+		t.Fatalf("expected the key %s, got %s", want, key)
+	}
+
+	tests := []struct {
+		name   string
+		change func(r *cacheKeyRequest)
+		// whether the change is sent to the provider, and so changes the key
+		changesKey bool
+	}{
+		{name: "message metadata", change: func(r *cacheKeyRequest) { r.messages[1].Metadata = json.RawMessage(`{"usage":{"prompt_tokens":20}}`) }},
+		{name: "equivalent template", change: func(r *cacheKeyRequest) { r.template.Name = "weather" }},
+		{name: "model", change: func(r *cacheKeyRequest) { r.template.Model = "gpt-4o-mini" }, changesKey: true},
+		{name: "litellm", change: func(r *cacheKeyRequest) { r.template.UseLiteLLM = true }, changesKey: true},
+		{name: "temperature", change: func(r *cacheKeyRequest) { r.template.Temperature = 0.7 }, changesKey: true},
+		{name: "max tokens", change: func(r *cacheKeyRequest) { r.template.MaxTokens = 200 }, changesKey: true},
+		{name: "response format", change: func(r *cacheKeyRequest) { r.template.ResponseFormat = nil }, changesKey: true},
+		{name: "system prompt", change: func(r *cacheKeyRequest) { r.systemPrompt = "" }, changesKey: true},
+		{name: "message content", change: func(r *cacheKeyRequest) { r.messages[0].ContentMap = json.RawMessage(`{"content":"Rome?"}`) }, changesKey: true},
+		{name: "message role", change: func(r *cacheKeyRequest) { r.messages[1].Role = "user" }, changesKey: true},
+		{name: "message order", change: func(r *cacheKeyRequest) { r.messages[0], r.messages[1] = r.messages[1], r.messages[0] }, changesKey: true},
+		{name: "tools", change: func(r *cacheKeyRequest) { r.tools = nil }, changesKey: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := newCacheKeyRequest()
+			test.change(request)
+			if changed := request.key(t) != key; changed != test.changesKey {
+				t.Fatalf("expected the key to change: %v, changed: %v", test.changesKey, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+// CreateProviderCredential encrypts the key and stores the credential.
+func CreateProviderCredential(db *gorm.DB, credential *models.ProviderCredential) error {
+	encryptedKey, err := secrets.Encrypt(credential.Key)
+	if err != nil {
+		return err
+	}
+	credential.Key = encryptedKey
+	return models.CreateProviderCredential(db, credential)
+}
+
+// UpdateProviderCredential encrypts the key, if set, and updates the credential.
+func UpdateProviderCredential(db *gorm.DB, credential *models.ProviderCredential) error {
+	encryptedKey, err := secrets.Encrypt(credential.Key)
+	if err != nil {
+		return err
+	}
+	credential.Key = encryptedKey
+	return models.UpdateProviderCredential(db, credential)
+}
+
+// resolveProviderCredentials returns a copy of the user with the provider keys
+// replaced by the credentials of the project. For every provider the credential
+// of the environment is used first, then the project wide one, then the key of the user.
+// The keys stay encrypted, the providers decrypt them when they build the api keys.
+func resolveProviderCredentials(db *gorm.DB, user *models.User, projectID, environment string) (*models.User, error) {
+	if projectID == "" {
+		return user, nil
+	}
+
+	credentials, err := models.GetApplicableProviderCredentials(db, projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]*models.ProviderCredential)
+	for i := range credentials {
+		credential := &credentials[i]
+		if existing, ok := resolved[credential.Provider]; ok && existing.Environment != "" {
+			continue
+		}
+		resolved[credential.Provider] = credential
+	}
+
+	resolvedUser := *user
+	if credential, ok := resolved[models.CredentialProvider_OPENAI]; ok {
+		resolvedUser.OpenAIKey = credential.Key
+	}
+	if credential, ok := resolved[models.CredentialProvider_ANTHROPIC]; ok {
+		resolvedUser.AnthropicKey = credential.Key
+	}
+	if credential, ok := resolved[models.CredentialProvider_AZURE]; ok {
+		resolvedUser.AzureKey = credential.Key
+		resolvedUser.AzureEndpoint = credential.Endpoint
+	}
+	if credential, ok := resolved[models.CredentialProvider_GOOGLE]; ok {
+		// stored like the keys encrypted with secrets.EncryptJSON
+		resolvedUser.GoogleServiceAccountCreds, err = json.Marshal(credential.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resolvedUser, nil
+}
+
+// RewrapProviderCredentials re-wraps the keys of the credentials encrypted with a
+// master key other than the primary one.
+func RewrapProviderCredentials(db *gorm.DB) error {
+	var credentials []models.ProviderCredential
+	if err := db.Find(&credentials).Error; err != nil {
+		return err
+	}
+
+	for _, credential := range credentials {
+		if !secrets.NeedsRewrap(credential.Key) {
+			continue
+		}
+		rewrappedKey, ok, err := rewrapSecret(credential.Key, "provider credential "+credential.Identifier)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := models.UpdateProviderCredential(db, &models.ProviderCredential{
+			Base: models.Base{
+				Identifier: credential.Identifier,
+			},
+			Key: rewrappedKey,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrapSecret re-wraps a stored secret with the primary master key. A secret wrapped with a
+// master key which is no longer configured is logged and left as is, it can't be decrypted
+// until its key is configured again but doesn't stop the server from starting.
+func rewrapSecret(value, description string) (string, bool, error) {
+	rewrapped, err := secrets.Rewrap(value)
+	if errors.Is(err, secrets.ErrUnknownMasterKey) {
+		logger.GetLogger().Errorf("Skipping re-wrapping the %s: %v", description, err)
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return rewrapped, true, nil
+}
+
+// rewrapSecretJSON is rewrapSecret for the secrets encrypted with secrets.EncryptJSON.
+func rewrapSecretJSON(value json.RawMessage, description string) (json.RawMessage, bool, error) {
+	rewrapped, err := secrets.RewrapJSON(value)
+	if errors.Is(err, secrets.ErrUnknownMasterKey) {
+		logger.GetLogger().Errorf("Skipping re-wrapping the %s: %v", description, err)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return rewrapped, true, nil
+}
@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/burnerlee/compextAI/internal/batch"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_DATASET_ITEMS  = MAX_BATCH_ITEMS
+	MAX_EVAL_TEMPLATES = 10
+	MAX_EVAL_SCORERS   = 10
+)
+
+var (
+	// ErrEvalRunFinished is returned when cancelling an eval run which is already completed or cancelled
+	ErrEvalRunFinished = errors.New("eval run is already finished")
+)
+
+// CreateEvalRun creates an eval run of the dataset items with a batch per template,
+// the outputs are scored by the eval scorer as the executions of the batches finish
+func CreateEvalRun(db *gorm.DB, req *CreateEvalRunRequest) (*models.EvalRun, error) {
+	scorersJson, err := json.Marshal(req.Scorers)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling eval scorers: %v", err)
+		return nil, err
+	}
+	evalRun := &models.EvalRun{
+		Base: models.Base{
+			Identifier: models.NewEvalRunIdentifier(),
+		},
+		UserID:      req.UserID,
+		ProjectID:   req.ProjectID,
+		Name:        req.Name,
+		DatasetID:   req.DatasetID,
+		TemplateIDs: req.TemplateIDs,
+		Scorers:     scorersJson,
+		Total:       len(req.Items),
+	}
+	metadataJson, err := json.Marshal(map[string]interface{}{
+		"eval_run_id": evalRun.Identifier,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		results := make([]*models.EvalResult, 0, len(req.TemplateIDs)*len(req.Items))
+		for _, templateID := range req.TemplateIDs {
+			batchModel, items, err := newBatch(&CreateBatchRequest{
+				UserID:      req.UserID,
+				ProjectID:   req.ProjectID,
+				TemplateID:  templateID,
+				EvalRunID:   evalRun.Identifier,
+				MessageSets: req.MessageSets,
+				Concurrency: req.Concurrency,
+				Options: &BatchOptions{
+					Metadata: metadataJson,
+				},
+			})
+			if err != nil {
+				return err
+			}
+			batchModel, err = models.CreateBatch(tx, batchModel, items)
+			if err != nil {
+				return err
+			}
+
+			for i, item := range req.Items {
+				results = append(results, &models.EvalResult{
+					TemplateID:     templateID,
+					ItemIndex:      i,
+					DatasetItemID:  item.Identifier,
+					ExpectedOutput: item.ExpectedOutput,
+					BatchID:        batchModel.Identifier,
+				})
+			}
+		}
+
+		var err error
+		evalRun, err = models.CreateEvalRun(tx, evalRun, results)
+		return err
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Error creating eval run: %v", err)
+		return nil, err
+	}
+
+	logger.GetLogger().Infof("Eval run created: %s: %d items, %d templates", evalRun.Identifier, evalRun.Total, len(req.TemplateIDs))
+	batch.Notify()
+
+	return evalRun, nil
+}
+
+// GetEvalRunSummary aggregates the scores of the results of the eval run by template
+func GetEvalRunSummary(db *gorm.DB, evalRun *models.EvalRun) (*EvalRunSummary, error) {
+	var scorers []*EvalScorer
+	if err := json.Unmarshal(evalRun.Scorers, &scorers); err != nil {
+		return nil, fmt.Errorf("error unmarshalling eval scorers: %v", err)
+	}
+	rows, err := models.GetEvalResultRows(db, evalRun.Identifier, 0, evalRun.Total)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting eval results: %s: %v", evalRun.Identifier, err)
+		return nil, err
+	}
+
+	summaries := make(map[string]*EvalTemplateSummary, len(evalRun.TemplateIDs))
+	summary := &EvalRunSummary{
+		EvalRun:   evalRun,
+		Templates: make([]*EvalTemplateSummary, 0, len(evalRun.TemplateIDs)),
+	}
+	for _, templateID := range evalRun.TemplateIDs {
+		templateSummary := &EvalTemplateSummary{
+			TemplateID: templateID,
+			Scorers:    make([]*EvalScorerSummary, 0, len(scorers)),
+		}
+		for _, scorer := range scorers {
+			templateSummary.Scorers = append(templateSummary.Scorers, &EvalScorerSummary{Scorer: scorer.Name})
+		}
+		summaries[templateID] = templateSummary
+		summary.Templates = append(summary.Templates, templateSummary)
+	}
+
+	// sums of the scores and the execution times, averaged once all the results are counted
+	scoreSums := make(map[string][]float64, len(summaries))
+	executionTimeSums := make(map[string]float64, len(summaries))
+	completedExecutions := make(map[string]int, len(summaries))
+	for _, row := range rows {
+		templateSummary, ok := summaries[row.TemplateID]
+		if !ok {
+			continue
+		}
+		templateSummary.Items++
+		templateSummary.PromptTokens += int64(row.PromptTokens)
+		templateSummary.CompletionTokens += int64(row.CompletionTokens)
+		templateSummary.Cost += row.Cost
+		if row.ExecutionStatus == models.ThreadExecutionStatus_COMPLETED {
+			executionTimeSums[row.TemplateID] += float64(row.ExecutionTime)
+			completedExecutions[row.TemplateID]++
+		}
+		if row.Status != models.EvalResultStatus_SCORED {
+			continue
+		}
+		templateSummary.Scored++
+		if row.ExecutionStatus != models.ThreadExecutionStatus_COMPLETED {
+			templateSummary.ExecutionsFailed++
+		}
+		if row.Passed != nil && *row.Passed {
+			templateSummary.Passed++
+		}
+
+		var scores []*EvalScore
+		if err := json.Unmarshal(row.Scores, &scores); err != nil {
+			logger.GetLogger().Warnf("Error unmarshalling eval scores: %s: %d: %v", evalRun.Identifier, row.ID, err)
+			continue
+		}
+		if scoreSums[row.TemplateID] == nil {
+			scoreSums[row.TemplateID] = make([]float64, len(scorers))
+		}
+		for i, score := range scores {
+			if i >= len(templateSummary.Scorers) || score.Score == nil {
+				continue
+			}
+			scorerSummary := templateSummary.Scorers[i]
+			scorerSummary.Scored++
+			scoreSums[row.TemplateID][i] += *score.Score
+			if score.Passed != nil && *score.Passed {
+				scorerSummary.Passed++
+			}
+		}
+	}
+
+	for templateID, templateSummary := range summaries {
+		if templateSummary.Scored > 0 {
+			templateSummary.PassRate = float64(templateSummary.Passed) / float64(templateSummary.Scored)
+		}
+		if completed := completedExecutions[templateID]; completed > 0 {
+			templateSummary.AvgExecutionTime = executionTimeSums[templateID] / float64(completed)
+		}
+		for i, scorerSummary := range templateSummary.Scorers {
+			if scorerSummary.Scored > 0 {
+				scorerSummary.PassRate = float64(scorerSummary.Passed) / float64(scorerSummary.Scored)
+				scorerSummary.AvgScore = scoreSums[templateID][i] / float64(scorerSummary.Scored)
+			}
+		}
+	}
+	return summary, nil
+}
+
+// CompareEvalRun returns the results of the templates of the eval run side by side,
+// for the dataset items of the page
+func CompareEvalRun(db *gorm.DB, evalRun *models.EvalRun, page, limit int) ([]*EvalComparisonItem, error) {
+	fromIndex := (page - 1) * limit
+	rows, err := models.GetEvalResultRows(db, evalRun.Identifier, fromIndex, fromIndex+limit)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting eval results: %s: %v", evalRun.Identifier, err)
+		return nil, err
+	}
+
+	templatePositions := make(map[string]int, len(evalRun.TemplateIDs))
+	for i, templateID := range evalRun.TemplateIDs {
+		templatePositions[templateID] = i
+	}
+
+	items := make([]*EvalComparisonItem, 0, limit)
+	for _, row := range rows {
+		if len(items) == 0 || items[len(items)-1].ItemIndex != row.ItemIndex {
+			items = append(items, &EvalComparisonItem{
+				ItemIndex:      row.ItemIndex,
+				DatasetItemID:  row.DatasetItemID,
+				ExpectedOutput: row.ExpectedOutput,
+				Results:        make([]*models.EvalResultRow, len(evalRun.TemplateIDs)),
+			})
+		}
+		if position, ok := templatePositions[row.TemplateID]; ok {
+			items[len(items)-1].Results[position] = row
+		}
+	}
+	return items, nil
+}
+
+// CancelEvalRun cancels the batches of the eval run and the results which are not scored yet
+func CancelEvalRun(db *gorm.DB, evalRunID string) error {
+	cancelled, err := models.CancelEvalRun(db, evalRunID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error cancelling eval run: %s: %v", evalRunID, err)
+		return err
+	}
+	if !cancelled {
+		return ErrEvalRunFinished
+	}
+
+	batchIDs, err := models.GetEvalRunBatchIDs(db, evalRunID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting eval run batches: %s: %v", evalRunID, err)
+		return err
+	}
+	for _, batchID := range batchIDs {
+		if err := CancelBatch(db, batchID); err != nil && !errors.Is(err, ErrBatchFinished) {
+			return err
+		}
+	}
+
+	logger.GetLogger().Infof("Eval run cancelled: %s", evalRunID)
+	return nil
+}
+
+// ScoreEvalResult scores a result claimed by the eval scorer. Once the execution of the item is
+// finished, the output is scored by the scorers which do not need a judge and the executions of
+// the llm judges are queued. The scores of the judges are read once their executions are finished.
+// An output whose execution failed fails every scorer.
+func ScoreEvalResult(ctx context.Context, db *gorm.DB, evalResult *models.EvalResult) error {
+	evalRun, err := models.GetEvalRunByID(db, evalResult.EvalRunID)
+	if err != nil {
+		return fmt.Errorf("error getting eval run: %v", err)
+	}
+	var scorers []*EvalScorer
+	if err := json.Unmarshal(evalRun.Scorers, &scorers); err != nil {
+		return fmt.Errorf("error unmarshalling eval scorers: %v", err)
+	}
+
+	previousStatus := evalResult.Status
+	var scores []*EvalScore
+	switch previousStatus {
+	case models.EvalResultStatus_PENDING:
+		scores, err = scoreEvalResultOutput(db, evalRun, evalResult, scorers)
+		if err != nil {
+			return err
+		}
+	case models.EvalResultStatus_JUDGING:
+		if err := json.Unmarshal(evalResult.Scores, &scores); err != nil {
+			return fmt.Errorf("error unmarshalling eval scores: %v", err)
+		}
+		for i, score := range scores {
+			if score.JudgeExecutionID == "" || score.Score != nil || i >= len(scorers) {
+				continue
+			}
+			if err := scoreJudgeExecution(db, scorers[i], score); err != nil {
+				return fmt.Errorf("error reading the judge execution: %s: %v", score.JudgeExecutionID, err)
+			}
+		}
+	default:
+		return nil
+	}
+
+	evalResult.Status = models.EvalResultStatus_SCORED
+	evalResult.JudgeExecutionIDs = make(models.StringList, 0)
+	evalResult.Passed = nil
+	for _, score := range scores {
+		if score.JudgeExecutionID != "" {
+			evalResult.JudgeExecutionIDs = append(evalResult.JudgeExecutionIDs, score.JudgeExecutionID)
+			if previousStatus == models.EvalResultStatus_PENDING {
+				evalResult.Status = models.EvalResultStatus_JUDGING
+			}
+		}
+		if score.Passed != nil && (evalResult.Passed == nil || *evalResult.Passed) {
+			evalResult.Passed = score.Passed
+		}
+	}
+	if evalResult.Status != models.EvalResultStatus_SCORED {
+		// passed once the judges are done
+		evalResult.Passed = nil
+	}
+	evalResult.Scores, err = json.Marshal(scores)
+	if err != nil {
+		return fmt.Errorf("error marshalling eval scores: %v", err)
+	}
+
+	updated, err := models.UpdateEvalResultScores(db, evalResult, previousStatus)
+	if err != nil {
+		return fmt.Errorf("error updating eval result: %v", err)
+	}
+	if !updated || evalResult.Status != models.EvalResultStatus_SCORED {
+		return nil
+	}
+
+	completed, err := models.CompleteEvalRun(db, evalRun.Identifier)
+	if err != nil {
+		return fmt.Errorf("error completing eval run: %v", err)
+	}
+	if completed {
+		logger.GetLogger().Infof("Eval run completed: %s", evalRun.Identifier)
+	}
+	return nil
+}
+
+// scoreEvalResultOutput scores the output of the item of the result and queues the executions of the judges
+func scoreEvalResultOutput(db *gorm.DB, evalRun *models.EvalRun, evalResult *models.EvalResult, scorers []*EvalScorer) ([]*EvalScore, error) {
+	itemResults, err := models.GetBatchResults(db, evalResult.BatchID, evalResult.ItemIndex-1, 1)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the execution of the item: %v", err)
+	}
+	if len(itemResults) == 0 {
+		return nil, fmt.Errorf("item %d of batch %s not found", evalResult.ItemIndex, evalResult.BatchID)
+	}
+	itemResult := itemResults[0]
+
+	scores := make([]*EvalScore, 0, len(scorers))
+	if itemResult.Status != models.ThreadExecutionStatus_COMPLETED {
+		reason := fmt.Sprintf("the execution is %s", itemResult.Status)
+		if itemResult.Error != "" {
+			reason = fmt.Sprintf("%s: %s", reason, itemResult.Error)
+		}
+		for _, scorer := range scorers {
+			scores = append(scores, newEvalScore(scorer.Name, 0, false, reason))
+		}
+		return scores, nil
+	}
+
+	var conversation []*models.Message
+	for _, scorer := range scorers {
+		if scorer.Type != EvalScorerType_LLM_JUDGE {
+			scores = append(scores, scoreOutput(scorer, itemResult.Content, evalResult.ExpectedOutput))
+			continue
+		}
+
+		if conversation == nil {
+			conversation, err = getBatchItemMessages(db, evalResult.BatchID, evalResult.ItemIndex)
+			if err != nil {
+				return nil, err
+			}
+		}
+		score := &EvalScore{Scorer: scorer.Name}
+		score.JudgeExecutionID, err = createJudgeExecution(db, evalRun, scorer, conversation, evalResult.ExpectedOutput, itemResult.Content)
+		if err != nil {
+			logger.GetLogger().Warnf("Error creating judge execution: %s: %s: %v", evalRun.Identifier, scorer.Name, err)
+			score.Reason = fmt.Sprintf("error creating the judge execution: %v", err)
+		}
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// getBatchItemMessages returns the messages the item of a batch was executed with
+func getBatchItemMessages(db *gorm.DB, batchID string, index int) ([]*models.Message, error) {
+	item, err := models.GetBatchItem(db, batchID, index)
+	if err != nil {
+		return nil, fmt.Errorf("error getting batch item: %v", err)
+	}
+	var jobMessages []*jobMessage
+	if err := json.Unmarshal(item.Messages, &jobMessages); err != nil {
+		return nil, fmt.Errorf("error unmarshalling batch item messages: %v", err)
+	}
+	messages := make([]*models.Message, 0, len(jobMessages))
+	for _, message := range jobMessages {
+		messages = append(messages, message.toMessage())
+	}
+	return messages, nil
+}
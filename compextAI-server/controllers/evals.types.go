@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+// EvalScorer scores the outputs of an eval run, see scoreOutput
+type EvalScorer struct {
+	Name string `json:"name"`
+	// exact_match, json_schema, regex or llm_judge
+	Type string `json:"type"`
+	// exact_match: compare the output and the expected output regardless of case
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+	// json_schema: schema the output should match
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// regex: pattern the output should match
+	Pattern string `json:"pattern,omitempty"`
+	// llm_judge: template of the project judging the output against the criteria, the output
+	// passes when the score of the judge is at least the threshold
+	JudgeTemplateID string  `json:"judge_template_id,omitempty"`
+	Criteria        string  `json:"criteria,omitempty"`
+	PassThreshold   float64 `json:"pass_threshold,omitempty"`
+}
+
+// EvalScore is the score of an output by a scorer of the run
+type EvalScore struct {
+	Scorer string `json:"scorer"`
+	// between 0 and 1, null when the scorer could not score the output
+	Score  *float64 `json:"score"`
+	Passed *bool    `json:"passed"`
+	Reason string   `json:"reason,omitempty"`
+	// execution judging the output, for the llm_judge scorers
+	JudgeExecutionID string `json:"judge_execution_id,omitempty"`
+}
+
+type CreateEvalRunRequest struct {
+	UserID    uint
+	ProjectID string
+	Name      string
+	DatasetID string
+	// items of the dataset, with the messages they are executed with
+	Items       []*models.DatasetItem
+	MessageSets [][]*models.Message
+	TemplateIDs []string
+	Scorers     []*EvalScorer
+	// number of executions of every template queued or running at the same time
+	Concurrency int
+}
+
+// EvalRunSummary is an eval run with the aggregated scores of each of its templates
+type EvalRunSummary struct {
+	*models.EvalRun
+	Templates []*EvalTemplateSummary `json:"templates"`
+}
+
+type EvalTemplateSummary struct {
+	TemplateID string `json:"template_id"`
+	Items      int    `json:"items"`
+	Scored     int    `json:"scored"`
+	// items whose execution failed, they fail every scorer
+	ExecutionsFailed int `json:"executions_failed"`
+	// items passed by all of the scorers, the rate is over the scored items
+	Passed           int                  `json:"passed"`
+	PassRate         float64              `json:"pass_rate"`
+	Scorers          []*EvalScorerSummary `json:"scorers"`
+	PromptTokens     int64                `json:"prompt_tokens"`
+	CompletionTokens int64                `json:"completion_tokens"`
+	Cost             float64              `json:"cost"`
+	// execution time of the completed executions in seconds
+	AvgExecutionTime float64 `json:"avg_execution_time"`
+}
+
+type EvalScorerSummary struct {
+	Scorer string `json:"scorer"`
+	// outputs the scorer gave a score to
+	Scored   int     `json:"scored"`
+	Passed   int     `json:"passed"`
+	PassRate float64 `json:"pass_rate"`
+	AvgScore float64 `json:"avg_score"`
+}
+
+// EvalComparisonItem lists the results of the templates of an eval run side by side for a dataset item
+type EvalComparisonItem struct {
+	ItemIndex      int    `json:"item_index"`
+	DatasetItemID  string `json:"dataset_item_id"`
+	ExpectedOutput string `json:"expected_output"`
+	// in the order of the templates of the run
+	Results []*models.EvalResultRow `json:"results"`
+}
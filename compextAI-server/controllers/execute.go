@@ -1,19 +1,43 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/internal/batch"
+	"github.com/burnerlee/compextAI/internal/cache"
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/prompts"
 	"github.com/burnerlee/compextAI/internal/providers/chat"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/internal/providers/chat/litellm"
+	"github.com/burnerlee/compextAI/internal/queue"
+	"github.com/burnerlee/compextAI/internal/stream"
+	"github.com/burnerlee/compextAI/internal/usage"
+	"github.com/burnerlee/compextAI/internal/worker"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrThreadExecutionFinished is returned when cancelling an execution which is no longer queued or running
+	ErrThreadExecutionFinished = errors.New("thread execution is already finished")
+	// ErrPromptRendering is returned when the variables of the request can not be rendered into the prompts
+	ErrPromptRendering = errors.New("error rendering the prompt")
+
+	// ErrTemplateNotInProject is returned when rerunning an execution with the template of another project
+	ErrTemplateNotInProject = errors.New("the template does not belong to the project of the thread execution")
+
+	// errThreadExecutionNotRunning aborts the completion of an execution which was cancelled meanwhile
+	errThreadExecutionNotRunning = errors.New("thread execution is no longer running")
+)
+
 func ExecuteThread(db *gorm.DB, req *ExecuteThreadRequest) (interface{}, error) {
 	threadExecutionParamsTemplate, err := models.GetThreadExecutionParamsTemplateByID(db, req.ThreadExecutionParamTemplateID)
 	if err != nil {
@@ -21,24 +45,61 @@ func ExecuteThread(db *gorm.DB, req *ExecuteThreadRequest) (interface{}, error)
 		return nil, err
 	}
 
-	if req.ThreadExecutionSystemPrompt != "" {
-		logger.GetLogger().Infof("Setting thread execution system prompt: %s", req.ThreadExecutionSystemPrompt)
-		threadExecutionParamsTemplate.SystemPrompt = req.ThreadExecutionSystemPrompt
+	if err := CheckProjectBudgets(db, req.ProjectID, req.Environment); err != nil {
+		return nil, err
 	}
 
-	var chatProvider chat.ChatCompletionsProvider
-	if threadExecutionParamsTemplate.UseLiteLLM {
-		chatProvider, err = chat.GetChatCompletionsProvider(litellm.LITELLM_IDENTIFIER)
+	// the execution runs with the revisions of the templates as they are now,
+	// changes made to the templates while it is queued do not apply to it
+	templateRevisionIDs := make(map[string]string)
+	templateRevision, err := getExecutionTemplateRevision(db, threadExecutionParamsTemplate.Identifier, req.TemplateRevisionID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting thread execution params template revision: %s: %v", threadExecutionParamsTemplate.Identifier, err)
+		return nil, err
+	}
+	templateRevisionIDs[threadExecutionParamsTemplate.Identifier] = templateRevision.Identifier
+	threadExecutionParamsTemplate = templateRevision.Template(threadExecutionParamsTemplate)
+
+	// validate that a provider is available for the template before queueing the execution
+	if _, err := getChatProvider(threadExecutionParamsTemplate); err != nil {
+		return nil, err
+	}
+
+	// the system prompt of the request replaces the system prompts of the templates
+	systemPrompt, err := renderPrompt(req.ThreadExecutionSystemPrompt, req.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("%w: system prompt: %v", ErrPromptRendering, err)
+	}
+	renderedSystemPrompt := systemPrompt
+	if systemPrompt == "" {
+		renderedSystemPrompt, err = renderPrompt(threadExecutionParamsTemplate.SystemPrompt, req.Variables)
 		if err != nil {
-			logger.GetLogger().Errorf("Error getting litellm chat provider: %v", err)
+			return nil, fmt.Errorf("%w: system prompt of template %s: %v", ErrPromptRendering, threadExecutionParamsTemplate.Identifier, err)
+		}
+	}
+
+	for _, fallbackTemplateID := range req.FallbackTemplateIDs {
+		fallbackTemplate, err := models.GetThreadExecutionParamsTemplateByID(db, fallbackTemplateID)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting fallback thread execution params template: %s: %v", fallbackTemplateID, err)
 			return nil, err
 		}
-	} else {
-		chatProvider, err = chat.GetChatCompletionsProvider(threadExecutionParamsTemplate.Model)
+		if _, err := getChatProvider(fallbackTemplate); err != nil {
+			return nil, err
+		}
+		fallbackTemplateRevision, err := models.GetCurrentTemplateRevision(db, fallbackTemplateID)
 		if err != nil {
-			logger.GetLogger().Errorf("Error getting chat provider: %s: %v", threadExecutionParamsTemplate.Model, err)
+			logger.GetLogger().Errorf("Error getting fallback thread execution params template revision: %s: %v", fallbackTemplateID, err)
 			return nil, err
 		}
+		templateRevisionIDs[fallbackTemplateID] = fallbackTemplateRevision.Identifier
+
+		// fail now rather than when falling back to the template
+		if systemPrompt == "" {
+			if _, err := renderPrompt(fallbackTemplate.SystemPrompt, req.Variables); err != nil {
+				return nil, fmt.Errorf("%w: system prompt of template %s: %v", ErrPromptRendering, fallbackTemplateID, err)
+			}
+		}
 	}
 
 	var messages []*models.Message
@@ -70,20 +131,108 @@ func ExecuteThread(db *gorm.DB, req *ExecuteThreadRequest) (interface{}, error)
 		req.Tools = make([]*models.ExecutionTool, 0)
 	}
 
+	projectToolIDs := make([]string, 0, len(req.ProjectTools))
+	for _, projectTool := range req.ProjectTools {
+		for _, tool := range req.Tools {
+			if tool.Name == projectTool.Name {
+				return nil, fmt.Errorf("%w: %s is both a tool of the request and a project tool", ErrToolNameConflict, tool.Name)
+			}
+		}
+		req.Tools = append(req.Tools, projectTool.ExecutionTool())
+		projectToolIDs = append(projectToolIDs, projectTool.Identifier)
+	}
+	maxToolSteps := req.MaxToolSteps
+	if maxToolSteps <= 0 {
+		maxToolSteps = DEFAULT_MAX_TOOL_STEPS
+	}
+
 	toolsJson, err := json.Marshal(req.Tools)
 	if err != nil {
 		logger.GetLogger().Errorf("Error marshalling tools: %v", err)
 		return nil, err
 	}
 
+	// the messages are snapshotted in the job payload, so the execution
+	// runs on the thread as it was when the execution was requested
+	jobPayload := &threadExecutionJobPayload{
+		SystemPrompt:            systemPrompt,
+		AppendAssistantResponse: req.AppendAssistantResponse,
+		Stream:                  req.Stream,
+		Messages:                make([]*jobMessage, 0, len(messages)),
+		TemplateRevisionIDs:     templateRevisionIDs,
+		Variables:               req.Variables,
+		ProjectToolIDs:          projectToolIDs,
+		MaxToolSteps:            maxToolSteps,
+	}
+	for i, message := range messages {
+		jobMessage := newJobMessage(message)
+		if req.Variables != nil && !req.MessagesRendered && isTemplateMessage(message) {
+			jobMessage.ContentMap, err = prompts.RenderContentMap(message.ContentMap, req.Variables)
+			if err != nil {
+				return nil, fmt.Errorf("%w: message %d: %v", ErrPromptRendering, i, err)
+			}
+		}
+		jobPayload.Messages = append(jobPayload.Messages, jobMessage)
+	}
+
+	var cachedResponse *cache.Entry
+	if req.Cache {
+		jobPayload.CacheKey, err = responseCacheKey(threadExecutionParamsTemplate, renderedSystemPrompt, jobPayload.Messages, toolsJson)
+		if err != nil {
+			logger.GetLogger().Errorf("Error computing response cache key: %v", err)
+			return nil, err
+		}
+		cacheTTL := req.CacheTTL
+		if cacheTTL <= 0 {
+			cacheTTL = cache.DefaultTTL()
+		}
+		jobPayload.CacheTTL = int(cacheTTL.Seconds())
+		cachedResponse = getCachedResponse(req.ProjectID, jobPayload.CacheKey)
+	}
+
+	jobPayloadJson, err := json.Marshal(jobPayload)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling job payload: %v", err)
+		return nil, err
+	}
+
 	threadExecution := &models.ThreadExecution{
-		UserID:                          req.UserID,
-		ThreadID:                        req.ThreadID,
-		ThreadExecutionParamsTemplateID: req.ThreadExecutionParamTemplateID,
-		Status:                          models.ThreadExecutionStatus_IN_PROGRESS,
-		ProjectID:                       req.ProjectID,
-		Metadata:                        req.Metadata,
-		Tools:                           toolsJson,
+		UserID:                                  req.UserID,
+		ThreadID:                                req.ThreadID,
+		ThreadExecutionParamsTemplateID:         req.ThreadExecutionParamTemplateID,
+		ThreadExecutionParamsTemplateRevisionID: templateRevision.Identifier,
+		FallbackTemplateIDs:                     req.FallbackTemplateIDs,
+		Environment:                             req.Environment,
+		CallbackURL:                             req.CallbackURL,
+		Status:                                  models.ThreadExecutionStatus_QUEUED,
+		ProjectID:                               req.ProjectID,
+		Metadata:                                req.Metadata,
+		Tools:                                   toolsJson,
+		JobPayload:                              jobPayloadJson,
+		RenderedSystemPrompt:                    renderedSystemPrompt,
+		ThreadExecutionParamsID:                 req.ThreadExecutionParamsID,
+		Variant:                                 req.Variant,
+		CacheKey:                                jobPayload.CacheKey,
+		BatchID:                                 req.BatchID,
+	}
+	if cachedResponse != nil {
+		// completed right away by this request, which holds the lease so that the queue
+		// workers neither claim the execution nor requeue it while it is completed
+		leaseExpiresAt := time.Now().Add(queue.LeaseDuration())
+		threadExecution.Status = models.ThreadExecutionStatus_IN_PROGRESS
+		threadExecution.LeaseOwner = worker.NewID()
+		threadExecution.LeaseExpiresAt = &leaseExpiresAt
+		threadExecution.CacheHit = true
+		threadExecution.CachedFromExecutionID = cachedResponse.ThreadExecutionID
+		threadExecution.ExecutedTemplateID = threadExecutionParamsTemplate.Identifier
+		threadExecution.ExecutedTemplateRevisionID = templateRevision.Identifier
+	}
+	if req.Variables != nil {
+		threadExecution.Variables, err = json.Marshal(req.Variables)
+		if err != nil {
+			logger.GetLogger().Errorf("Error marshalling variables: %v", err)
+			return nil, err
+		}
 	}
 
 	threadExecution, err = models.CreateThreadExecution(db, threadExecution)
@@ -114,37 +263,430 @@ func ExecuteThread(db *gorm.DB, req *ExecuteThreadRequest) (interface{}, error)
 		}
 	}
 
-	go func(p chat.ChatCompletionsProvider, messages []*models.Message, threadExecution models.ThreadExecution, threadExecutionParamsTemplate models.ThreadExecutionParamsTemplate, appendAssistantResponse bool) {
-		// get the user
-		user, err := models.GetUserByID(db, threadExecution.UserID)
+	if cachedResponse != nil {
+		logger.GetLogger().Infof("Completing thread execution from the response cache: %s: %s", threadExecution.Identifier, cachedResponse.ThreadExecutionID)
+		completeThreadExecutionFromCache(db, threadExecution, threadExecutionParamsTemplate, renderedSystemPrompt, cachedResponse, req.AppendAssistantResponse)
+		return threadExecution, nil
+	}
+
+	// the execution is picked up by a queue worker, see ProcessThreadExecution
+	queue.Notify()
+
+	return threadExecution, nil
+}
+
+// isTemplateMessage reports whether the content of the message is rendered with the variables. The
+// system messages are, the user messages only when their metadata marks them with "template": true.
+// The assistant and tool messages hold model and tool outputs, they are never rendered.
+func isTemplateMessage(message *models.Message) bool {
+	switch message.Role {
+	case "system":
+		return true
+	case "user":
+		var metadata struct {
+			Template bool `json:"template"`
+		}
+		if err := json.Unmarshal(message.Metadata, &metadata); err != nil {
+			return false
+		}
+		return metadata.Template
+	default:
+		return false
+	}
+}
+
+// renderPrompt renders the prompt with the variables, prompts are not templated when there are no variables
+func renderPrompt(prompt string, variables map[string]interface{}) (string, error) {
+	if variables == nil {
+		return prompt, nil
+	}
+	return prompts.Render(prompt, variables)
+}
+
+func getChatProvider(threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate) (chat.ChatCompletionsProvider, error) {
+	if threadExecutionParamsTemplate.UseLiteLLM {
+		chatProvider, err := chat.GetChatCompletionsProvider(litellm.LITELLM_IDENTIFIER)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting litellm chat provider: %v", err)
+			return nil, err
+		}
+		return chatProvider, nil
+	}
+
+	chatProvider, err := chat.GetChatCompletionsProvider(threadExecutionParamsTemplate.Model)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting chat provider: %s: %v", threadExecutionParamsTemplate.Model, err)
+		return nil, err
+	}
+	return chatProvider, nil
+}
+
+// NotifyThreadExecutionFinished notifies the webhooks, the batch and the stream subscribers of an
+// execution finished without a queue worker running it, e.g. failed by the orphan recovery or
+// cancelled while queued, as ProcessThreadExecution does once an execution it runs is finished.
+func NotifyThreadExecutionFinished(db *gorm.DB, threadExecution *models.ThreadExecution) {
+	enqueueThreadExecutionWebhooks(db, threadExecution.Identifier)
+	if threadExecution.BatchID != "" {
+		batch.Notify()
+	}
+	stream.PublishResult(threadExecution.Identifier, GetThreadExecutionResultEvent(threadExecution))
+}
+
+// ProcessThreadExecution runs a queued thread execution. It is called by the execution queue
+// workers once they have claimed the execution.
+func ProcessThreadExecution(ctx context.Context, db *gorm.DB, threadExecution *models.ThreadExecution) {
+	// notify the callback url and the webhooks once handleThreadExecutionSuccess
+	// or handleThreadExecutionError moved the execution to its final status
+	defer enqueueThreadExecutionWebhooks(db, threadExecution.Identifier)
+	if threadExecution.BatchID != "" {
+		// a slot of the batch is free once the execution is finished
+		defer batch.Notify()
+	}
+
+	var jobPayload threadExecutionJobPayload
+	if err := json.Unmarshal(threadExecution.JobPayload, &jobPayload); err != nil {
+		logger.GetLogger().Errorf("Error unmarshalling job payload: %s: %v", threadExecution.Identifier, err)
+		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error unmarshalling job payload: %v", err))
+		return
+	}
+
+	messages := make([]*models.Message, 0, len(jobPayload.Messages))
+	for _, message := range jobPayload.Messages {
+		messages = append(messages, message.toMessage())
+	}
+
+	tools := make([]*models.ExecutionTool, 0)
+	if err := json.Unmarshal(threadExecution.Tools, &tools); err != nil {
+		logger.GetLogger().Errorf("Error unmarshalling tools: %s: %v", threadExecution.Identifier, err)
+		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error unmarshalling tools: %v", err))
+		return
+	}
+
+	projectTools := make(map[string]*models.ProjectTool)
+	if len(jobPayload.ProjectToolIDs) > 0 {
+		toolsByID, err := models.GetProjectToolsByID(db, jobPayload.ProjectToolIDs)
 		if err != nil {
-			logger.GetLogger().Errorf("Error getting user: %d: %v", threadExecution.UserID, err)
+			logger.GetLogger().Errorf("Error getting project tools: %s: %v", threadExecution.Identifier, err)
+			handleThreadExecutionError(db, threadExecution, fmt.Errorf("error getting project tools: %v", err))
+			return
+		}
+		// tools deleted since the execution was queued are left to the client
+		for _, tool := range toolsByID {
+			projectTools[tool.Name] = tool
+		}
+	}
+
+	// get the user
+	user, err := models.GetUserByID(db, threadExecution.UserID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting user: %d: %v", threadExecution.UserID, err)
+		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error getting user: %v", err))
+		return
+	}
+
+	// use the provider credentials of the project and environment when it has any
+	user, err = resolveProviderCredentials(db, user, threadExecution.ProjectID, threadExecution.Environment)
+	if err != nil {
+		logger.GetLogger().Errorf("Error resolving provider credentials: %s: %v", threadExecution.ProjectID, err)
+		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error resolving provider credentials: %v", err))
+		return
+	}
+
+	// once content has been streamed, falling back to another template
+	// would send the client a second response
+	streamed := false
+	var streamHandler base.StreamHandler
+	if jobPayload.Stream {
+		stream.Open(threadExecution.Identifier)
+		defer publishThreadExecutionResult(db, threadExecution.Identifier)
+
+		streamHandler = func(delta string) {
+			streamed = true
+			stream.Publish(threadExecution.Identifier, stream.Event{
+				Type:    stream.EVENT_TYPE_DELTA,
+				Content: delta,
+			})
+		}
+	}
+
+	templateIDs := append([]string{threadExecution.ThreadExecutionParamsTemplateID}, threadExecution.FallbackTemplateIDs...)
+	skippedTemplates := make([]*models.SkippedTemplate, 0)
+
+	var execErr error
+	for i, templateID := range templateIDs {
+		if i > 0 {
+			logger.GetLogger().Warnf("Falling back to thread execution params template: %s: %s", threadExecution.Identifier, templateID)
+		}
+
+		templateRevisionID := jobPayload.TemplateRevisionIDs[templateID]
+		result, skipped, err := executeThreadWithTemplate(ctx, db, user, messages, tools, projectTools, jobPayload.MaxToolSteps, threadExecution, templateID, templateRevisionID, jobPayload.SystemPrompt, jobPayload.Variables, streamHandler)
+		if skipped == nil {
+			if err := updateThreadExecutionFallbacks(db, threadExecution, templateID, templateRevisionID, result.systemPrompt, skippedTemplates); err != nil {
+				logger.GetLogger().Errorf("Error updating thread execution fallbacks: %s: %v", threadExecution.Identifier, err)
+			}
+			logger.GetLogger().Infof("Thread execution completed: %s", threadExecution.ThreadID)
+			handleThreadExecutionSuccess(db, threadExecution, result, jobPayload.AppendAssistantResponse)
+
+			// responses of the fallbacks and of the tool loops depend on more than the request
+			if jobPayload.CacheKey != "" && i == 0 && len(result.toolMessages) == 0 {
+				cacheThreadExecutionResponse(db, threadExecution.Identifier, jobPayload.CacheKey, time.Duration(jobPayload.CacheTTL)*time.Second, result)
+			}
+
+			// emit the threshold events crossed by this execution
+			if err := CheckProjectBudgets(db, threadExecution.ProjectID, threadExecution.Environment); err != nil && !errors.Is(err, ErrBudgetExceeded) {
+				logger.GetLogger().Errorf("Error checking project budgets: %s: %v", threadExecution.ProjectID, err)
+			}
 			return
 		}
 
-		if threadExecutionParamsTemplate.ResponseFormat == nil {
-			threadExecutionParamsTemplate.ResponseFormat = json.RawMessage("{}")
+		logger.GetLogger().Errorf("Error executing thread: %s: template: %s: status code: %d: %v", threadExecution.ThreadID, templateID, skipped.StatusCode, err)
+		skippedTemplates = append(skippedTemplates, skipped)
+		execErr = err
+		if streamed || ctx.Err() != nil {
+			break
 		}
+	}
+
+	// the execution was cancelled or its lease was lost, it is finished by whoever cancelled it
+	if ctx.Err() != nil {
+		logger.GetLogger().Warnf("Thread execution interrupted: %s: %v", threadExecution.Identifier, ctx.Err())
+		return
+	}
+
+	if err := updateThreadExecutionFallbacks(db, threadExecution, "", "", "", skippedTemplates); err != nil {
+		logger.GetLogger().Errorf("Error updating thread execution fallbacks: %s: %v", threadExecution.Identifier, err)
+	}
+	handleThreadExecutionError(db, threadExecution, execErr)
+}
+
+// templateExecution is the successful execution of a thread with a template of the fallback chain
+type templateExecution struct {
+	template     *models.ThreadExecutionParamsTemplate
+	chatProvider chat.ChatCompletionsProvider
+	response     interface{}
+	// rendered system prompt the thread was executed with
+	systemPrompt string
+	// messages of the tool steps run before the response, the assistant tool calls and the tool results
+	toolMessages []*models.Message
+	// json the response is validated against, nil if the template does not ask for json
+	structuredOutput *structuredOutput
+	// re-prompts made because the response did not match the response format,
+	// and the metadata of the rejected responses for the usage
+	responseFormatRetries    int
+	rejectedResponseMetadata []json.RawMessage
+	// the response was read from the response cache
+	cacheHit bool
+}
+
+// getExecutionTemplateRevision returns the revision of the template the execution runs with,
+// the pinned revision of the execution params when set, the current revision otherwise
+func getExecutionTemplateRevision(db *gorm.DB, templateID, pinnedRevisionID string) (*models.ThreadExecutionParamsTemplateRevision, error) {
+	if pinnedRevisionID == "" {
+		return models.GetCurrentTemplateRevision(db, templateID)
+	}
+	templateRevision, err := models.GetTemplateRevisionByID(db, pinnedRevisionID)
+	if err != nil {
+		return nil, err
+	}
+	if templateRevision.TemplateID != templateID {
+		return nil, fmt.Errorf("template revision %s does not belong to template %s", pinnedRevisionID, templateID)
+	}
+	return templateRevision, nil
+}
+
+// executeThreadWithTemplate executes the thread with one template of the fallback chain, at the given
+// revision of the template. The current template is used for the executions queued without revisions.
+// While the model only calls project tools, the server runs them and calls the model again with their
+// results, for up to maxToolSteps steps. A final response which does not match the json response format
+// of the template is sent back to the model with the validation errors, up to ResponseFormatRetries times.
+// A failed execution is returned as the skipped template, along with the error to report.
+func executeThreadWithTemplate(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, tools []*models.ExecutionTool, projectTools map[string]*models.ProjectTool, maxToolSteps int, threadExecution *models.ThreadExecution, templateID, templateRevisionID, systemPrompt string, variables map[string]interface{}, streamHandler base.StreamHandler) (*templateExecution, *models.SkippedTemplate, error) {
+	skipped := &models.SkippedTemplate{
+		TemplateID: templateID,
+	}
 
+	threadExecutionParamsTemplate, err := models.GetThreadExecutionParamsTemplateByID(db, templateID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting thread execution params template: %s: %v", templateID, err)
+		skipped.Reason = fmt.Sprintf("error getting thread execution params template: %v", err)
+		return nil, skipped, fmt.Errorf("error getting thread execution params template: %v", err)
+	}
+	if templateRevisionID != "" {
+		templateRevision, err := models.GetTemplateRevisionByID(db, templateRevisionID)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting thread execution params template revision: %s: %v", templateRevisionID, err)
+			skipped.Reason = fmt.Sprintf("error getting thread execution params template revision: %v", err)
+			return nil, skipped, fmt.Errorf("error getting thread execution params template revision: %v", err)
+		}
+		threadExecutionParamsTemplate = templateRevision.Template(threadExecutionParamsTemplate)
+	}
+	skipped.Model = threadExecutionParamsTemplate.Model
+
+	if systemPrompt != "" {
+		logger.GetLogger().Infof("Setting thread execution system prompt: %s", systemPrompt)
+		threadExecutionParamsTemplate.SystemPrompt = systemPrompt
+	} else {
+		threadExecutionParamsTemplate.SystemPrompt, err = renderPrompt(threadExecutionParamsTemplate.SystemPrompt, variables)
+		if err != nil {
+			skipped.Reason = fmt.Sprintf("error rendering system prompt: %v", err)
+			return nil, skipped, fmt.Errorf("%w: system prompt of template %s: %v", ErrPromptRendering, templateID, err)
+		}
+	}
+	// the providers may clear the system prompt of the template, e.g. to send it as a message
+	renderedSystemPrompt := threadExecutionParamsTemplate.SystemPrompt
+
+	if threadExecutionParamsTemplate.ResponseFormat == nil {
+		threadExecutionParamsTemplate.ResponseFormat = json.RawMessage("{}")
+	}
+
+	chatProvider, err := getChatProvider(threadExecutionParamsTemplate)
+	if err != nil {
+		skipped.Reason = fmt.Sprintf("error getting chat provider: %v", err)
+		return nil, skipped, fmt.Errorf("error getting chat provider: %v", err)
+	}
+
+	result := &templateExecution{
+		template:     threadExecutionParamsTemplate,
+		chatProvider: chatProvider,
+		systemPrompt: renderedSystemPrompt,
+		toolMessages: make([]*models.Message, 0),
+	}
+	result.structuredOutput, err = getStructuredOutput(threadExecutionParamsTemplate.ResponseFormat)
+	if err != nil {
+		// templates saved before the response formats were validated are executed as they were
+		logger.GetLogger().Warnf("Not validating the response of template: %s: %v", templateID, err)
+	}
+	// the tool steps and the re-prompts extend the messages, without changing the messages of the other templates
+	messages = messages[:len(messages):len(messages)]
+
+	for step, reprompts := 1, 0; ; {
 		// execute the thread using the chat provider
-		statusCode, threadExecutionResponse, err := chatProvider.ExecuteThread(db, user, messages, &threadExecutionParamsTemplate, threadExecution.Identifier, req.Tools)
+		statusCode, threadExecutionResponse, err := chatProvider.ExecuteThread(ctx, db, user, messages, threadExecutionParamsTemplate, threadExecution.Identifier, tools, streamHandler)
+		skipped.StatusCode = statusCode
 		if err != nil {
-			logger.GetLogger().Errorf("Error executing thread: %s: %v: %v", req.ThreadID, err, threadExecutionResponse)
-			handleThreadExecutionError(db, &threadExecution, fmt.Errorf("error executing thread: %v: %v", err, threadExecutionResponse))
-			return
+			skipped.Reason = fmt.Sprintf("%v: %v", err, threadExecutionResponse)
+			return nil, skipped, fmt.Errorf("error executing thread: %v: %v", err, threadExecutionResponse)
 		}
 
 		if statusCode != http.StatusOK {
-			logger.GetLogger().Errorf("Error executing thread: %s: status code: %d: %v", req.ThreadID, statusCode, threadExecutionResponse)
-			handleThreadExecutionError(db, &threadExecution, fmt.Errorf("status code: %d: %v", statusCode, threadExecutionResponse))
-			return
+			skipped.Reason = fmt.Sprintf("%v", threadExecutionResponse)
+			return nil, skipped, fmt.Errorf("status code: %d: %v", statusCode, threadExecutionResponse)
 		}
+		result.response = threadExecutionResponse
 
-		logger.GetLogger().Infof("Thread execution completed: %s", req.ThreadID)
-		handleThreadExecutionSuccess(db, p, &threadExecution, threadExecutionResponse, appendAssistantResponse)
-	}(chatProvider, messages, *threadExecution, *threadExecutionParamsTemplate, req.AppendAssistantResponse)
+		if len(projectTools) == 0 && result.structuredOutput == nil {
+			return result, nil, nil
+		}
+		// a response which can not be converted is reported by handleThreadExecutionSuccess
+		message, err := chatProvider.ConvertExecutionResponseToMessage(threadExecutionResponse)
+		if err != nil {
+			return result, nil, nil
+		}
+		toolCalls, err := serverToolCalls(message, projectTools)
+		if err != nil {
+			skipped.Reason = err.Error()
+			return nil, skipped, err
+		}
+		if len(toolCalls) > 0 {
+			if step > maxToolSteps {
+				logger.GetLogger().Warnf("Thread execution reached the max tool steps: %s: %d", threadExecution.Identifier, maxToolSteps)
+				return result, nil, nil
+			}
+
+			toolMessages, err := runToolStep(ctx, db, threadExecution.Identifier, &models.ThreadExecutionToolStep{
+				Step:       step,
+				TemplateID: templateID,
+				Content:    messageContentString(message),
+			}, toolCalls, projectTools)
+			if err != nil {
+				skipped.Reason = fmt.Sprintf("error running tools: %v", err)
+				return nil, skipped, fmt.Errorf("error running tools: %v", err)
+			}
+			result.toolMessages = append(result.toolMessages, message)
+			result.toolMessages = append(result.toolMessages, toolMessages...)
+			messages = append(messages, message)
+			messages = append(messages, toolMessages...)
+			step++
+			continue
+		}
 
-	return threadExecution, nil
+		// a streamed response has already been sent to the client, it is only validated
+		if result.structuredOutput == nil || streamHandler != nil || reprompts >= threadExecutionParamsTemplate.ResponseFormatRetries || hasToolCalls(message) {
+			return result, nil, nil
+		}
+		// an error of the schema itself is reported by handleThreadExecutionSuccess
+		_, validationErrors, err := result.structuredOutput.parse(messageContentString(message))
+		if err != nil || len(validationErrors) == 0 {
+			return result, nil, nil
+		}
+		reprompts++
+		logger.GetLogger().Warnf("Response does not match the response format, re-prompting: %s: %d: %v", threadExecution.Identifier, reprompts, validationErrors)
+		repromptMessage, err := responseFormatRepromptMessage(validationErrors)
+		if err != nil {
+			skipped.Reason = fmt.Sprintf("error creating the re-prompt message: %v", err)
+			return nil, skipped, fmt.Errorf("error creating the re-prompt message: %v", err)
+		}
+		result.responseFormatRetries = reprompts
+		result.rejectedResponseMetadata = append(result.rejectedResponseMetadata, message.Metadata)
+		messages = append(messages, message, repromptMessage)
+	}
+}
+
+// updateThreadExecutionFallbacks records the template which produced the output, the system prompt
+// it was executed with and the templates of the fallback chain which failed before it
+func updateThreadExecutionFallbacks(db *gorm.DB, threadExecution *models.ThreadExecution, executedTemplateID, executedTemplateRevisionID, renderedSystemPrompt string, skippedTemplates []*models.SkippedTemplate) error {
+	skippedTemplatesJson, err := json.Marshal(skippedTemplates)
+	if err != nil {
+		return err
+	}
+	return models.UpdateThreadExecution(db, &models.ThreadExecution{
+		Base: models.Base{
+			ID:         threadExecution.ID,
+			Identifier: threadExecution.Identifier,
+		},
+		ExecutedTemplateID:         executedTemplateID,
+		ExecutedTemplateRevisionID: executedTemplateRevisionID,
+		RenderedSystemPrompt:       renderedSystemPrompt,
+		SkippedTemplates:           skippedTemplatesJson,
+	})
+}
+
+// publishThreadExecutionResult sends the final state of a streamed execution to its subscribers
+func publishThreadExecutionResult(db *gorm.DB, executionID string) {
+	threadExecution, err := models.GetThreadExecutionByID(db, executionID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting thread execution: %s: %v", executionID, err)
+		stream.Publish(executionID, stream.Event{
+			Type:  stream.EVENT_TYPE_ERROR,
+			Error: err.Error(),
+		})
+		return
+	}
+	stream.Publish(executionID, GetThreadExecutionResultEvent(threadExecution))
+}
+
+// GetThreadExecutionResultEvent converts a finished thread execution to the last event of its stream
+func GetThreadExecutionResultEvent(threadExecution *models.ThreadExecution) stream.Event {
+	if threadExecution.Status == models.ThreadExecutionStatus_COMPLETED {
+		return stream.Event{
+			Type:    stream.EVENT_TYPE_DONE,
+			Status:  threadExecution.Status,
+			Content: threadExecution.Content,
+			Role:    threadExecution.Role,
+		}
+	}
+
+	var output struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(threadExecution.Output, &output); err != nil || output.Error == "" {
+		output.Error = fmt.Sprintf("thread execution is: %s", threadExecution.Status)
+	}
+	return stream.Event{
+		Type:   stream.EVENT_TYPE_ERROR,
+		Status: threadExecution.Status,
+		Error:  output.Error,
+	}
 }
 
 func handleThreadExecutionError(db *gorm.DB, threadExecution *models.ThreadExecution, execErr error) {
@@ -173,76 +715,190 @@ func handleThreadExecutionError(db *gorm.DB, threadExecution *models.ThreadExecu
 	models.UpdateThreadExecution(db, &updatedThreadExecution)
 }
 
-func handleThreadExecutionSuccess(db *gorm.DB, p chat.ChatCompletionsProvider, threadExecution *models.ThreadExecution, threadExecutionResponse interface{}, appendAssistantResponse bool) {
+func handleThreadExecutionSuccess(db *gorm.DB, threadExecution *models.ThreadExecution, result *templateExecution, appendAssistantResponse bool) {
+	p, model, threadExecutionResponse, toolMessages := result.chatProvider, result.template.Model, result.response, result.toolMessages
+
 	updatedThreadExecution := models.ThreadExecution{
 		Base: models.Base{
 			ID:         threadExecution.ID,
 			Identifier: threadExecution.Identifier,
 		},
-		Status: models.ThreadExecutionStatus_COMPLETED,
+		Model: model,
 	}
+	// failExecution records the failure of a response which could not be completed, with the usage known so far
+	failExecution := func(err error) {
+		logger.GetLogger().Errorf("Error completing thread execution: %s: %v", threadExecution.Identifier, err)
+		updatedThreadExecution.Status = models.ThreadExecutionStatus_FAILED
+		updatedThreadExecution.Output = nil
+		models.UpdateThreadExecution(db, &updatedThreadExecution)
+		handleThreadExecutionError(db, threadExecution, err)
+	}
+
 	responseJson, err := json.Marshal(threadExecutionResponse)
 	if err != nil {
-		logger.GetLogger().Errorf("Error marshalling thread execution response: %v", err)
-		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error marshalling thread execution response: %v", err))
-		models.UpdateThreadExecution(db, &updatedThreadExecution)
+		failExecution(fmt.Errorf("error marshalling thread execution response: %v", err))
 		return
 	}
 
-	updatedThreadExecution.Output = responseJson
-
 	message, err := p.ConvertExecutionResponseToMessage(threadExecutionResponse)
 	if err != nil {
-		logger.GetLogger().Errorf("Error converting thread execution response to message: %v", err)
-		handleThreadExecutionError(db, threadExecution, fmt.Errorf("error converting thread execution response to message: %v", err))
-		models.UpdateThreadExecution(db, &updatedThreadExecution)
+		failExecution(fmt.Errorf("error converting thread execution response to message: %v", err))
 		return
 	}
 
 	updatedThreadExecution.Role = message.Role
 	updatedThreadExecution.ExecutionResponseMetadata = message.Metadata
+	// the model was called once for every tool step and re-prompt before the response
+	usageMetadata := []json.RawMessage{message.Metadata}
+	for _, toolMessage := range toolMessages {
+		if toolMessage.Role != "tool" {
+			usageMetadata = append(usageMetadata, toolMessage.Metadata)
+		}
+	}
+	usageMetadata = append(usageMetadata, result.rejectedResponseMetadata...)
+	// a cached response used no tokens
+	if !result.cacheHit {
+		setThreadExecutionUsage(&updatedThreadExecution, model, usageMetadata...)
+	}
+	updatedThreadExecution.ResponseFormatRetries = result.responseFormatRetries
+	updatedThreadExecution.Content = messageContent(message)
+
+	// a response calling the tools of the client is validated once the client sends the tool results
+	if result.structuredOutput != nil && !hasToolCalls(message) {
+		parsedOutput, validationErrors, err := result.structuredOutput.parse(messageContentString(message))
+		if err == nil && len(validationErrors) > 0 {
+			err = fmt.Errorf("%w: %s", ErrResponseFormatMismatch, strings.Join(validationErrors, "; "))
+		}
+		if err != nil {
+			// the usage and the rejected content are kept on the failed execution
+			failExecution(err)
+			return
+		}
+		updatedThreadExecution.ParsedOutput = parsedOutput
+	}
 
-	if appendAssistantResponse {
-		logger.GetLogger().Infof("Appending assistant response")
+	updatedThreadExecution.Output = responseJson
+	updatedThreadExecution.ExecutionTime = uint(time.Since(threadExecution.CreatedAt).Seconds())
 
-		if err := models.CreateMessage(db, &models.Message{
+	// the result is written with the completed status and the messages are appended in one transaction,
+	// so a cancelled execution, or one reclaimed by another worker after its lease expired, never adds
+	// a message to the thread and a completed one is never failed later
+	err = db.Transaction(func(tx *gorm.DB) error {
+		completed, err := models.CompleteThreadExecution(tx, &updatedThreadExecution, threadExecution.LeaseOwner)
+		if err != nil {
+			return fmt.Errorf("error completing thread execution: %v", err)
+		}
+		if !completed {
+			return errThreadExecutionNotRunning
+		}
+		if !appendAssistantResponse {
+			return nil
+		}
+
+		logger.GetLogger().Infof("Appending assistant response")
+		for _, toolMessage := range toolMessages {
+			toolMessage.ThreadID = threadExecution.ThreadID
+			if err := models.CreateMessage(tx, toolMessage); err != nil {
+				return fmt.Errorf("error creating tool step message: %v", err)
+			}
+		}
+		if err := models.CreateMessage(tx, &models.Message{
 			ThreadID:   threadExecution.ThreadID,
 			Role:       message.Role,
 			ContentMap: message.ContentMap,
 			Metadata:   message.Metadata,
+			ToolCalls:  message.ToolCalls,
 		}); err != nil {
-			logger.GetLogger().Errorf("Error creating assistant message: %v", err)
-			handleThreadExecutionError(db, threadExecution, fmt.Errorf("error creating assistant message: %v", err))
-			return
-		} else {
-			logger.GetLogger().Infof("assistant message created")
+			return fmt.Errorf("error creating assistant message: %v", err)
 		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errThreadExecutionNotRunning):
+		logger.GetLogger().Warnf("Thread execution is no longer running, dropping its response: %s", threadExecution.Identifier)
+	case err != nil:
+		// nothing was written, the execution is still running
+		logger.GetLogger().Errorf("Error completing thread execution: %s: %v", threadExecution.Identifier, err)
+		handleThreadExecutionError(db, threadExecution, err)
 	}
+}
 
+// messageContent returns the content of the response message as the text stored on the execution
+func messageContent(message *models.Message) string {
 	var contentMap map[string]interface{}
 	if err := json.Unmarshal(message.ContentMap, &contentMap); err != nil {
 		logger.GetLogger().Errorf("Error unmarshalling content map: %v", err)
-		models.UpdateThreadExecution(db, &updatedThreadExecution)
-		return
+		return ""
 	}
 	outputContent, ok := contentMap["content"]
 	if !ok {
 		logger.GetLogger().Errorf("Content map does not contain 'content' key")
-		models.UpdateThreadExecution(db, &updatedThreadExecution)
-		return
+		return ""
 	}
-
 	outputContentString, ok := outputContent.(string)
 	if !ok {
 		logger.GetLogger().Errorf("Content is not a string")
 		outputContentString = fmt.Sprintf("%v", outputContent)
 	}
+	return outputContentString
+}
 
-	executionTime := time.Since(threadExecution.CreatedAt).Seconds()
+// setThreadExecutionUsage sets the token usage and cost of an execution from the usage
+// objects saved in the metadata of the response messages of the execution
+func setThreadExecutionUsage(threadExecution *models.ThreadExecution, model string, messageMetadata ...json.RawMessage) {
+	tokenUsage := &usage.TokenUsage{}
+	for _, metadata := range messageMetadata {
+		messageUsage, err := usage.ParseUsage(metadata)
+		if err != nil {
+			logger.GetLogger().Errorf("Error parsing thread execution usage: %s: %v", threadExecution.Identifier, err)
+			return
+		}
+		tokenUsage.PromptTokens += messageUsage.PromptTokens
+		tokenUsage.CompletionTokens += messageUsage.CompletionTokens
+		tokenUsage.CachedTokens += messageUsage.CachedTokens
+	}
 
-	updatedThreadExecution.ExecutionTime = uint(executionTime)
-	updatedThreadExecution.Content = outputContentString
-	models.UpdateThreadExecution(db, &updatedThreadExecution)
+	threadExecution.PromptTokens = tokenUsage.PromptTokens
+	threadExecution.CompletionTokens = tokenUsage.CompletionTokens
+	threadExecution.CachedTokens = tokenUsage.CachedTokens
+
+	cost, ok := usage.Cost(model, tokenUsage)
+	if !ok {
+		logger.GetLogger().Warnf("No price found for model: %s, cost of thread execution %s is not computed", model, threadExecution.Identifier)
+		return
+	}
+	threadExecution.Cost = cost
+}
+
+// CancelThreadExecution cancels a queued or running execution.
+// The in-flight executor call is aborted and the execution won't append an assistant message.
+func CancelThreadExecution(db *gorm.DB, executionID string) error {
+	previousStatus, cancelled, err := models.CancelThreadExecution(db, executionID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error cancelling thread execution: %s: %v", executionID, err)
+		return err
+	}
+	if !cancelled {
+		return fmt.Errorf("%w: thread execution is %s", ErrThreadExecutionFinished, previousStatus)
+	}
+
+	logger.GetLogger().Infof("Thread execution cancelled: %s", executionID)
+
+	if previousStatus == models.ThreadExecutionStatus_QUEUED {
+		// no worker picked it up, so none will notify its webhooks, batch and stream subscribers
+		threadExecution, err := models.GetThreadExecutionByID(db, executionID)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting thread execution: %s: %v", executionID, err)
+			return nil
+		}
+		NotifyThreadExecutionFinished(db, threadExecution)
+		return nil
+	}
+
+	// interrupt the execution right away if it runs in this process,
+	// otherwise the heartbeat of its worker notices the cancellation
+	queue.Cancel(executionID)
+	return nil
 }
 
 func RerunThreadExecution(db *gorm.DB, req *RerunThreadExecutionRequest) (interface{}, error) {
@@ -266,15 +922,37 @@ func RerunThreadExecution(db *gorm.DB, req *RerunThreadExecutionRequest) (interf
 		return nil, fmt.Errorf("thread execution input messages are empty")
 	}
 
+	template, err := models.GetThreadExecutionParamsTemplateByID(db, req.ThreadExecutionParamTemplateID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting thread execution params template: %s: %v", req.ThreadExecutionParamTemplateID, err)
+		return nil, err
+	}
+	if template.ProjectID != threadExecution.ProjectID {
+		return nil, ErrTemplateNotInProject
+	}
+
+	// the input messages were rendered by the execution, only the system prompts are rendered again
+	var variables map[string]interface{}
+	if len(threadExecution.Variables) > 0 {
+		if err := json.Unmarshal(threadExecution.Variables, &variables); err != nil {
+			logger.GetLogger().Errorf("Error unmarshalling variables: %v", err)
+			return nil, err
+		}
+	}
+
 	return ExecuteThread(db, &ExecuteThreadRequest{
-		UserID:                         threadExecution.UserID,
+		UserID:                         req.UserID,
 		ThreadID:                       threadExecution.ThreadID,
 		ThreadExecutionParamTemplateID: req.ThreadExecutionParamTemplateID,
+		FallbackTemplateIDs:            threadExecution.FallbackTemplateIDs,
+		Environment:                    threadExecution.Environment,
 		ThreadExecutionSystemPrompt:    req.SystemPrompt,
 		AppendAssistantResponse:        req.AppendAssistantResponse,
 		Messages:                       messages,
 		FetchMessagesFromThread:        false,
 		ProjectID:                      threadExecution.ProjectID,
 		Tools:                          req.Tools,
+		Variables:                      variables,
+		MessagesRendered:               true,
 	})
 }
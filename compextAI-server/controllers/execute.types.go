@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/burnerlee/compextAI/models"
 )
@@ -10,13 +11,41 @@ type ExecuteThreadRequest struct {
 	UserID                         uint
 	ThreadID                       string
 	ThreadExecutionParamTemplateID string
-	ThreadExecutionSystemPrompt    string
-	AppendAssistantResponse        bool
-	Messages                       []*models.Message
-	FetchMessagesFromThread        bool
-	ProjectID                      string
-	Metadata                       json.RawMessage
-	Tools                          []*models.ExecutionTool
+	// revision of the template the execution params are pinned to, the current revision is used when empty
+	TemplateRevisionID string
+	// templates tried in order when the execution with the template fails
+	FallbackTemplateIDs         []string
+	ThreadExecutionSystemPrompt string
+	AppendAssistantResponse     bool
+	Messages                    []*models.Message
+	FetchMessagesFromThread     bool
+	ProjectID                   string
+	// environment of the execution params, recorded for the usage rollups
+	Environment string
+	Metadata    json.RawMessage
+	Tools       []*models.ExecutionTool
+	// stream the tokens of the execution, see GET /threadexec/{id}/stream
+	Stream bool
+	// url notified once the execution is finished
+	CallbackURL string
+	// variables the system prompt and the template messages are rendered with, see internal/prompts
+	// and isTemplateMessage. The prompts are sent as they are when no variables are given.
+	Variables map[string]interface{}
+	// set when the messages were already rendered by an earlier execution
+	MessagesRendered bool
+	// execution params and their variant the execution is made with, recorded for the variant comparison
+	ThreadExecutionParamsID string
+	Variant                 string
+	// tools of the project run by the server when the model calls them, their schemas are sent along the Tools
+	ProjectTools []*models.ProjectTool
+	// number of rounds of tool calls run by the server before the response is returned as it is
+	MaxToolSteps int
+	// complete the execution with the cached response of an identical request of the project when
+	// there is one, and cache the response otherwise. CacheTTL is how long it is cached for.
+	Cache    bool
+	CacheTTL time.Duration
+	// batch the execution is submitted for, see ScheduleBatch
+	BatchID string
 }
 
 type ExecuteThreadResponse struct {
@@ -31,3 +60,54 @@ type RerunThreadExecutionRequest struct {
 	AppendAssistantResponse        bool
 	Tools                          []*models.ExecutionTool
 }
+
+// threadExecutionJobPayload is stored on the thread execution when it is queued,
+// it holds everything a queue worker needs to run the execution
+type threadExecutionJobPayload struct {
+	SystemPrompt            string        `json:"system_prompt"`
+	AppendAssistantResponse bool          `json:"append_assistant_response"`
+	Stream                  bool          `json:"stream"`
+	Messages                []*jobMessage `json:"messages"`
+	// revisions of the templates of the fallback chain when the execution was queued, keyed by template id
+	TemplateRevisionIDs map[string]string `json:"template_revision_ids"`
+	// the system prompts of the templates are rendered with the variables when they are set,
+	// the messages and the system prompt of the request are rendered when queueing the execution
+	Variables map[string]interface{} `json:"variables"`
+	// project tools run by the server, see runToolStep
+	ProjectToolIDs []string `json:"project_tool_ids"`
+	MaxToolSteps   int      `json:"max_tool_steps"`
+	// set when the response is cached once the execution completes, the ttl is in seconds
+	CacheKey string `json:"cache_key,omitempty"`
+	CacheTTL int    `json:"cache_ttl,omitempty"`
+}
+
+type jobMessage struct {
+	Role         string          `json:"role"`
+	ContentMap   json.RawMessage `json:"content_map"`
+	ToolCallID   string          `json:"tool_call_id"`
+	ToolCalls    json.RawMessage `json:"tool_calls"`
+	FunctionCall json.RawMessage `json:"function_call"`
+	Metadata     json.RawMessage `json:"metadata"`
+}
+
+func newJobMessage(message *models.Message) *jobMessage {
+	return &jobMessage{
+		Role:         message.Role,
+		ContentMap:   message.ContentMap,
+		ToolCallID:   message.ToolCallID,
+		ToolCalls:    message.ToolCalls,
+		FunctionCall: message.FunctionCall,
+		Metadata:     message.Metadata,
+	}
+}
+
+func (m *jobMessage) toMessage() *models.Message {
+	return &models.Message{
+		Role:         m.Role,
+		ContentMap:   m.ContentMap,
+		ToolCallID:   m.ToolCallID,
+		ToolCalls:    m.ToolCalls,
+		FunctionCall: m.FunctionCall,
+		Metadata:     m.Metadata,
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvitationNotPending  = errors.New("invitation is not pending anymore")
+	ErrInvitationNotForUser  = errors.New("invitation was sent to another email")
+	ErrLastOrganizationOwner = errors.New("an organization should have at least one owner")
+)
+
+// CreateOrganization creates the organization with the user as its owner.
+func CreateOrganization(db *gorm.DB, userID uint, name string) (*models.Organization, error) {
+	organization := &models.Organization{
+		Name:   name,
+		UserID: userID,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreateOrganization(tx, organization); err != nil {
+			return err
+		}
+		return models.CreateOrganizationMember(tx, &models.OrganizationMember{
+			OrganizationID: organization.Identifier,
+			UserID:         userID,
+			Role:           models.Role_OWNER,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+// AcceptInvitation adds the user to the project or organization of the invitation,
+// the role of a user who is already a member is replaced by the role of the invitation.
+func AcceptInvitation(db *gorm.DB, invitationID string, user *models.User) (*models.Invitation, error) {
+	invitation, err := models.GetInvitation(db, invitationID)
+	if err != nil {
+		return nil, err
+	}
+	if invitation.Email != user.Email {
+		return nil, ErrInvitationNotForUser
+	}
+	if !invitation.IsPending() {
+		return nil, ErrInvitationNotPending
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		accepted, err := models.MarkInvitationAnswered(tx, invitation.Identifier, true)
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return ErrInvitationNotPending
+		}
+
+		if invitation.OrganizationID != "" {
+			return upsertOrganizationMember(tx, invitation.OrganizationID, user.ID, invitation.Role)
+		}
+		return upsertProjectMember(tx, invitation.ProjectID, user.ID, invitation.Role)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// DeclineInvitation marks the invitation of the user as declined.
+func DeclineInvitation(db *gorm.DB, invitationID string, user *models.User) error {
+	invitation, err := models.GetInvitation(db, invitationID)
+	if err != nil {
+		return err
+	}
+	if invitation.Email != user.Email {
+		return ErrInvitationNotForUser
+	}
+
+	declined, err := models.MarkInvitationAnswered(db, invitation.Identifier, false)
+	if err != nil {
+		return err
+	}
+	if !declined {
+		return ErrInvitationNotPending
+	}
+	return nil
+}
+
+func upsertOrganizationMember(db *gorm.DB, organizationID string, userID uint, role string) error {
+	member, err := models.GetOrganizationMemberByUserID(db, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.CreateOrganizationMember(db, &models.OrganizationMember{
+				OrganizationID: organizationID,
+				UserID:         userID,
+				Role:           role,
+			})
+		}
+		return err
+	}
+	return UpdateOrganizationMemberRole(db, member, role)
+}
+
+func upsertProjectMember(db *gorm.DB, projectID string, userID uint, role string) error {
+	member, err := models.GetProjectMemberByUserID(db, projectID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.CreateProjectMember(db, &models.ProjectMember{
+				ProjectID: projectID,
+				UserID:    userID,
+				Role:      role,
+			})
+		}
+		return err
+	}
+	return models.UpdateProjectMemberRole(db, member.Identifier, role)
+}
+
+// UpdateOrganizationMemberRole changes the role of the member, the last owner of an organization can't be demoted.
+func UpdateOrganizationMemberRole(db *gorm.DB, member *models.OrganizationMember, role string) error {
+	if member.Role == models.Role_OWNER && role != models.Role_OWNER {
+		if err := checkNotLastOrganizationOwner(db, member.OrganizationID); err != nil {
+			return err
+		}
+	}
+	return models.UpdateOrganizationMemberRole(db, member.Identifier, role)
+}
+
+// RemoveOrganizationMember removes the member, the last owner of an organization can't be removed.
+func RemoveOrganizationMember(db *gorm.DB, member *models.OrganizationMember) error {
+	if member.Role == models.Role_OWNER {
+		if err := checkNotLastOrganizationOwner(db, member.OrganizationID); err != nil {
+			return err
+		}
+	}
+	return models.DeleteOrganizationMember(db, member.Identifier)
+}
+
+func checkNotLastOrganizationOwner(db *gorm.DB, organizationID string) error {
+	owners, err := models.CountOrganizationOwners(db, organizationID)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOrganizationOwner
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPromotionSameEnvironment = errors.New("from_environment and to_environment must differ")
+	ErrPromotionNotPending      = errors.New("promotion is not pending anymore")
+	ErrPromotionSelfApproval    = errors.New("a promotion can not be approved by the user who requested it")
+	ErrPromotionAlreadyApproved = errors.New("the user already approved this promotion")
+)
+
+// RequestPromotion records the promotion of the execution params to the target environment. The
+// promotion is applied right away unless the target environment requires approvals.
+func RequestPromotion(db *gorm.DB, req *RequestPromotionRequest) (*models.Promotion, error) {
+	if req.FromEnvironment == req.ToEnvironment {
+		return nil, ErrPromotionSameEnvironment
+	}
+
+	source, err := models.GetThreadExecutionParamsByNameAndEnvironment(db, req.Name, req.FromEnvironment, req.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the revision the source environment runs with, it is what the approvers approve
+	templateRevision, err := getExecutionTemplateRevision(db, source.TemplateID, source.TemplateRevisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := models.GetPromotionRule(db, req.ProjectID, req.ToEnvironment)
+	if err != nil {
+		return nil, err
+	}
+	requiredApprovals := 0
+	if rule != nil {
+		requiredApprovals = rule.RequiredApprovals
+	}
+
+	promotion := &models.Promotion{
+		ProjectID:           req.ProjectID,
+		Name:                req.Name,
+		FromEnvironment:     req.FromEnvironment,
+		ToEnvironment:       req.ToEnvironment,
+		TemplateID:          source.TemplateID,
+		TemplateRevisionID:  templateRevision.Identifier,
+		FallbackTemplateIDs: source.FallbackTemplateIDs,
+		Variants:            source.Variants,
+		Status:              models.PromotionStatus_PENDING,
+		RequiredApprovals:   requiredApprovals,
+		RequestedBy:         req.UserID,
+		Comment:             req.Comment,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreatePromotion(tx, promotion); err != nil {
+			return err
+		}
+		if requiredApprovals > 0 {
+			return nil
+		}
+		return applyPromotion(tx, promotion, req.UserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return models.GetPromotion(db, promotion.Identifier)
+}
+
+// ApprovePromotion records the approval of the user, the promotion is applied
+// once it has all the approvals required when it was requested.
+func ApprovePromotion(db *gorm.DB, promotionID string, userID uint) (*models.Promotion, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		promotion, err := models.LockPromotion(tx, promotionID)
+		if err != nil {
+			return err
+		}
+		if promotion.Status != models.PromotionStatus_PENDING {
+			return ErrPromotionNotPending
+		}
+		if promotion.RequestedBy == userID {
+			return ErrPromotionSelfApproval
+		}
+		for _, approval := range promotion.Approvals {
+			if approval.UserID == userID {
+				return ErrPromotionAlreadyApproved
+			}
+		}
+
+		if err := models.CreatePromotionApproval(tx, &models.PromotionApproval{
+			PromotionID: promotionID,
+			UserID:      userID,
+		}); err != nil {
+			return err
+		}
+
+		if len(promotion.Approvals)+1 < promotion.RequiredApprovals {
+			return nil
+		}
+		return applyPromotion(tx, promotion, promotion.RequestedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return models.GetPromotion(db, promotionID)
+}
+
+func RejectPromotion(db *gorm.DB, promotionID string, userID uint) (*models.Promotion, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		promotion, err := models.LockPromotion(tx, promotionID)
+		if err != nil {
+			return err
+		}
+		if promotion.Status != models.PromotionStatus_PENDING {
+			return ErrPromotionNotPending
+		}
+
+		return models.UpdatePromotion(tx, promotionID, map[string]interface{}{
+			"status":      models.PromotionStatus_REJECTED,
+			"rejected_by": userID,
+			"rejected_at": time.Now(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return models.GetPromotion(db, promotionID)
+}
+
+// applyPromotion copies the template binding of the promotion to the execution params of the
+// target environment, they are created if the environment has none with this name yet. When the
+// promotion required approvals the execution params are pinned to the approved revision of the
+// template, so the later changes to the template do not reach the environment without a promotion.
+func applyPromotion(tx *gorm.DB, promotion *models.Promotion, userID uint) error {
+	templateRevisionID := ""
+	if promotion.RequiredApprovals > 0 {
+		templateRevisionID = promotion.TemplateRevisionID
+	}
+
+	previousTemplateID, previousTemplateRevisionID := "", ""
+	var previousFallbackTemplateIDs models.StringList
+	var previousVariants models.TemplateVariants
+
+	target, err := models.GetThreadExecutionParamsByNameAndEnvironment(tx, promotion.Name, promotion.ToEnvironment, promotion.ProjectID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if _, err := models.CreateThreadExecutionParams(tx, &models.ThreadExecutionParams{
+			UserID:              userID,
+			ProjectID:           promotion.ProjectID,
+			Name:                promotion.Name,
+			Environment:         promotion.ToEnvironment,
+			TemplateID:          promotion.TemplateID,
+			TemplateRevisionID:  templateRevisionID,
+			FallbackTemplateIDs: promotion.FallbackTemplateIDs,
+			Variants:            promotion.Variants,
+		}); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		previousTemplateID = target.TemplateID
+		previousTemplateRevisionID = target.TemplateRevisionID
+		previousFallbackTemplateIDs = target.FallbackTemplateIDs
+		previousVariants = target.Variants
+		if err := models.UpdateThreadExecutionParamsTemplateID(tx, target.Identifier, promotion.TemplateID); err != nil {
+			return err
+		}
+		if err := models.UpdateThreadExecutionParamsTemplateRevisionID(tx, target.Identifier, templateRevisionID); err != nil {
+			return err
+		}
+		if err := models.UpdateThreadExecutionParamsFallbackTemplateIDs(tx, target.Identifier, promotion.FallbackTemplateIDs); err != nil {
+			return err
+		}
+		if err := models.UpdateThreadExecutionParamsVariants(tx, target.Identifier, promotion.Variants); err != nil {
+			return err
+		}
+	}
+
+	return models.UpdatePromotion(tx, promotion.Identifier, map[string]interface{}{
+		"status":                         models.PromotionStatus_APPLIED,
+		"applied_at":                     time.Now(),
+		"previous_template_id":           previousTemplateID,
+		"previous_template_revision_id":  previousTemplateRevisionID,
+		"previous_fallback_template_ids": previousFallbackTemplateIDs,
+		"previous_variants":              previousVariants,
+	})
+}
@@ -0,0 +1,10 @@
+package controllers
+
+type RequestPromotionRequest struct {
+	UserID          uint
+	ProjectID       string
+	Name            string
+	FromEnvironment string
+	ToEnvironment   string
+	Comment         string
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/burnerlee/compextAI/internal/jsonschema"
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	ResponseFormatType_JSON_SCHEMA = "json_schema"
+	ResponseFormatType_JSON_OBJECT = "json_object"
+
+	// re-prompts made for a response which does not match the response format
+	MAX_RESPONSE_FORMAT_RETRIES = 5
+)
+
+var (
+	// ErrResponseFormatMismatch is returned when the response of an execution does not match its response format
+	ErrResponseFormatMismatch = errors.New("response does not match the response format")
+)
+
+// structuredOutput is the response format of a template the responses are validated against,
+// see https://platform.openai.com/docs/guides/structured-outputs
+type structuredOutput struct {
+	// json_schema or json_object
+	Type string
+	// schema of the json_schema response formats
+	Schema json.RawMessage
+}
+
+// getStructuredOutput returns the structured output the response format asks for,
+// nil if the response format does not ask for json
+func getStructuredOutput(responseFormat json.RawMessage) (*structuredOutput, error) {
+	var format struct {
+		Type       string `json:"type"`
+		JsonSchema struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	}
+	trimmed := bytes.TrimSpace(responseFormat)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, nil
+	}
+	if err := json.Unmarshal(trimmed, &format); err != nil {
+		return nil, fmt.Errorf("invalid response format: %v", err)
+	}
+
+	switch format.Type {
+	case ResponseFormatType_JSON_SCHEMA:
+		if len(format.JsonSchema.Schema) == 0 {
+			return nil, errors.New("invalid response format: json_schema.schema is required")
+		}
+		return &structuredOutput{
+			Type:   format.Type,
+			Schema: format.JsonSchema.Schema,
+		}, nil
+	case ResponseFormatType_JSON_OBJECT:
+		return &structuredOutput{
+			Type: format.Type,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// ValidateResponseFormat checks that the response format of a template can be used to validate the responses
+func ValidateResponseFormat(responseFormat json.RawMessage) error {
+	output, err := getStructuredOutput(responseFormat)
+	if err != nil || output == nil || output.Schema == nil {
+		return err
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(output.Schema, &schema); err != nil {
+		return errors.New("invalid response format: json_schema.schema should be a json schema object")
+	}
+	return nil
+}
+
+// parse parses the content of a response and validates it against the response format.
+// It returns the parsed json and the validation errors, which are reported to the model on a re-prompt.
+func (o *structuredOutput) parse(content string) (json.RawMessage, []string, error) {
+	content = trimCodeFence(content)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return nil, []string{fmt.Sprintf("the response is not valid json: %v", err)}, nil
+	}
+
+	var validationErrors []string
+	if o.Schema != nil {
+		var err error
+		validationErrors, err = jsonschema.Validate(o.Schema, value)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if _, ok := value.(map[string]interface{}); !ok {
+		validationErrors = []string{"the response should be a json object"}
+	}
+	if len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+
+	// the json is stored as the model wrote it, keeping the order of its properties
+	var parsed bytes.Buffer
+	if err := json.Compact(&parsed, []byte(content)); err != nil {
+		return nil, nil, err
+	}
+	return parsed.Bytes(), nil, nil
+}
+
+// trimCodeFence removes the markdown code fence models without native structured outputs wrap json in
+func trimCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") || !strings.HasSuffix(content, "```") || len(content) < 6 {
+		return content
+	}
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "```"), "```")
+	// drop the language of the fence, e.g. ```json
+	if newline := strings.IndexByte(content, '\n'); newline >= 0 && !strings.ContainsAny(content[:newline], "{[\"") {
+		content = content[newline+1:]
+	}
+	return strings.TrimSpace(content)
+}
+
+// responseFormatRepromptMessage asks the model to fix a response which does not match the response format
+func responseFormatRepromptMessage(validationErrors []string) (*models.Message, error) {
+	contentMapJson, err := json.Marshal(map[string]interface{}{
+		"content": fmt.Sprintf("Your response does not match the required response format:\n- %s\n"+
+			"Respond again with only the corrected json.", strings.Join(validationErrors, "\n- ")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.Message{
+		Role:       "user",
+		ContentMap: contentMapJson,
+	}, nil
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	EvalScorerType_EXACT_MATCH = "exact_match"
+	EvalScorerType_JSON_SCHEMA = "json_schema"
+	EvalScorerType_REGEX       = "regex"
+	EvalScorerType_LLM_JUDGE   = "llm_judge"
+
+	// score an llm judge has to give for the output to pass, when the scorer does not set it
+	DEFAULT_JUDGE_PASS_THRESHOLD = 0.5
+)
+
+// ValidateEvalScorer checks the configuration of a scorer, the judge template is checked by the caller
+func ValidateEvalScorer(scorer *EvalScorer) error {
+	if scorer.Name == "" {
+		return errors.New("name is required")
+	}
+	switch scorer.Type {
+	case EvalScorerType_EXACT_MATCH:
+	case EvalScorerType_JSON_SCHEMA:
+		var schema map[string]interface{}
+		if err := json.Unmarshal(scorer.Schema, &schema); err != nil {
+			return errors.New("schema should be a json schema object")
+		}
+	case EvalScorerType_REGEX:
+		if scorer.Pattern == "" {
+			return errors.New("pattern is required")
+		}
+		if _, err := regexp.Compile(scorer.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	case EvalScorerType_LLM_JUDGE:
+		if scorer.JudgeTemplateID == "" {
+			return errors.New("judge_template_id is required")
+		}
+		if scorer.Criteria == "" {
+			return errors.New("criteria is required")
+		}
+		if scorer.PassThreshold < 0 || scorer.PassThreshold > 1 {
+			return errors.New("pass_threshold should be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("unsupported type %q, should be one of %s, %s, %s or %s", scorer.Type,
+			EvalScorerType_EXACT_MATCH, EvalScorerType_JSON_SCHEMA, EvalScorerType_REGEX, EvalScorerType_LLM_JUDGE)
+	}
+	return nil
+}
+
+func newEvalScore(scorer string, score float64, passed bool, reason string) *EvalScore {
+	return &EvalScore{
+		Scorer: scorer,
+		Score:  &score,
+		Passed: &passed,
+		Reason: reason,
+	}
+}
+
+// scoreOutput scores the output with a scorer which does not need a judge. A scorer which passes
+// the output scores it 1, 0 otherwise.
+func scoreOutput(scorer *EvalScorer, output, expectedOutput string) *EvalScore {
+	switch scorer.Type {
+	case EvalScorerType_EXACT_MATCH:
+		if expectedOutput == "" {
+			return &EvalScore{Scorer: scorer.Name, Reason: "the dataset item has no expected output"}
+		}
+		if !outputsMatch(output, expectedOutput, scorer.IgnoreCase) {
+			return newEvalScore(scorer.Name, 0, false, "the output does not match the expected output")
+		}
+		return newEvalScore(scorer.Name, 1, true, "")
+	case EvalScorerType_JSON_SCHEMA:
+		schemaOutput := &structuredOutput{Type: ResponseFormatType_JSON_SCHEMA, Schema: scorer.Schema}
+		_, validationErrors, err := schemaOutput.parse(output)
+		if err != nil {
+			return &EvalScore{Scorer: scorer.Name, Reason: err.Error()}
+		}
+		if len(validationErrors) > 0 {
+			return newEvalScore(scorer.Name, 0, false, strings.Join(validationErrors, "; "))
+		}
+		return newEvalScore(scorer.Name, 1, true, "")
+	case EvalScorerType_REGEX:
+		pattern, err := regexp.Compile(scorer.Pattern)
+		if err != nil {
+			return &EvalScore{Scorer: scorer.Name, Reason: fmt.Sprintf("invalid pattern: %v", err)}
+		}
+		if !pattern.MatchString(output) {
+			return newEvalScore(scorer.Name, 0, false, fmt.Sprintf("the output does not match the pattern %q", scorer.Pattern))
+		}
+		return newEvalScore(scorer.Name, 1, true, "")
+	default:
+		return &EvalScore{Scorer: scorer.Name, Reason: fmt.Sprintf("unsupported scorer type: %s", scorer.Type)}
+	}
+}
+
+// outputsMatch compares the output to the expected output, ignoring the surrounding whitespace.
+// Outputs which are both json are compared as json, regardless of formatting and property order.
+func outputsMatch(output, expectedOutput string, ignoreCase bool) bool {
+	output, expectedOutput = strings.TrimSpace(output), strings.TrimSpace(expectedOutput)
+	if ignoreCase {
+		output, expectedOutput = strings.ToLower(output), strings.ToLower(expectedOutput)
+	}
+	if output == expectedOutput {
+		return true
+	}
+
+	var outputValue, expectedValue interface{}
+	if json.Unmarshal([]byte(trimCodeFence(output)), &outputValue) != nil || json.Unmarshal([]byte(expectedOutput), &expectedValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(outputValue, expectedValue)
+}
+
+const judgeSystemPrompt = `You are evaluating the response of an AI assistant to a conversation against the following criteria:
+
+%s
+
+Respond with only a json object with a "score" property between 0 and 1, where 1 means that the response fully meets the criteria, and a "reason" property explaining the score.`
+
+// createJudgeExecution queues the execution of the judge template scoring the output, it is made
+// without a thread, on behalf of the user who created the eval run
+func createJudgeExecution(db *gorm.DB, evalRun *models.EvalRun, scorer *EvalScorer, conversation []*models.Message, expectedOutput, output string) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Conversation:\n")
+	for _, message := range conversation {
+		fmt.Fprintf(&prompt, "%s: %s\n", message.Role, messageText(message))
+	}
+	if expectedOutput != "" {
+		fmt.Fprintf(&prompt, "\nExpected response:\n%s\n", expectedOutput)
+	}
+	fmt.Fprintf(&prompt, "\nResponse to evaluate:\n%s", output)
+
+	contentMapJson, err := json.Marshal(map[string]interface{}{
+		"content": prompt.String(),
+	})
+	if err != nil {
+		return "", err
+	}
+	metadataJson, err := json.Marshal(map[string]interface{}{
+		"eval_run_id": evalRun.Identifier,
+		"eval_scorer": scorer.Name,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	threadExecution, err := ExecuteThread(db, &ExecuteThreadRequest{
+		UserID:                         evalRun.UserID,
+		ThreadID:                       constants.THREAD_IDENTIFIER_FOR_NULL_THREAD,
+		ThreadExecutionParamTemplateID: scorer.JudgeTemplateID,
+		ThreadExecutionSystemPrompt:    fmt.Sprintf(judgeSystemPrompt, scorer.Criteria),
+		Messages: []*models.Message{{
+			Role:       "user",
+			ContentMap: contentMapJson,
+		}},
+		ProjectID: evalRun.ProjectID,
+		Metadata:  metadataJson,
+	})
+	if err != nil {
+		return "", err
+	}
+	return threadExecution.(*models.ThreadExecution).Identifier, nil
+}
+
+// scoreJudgeExecution reads the score given by a finished judge execution
+func scoreJudgeExecution(db *gorm.DB, scorer *EvalScorer, score *EvalScore) error {
+	threadExecution, err := models.GetThreadExecutionByID(db, score.JudgeExecutionID)
+	if err != nil {
+		return err
+	}
+	if threadExecution.Status != models.ThreadExecutionStatus_COMPLETED {
+		score.Reason = fmt.Sprintf("the judge execution is %s", threadExecution.Status)
+		return nil
+	}
+
+	var verdict struct {
+		Score  *float64 `json:"score"`
+		Reason string   `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(trimCodeFence(threadExecution.Content)), &verdict); err != nil || verdict.Score == nil {
+		score.Reason = "the judge did not respond with a score"
+		return nil
+	}
+	if *verdict.Score < 0 || *verdict.Score > 1 {
+		score.Reason = fmt.Sprintf("the judge responded with a score out of range: %v", *verdict.Score)
+		return nil
+	}
+
+	threshold := scorer.PassThreshold
+	if threshold == 0 {
+		threshold = DEFAULT_JUDGE_PASS_THRESHOLD
+	}
+	passed := *verdict.Score >= threshold
+	score.Score = verdict.Score
+	score.Passed = &passed
+	score.Reason = verdict.Reason
+	return nil
+}
+
+// messageText returns the content of a message as text, content which is not a string is returned as json
+func messageText(message *models.Message) string {
+	var contentMap struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(message.ContentMap, &contentMap); err != nil {
+		return ""
+	}
+	var content string
+	if err := json.Unmarshal(contentMap.Content, &content); err == nil {
+		return content
+	}
+	return string(contentMap.Content)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+// fields of a revision which are not settings of the template
+var templateRevisionMetadataFields = []string{"id", "identifier", "created_at", "updated_at", "deleted_at", "template_id", "revision", "user_id", "rolled_back_from"}
+
+// DiffTemplateRevisions returns the settings of the template which differ between the two revisions
+func DiffTemplateRevisions(db *gorm.DB, templateID string, fromRevision, toRevision int) (*TemplateRevisionDiff, error) {
+	from, err := models.GetTemplateRevision(db, templateID, fromRevision)
+	if err != nil {
+		return nil, err
+	}
+	to, err := models.GetTemplateRevision(db, templateID, toRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	fromSettings, err := templateRevisionSettings(from)
+	if err != nil {
+		return nil, err
+	}
+	toSettings, err := templateRevisionSettings(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &TemplateRevisionDiff{
+		TemplateID: templateID,
+		From:       fromRevision,
+		To:         toRevision,
+		Changes:    make(map[string]TemplateRevisionChange),
+	}
+	for field, value := range fromSettings {
+		if !reflect.DeepEqual(value, toSettings[field]) {
+			diff.Changes[field] = TemplateRevisionChange{From: value, To: toSettings[field]}
+		}
+	}
+	return diff, nil
+}
+
+// templateRevisionSettings returns the settings of the revision as they are serialized by the api
+func templateRevisionSettings(revision *models.ThreadExecutionParamsTemplateRevision) (map[string]interface{}, error) {
+	data, err := json.Marshal(revision)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	for _, field := range templateRevisionMetadataFields {
+		delete(settings, field)
+	}
+	return settings, nil
+}
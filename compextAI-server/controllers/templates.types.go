@@ -0,0 +1,14 @@
+package controllers
+
+type TemplateRevisionChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type TemplateRevisionDiff struct {
+	TemplateID string `json:"template_id"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	// changed settings keyed by the field name
+	Changes map[string]TemplateRevisionChange `json:"changes"`
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/tools"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// tool steps run for an execution which does not set max_tool_steps
+	DEFAULT_MAX_TOOL_STEPS = 5
+	MAX_TOOL_STEPS         = 25
+)
+
+var (
+	// ErrToolNameConflict is returned when a tool of the request has the name of a project tool of the execution
+	ErrToolNameConflict = errors.New("tool names must be unique")
+)
+
+// messageToolCall is a tool call of a message, see Message.ToolCalls
+type messageToolCall struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// parseMessageToolCalls returns the tool calls of a message, messages without tool calls store null, {} or []
+func parseMessageToolCalls(toolCallsJson json.RawMessage) ([]*messageToolCall, error) {
+	trimmed := bytes.TrimSpace(toolCallsJson)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, nil
+	}
+	var toolCalls []*messageToolCall
+	if err := json.Unmarshal(trimmed, &toolCalls); err != nil {
+		return nil, err
+	}
+	return toolCalls, nil
+}
+
+// toolCallInput returns the arguments of the call as a json object, the providers encode them as a string
+func toolCallInput(arguments json.RawMessage) json.RawMessage {
+	var encoded string
+	if err := json.Unmarshal(arguments, &encoded); err == nil {
+		arguments = json.RawMessage(encoded)
+	}
+	if len(bytes.TrimSpace(arguments)) == 0 {
+		return json.RawMessage("{}")
+	}
+	return arguments
+}
+
+// serverToolCalls returns the tool calls of the message if the server can run all of them.
+// Nil is returned when the message calls no tool or calls a tool the client has to run.
+func serverToolCalls(message *models.Message, projectTools map[string]*models.ProjectTool) ([]*messageToolCall, error) {
+	toolCalls, err := parseMessageToolCalls(message.ToolCalls)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing tool calls: %v", err)
+	}
+	for _, toolCall := range toolCalls {
+		if _, ok := projectTools[toolCall.Function.Name]; !ok {
+			return nil, nil
+		}
+	}
+	return toolCalls, nil
+}
+
+// hasToolCalls reports whether the message calls a tool
+func hasToolCalls(message *models.Message) bool {
+	toolCalls, err := parseMessageToolCalls(message.ToolCalls)
+	return err == nil && len(toolCalls) > 0
+}
+
+// runToolStep runs the tool calls of the message and returns the tool messages with their results.
+// A failed call is reported to the model as the result of the call, so it can recover from it.
+func runToolStep(ctx context.Context, db *gorm.DB, threadExecutionID string, step *models.ThreadExecutionToolStep, toolCalls []*messageToolCall, projectTools map[string]*models.ProjectTool) ([]*models.Message, error) {
+	toolMessages := make([]*models.Message, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		record := &models.ThreadExecutionToolCallRecord{
+			ToolCallID: toolCall.ID,
+			Name:       toolCall.Function.Name,
+			Input:      toolCallInput(toolCall.Function.Arguments),
+			StartedAt:  time.Now(),
+		}
+		output, err := tools.Invoke(ctx, projectTools[toolCall.Function.Name], &tools.Call{
+			ID:                toolCall.ID,
+			Name:              toolCall.Function.Name,
+			Input:             record.Input,
+			ThreadExecutionID: threadExecutionID,
+		})
+		record.Duration = time.Since(record.StartedAt).Milliseconds()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.GetLogger().Warnf("Tool call failed: %s: %s: %v", threadExecutionID, toolCall.Function.Name, err)
+			record.Error = err.Error()
+			output = fmt.Sprintf("error: %v", err)
+		}
+		record.Output = output
+		step.ToolCalls = append(step.ToolCalls, record)
+
+		contentMapJson, err := json.Marshal(map[string]interface{}{
+			"content": output,
+		})
+		if err != nil {
+			return nil, err
+		}
+		toolMessages = append(toolMessages, &models.Message{
+			Role:       "tool",
+			ToolCallID: toolCall.ID,
+			ContentMap: contentMapJson,
+		})
+	}
+
+	if err := models.AppendThreadExecutionToolStep(db, threadExecutionID, step); err != nil {
+		logger.GetLogger().Errorf("Error recording thread execution tool step: %s: %v", threadExecutionID, err)
+	}
+	return toolMessages, nil
+}
+
+// messageContentString returns the content of a message when it is a string
+func messageContentString(message *models.Message) string {
+	var contentMap struct {
+		Content interface{} `json:"content"`
+	}
+	if err := json.Unmarshal(message.ContentMap, &contentMap); err != nil {
+		return ""
+	}
+	content, _ := contentMap.Content.(string)
+	return content
+}
@@ -2,51 +2,238 @@ package controllers
 
 import (
 	"errors"
+	"time"
 
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/secrets"
 	"github.com/burnerlee/compextAI/models"
 	"github.com/burnerlee/compextAI/utils"
 	"gorm.io/gorm"
 )
 
-func CreateUser(db *gorm.DB, request *CreateUserRequest) (*models.User, error) {
+const (
+	// consecutive failed logins after which the user is locked
+	MAX_FAILED_LOGIN_ATTEMPTS = 5
+	LOGIN_LOCKOUT_DURATION    = 15 * time.Minute
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserLocked         = errors.New("too many failed login attempts, try again later")
+)
+
+// CreateUser creates the user along with the api token it is logged in with, the token is returned
+func CreateUser(db *gorm.DB, request *CreateUserRequest) (*models.User, string, error) {
+	hashedPassword, err := utils.HashPassword(request.Password)
+	if err != nil {
+		return nil, "", err
+	}
 
 	user := &models.User{
 		Base: models.Base{
 			Identifier: request.Username,
 		},
 		Username: request.Username,
-		Password: request.Password,
+		Password: hashedPassword,
 		Email:    request.Email,
 	}
 
-	apiToken, err := utils.GenerateRandomString(32)
+	var apiToken string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreateUser(tx, user); err != nil {
+			return err
+		}
+		apiToken, err = createLoginAPIToken(tx, user.ID)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	return user, apiToken, nil
+}
+
+// Login checks the password of the user and issues a new api token for the session, the token is returned
+func Login(db *gorm.DB, request *LoginRequest) (*models.User, string, error) {
+	user, err := models.GetUserByUsername(db, request.Username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrInvalidCredentials
+		}
+		return nil, "", err
+	}
+
+	if user.IsLocked() {
+		return nil, "", ErrUserLocked
+	}
+
+	if !utils.CheckPassword(user.Password, request.Password) {
+		if err := models.RecordFailedLogin(db, user.ID, MAX_FAILED_LOGIN_ATTEMPTS, time.Now().Add(LOGIN_LOCKOUT_DURATION)); err != nil {
+			logger.GetLogger().Errorf("Error recording failed login for user %s: %v", user.Username, err)
+		}
+		return nil, "", ErrInvalidCredentials
 	}
-	user.APIToken = apiToken
 
-	tx := db.Begin()
-	if err := models.CreateUser(tx, user); err != nil {
-		tx.Rollback()
-		return nil, err
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := models.ResetFailedLogins(db, user.ID); err != nil {
+			logger.GetLogger().Errorf("Error resetting failed logins for user %s: %v", user.Username, err)
+		}
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		return nil, err
+	// users created before the passwords were hashed are migrated on login
+	if !utils.IsPasswordHashed(user.Password) {
+		if err := rehashUserPassword(db, user, request.Password); err != nil {
+			logger.GetLogger().Errorf("Error hashing password for user %s: %v", user.Username, err)
+		}
 	}
 
-	return user, nil
+	apiToken, err := createLoginAPIToken(db, user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, apiToken, nil
 }
 
-func Login(db *gorm.DB, request *LoginRequest) (*models.User, error) {
-	user, err := models.GetUserByUsername(db, request.Username)
+// ChangePassword sets a new password for the user and revokes the api tokens issued by its logins,
+// a new api token is returned.
+func ChangePassword(db *gorm.DB, request *ChangePasswordRequest) (string, error) {
+	user, err := models.GetUserByID(db, request.UserID)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if user.Password != request.Password {
-		return nil, errors.New("invalid password")
+	if !utils.CheckPassword(user.Password, request.CurrentPassword) {
+		return "", ErrInvalidCredentials
+	}
+
+	hashedPassword, err := utils.HashPassword(request.NewPassword)
+	if err != nil {
+		return "", err
+	}
+
+	var apiToken string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := models.UpdateUserCredentials(tx, user.ID, hashedPassword); err != nil {
+			return err
+		}
+		if err := models.RevokeUserLoginAPITokens(tx, user.ID); err != nil {
+			return err
+		}
+		apiToken, err = createLoginAPIToken(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return apiToken, nil
+}
+
+// HashUnhashedPasswords hashes the passwords that are still stored as plain text.
+func HashUnhashedPasswords(db *gorm.DB) error {
+	users, err := models.GetUsersWithUnhashedPasswords(db)
+	if err != nil {
+		return err
 	}
 
-	return user, nil
+	for _, user := range users {
+		if err := rehashUserPassword(db, user, user.Password); err != nil {
+			return err
+		}
+	}
+
+	if len(users) > 0 {
+		logger.GetLogger().Infof("Hashed the passwords of %d users", len(users))
+	}
+	return nil
+}
+
+func rehashUserPassword(db *gorm.DB, user *models.User, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := models.UpdateUserPassword(db, user.ID, hashedPassword); err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+// UpdateUserProviderKeys encrypts and stores the provider keys of the user.
+func UpdateUserProviderKeys(db *gorm.DB, request *UpdateUserProviderKeysRequest) error {
+	user := &models.User{
+		Base: models.Base{
+			ID: request.UserID,
+		},
+		AzureEndpoint: request.AzureEndpoint,
+	}
+
+	var err error
+	if user.OpenAIKey, err = secrets.Encrypt(request.OpenAIKey); err != nil {
+		return err
+	}
+	if user.AnthropicKey, err = secrets.Encrypt(request.AnthropicKey); err != nil {
+		return err
+	}
+	if user.AzureKey, err = secrets.Encrypt(request.AzureKey); err != nil {
+		return err
+	}
+	if user.GoogleServiceAccountCreds, err = secrets.EncryptJSON(request.GoogleServiceAccountCreds); err != nil {
+		return err
+	}
+
+	return models.UpdateUserProviderKeys(db, user)
+}
+
+// EncryptUserProviderKeys encrypts the provider keys stored in plain text and
+// re-wraps the keys encrypted with a master key other than the primary one.
+func EncryptUserProviderKeys(db *gorm.DB) error {
+	users, err := models.GetUsersWithProviderKeys(db)
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, user := range users {
+		update := &models.User{
+			Base: models.Base{
+				ID: user.ID,
+			},
+		}
+		// the keys which can't be re-wrapped are left empty in the update, so they are not updated
+		if secrets.NeedsRewrap(user.OpenAIKey) {
+			if update.OpenAIKey, _, err = rewrapSecret(user.OpenAIKey, "openai key of user "+user.Identifier); err != nil {
+				return err
+			}
+		}
+		if secrets.NeedsRewrap(user.AnthropicKey) {
+			if update.AnthropicKey, _, err = rewrapSecret(user.AnthropicKey, "anthropic key of user "+user.Identifier); err != nil {
+				return err
+			}
+		}
+		if secrets.NeedsRewrap(user.AzureKey) {
+			if update.AzureKey, _, err = rewrapSecret(user.AzureKey, "azure key of user "+user.Identifier); err != nil {
+				return err
+			}
+		}
+		if secrets.NeedsRewrapJSON(user.GoogleServiceAccountCreds) {
+			if update.GoogleServiceAccountCreds, _, err = rewrapSecretJSON(user.GoogleServiceAccountCreds, "google service account credentials of user "+user.Identifier); err != nil {
+				return err
+			}
+		}
+		if update.OpenAIKey == "" && update.AnthropicKey == "" && update.AzureKey == "" && len(update.GoogleServiceAccountCreds) == 0 {
+			continue
+		}
+
+		if err := models.UpdateUserProviderKeys(db, update); err != nil {
+			return err
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		logger.GetLogger().Infof("Encrypted the provider keys of %d users", updated)
+	}
+	return nil
 }
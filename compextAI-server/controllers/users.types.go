@@ -1,5 +1,7 @@
 package controllers
 
+import "encoding/json"
+
 type CreateUserRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -10,3 +12,18 @@ type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+type ChangePasswordRequest struct {
+	UserID          uint
+	CurrentPassword string
+	NewPassword     string
+}
+
+type UpdateUserProviderKeysRequest struct {
+	UserID                    uint
+	OpenAIKey                 string
+	AnthropicKey              string
+	AzureKey                  string
+	AzureEndpoint             string
+	GoogleServiceAccountCreds json.RawMessage
+}
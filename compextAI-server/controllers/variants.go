@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+// SelectTemplateVariant picks the variant of the execution params an execution on the thread runs with,
+// nil if the execution params have no variants. The variant is derived from the thread, so the executions
+// of a thread stay on the same variant until the variants are changed. Executions without a thread are
+// split at random.
+func SelectTemplateVariant(threadExecutionParams *models.ThreadExecutionParams, threadID string) *models.TemplateVariant {
+	totalWeight := 0
+	for _, variant := range threadExecutionParams.Variants {
+		totalWeight += variant.Weight
+	}
+	if totalWeight <= 0 {
+		return nil
+	}
+
+	var point int
+	if threadID == constants.THREAD_IDENTIFIER_FOR_NULL_THREAD {
+		point = rand.Intn(totalWeight)
+	} else {
+		hash := fnv.New64a()
+		hash.Write([]byte(threadExecutionParams.Identifier + ":" + threadID))
+		point = int(hash.Sum64() % uint64(totalWeight))
+	}
+
+	for i := range threadExecutionParams.Variants {
+		variant := &threadExecutionParams.Variants[i]
+		if point < variant.Weight {
+			return variant
+		}
+		point -= variant.Weight
+	}
+	return nil
+}
+
+// PinnedTemplateRevisionID returns the revision the execution params are pinned to for the template,
+// empty when they are not pinned or the template is the one of a variant.
+func PinnedTemplateRevisionID(threadExecutionParams *models.ThreadExecutionParams, templateID string) string {
+	if templateID != threadExecutionParams.TemplateID {
+		return ""
+	}
+	return threadExecutionParams.TemplateRevisionID
+}
+
+// CompareTemplateVariants returns the stats of every variant of the execution params, the variants
+// which are not configured anymore but have executions in the period are listed after the current ones.
+func CompareTemplateVariants(db *gorm.DB, threadExecutionParams *models.ThreadExecutionParams, from, to *time.Time) ([]*VariantComparison, error) {
+	stats, err := models.GetVariantStats(db, threadExecutionParams.Identifier, from, to)
+	if err != nil {
+		return nil, err
+	}
+	statsByVariant := make(map[string]models.VariantStats, len(stats))
+	for _, variantStats := range stats {
+		statsByVariant[variantStats.Variant] = variantStats
+	}
+
+	comparison := make([]*VariantComparison, 0, len(stats))
+	for _, variant := range threadExecutionParams.Variants {
+		variantStats, ok := statsByVariant[variant.Name]
+		if !ok {
+			variantStats = models.VariantStats{Variant: variant.Name}
+		}
+		delete(statsByVariant, variant.Name)
+		comparison = append(comparison, newVariantComparison(variantStats, variant.TemplateID, variant.Weight, true))
+	}
+	for _, variantStats := range stats {
+		if _, ok := statsByVariant[variantStats.Variant]; ok {
+			comparison = append(comparison, newVariantComparison(variantStats, "", 0, false))
+		}
+	}
+	return comparison, nil
+}
+
+func newVariantComparison(stats models.VariantStats, templateID string, weight int, active bool) *VariantComparison {
+	comparison := &VariantComparison{
+		VariantStats: stats,
+		TemplateID:   templateID,
+		Weight:       weight,
+		Active:       active,
+	}
+	if finished := stats.Completed + stats.Failed; finished > 0 {
+		comparison.FailureRate = float64(stats.Failed) / float64(finished)
+	}
+	if stats.Executions > 0 {
+		comparison.AvgPromptTokens = float64(stats.PromptTokens) / float64(stats.Executions)
+		comparison.AvgCompletionTokens = float64(stats.CompletionTokens) / float64(stats.Executions)
+	}
+	return comparison
+}
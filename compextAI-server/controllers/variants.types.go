@@ -0,0 +1,15 @@
+package controllers
+
+import "github.com/burnerlee/compextAI/models"
+
+type VariantComparison struct {
+	models.VariantStats
+	TemplateID string `json:"template_id"`
+	Weight     int    `json:"weight"`
+	// false for variants which were removed from the execution params
+	Active bool `json:"active"`
+	// share of the finished executions which failed
+	FailureRate         float64 `json:"failure_rate"`
+	AvgPromptTokens     float64 `json:"avg_prompt_tokens"`
+	AvgCompletionTokens float64 `json:"avg_completion_tokens"`
+}
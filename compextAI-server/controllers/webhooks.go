@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/internal/webhooks"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+// GetProjectWebhookSecret returns the secret signing the callback url deliveries of the project,
+// the secret is generated the first time it is needed. It is stored encrypted.
+func GetProjectWebhookSecret(db *gorm.DB, projectID string) (string, error) {
+	project, err := models.GetProject(db, projectID)
+	if err != nil {
+		return "", err
+	}
+	if project.WebhookSecret != "" {
+		return secrets.Decrypt(project.WebhookSecret)
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %w", err)
+	}
+	encryptedSecret, err := secrets.Encrypt(secret)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting webhook secret: %w", err)
+	}
+	// another request may have set the secret first, its secret is the one returned
+	storedSecret, err := models.SetProjectWebhookSecret(db, projectID, encryptedSecret)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Decrypt(storedSecret)
+}
+
+// EncryptWebhookSecrets encrypts the webhook secrets stored in plain text and re-wraps
+// the secrets encrypted with a master key other than the primary one.
+func EncryptWebhookSecrets(db *gorm.DB) error {
+	projects, err := models.GetProjectsWithWebhookSecret(db)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		if !secrets.NeedsRewrap(project.WebhookSecret) {
+			continue
+		}
+		rewrappedSecret, ok, err := rewrapSecret(project.WebhookSecret, "webhook secret of project "+project.Identifier)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := models.UpdateProjectWebhookSecret(db, project.Identifier, rewrappedSecret); err != nil {
+			return err
+		}
+	}
+
+	projectWebhooks, err := models.GetAllWebhooks(db)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range projectWebhooks {
+		if !secrets.NeedsRewrap(webhook.Secret) {
+			continue
+		}
+		rewrappedSecret, ok, err := rewrapSecret(webhook.Secret, "secret of webhook "+webhook.Identifier)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := models.UpdateWebhookSecret(db, webhook.Identifier, rewrappedSecret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueThreadExecutionWebhooks creates the deliveries of a finished execution
+// for its callback url and the webhooks of its project
+func enqueueThreadExecutionWebhooks(db *gorm.DB, executionID string) {
+	threadExecution, err := models.GetThreadExecutionByID(db, executionID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting thread execution: %s: %v", executionID, err)
+		return
+	}
+
+	var event string
+	switch threadExecution.Status {
+	case models.ThreadExecutionStatus_COMPLETED:
+		event = models.WebhookEvent_EXECUTION_COMPLETED
+	case models.ThreadExecutionStatus_FAILED:
+		event = models.WebhookEvent_EXECUTION_FAILED
+	case models.ThreadExecutionStatus_CANCELLED:
+		event = models.WebhookEvent_EXECUTION_CANCELLED
+	default:
+		// the execution was not finished, e.g. the worker lost its lease
+		return
+	}
+
+	data := &threadExecutionWebhookData{
+		Identifier:       threadExecution.Identifier,
+		ProjectID:        threadExecution.ProjectID,
+		ThreadID:         threadExecution.ThreadID,
+		Status:           threadExecution.Status,
+		Content:          threadExecution.Content,
+		Role:             threadExecution.Role,
+		Output:           threadExecution.Output,
+		Metadata:         threadExecution.Metadata,
+		Environment:      threadExecution.Environment,
+		Model:            threadExecution.Model,
+		PromptTokens:     threadExecution.PromptTokens,
+		CompletionTokens: threadExecution.CompletionTokens,
+		CachedTokens:     threadExecution.CachedTokens,
+		Cost:             threadExecution.Cost,
+		ExecutionTime:    threadExecution.ExecutionTime,
+		CreatedAt:        threadExecution.CreatedAt,
+	}
+
+	if threadExecution.CallbackURL != "" {
+		// make sure the project has a secret before the delivery is signed
+		if _, err := GetProjectWebhookSecret(db, threadExecution.ProjectID); err != nil {
+			logger.GetLogger().Errorf("Error getting project webhook secret: %s: %v", threadExecution.ProjectID, err)
+		} else {
+			enqueueWebhookDelivery(db, &models.WebhookDelivery{
+				ProjectID:         threadExecution.ProjectID,
+				URL:               threadExecution.CallbackURL,
+				Event:             event,
+				ThreadExecutionID: threadExecution.Identifier,
+			}, data)
+		}
+	}
+
+	projectWebhooks, err := models.GetProjectWebhooks(db, threadExecution.ProjectID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting project webhooks: %s: %v", threadExecution.ProjectID, err)
+		return
+	}
+	for _, webhook := range projectWebhooks {
+		if !webhook.SubscribesTo(event) {
+			continue
+		}
+		enqueueWebhookDelivery(db, &models.WebhookDelivery{
+			ProjectID:         threadExecution.ProjectID,
+			WebhookID:         webhook.Identifier,
+			URL:               webhook.URL,
+			Event:             event,
+			ThreadExecutionID: threadExecution.Identifier,
+		}, data)
+	}
+}
+
+func enqueueWebhookDelivery(db *gorm.DB, delivery *models.WebhookDelivery, data interface{}) {
+	// the payload carries the identifier of the delivery, so it is generated before the delivery is created
+	delivery.Identifier = models.NewWebhookDeliveryIdentifier()
+
+	payloadJson, err := json.Marshal(&WebhookPayload{
+		ID:        delivery.Identifier,
+		Event:     delivery.Event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling webhook payload: %s: %v", delivery.Identifier, err)
+		return
+	}
+	delivery.Payload = payloadJson
+
+	if err := models.CreateWebhookDelivery(db, delivery); err != nil {
+		logger.GetLogger().Errorf("Error creating webhook delivery: %s: %v", delivery.URL, err)
+		return
+	}
+
+	webhooks.Notify()
+}
+
+// ReplayWebhookDelivery queues a new delivery with the payload of a previous one.
+// The payload keeps the id of the original delivery, so receivers can deduplicate it.
+func ReplayWebhookDelivery(db *gorm.DB, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	replay := &models.WebhookDelivery{
+		ProjectID:         delivery.ProjectID,
+		WebhookID:         delivery.WebhookID,
+		URL:               delivery.URL,
+		Event:             delivery.Event,
+		ThreadExecutionID: delivery.ThreadExecutionID,
+		Payload:           delivery.Payload,
+		ReplayOf:          delivery.Identifier,
+	}
+
+	// deliveries to a webhook are replayed to its current url
+	if delivery.WebhookID != "" {
+		webhook, err := models.GetWebhook(db, delivery.WebhookID)
+		if err != nil {
+			logger.GetLogger().Errorf("Error getting webhook: %s: %v", delivery.WebhookID, err)
+			return nil, err
+		}
+		replay.URL = webhook.URL
+	}
+
+	if err := models.CreateWebhookDelivery(db, replay); err != nil {
+		logger.GetLogger().Errorf("Error creating webhook delivery: %v", err)
+		return nil, err
+	}
+
+	webhooks.Notify()
+	return replay, nil
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookPayload is the body of a webhook delivery
+type WebhookPayload struct {
+	// identifier of the delivery, replays keep the identifier of the original delivery
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// threadExecutionWebhookData is the execution sent in the execution events
+type threadExecutionWebhookData struct {
+	Identifier       string          `json:"identifier"`
+	ProjectID        string          `json:"project_id"`
+	ThreadID         string          `json:"thread_id"`
+	Status           string          `json:"status"`
+	Content          string          `json:"content"`
+	Role             string          `json:"role"`
+	Output           json.RawMessage `json:"output"`
+	Metadata         json.RawMessage `json:"metadata"`
+	Environment      string          `json:"environment"`
+	Model            string          `json:"model"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+	CachedTokens     int             `json:"cached_tokens"`
+	Cost             float64         `json:"cost"`
+	ExecutionTime    uint            `json:"execution_time"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
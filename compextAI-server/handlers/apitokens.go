@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	apiTokens, err := models.GetUserAPITokens(s.DB, uint(userID))
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, apiTokens)
+}
+
+func (s *Server) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var request CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.ProjectID != "" {
+		hasAccess, err := utils.CheckProjectAccess(s.DB, request.ProjectID, uint(userID), models.Role_VIEWER)
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !hasAccess {
+			responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+			return
+		}
+	}
+
+	apiToken, token, err := controllers.CreateAPIToken(s.DB, &controllers.CreateAPITokenRequest{
+		UserID:        uint(userID),
+		Name:          request.Name,
+		Scopes:        request.Scopes,
+		ProjectID:     request.ProjectID,
+		ExpiresInDays: request.ExpiresInDays,
+	})
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, &CreateAPITokenResponse{
+		APIToken: apiToken,
+		Token:    token,
+	})
+}
+
+func (s *Server) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	apiTokenID := mux.Vars(r)["id"]
+
+	if apiTokenID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	apiToken, err := models.GetAPIToken(s.DB, apiTokenID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if apiToken.UserID != uint(userID) {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to revoke this api token")
+		return
+	}
+
+	if err := models.RevokeAPIToken(s.DB, apiTokenID); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "API token revoked")
+}
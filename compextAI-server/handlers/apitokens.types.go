@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+var (
+	apiTokenScopes = []string{models.APITokenScope_READ_ONLY, models.APITokenScope_EXECUTE, models.APITokenScope_ADMIN}
+)
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// restricts the token to a single project
+	ProjectID string `json:"project_id"`
+	// the token never expires if not set
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+func (r *CreateAPITokenRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(r.Scopes) == 0 {
+		return fmt.Errorf("scopes are required, scopes should be a list of %v", apiTokenScopes)
+	}
+	for _, scope := range r.Scopes {
+		if !slices.Contains(apiTokenScopes, scope) {
+			return fmt.Errorf("invalid scope %s, scopes should be a list of %v", scope, apiTokenScopes)
+		}
+	}
+	if r.ExpiresInDays < 0 {
+		return errors.New("expires_in_days should not be negative")
+	}
+	return nil
+}
+
+type CreateAPITokenResponse struct {
+	*models.APIToken
+	// only returned when the token is created
+	Token string `json:"token"`
+}
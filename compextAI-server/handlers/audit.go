@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils/responses"
+)
+
+func (s *Server) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+
+	searchFilters := r.URL.Query().Get("filters")
+	var searchFiltersMap map[string]string
+	if searchFilters != "" {
+		searchFilters, err = url.QueryUnescape(searchFilters)
+		if err != nil {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := json.Unmarshal([]byte(searchFilters), &searchFiltersMap); err != nil {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	from, err := parseAuditTime(r.URL.Query().Get("from"))
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseAuditTime(r.URL.Query().Get("to"))
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, total, err := models.GetProjectAuditEvents(s.DB, projectID, searchFiltersMap, from, to, page, limit)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, struct {
+		Events []models.AuditEvent `json:"events"`
+		Total  int                 `json:"total"`
+	}{
+		Events: events,
+		Total:  int(total),
+	})
+}
+
+// parseAuditTime parses the RFC3339 bounds of the audit queries, empty values are zero times
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339", value)
+	}
+	return t, nil
+}
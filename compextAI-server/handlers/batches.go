@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// getBatchWithRole returns the batch of the request if the user has the role in its project,
+// it writes the error response otherwise
+func (s *Server) getBatchWithRole(w http.ResponseWriter, r *http.Request, role string) (*models.Batch, bool) {
+	batchID := mux.Vars(r)["id"]
+	if batchID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return nil, false
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+
+	batch, err := models.GetBatch(s.DB, batchID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "batch not found")
+			return nil, false
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, batch.ProjectID, uint(userID), role)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to access this batch")
+		return nil, false
+	}
+
+	return batch, true
+}
+
+func (s *Server) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var request CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	threadExecutionParam, err := models.GetThreadExecutionParamsByID(s.DB, request.ThreadExecutionParamID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "execution params not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// the batches are listed and accessed through their project
+	if threadExecutionParam.ProjectID == "" {
+		responses.Error(w, http.StatusBadRequest, "the execution params do not belong to a project")
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, threadExecutionParam.ProjectID, uint(userID), models.Role_EXECUTOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to use these execution params")
+		return
+	}
+
+	if len(request.ThreadIDs) > 0 {
+		threadIDs := slices.Clone(request.ThreadIDs)
+		slices.Sort(threadIDs)
+		threadIDs = slices.Compact(threadIDs)
+		count, err := models.CountProjectThreads(s.DB, threadExecutionParam.ProjectID, threadIDs)
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if count != int64(len(threadIDs)) {
+			responses.Error(w, http.StatusBadRequest, fmt.Sprintf("%d of the threads do not exist or do not belong to the project of the execution params", int64(len(threadIDs))-count))
+			return
+		}
+	}
+
+	// fail now rather than on every item of the batch
+	if len(request.ProjectTools) > 0 {
+		if _, err := models.GetProjectToolsByName(s.DB, threadExecutionParam.ProjectID, request.ProjectTools); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				responses.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := controllers.CheckProjectBudgets(s.DB, threadExecutionParam.ProjectID, threadExecutionParam.Environment); err != nil {
+		if errors.Is(err, controllers.ErrBudgetExceeded) {
+			responses.Error(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageSets := make([][]*models.Message, 0, len(request.Messages))
+	for _, messages := range request.Messages {
+		threadMessages, err := newExecutionMessages(messages)
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		messageSets = append(messageSets, threadMessages)
+	}
+
+	metadataJson, err := json.Marshal(request.Metadata)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	batch, err := controllers.CreateBatch(s.DB, &controllers.CreateBatchRequest{
+		UserID:                  uint(userID),
+		ProjectID:               threadExecutionParam.ProjectID,
+		ThreadExecutionParamsID: threadExecutionParam.Identifier,
+		ThreadIDs:               request.ThreadIDs,
+		MessageSets:             messageSets,
+		Concurrency:             request.Concurrency,
+		Options: &controllers.BatchOptions{
+			SystemPrompt:            request.ThreadExecutionSystemPrompt,
+			AppendAssistantResponse: request.AppendAssistantResponse,
+			Tools:                   request.Tools,
+			Metadata:                metadataJson,
+			Variables:               request.Variables,
+			ProjectTools:            request.ProjectTools,
+			MaxToolSteps:            request.MaxToolSteps,
+			Cache:                   request.Cache,
+			CacheTTL:                request.CacheTTL,
+		},
+	})
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, batch)
+}
+
+func (s *Server) ListBatches(w http.ResponseWriter, r *http.Request) {
+	projectName := mux.Vars(r)["projectname"]
+	if projectName == "" {
+		responses.Error(w, http.StatusBadRequest, "projectname parameter is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	batches, err := models.GetProjectBatches(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, batches)
+}
+
+func (s *Server) GetBatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := s.getBatchWithRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	batchStatus, err := controllers.GetBatchStatus(s.DB, batch)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, batchStatus)
+}
+
+// GetBatchResults streams the results of the items of the batch as json lines, in the order of the items.
+// The items which are not finished are listed with their current status.
+func (s *Server) GetBatchResults(w http.ResponseWriter, r *http.Request) {
+	batch, ok := s.getBatchWithRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	// read the first chunk before writing the headers, so a failure is still reported as an error
+	results, err := controllers.GetBatchResults(s.DB, batch, -1, BATCH_RESULTS_CHUNK_SIZE)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.jsonl\"", batch.Identifier))
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for len(results) > 0 {
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				logger.GetLogger().Warnf("Error writing batch results: %s: %v", batch.Identifier, err)
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(results) < BATCH_RESULTS_CHUNK_SIZE {
+			return
+		}
+
+		results, err = controllers.GetBatchResults(s.DB, batch, results[len(results)-1].Index, BATCH_RESULTS_CHUNK_SIZE)
+		if err != nil {
+			// the response is already started, the client sees a truncated file
+			logger.GetLogger().Errorf("Error reading batch results: %s: %v", batch.Identifier, err)
+			return
+		}
+	}
+}
+
+func (s *Server) CancelBatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := s.getBatchWithRole(w, r, models.Role_EXECUTOR)
+	if !ok {
+		return
+	}
+
+	if err := controllers.CancelBatch(s.DB, batch.Identifier); err != nil {
+		if errors.Is(err, controllers.ErrBatchFinished) {
+			responses.Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, BatchStatusResponse{Status: models.BatchStatus_CANCELLED})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	// number of results read from the database at a time while streaming the results of a batch
+	BATCH_RESULTS_CHUNK_SIZE = 500
+)
+
+type CreateBatchRequest struct {
+	ThreadExecutionParamID string `json:"thread_execution_param_id"`
+	// threads executed with their messages, or message sets executed without a thread
+	ThreadIDs []string           `json:"thread_ids"`
+	Messages  [][]*createMessage `json:"messages"`
+	// number of executions of the batch queued or running at the same time
+	Concurrency int `json:"concurrency"`
+
+	// options of every execution of the batch, as for POST /thread/{id}/execute
+	ThreadExecutionSystemPrompt string                  `json:"thread_execution_system_prompt"`
+	AppendAssistantResponse     bool                    `json:"append_assistant_response"`
+	Tools                       []*models.ExecutionTool `json:"tools"`
+	Metadata                    map[string]interface{}  `json:"metadata"`
+	Variables                   map[string]interface{}  `json:"variables"`
+	ProjectTools                []string                `json:"project_tools"`
+	MaxToolSteps                int                     `json:"max_tool_steps"`
+	Cache                       bool                    `json:"cache"`
+	CacheTTL                    int                     `json:"cache_ttl"`
+}
+
+func (r *CreateBatchRequest) Validate() error {
+	if r.ThreadExecutionParamID == "" {
+		return fmt.Errorf("thread_execution_param_id is required")
+	}
+
+	if len(r.ThreadIDs) == 0 && len(r.Messages) == 0 {
+		return fmt.Errorf("thread_ids or messages are required")
+	}
+	if len(r.ThreadIDs) > 0 && len(r.Messages) > 0 {
+		return fmt.Errorf("only one of thread_ids and messages can be provided")
+	}
+	if len(r.ThreadIDs)+len(r.Messages) > controllers.MAX_BATCH_ITEMS {
+		return fmt.Errorf("a batch can have at most %d items", controllers.MAX_BATCH_ITEMS)
+	}
+	for i, threadID := range r.ThreadIDs {
+		if threadID == "" {
+			return fmt.Errorf("thread_ids[%d] is empty", i)
+		}
+	}
+	for i, messages := range r.Messages {
+		if len(messages) == 0 {
+			return fmt.Errorf("messages[%d] is empty", i)
+		}
+		for j, message := range messages {
+			if err := message.Validate(); err != nil {
+				return fmt.Errorf("messages[%d][%d]: %v", i, j, err)
+			}
+		}
+	}
+
+	if r.Concurrency < 0 || r.Concurrency > controllers.MAX_BATCH_CONCURRENCY {
+		return fmt.Errorf("concurrency should be between 0 and %d", controllers.MAX_BATCH_CONCURRENCY)
+	}
+
+	return validateExecutionOptions(r.ProjectTools, r.MaxToolSteps, r.Cache, r.CacheTTL)
+}
+
+type BatchStatusResponse struct {
+	Status string `json:"status"`
+}
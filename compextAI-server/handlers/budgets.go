@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) ListProjectBudgets(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	budgets, err := controllers.GetProjectBudgetStatuses(s.DB, projectID, "")
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, budgets)
+}
+
+func (s *Server) CreateProjectBudget(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	var request CreateProjectBudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// only one budget of each type per environment
+	existingBudgets, err := models.GetProjectBudgets(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, existingBudget := range existingBudgets {
+		if existingBudget.Environment == request.Environment && existingBudget.Type == request.Type {
+			responses.Error(w, http.StatusBadRequest, "a budget of this type already exists for the environment")
+			return
+		}
+	}
+
+	budget := &models.ProjectBudget{
+		UserID:        uint(userID),
+		ProjectID:     projectID,
+		Environment:   request.Environment,
+		Type:          request.Type,
+		MonthlyLimit:  request.MonthlyLimit,
+		SoftThreshold: request.SoftThreshold,
+		HardThreshold: request.HardThreshold,
+	}
+
+	if err := models.CreateProjectBudget(s.DB, budget); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, budget)
+}
+
+func (s *Server) UpdateProjectBudget(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+	budgetID := mux.Vars(r)["budget_id"]
+
+	if projectID == "" || budgetID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and budget id are required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	var request UpdateProjectBudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existingBudget, err := models.GetProjectBudget(s.DB, budgetID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if existingBudget.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "budget does not belong to this project")
+		return
+	}
+
+	// validate the thresholds the budget will have after the update
+	softThreshold, hardThreshold := existingBudget.SoftThreshold, existingBudget.HardThreshold
+	if request.SoftThreshold != 0 {
+		softThreshold = request.SoftThreshold
+	}
+	if request.HardThreshold != 0 {
+		hardThreshold = request.HardThreshold
+	}
+	if err := validateBudgetThresholds(softThreshold, hardThreshold); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := models.UpdateProjectBudget(s.DB, &models.ProjectBudget{
+		Base: models.Base{
+			Identifier: existingBudget.Identifier,
+		},
+		MonthlyLimit:  request.MonthlyLimit,
+		SoftThreshold: request.SoftThreshold,
+		HardThreshold: request.HardThreshold,
+	}); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "budget updated")
+}
+
+func (s *Server) DeleteProjectBudget(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+	budgetID := mux.Vars(r)["budget_id"]
+
+	if projectID == "" || budgetID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and budget id are required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	existingBudget, err := models.GetProjectBudget(s.DB, budgetID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if existingBudget.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "budget does not belong to this project")
+		return
+	}
+
+	if err := models.DeleteProjectBudget(s.DB, budgetID); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "budget deleted")
+}
+
+func (s *Server) ListBudgetEvents(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	events, err := models.GetBudgetEvents(s.DB, projectID, BUDGET_EVENTS_LIMIT)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	DEFAULT_BUDGET_SOFT_THRESHOLD = 0.8
+	DEFAULT_BUDGET_HARD_THRESHOLD = 1.0
+
+	// number of budget events returned by the events endpoint
+	BUDGET_EVENTS_LIMIT = 100
+)
+
+type CreateProjectBudgetRequest struct {
+	// leave empty for a budget on all the environments of the project
+	Environment   string  `json:"environment"`
+	Type          string  `json:"type"`
+	MonthlyLimit  float64 `json:"monthly_limit"`
+	SoftThreshold float64 `json:"soft_threshold"`
+	HardThreshold float64 `json:"hard_threshold"`
+}
+
+func (r *CreateProjectBudgetRequest) Validate() error {
+	if r.Type != models.BudgetType_TOKENS && r.Type != models.BudgetType_COST {
+		return fmt.Errorf("type should be one of %s, %s", models.BudgetType_TOKENS, models.BudgetType_COST)
+	}
+	if r.MonthlyLimit <= 0 {
+		return errors.New("monthly_limit should be greater than 0")
+	}
+	if r.SoftThreshold == 0 {
+		r.SoftThreshold = DEFAULT_BUDGET_SOFT_THRESHOLD
+	}
+	if r.HardThreshold == 0 {
+		r.HardThreshold = DEFAULT_BUDGET_HARD_THRESHOLD
+	}
+	return validateBudgetThresholds(r.SoftThreshold, r.HardThreshold)
+}
+
+type UpdateProjectBudgetRequest struct {
+	MonthlyLimit  float64 `json:"monthly_limit"`
+	SoftThreshold float64 `json:"soft_threshold"`
+	HardThreshold float64 `json:"hard_threshold"`
+}
+
+func (r *UpdateProjectBudgetRequest) Validate() error {
+	if r.MonthlyLimit < 0 {
+		return errors.New("monthly_limit should be greater than 0")
+	}
+	if r.SoftThreshold < 0 || r.HardThreshold < 0 {
+		return errors.New("thresholds should be greater than 0")
+	}
+	return nil
+}
+
+func validateBudgetThresholds(softThreshold, hardThreshold float64) error {
+	if softThreshold <= 0 || softThreshold > 1 {
+		return errors.New("soft_threshold should be between 0 and 1")
+	}
+	if hardThreshold <= 0 {
+		return errors.New("hard_threshold should be greater than 0")
+	}
+	if softThreshold > hardThreshold {
+		return errors.New("soft_threshold should not be greater than hard_threshold")
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils/responses"
+)
+
+// InvalidateProjectResponseCache removes the cached responses of the project,
+// the next executions of the project which opt in to the cache call the providers again
+func (s *Server) InvalidateProjectResponseCache(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	if err := controllers.InvalidateProjectResponseCache(r.Context(), projectID); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "Response cache invalidated")
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) ListProviderCredentials(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	credentials, err := models.GetProviderCredentials(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := make([]*ProviderCredentialResponse, 0, len(credentials))
+	for i := range credentials {
+		response = append(response, newProviderCredentialResponse(&credentials[i]))
+	}
+
+	responses.JSON(w, http.StatusOK, response)
+}
+
+func (s *Server) CreateProviderCredential(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	var request CreateProviderCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// only one credential of each provider per environment
+	existingCredentials, err := models.GetProviderCredentials(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, existingCredential := range existingCredentials {
+		if existingCredential.Environment == request.Environment && existingCredential.Provider == request.Provider {
+			responses.Error(w, http.StatusBadRequest, "a credential of this provider already exists for the environment")
+			return
+		}
+	}
+
+	credential := &models.ProviderCredential{
+		UserID:      uint(userID),
+		ProjectID:   projectID,
+		Environment: request.Environment,
+		Provider:    request.Provider,
+		Key:         request.Key,
+		Endpoint:    request.Endpoint,
+	}
+
+	if err := controllers.CreateProviderCredential(s.DB, credential); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, newProviderCredentialResponse(credential))
+}
+
+func (s *Server) UpdateProviderCredential(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+	credentialID := mux.Vars(r)["credential_id"]
+
+	if projectID == "" || credentialID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and credential id are required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	var request UpdateProviderCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existingCredential, err := models.GetProviderCredential(s.DB, credentialID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if existingCredential.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "credential does not belong to this project")
+		return
+	}
+
+	if err := validateCredentialKey(existingCredential.Provider, request.Key); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := controllers.UpdateProviderCredential(s.DB, &models.ProviderCredential{
+		Base: models.Base{
+			Identifier: existingCredential.Identifier,
+		},
+		Key:      request.Key,
+		Endpoint: request.Endpoint,
+	}); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "credential updated")
+}
+
+func (s *Server) DeleteProviderCredential(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+	credentialID := mux.Vars(r)["credential_id"]
+
+	if projectID == "" || credentialID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and credential id are required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	existingCredential, err := models.GetProviderCredential(s.DB, credentialID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if existingCredential.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "credential does not belong to this project")
+		return
+	}
+
+	if err := models.DeleteProviderCredential(s.DB, credentialID); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "credential deleted")
+}
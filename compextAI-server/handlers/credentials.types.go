@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/models"
+)
+
+var (
+	credentialProviders = []string{models.CredentialProvider_OPENAI, models.CredentialProvider_ANTHROPIC, models.CredentialProvider_AZURE, models.CredentialProvider_GOOGLE}
+)
+
+type CreateProviderCredentialRequest struct {
+	// leave empty for a credential on all the environments of the project
+	Environment string `json:"environment"`
+	Provider    string `json:"provider"`
+	// the service account json for google credentials
+	Key      string `json:"key"`
+	Endpoint string `json:"endpoint"`
+}
+
+func (r *CreateProviderCredentialRequest) Validate() error {
+	if !slices.Contains(credentialProviders, r.Provider) {
+		return fmt.Errorf("provider should be one of %v", credentialProviders)
+	}
+	if r.Key == "" {
+		return errors.New("key is required")
+	}
+	if r.Provider == models.CredentialProvider_AZURE && r.Endpoint == "" {
+		return errors.New("endpoint is required for azure credentials")
+	}
+	return validateCredentialKey(r.Provider, r.Key)
+}
+
+type UpdateProviderCredentialRequest struct {
+	Key      string `json:"key"`
+	Endpoint string `json:"endpoint"`
+}
+
+func (r *UpdateProviderCredentialRequest) Validate() error {
+	if r.Key == "" && r.Endpoint == "" {
+		return errors.New("key or endpoint is required")
+	}
+	return nil
+}
+
+func validateCredentialKey(provider, key string) error {
+	if provider == models.CredentialProvider_GOOGLE && key != "" && !json.Valid([]byte(key)) {
+		return errors.New("key should be the service account json for google credentials")
+	}
+	return nil
+}
+
+// the key of the credential is masked, only its last characters are returned
+type ProviderCredentialResponse struct {
+	models.ProviderCredential
+	Key string `json:"key"`
+}
+
+func newProviderCredentialResponse(credential *models.ProviderCredential) *ProviderCredentialResponse {
+	return &ProviderCredentialResponse{
+		ProviderCredential: *credential,
+		Key:                secrets.Mask(credential.Key),
+	}
+}
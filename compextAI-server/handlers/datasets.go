@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func (s *Server) ListDatasets(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	datasets, err := models.GetProjectDatasets(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, datasets)
+}
+
+func (s *Server) CreateDataset(w http.ResponseWriter, r *http.Request) {
+	projectID, userID, ok := s.checkProjectRole(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	var request CreateDatasetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := models.GetDatasetByName(s.DB, projectID, request.Name); err == nil {
+		responses.Error(w, http.StatusConflict, "a dataset with this name already exists in the project")
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items, err := newDatasetItems(request.Items)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	dataset, err := models.CreateDataset(s.DB, &models.Dataset{
+		UserID:      userID,
+		ProjectID:   projectID,
+		Name:        request.Name,
+		Description: request.Description,
+	}, items)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, DatasetResponse{
+		Dataset: dataset,
+		Items:   items,
+	})
+}
+
+func (s *Server) GetDataset(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := s.getDataset(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	items, err := models.GetDatasetItems(s.DB, dataset.Identifier)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, DatasetResponse{
+		Dataset: dataset,
+		Items:   items,
+	})
+}
+
+func (s *Server) AddDatasetItems(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := s.getDataset(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	var request AddDatasetItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	count, err := models.CountDatasetItems(s.DB, dataset.Identifier)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if count+int64(len(request.Items)) > controllers.MAX_DATASET_ITEMS {
+		responses.Error(w, http.StatusBadRequest, fmt.Sprintf("a dataset can have at most %d items, it has %d", controllers.MAX_DATASET_ITEMS, count))
+		return
+	}
+
+	items, err := newDatasetItems(request.Items)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := models.AddDatasetItems(s.DB, dataset.Identifier, items); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, items)
+}
+
+func (s *Server) DeleteDatasetItem(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := s.getDataset(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteDatasetItem(s.DB, dataset.Identifier, mux.Vars(r)["item_id"]); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "dataset item not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "dataset item deleted")
+}
+
+func (s *Server) DeleteDataset(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := s.getDataset(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteDataset(s.DB, dataset.Identifier); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "dataset deleted")
+}
+
+// getDataset loads the dataset of the request after checking the role of the user in its project
+func (s *Server) getDataset(w http.ResponseWriter, r *http.Request, role string) (*models.Dataset, bool) {
+	projectID, _, ok := s.checkProjectRole(w, r, role)
+	if !ok {
+		return nil, false
+	}
+
+	dataset, err := models.GetDataset(s.DB, projectID, mux.Vars(r)["dataset_id"])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "dataset not found")
+			return nil, false
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return dataset, true
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+)
+
+type createDatasetItem struct {
+	// input of the item, executed without a thread
+	Messages []*createMessage `json:"messages"`
+	// compared to the outputs by the scorers which need it
+	ExpectedOutput string                 `json:"expected_output"`
+	Metadata       map[string]interface{} `json:"metadata"`
+}
+
+func (i *createDatasetItem) Validate() error {
+	if len(i.Messages) == 0 {
+		return errors.New("messages are required")
+	}
+	for j, message := range i.Messages {
+		if err := message.Validate(); err != nil {
+			return fmt.Errorf("messages[%d]: %v", j, err)
+		}
+	}
+	return nil
+}
+
+func validateDatasetItems(items []*createDatasetItem) error {
+	if len(items) > controllers.MAX_DATASET_ITEMS {
+		return fmt.Errorf("a dataset can have at most %d items", controllers.MAX_DATASET_ITEMS)
+	}
+	for i, item := range items {
+		if item == nil {
+			return fmt.Errorf("items[%d] is empty", i)
+		}
+		if err := item.Validate(); err != nil {
+			return fmt.Errorf("items[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// newDatasetItems returns the models of the items, their messages are stored as they were given
+func newDatasetItems(items []*createDatasetItem) ([]*models.DatasetItem, error) {
+	datasetItems := make([]*models.DatasetItem, 0, len(items))
+	for _, item := range items {
+		messagesJson, err := json.Marshal(item.Messages)
+		if err != nil {
+			return nil, err
+		}
+		metadataJson, err := json.Marshal(item.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		datasetItems = append(datasetItems, &models.DatasetItem{
+			Messages:       messagesJson,
+			ExpectedOutput: item.ExpectedOutput,
+			Metadata:       metadataJson,
+		})
+	}
+	return datasetItems, nil
+}
+
+type CreateDatasetRequest struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Items       []*createDatasetItem `json:"items"`
+}
+
+func (r *CreateDatasetRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	return validateDatasetItems(r.Items)
+}
+
+type AddDatasetItemsRequest struct {
+	Items []*createDatasetItem `json:"items"`
+}
+
+func (r *AddDatasetItemsRequest) Validate() error {
+	if len(r.Items) == 0 {
+		return errors.New("items are required")
+	}
+	return validateDatasetItems(r.Items)
+}
+
+type DatasetResponse struct {
+	*models.Dataset
+	Items []*models.DatasetItem `json:"items"`
+}
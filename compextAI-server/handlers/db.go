@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/controllers"
 	"github.com/burnerlee/compextAI/internal/logger"
 	"github.com/burnerlee/compextAI/models"
 	"github.com/joho/godotenv"
@@ -43,10 +44,38 @@ func InitDB() (*gorm.DB, error) {
 }
 
 func MigrateDB(db *gorm.DB) error {
-	if err := db.AutoMigrate(&models.Project{}, &models.Message{}, &models.Thread{}, &models.User{}, &models.ThreadExecution{}, &models.ThreadExecutionParams{}); err != nil {
+	if err := db.AutoMigrate(&models.Project{}, &models.Message{}, &models.Thread{}, &models.User{}, &models.ThreadExecution{}, &models.ThreadExecutionParams{}, &models.ThreadExecutionParamsTemplate{}, &models.ProjectBudget{}, &models.BudgetEvent{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.APIToken{}, &models.ProviderCredential{}, &models.Organization{}, &models.OrganizationMember{}, &models.ProjectMember{}, &models.Invitation{}, &models.AuditEvent{}, &models.ThreadExecutionParamsTemplateRevision{}, &models.Promotion{}, &models.PromotionApproval{}, &models.PromotionRule{}, &models.ProjectTool{}, &models.ResponseCacheEntry{}, &models.Batch{}, &models.BatchItem{}, &models.Dataset{}, &models.DatasetItem{}, &models.EvalRun{}, &models.EvalResult{}); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if err := models.EnsureAuditEventsAppendOnly(db); err != nil {
+		return fmt.Errorf("failed to make audit events append only: %w", err)
+	}
+
+	if err := controllers.HashUnhashedPasswords(db); err != nil {
+		return fmt.Errorf("failed to hash user passwords: %w", err)
+	}
+
+	if err := controllers.MigrateLegacyAPITokens(db); err != nil {
+		return fmt.Errorf("failed to migrate legacy api tokens: %w", err)
+	}
+
+	if err := controllers.EncryptUserProviderKeys(db); err != nil {
+		return fmt.Errorf("failed to encrypt user provider keys: %w", err)
+	}
+
+	if err := controllers.RewrapProviderCredentials(db); err != nil {
+		return fmt.Errorf("failed to rewrap provider credentials: %w", err)
+	}
+
+	if err := controllers.EncryptWebhookSecrets(db); err != nil {
+		return fmt.Errorf("failed to encrypt webhook secrets: %w", err)
+	}
+
+	if err := models.CreateMissingTemplateRevisions(db); err != nil {
+		return fmt.Errorf("failed to create template revisions: %w", err)
+	}
+
 	adminUser, err := models.GetUserByUsername(db, "admin")
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func (s *Server) ListEvalRuns(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	evalRuns, err := models.GetProjectEvalRuns(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, evalRuns)
+}
+
+func (s *Server) CreateEvalRun(w http.ResponseWriter, r *http.Request) {
+	projectID, userID, ok := s.checkProjectRole(w, r, models.Role_EXECUTOR)
+	if !ok {
+		return
+	}
+
+	var request CreateEvalRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dataset, err := models.GetDataset(s.DB, projectID, request.DatasetID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "dataset not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the templates executing the items and judging the outputs have to belong to the project
+	templateIDs := slices.Clone(request.TemplateIDs)
+	for _, scorer := range request.Scorers {
+		if scorer.JudgeTemplateID != "" && !slices.Contains(templateIDs, scorer.JudgeTemplateID) {
+			templateIDs = append(templateIDs, scorer.JudgeTemplateID)
+		}
+	}
+	for _, templateID := range templateIDs {
+		template, err := models.GetThreadExecutionParamsTemplateByID(s.DB, templateID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				responses.Error(w, http.StatusBadRequest, fmt.Sprintf("template %s not found", templateID))
+				return
+			}
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if template.ProjectID != projectID {
+			responses.Error(w, http.StatusBadRequest, fmt.Sprintf("template %s does not belong to this project", templateID))
+			return
+		}
+	}
+
+	items, err := models.GetDatasetItems(s.DB, dataset.Identifier)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(items) == 0 {
+		responses.Error(w, http.StatusBadRequest, "the dataset has no items")
+		return
+	}
+
+	if err := controllers.CheckProjectBudgets(s.DB, projectID, ""); err != nil {
+		if errors.Is(err, controllers.ErrBudgetExceeded) {
+			responses.Error(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageSets := make([][]*models.Message, 0, len(items))
+	for _, item := range items {
+		var messages []*createMessage
+		if err := json.Unmarshal(item.Messages, &messages); err != nil {
+			responses.Error(w, http.StatusInternalServerError, fmt.Sprintf("error reading the messages of dataset item %s: %v", item.Identifier, err))
+			return
+		}
+		threadMessages, err := newExecutionMessages(messages)
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		messageSets = append(messageSets, threadMessages)
+	}
+
+	evalRun, err := controllers.CreateEvalRun(s.DB, &controllers.CreateEvalRunRequest{
+		UserID:      userID,
+		ProjectID:   projectID,
+		Name:        request.Name,
+		DatasetID:   dataset.Identifier,
+		Items:       items,
+		MessageSets: messageSets,
+		TemplateIDs: request.TemplateIDs,
+		Scorers:     request.Scorers,
+		Concurrency: request.Concurrency,
+	})
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, evalRun)
+}
+
+func (s *Server) GetEvalRun(w http.ResponseWriter, r *http.Request) {
+	evalRun, ok := s.getEvalRun(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	summary, err := controllers.GetEvalRunSummary(s.DB, evalRun)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, summary)
+}
+
+// GetEvalRunResults returns the results of the templates of the eval run side by side, by dataset item
+func (s *Server) GetEvalRunResults(w http.ResponseWriter, r *http.Request) {
+	evalRun, ok := s.getEvalRun(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = DEFAULT_EVAL_RESULTS_LIMIT
+	}
+	if limit > MAX_EVAL_RESULTS_LIMIT {
+		limit = MAX_EVAL_RESULTS_LIMIT
+	}
+
+	items, err := controllers.CompareEvalRun(s.DB, evalRun, page, limit)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, EvalResultsResponse{
+		Items: items,
+		Total: evalRun.Total,
+	})
+}
+
+func (s *Server) CancelEvalRun(w http.ResponseWriter, r *http.Request) {
+	evalRun, ok := s.getEvalRun(w, r, models.Role_EXECUTOR)
+	if !ok {
+		return
+	}
+
+	if err := controllers.CancelEvalRun(s.DB, evalRun.Identifier); err != nil {
+		if errors.Is(err, controllers.ErrEvalRunFinished) {
+			responses.Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "eval run cancelled")
+}
+
+// getEvalRun loads the eval run of the request after checking the role of the user in its project
+func (s *Server) getEvalRun(w http.ResponseWriter, r *http.Request, role string) (*models.EvalRun, bool) {
+	projectID, _, ok := s.checkProjectRole(w, r, role)
+	if !ok {
+		return nil, false
+	}
+
+	evalRun, err := models.GetEvalRun(s.DB, projectID, mux.Vars(r)["eval_id"])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "eval run not found")
+			return nil, false
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return evalRun, true
+}
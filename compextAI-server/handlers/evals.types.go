@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/burnerlee/compextAI/controllers"
+)
+
+const (
+	// dataset items of a page of the comparison of the results of an eval run
+	DEFAULT_EVAL_RESULTS_LIMIT = 20
+	MAX_EVAL_RESULTS_LIMIT     = 100
+)
+
+type CreateEvalRunRequest struct {
+	Name      string `json:"name"`
+	DatasetID string `json:"dataset_id"`
+	// templates the items of the dataset are executed with, compared side by side
+	TemplateIDs []string                  `json:"template_ids"`
+	Scorers     []*controllers.EvalScorer `json:"scorers"`
+	// number of executions of every template queued or running at the same time
+	Concurrency int `json:"concurrency"`
+}
+
+func (r *CreateEvalRunRequest) Validate() error {
+	if r.DatasetID == "" {
+		return errors.New("dataset_id is required")
+	}
+
+	if len(r.TemplateIDs) == 0 {
+		return errors.New("template_ids are required")
+	}
+	if len(r.TemplateIDs) > controllers.MAX_EVAL_TEMPLATES {
+		return fmt.Errorf("an eval run can have at most %d templates", controllers.MAX_EVAL_TEMPLATES)
+	}
+	for i, templateID := range r.TemplateIDs {
+		if templateID == "" {
+			return fmt.Errorf("template_ids[%d] is empty", i)
+		}
+		if slices.Contains(r.TemplateIDs[:i], templateID) {
+			return fmt.Errorf("template_ids[%d] is a duplicate", i)
+		}
+	}
+
+	if len(r.Scorers) > controllers.MAX_EVAL_SCORERS {
+		return fmt.Errorf("an eval run can have at most %d scorers", controllers.MAX_EVAL_SCORERS)
+	}
+	scorerNames := make(map[string]bool, len(r.Scorers))
+	for i, scorer := range r.Scorers {
+		if scorer == nil {
+			return fmt.Errorf("scorers[%d] is empty", i)
+		}
+		if err := controllers.ValidateEvalScorer(scorer); err != nil {
+			return fmt.Errorf("scorers[%d]: %v", i, err)
+		}
+		if scorerNames[scorer.Name] {
+			return fmt.Errorf("scorers[%d]: scorer names must be unique", i)
+		}
+		scorerNames[scorer.Name] = true
+	}
+
+	if r.Concurrency < 0 || r.Concurrency > controllers.MAX_BATCH_CONCURRENCY {
+		return fmt.Errorf("concurrency should be between 0 and %d", controllers.MAX_BATCH_CONCURRENCY)
+	}
+	return nil
+}
+
+type EvalResultsResponse struct {
+	Items []*controllers.EvalComparisonItem `json:"items"`
+	Total int                               `json:"total"`
+}
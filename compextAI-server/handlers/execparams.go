@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"gorm.io/gorm"
 
+	"github.com/burnerlee/compextAI/controllers"
 	"github.com/burnerlee/compextAI/models"
 	"github.com/burnerlee/compextAI/utils"
 	"github.com/burnerlee/compextAI/utils/responses"
@@ -25,13 +28,13 @@ func (s *Server) ListThreadExecutionParams(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	executionParams, err := models.GetAllThreadExecutionParams(s.DB, uint(userID), projectID)
+	executionParams, err := models.GetAllThreadExecutionParams(s.DB, projectID)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -40,20 +43,25 @@ func (s *Server) ListThreadExecutionParams(w http.ResponseWriter, r *http.Reques
 	response := make(ExecuteParamsResponse, 0)
 	for _, executionParam := range executionParams {
 		response = append(response, &squashedThreadExecutionParams{
-			ProjectID:           executionParam.ProjectID,
-			Identifier:          executionParam.Identifier,
-			Name:                executionParam.Name,
-			Environment:         executionParam.Environment,
-			TemplateID:          executionParam.TemplateID,
-			Model:               executionParam.Template.Model,
-			Temperature:         executionParam.Template.Temperature,
-			Timeout:             executionParam.Template.Timeout,
-			MaxTokens:           executionParam.Template.MaxTokens,
-			MaxCompletionTokens: executionParam.Template.MaxCompletionTokens,
-			MaxOutputTokens:     executionParam.Template.MaxOutputTokens,
-			TopP:                executionParam.Template.TopP,
-			ResponseFormat:      executionParam.Template.ResponseFormat,
-			SystemPrompt:        executionParam.Template.SystemPrompt,
+			ProjectID:             executionParam.ProjectID,
+			Identifier:            executionParam.Identifier,
+			Name:                  executionParam.Name,
+			Environment:           executionParam.Environment,
+			TemplateID:            executionParam.TemplateID,
+			TemplateRevisionID:    executionParam.TemplateRevisionID,
+			Model:                 executionParam.Template.Model,
+			Temperature:           executionParam.Template.Temperature,
+			Timeout:               executionParam.Template.Timeout,
+			MaxTokens:             executionParam.Template.MaxTokens,
+			MaxCompletionTokens:   executionParam.Template.MaxCompletionTokens,
+			MaxOutputTokens:       executionParam.Template.MaxOutputTokens,
+			TopP:                  executionParam.Template.TopP,
+			ResponseFormat:        executionParam.Template.ResponseFormat,
+			SystemPrompt:          executionParam.Template.SystemPrompt,
+			RetryPolicy:           executionParam.Template.RetryPolicy,
+			ResponseFormatRetries: executionParam.Template.ResponseFormatRetries,
+			FallbackTemplateIDs:   executionParam.FallbackTemplateIDs,
+			Variants:              executionParam.Variants,
 		})
 	}
 
@@ -77,14 +85,18 @@ func (s *Server) CreateThreadExecutionParams(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if !s.checkEnvironmentAllowsChanges(w, projectID, request.Environment) {
+		return
+	}
+
 	// checking for existing execution params with the same name
-	_, err = models.GetThreadExecutionParamsByUserIDAndNameAndEnvironment(s.DB, uint(userID), request.Name, request.Environment, projectID)
+	_, err = models.GetThreadExecutionParamsByNameAndEnvironment(s.DB, request.Name, request.Environment, projectID)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// no existing execution params with the same name
@@ -97,12 +109,23 @@ func (s *Server) CreateThreadExecutionParams(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if err := s.checkFallbackTemplatesAccess(request.FallbackTemplateIDs, uint(userID)); err != nil {
+		responses.Error(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := s.checkVariantTemplatesAccess(request.Variants, uint(userID)); err != nil {
+		responses.Error(w, http.StatusForbidden, err.Error())
+		return
+	}
+
 	executionParams := models.ThreadExecutionParams{
-		UserID:      uint(userID),
-		ProjectID:   projectID,
-		Name:        request.Name,
-		Environment: request.Environment,
-		TemplateID:  request.TemplateID,
+		UserID:              uint(userID),
+		ProjectID:           projectID,
+		Name:                request.Name,
+		Environment:         request.Environment,
+		TemplateID:          request.TemplateID,
+		FallbackTemplateIDs: request.FallbackTemplateIDs,
+		Variants:            request.Variants,
 	}
 
 	executionParamsCreated, err := models.CreateThreadExecutionParams(s.DB, &executionParams)
@@ -131,7 +154,7 @@ func (s *Server) GetThreadExecutionParamsByNameAndEnv(w http.ResponseWriter, r *
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -139,26 +162,31 @@ func (s *Server) GetThreadExecutionParamsByNameAndEnv(w http.ResponseWriter, r *
 
 	// no need to check access, because the user can only get his own execution params
 
-	executionParams, err := models.GetThreadExecutionParamsByUserIDAndNameAndEnvironment(s.DB, uint(userID), request.Name, request.Environment, projectID)
+	executionParams, err := models.GetThreadExecutionParamsByNameAndEnvironment(s.DB, request.Name, request.Environment, projectID)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	response := &squashedThreadExecutionParams{
-		Identifier:          executionParams.Identifier,
-		Name:                executionParams.Name,
-		Environment:         executionParams.Environment,
-		TemplateID:          executionParams.TemplateID,
-		Model:               executionParams.Template.Model,
-		Temperature:         executionParams.Template.Temperature,
-		Timeout:             executionParams.Template.Timeout,
-		MaxTokens:           executionParams.Template.MaxTokens,
-		MaxCompletionTokens: executionParams.Template.MaxCompletionTokens,
-		MaxOutputTokens:     executionParams.Template.MaxOutputTokens,
-		TopP:                executionParams.Template.TopP,
-		ResponseFormat:      executionParams.Template.ResponseFormat,
-		SystemPrompt:        executionParams.Template.SystemPrompt,
+		Identifier:            executionParams.Identifier,
+		Name:                  executionParams.Name,
+		Environment:           executionParams.Environment,
+		TemplateID:            executionParams.TemplateID,
+		TemplateRevisionID:    executionParams.TemplateRevisionID,
+		Model:                 executionParams.Template.Model,
+		Temperature:           executionParams.Template.Temperature,
+		Timeout:               executionParams.Template.Timeout,
+		MaxTokens:             executionParams.Template.MaxTokens,
+		MaxCompletionTokens:   executionParams.Template.MaxCompletionTokens,
+		MaxOutputTokens:       executionParams.Template.MaxOutputTokens,
+		TopP:                  executionParams.Template.TopP,
+		ResponseFormat:        executionParams.Template.ResponseFormat,
+		SystemPrompt:          executionParams.Template.SystemPrompt,
+		RetryPolicy:           executionParams.Template.RetryPolicy,
+		ResponseFormatRetries: executionParams.Template.ResponseFormatRetries,
+		FallbackTemplateIDs:   executionParams.FallbackTemplateIDs,
+		Variants:              executionParams.Variants,
 	}
 
 	responses.JSON(w, http.StatusOK, response)
@@ -181,22 +209,54 @@ func (s *Server) UpdateThreadExecutionParams(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	existingExecutionParams, err := models.GetThreadExecutionParamsByUserIDAndNameAndEnvironment(s.DB, uint(userID), request.Name, request.Environment, projectID)
+	if !s.checkEnvironmentAllowsChanges(w, projectID, request.Environment) {
+		return
+	}
+
+	existingExecutionParams, err := models.GetThreadExecutionParamsByNameAndEnvironment(s.DB, request.Name, request.Environment, projectID)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if err := s.checkFallbackTemplatesAccess(request.FallbackTemplateIDs, uint(userID)); err != nil {
+		responses.Error(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := s.checkVariantTemplatesAccess(request.Variants, uint(userID)); err != nil {
+		responses.Error(w, http.StatusForbidden, err.Error())
+		return
+	}
+
 	if err := models.UpdateThreadExecutionParamsTemplateID(s.DB, existingExecutionParams.Identifier, request.TemplateID); err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	// the params follow the current revision of the template again, a pin is only set by promotions
+	if err := models.UpdateThreadExecutionParamsTemplateRevisionID(s.DB, existingExecutionParams.Identifier, ""); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if request.FallbackTemplateIDs != nil {
+		if err := models.UpdateThreadExecutionParamsFallbackTemplateIDs(s.DB, existingExecutionParams.Identifier, request.FallbackTemplateIDs); err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if request.Variants != nil {
+		if err := models.UpdateThreadExecutionParamsVariants(s.DB, existingExecutionParams.Identifier, request.Variants); err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 
 	responses.JSON(w, http.StatusOK, "Execution params updated")
 }
@@ -218,13 +278,13 @@ func (s *Server) DeleteThreadExecutionParams(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	existingExecutionParams, err := models.GetThreadExecutionParamsByUserIDAndNameAndEnvironment(s.DB, uint(userID), request.Name, request.Environment, projectID)
+	existingExecutionParams, err := models.GetThreadExecutionParamsByNameAndEnvironment(s.DB, request.Name, request.Environment, projectID)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -238,6 +298,64 @@ func (s *Server) DeleteThreadExecutionParams(w http.ResponseWriter, r *http.Requ
 	responses.JSON(w, http.StatusOK, "Execution params deleted")
 }
 
+// CompareThreadExecutionParamsVariants reports the latency, failure rate and token usage of each variant of the execution params
+func (s *Server) CompareThreadExecutionParamsVariants(w http.ResponseWriter, r *http.Request) {
+	threadExecutionParamsID := mux.Vars(r)["id"]
+	if threadExecutionParamsID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	request, err := newCompareVariantsRequest(r.URL.Query())
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	executionParams, err := models.GetThreadExecutionParamsByID(s.DB, threadExecutionParamsID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "execution params not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, executionParams.ProjectID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to access these execution params")
+		return
+	}
+
+	comparison, err := controllers.CompareTemplateVariants(s.DB, executionParams, request.From, request.To)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, &CompareVariantsResponse{
+		ThreadExecutionParamsID: executionParams.Identifier,
+		Name:                    executionParams.Name,
+		Environment:             executionParams.Environment,
+		Variants:                comparison,
+	})
+}
+
 func (s *Server) ListThreadExecutionParamsTemplates(w http.ResponseWriter, r *http.Request) {
 	userID, err := utils.GetUserIDFromRequest(r)
 	if err != nil {
@@ -251,13 +369,13 @@ func (s *Server) ListThreadExecutionParamsTemplates(w http.ResponseWriter, r *ht
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	threadExecutionParamsTemplates, err := models.GetAllThreadExecutionParamsTemplates(s.DB, uint(userID), projectID)
+	threadExecutionParamsTemplates, err := models.GetAllThreadExecutionParamsTemplates(s.DB, projectID)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -289,24 +407,28 @@ func (s *Server) CreateThreadExecutionParamsTemplate(w http.ResponseWriter, r *h
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	threadExecutionParamsTemplate := models.ThreadExecutionParamsTemplate{
-		Name:                request.Name,
-		ProjectID:           projectID,
-		UserID:              uint(userID),
-		Model:               request.Model,
-		Temperature:         request.Temperature,
-		Timeout:             request.Timeout,
-		MaxTokens:           request.MaxTokens,
-		MaxCompletionTokens: request.MaxCompletionTokens,
-		MaxOutputTokens:     request.MaxOutputTokens,
-		SystemPrompt:        request.SystemPrompt,
-		ResponseFormat:      responseFormat,
+		Name:                  request.Name,
+		ProjectID:             projectID,
+		UserID:                uint(userID),
+		Model:                 request.Model,
+		Temperature:           request.Temperature,
+		Timeout:               request.Timeout,
+		MaxTokens:             request.MaxTokens,
+		MaxCompletionTokens:   request.MaxCompletionTokens,
+		MaxOutputTokens:       request.MaxOutputTokens,
+		SystemPrompt:          request.SystemPrompt,
+		ResponseFormat:        responseFormat,
+		ResponseFormatRetries: request.ResponseFormatRetries,
+	}
+	if request.RetryPolicy != nil {
+		threadExecutionParamsTemplate.RetryPolicy = *request.RetryPolicy
 	}
 
 	threadExecutionParamsTemplateCreated, err := models.CreateThreadExecutionParamsTemplate(s.DB, &threadExecutionParamsTemplate)
@@ -330,7 +452,7 @@ func (s *Server) GetThreadExecutionParamsTemplateByID(w http.ResponseWriter, r *
 		responses.Error(w, http.StatusUnauthorized, err.Error())
 		return
 	}
-	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -362,7 +484,7 @@ func (s *Server) DeleteThreadExecutionParamsTemplate(w http.ResponseWriter, r *h
 		return
 	}
 
-	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -406,7 +528,7 @@ func (s *Server) UpdateThreadExecutionParamsTemplate(w http.ResponseWriter, r *h
 		return
 	}
 
-	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -447,11 +569,58 @@ func (s *Server) UpdateThreadExecutionParamsTemplate(w http.ResponseWriter, r *h
 	threadExecutionParamsTemplate.MaxOutputTokens = request.MaxOutputTokens
 	threadExecutionParamsTemplate.SystemPrompt = request.SystemPrompt
 	threadExecutionParamsTemplate.ResponseFormat = responseFormat
+	threadExecutionParamsTemplate.ResponseFormatRetries = request.ResponseFormatRetries
+	if request.RetryPolicy != nil {
+		threadExecutionParamsTemplate.RetryPolicy = *request.RetryPolicy
+	}
 
-	if err := models.UpdateThreadExecutionParamsTemplate(s.DB, threadExecutionParamsTemplate); err != nil {
+	if err := models.UpdateThreadExecutionParamsTemplate(s.DB, threadExecutionParamsTemplate, uint(userID)); err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	responses.JSON(w, http.StatusOK, "Template updated")
 }
+
+// checkEnvironmentAllowsChanges rejects the direct changes to the execution params of an environment
+// whose promotion rule requires approvals, they can only be changed by an approved promotion
+func (s *Server) checkEnvironmentAllowsChanges(w http.ResponseWriter, projectID, environment string) bool {
+	rule, err := models.GetPromotionRule(s.DB, projectID, environment)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if rule != nil && rule.RequiredApprovals > 0 {
+		responses.Error(w, http.StatusForbidden, fmt.Sprintf("the %s environment requires approvals, use /execparams/promote to change its execution params", environment))
+		return false
+	}
+	return true
+}
+
+// checkFallbackTemplatesAccess verifies that the user has access to all the templates of a fallback chain
+func (s *Server) checkFallbackTemplatesAccess(fallbackTemplateIDs []string, userID uint) error {
+	for _, fallbackTemplateID := range fallbackTemplateIDs {
+		hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, fallbackTemplateID, userID, models.Role_VIEWER)
+		if err != nil {
+			return fmt.Errorf("error checking access to fallback template %s: %v", fallbackTemplateID, err)
+		}
+		if !hasAccess {
+			return fmt.Errorf("you are not allowed to use the fallback template %s", fallbackTemplateID)
+		}
+	}
+	return nil
+}
+
+// checkVariantTemplatesAccess verifies that the user has access to the templates of all the variants
+func (s *Server) checkVariantTemplatesAccess(variants []models.TemplateVariant, userID uint) error {
+	for _, variant := range variants {
+		hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, variant.TemplateID, userID, models.Role_VIEWER)
+		if err != nil {
+			return fmt.Errorf("error checking access to the template of variant %s: %v", variant.Name, err)
+		}
+		if !hasAccess {
+			return fmt.Errorf("you are not allowed to use the template %s in variant %s", variant.TemplateID, variant.Name)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,26 @@
 package handlers
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+)
 
 type CreateThreadExecutionParamsRequest struct {
 	Name        string `json:"name"`
 	Environment string `json:"environment"`
 	TemplateID  string `json:"template_id"`
 	ProjectName string `json:"project_name"`
+	// templates tried in order when the template fails or is rate limited
+	FallbackTemplateIDs []string `json:"fallback_template_ids"`
+	// weighted split of the executions between templates, the template is used when not set
+	Variants []models.TemplateVariant `json:"variants"`
 }
 
 func (r *CreateThreadExecutionParamsRequest) Validate() error {
@@ -22,6 +36,52 @@ func (r *CreateThreadExecutionParamsRequest) Validate() error {
 	if r.ProjectName == "" {
 		return errors.New("project_name is required")
 	}
+	if err := validateFallbackTemplateIDs(r.TemplateID, r.FallbackTemplateIDs); err != nil {
+		return err
+	}
+	return validateTemplateVariants(r.Variants, r.FallbackTemplateIDs)
+}
+
+func validateFallbackTemplateIDs(templateID string, fallbackTemplateIDs []string) error {
+	seen := map[string]bool{templateID: true}
+	for _, fallbackTemplateID := range fallbackTemplateIDs {
+		if fallbackTemplateID == "" {
+			return errors.New("fallback_template_ids can not contain empty template ids")
+		}
+		if seen[fallbackTemplateID] {
+			return fmt.Errorf("fallback_template_ids can not repeat a template of the chain: %s", fallbackTemplateID)
+		}
+		seen[fallbackTemplateID] = true
+	}
+	return nil
+}
+
+func validateTemplateVariants(variants []models.TemplateVariant, fallbackTemplateIDs []string) error {
+	if len(variants) == 0 {
+		return nil
+	}
+	if len(variants) < 2 {
+		return errors.New("variants should have at least two variants")
+	}
+	names := make(map[string]bool)
+	for _, variant := range variants {
+		if variant.Name == "" {
+			return errors.New("variants should have a name")
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("variants can not repeat a name: %s", variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.TemplateID == "" {
+			return fmt.Errorf("template_id is required for the variant %s", variant.Name)
+		}
+		if slices.Contains(fallbackTemplateIDs, variant.TemplateID) {
+			return fmt.Errorf("the template of the variant %s can not be a fallback template", variant.Name)
+		}
+		if variant.Weight <= 0 {
+			return fmt.Errorf("weight of the variant %s should be positive", variant.Name)
+		}
+	}
 	return nil
 }
 
@@ -75,6 +135,48 @@ type CreateThreadExecutionParamsTemplateRequest struct {
 	TopP                float64     `json:"top_p"`
 	SystemPrompt        string      `json:"system_prompt"`
 	ResponseFormat      interface{} `json:"response_format"`
+	// retry policy for the calls to the executor, no retries if not provided
+	RetryPolicy *models.RetryPolicy `json:"retry_policy"`
+	// re-prompts made when the response does not match a json response format
+	ResponseFormatRetries int `json:"response_format_retries"`
+}
+
+func validateResponseFormat(responseFormat interface{}, responseFormatRetries int) error {
+	if responseFormatRetries < 0 || responseFormatRetries > controllers.MAX_RESPONSE_FORMAT_RETRIES {
+		return fmt.Errorf("response_format_retries should be between 0 and %d", controllers.MAX_RESPONSE_FORMAT_RETRIES)
+	}
+	if responseFormat == nil {
+		return nil
+	}
+	responseFormatJson, err := json.Marshal(responseFormat)
+	if err != nil {
+		return err
+	}
+	return controllers.ValidateResponseFormat(responseFormatJson)
+}
+
+func validateRetryPolicy(retryPolicy *models.RetryPolicy) error {
+	if retryPolicy == nil {
+		return nil
+	}
+	if retryPolicy.MaxAttempts < 0 {
+		return errors.New("retry_policy.max_attempts must not be negative")
+	}
+	if retryPolicy.InitialBackoff < 0 || retryPolicy.MaxBackoff < 0 {
+		return errors.New("retry_policy backoffs must not be negative")
+	}
+	if retryPolicy.BackoffMultiplier != 0 && retryPolicy.BackoffMultiplier < 1 {
+		return errors.New("retry_policy.backoff_multiplier must be at least 1")
+	}
+	if retryPolicy.Jitter < 0 || retryPolicy.Jitter > 1 {
+		return errors.New("retry_policy.jitter must be between 0 and 1")
+	}
+	for _, statusCode := range retryPolicy.RetryableStatusCodes {
+		if statusCode < 100 || statusCode > 599 {
+			return errors.New("retry_policy.retryable_status_codes must be valid http status codes")
+		}
+	}
+	return nil
 }
 
 func (r *CreateThreadExecutionParamsTemplateRequest) Validate() error {
@@ -87,7 +189,10 @@ func (r *CreateThreadExecutionParamsTemplateRequest) Validate() error {
 	if r.ProjectName == "" {
 		return errors.New("project_name is required")
 	}
-	return nil
+	if err := validateResponseFormat(r.ResponseFormat, r.ResponseFormatRetries); err != nil {
+		return err
+	}
+	return validateRetryPolicy(r.RetryPolicy)
 }
 
 type UpdateThreadExecutionParamsTemplateRequest struct {
@@ -95,24 +200,34 @@ type UpdateThreadExecutionParamsTemplateRequest struct {
 }
 
 func (r *UpdateThreadExecutionParamsTemplateRequest) Validate() error {
-	return nil
+	if err := validateResponseFormat(r.ResponseFormat, r.ResponseFormatRetries); err != nil {
+		return err
+	}
+	return validateRetryPolicy(r.RetryPolicy)
 }
 
 type squashedThreadExecutionParams struct {
-	ProjectID           string      `json:"project_id"`
-	Identifier          string      `json:"identifier"`
-	Name                string      `json:"name"`
-	Environment         string      `json:"environment"`
-	TemplateID          string      `json:"template_id"`
-	Model               string      `json:"model"`
-	Temperature         float64     `json:"temperature"`
-	Timeout             int         `json:"timeout"`
-	MaxTokens           int         `json:"max_tokens"`
-	MaxCompletionTokens int         `json:"max_completion_tokens"`
-	MaxOutputTokens     int         `json:"max_output_tokens"`
-	TopP                float64     `json:"top_p"`
-	ResponseFormat      interface{} `json:"response_format"`
-	SystemPrompt        string      `json:"system_prompt"`
+	ProjectID   string `json:"project_id"`
+	Identifier  string `json:"identifier"`
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+	TemplateID  string `json:"template_id"`
+	// revision the execution params are pinned to by an approved promotion
+	TemplateRevisionID  string             `json:"template_revision_id"`
+	Model               string             `json:"model"`
+	Temperature         float64            `json:"temperature"`
+	Timeout             int                `json:"timeout"`
+	MaxTokens           int                `json:"max_tokens"`
+	MaxCompletionTokens int                `json:"max_completion_tokens"`
+	MaxOutputTokens     int                `json:"max_output_tokens"`
+	TopP                float64            `json:"top_p"`
+	ResponseFormat      interface{}        `json:"response_format"`
+	SystemPrompt        string             `json:"system_prompt"`
+	RetryPolicy         models.RetryPolicy `json:"retry_policy"`
+	// re-prompts made when the response does not match the response format
+	ResponseFormatRetries int                      `json:"response_format_retries"`
+	FallbackTemplateIDs   []string                 `json:"fallback_template_ids"`
+	Variants              []models.TemplateVariant `json:"variants"`
 }
 
 type ExecuteParamsResponse []*squashedThreadExecutionParams
@@ -122,6 +237,10 @@ type UpdateThreadExecutionParamsRequest struct {
 	Name        string `json:"name"`
 	Environment string `json:"environment"`
 	TemplateID  string `json:"template_id"`
+	// replaces the fallback chain when set, an empty list removes it
+	FallbackTemplateIDs []string `json:"fallback_template_ids"`
+	// replaces the variants when set, an empty list removes them
+	Variants []models.TemplateVariant `json:"variants"`
 }
 
 func (r *UpdateThreadExecutionParamsRequest) Validate() error {
@@ -137,5 +256,48 @@ func (r *UpdateThreadExecutionParamsRequest) Validate() error {
 	if r.TemplateID == "" {
 		return errors.New("template_id is required")
 	}
+	if err := validateFallbackTemplateIDs(r.TemplateID, r.FallbackTemplateIDs); err != nil {
+		return err
+	}
+	return validateTemplateVariants(r.Variants, r.FallbackTemplateIDs)
+}
+
+type CompareVariantsRequest struct {
+	// executions created on or after from, and before the end of the to day
+	From *time.Time
+	To   *time.Time
+}
+
+func newCompareVariantsRequest(query url.Values) (*CompareVariantsRequest, error) {
+	request := &CompareVariantsRequest{}
+	if from := query.Get("from"); from != "" {
+		fromDate, err := time.Parse(USAGE_DATE_LAYOUT, from)
+		if err != nil {
+			return nil, fmt.Errorf("from should be a date in the format %s", USAGE_DATE_LAYOUT)
+		}
+		request.From = &fromDate
+	}
+	if to := query.Get("to"); to != "" {
+		toDate, err := time.Parse(USAGE_DATE_LAYOUT, to)
+		if err != nil {
+			return nil, fmt.Errorf("to should be a date in the format %s", USAGE_DATE_LAYOUT)
+		}
+		toDate = toDate.AddDate(0, 0, 1)
+		request.To = &toDate
+	}
+	return request, nil
+}
+
+func (r *CompareVariantsRequest) Validate() error {
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		return errors.New("from should not be after to")
+	}
 	return nil
 }
+
+type CompareVariantsResponse struct {
+	ThreadExecutionParamsID string                           `json:"thread_execution_params_id"`
+	Name                    string                           `json:"name"`
+	Environment             string                           `json:"environment"`
+	Variants                []*controllers.VariantComparison `json:"variants"`
+}
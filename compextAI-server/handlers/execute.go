@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -11,10 +12,12 @@ import (
 	"github.com/burnerlee/compextAI/constants"
 	"github.com/burnerlee/compextAI/controllers"
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/stream"
 	"github.com/burnerlee/compextAI/models"
 	"github.com/burnerlee/compextAI/utils"
 	"github.com/burnerlee/compextAI/utils/responses"
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
 func (s *Server) GetThreadExecution(w http.ResponseWriter, r *http.Request) {
@@ -31,7 +34,7 @@ func (s *Server) GetThreadExecution(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -108,7 +111,7 @@ func (s *Server) ListThreadExecutions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID))
+	projectID, err := utils.GetProjectIDFromName(s.DB, projectName, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -121,22 +124,34 @@ func (s *Server) ListThreadExecutions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type threadExecution struct {
-		Identifier string          `json:"identifier"`
-		Status     string          `json:"status"`
-		CreatedAt  string          `json:"created_at"`
-		UpdatedAt  string          `json:"updated_at"`
-		ThreadID   string          `json:"thread_id"`
-		Metadata   json.RawMessage `json:"metadata"`
+		Identifier       string          `json:"identifier"`
+		Status           string          `json:"status"`
+		CreatedAt        string          `json:"created_at"`
+		UpdatedAt        string          `json:"updated_at"`
+		ThreadID         string          `json:"thread_id"`
+		Metadata         json.RawMessage `json:"metadata"`
+		AttemptCount     uint            `json:"attempt_count"`
+		Model            string          `json:"model"`
+		Environment      string          `json:"environment"`
+		PromptTokens     int             `json:"prompt_tokens"`
+		CompletionTokens int             `json:"completion_tokens"`
+		Cost             float64         `json:"cost"`
 	}
 	threadExecutions := []threadExecution{}
 	for _, exec := range execs {
 		threadExecutions = append(threadExecutions, threadExecution{
-			Identifier: exec.Identifier,
-			Status:     exec.Status,
-			CreatedAt:  exec.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:  exec.UpdatedAt.Format(time.RFC3339),
-			ThreadID:   exec.ThreadID,
-			Metadata:   exec.Metadata,
+			Identifier:       exec.Identifier,
+			Status:           exec.Status,
+			CreatedAt:        exec.CreatedAt.Format(time.RFC3339),
+			UpdatedAt:        exec.UpdatedAt.Format(time.RFC3339),
+			ThreadID:         exec.ThreadID,
+			Metadata:         exec.Metadata,
+			AttemptCount:     exec.AttemptCount,
+			Model:            exec.Model,
+			Environment:      exec.Environment,
+			PromptTokens:     exec.PromptTokens,
+			CompletionTokens: exec.CompletionTokens,
+			Cost:             exec.Cost,
 		})
 	}
 
@@ -175,7 +190,7 @@ func (s *Server) ExecuteThread(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if threadID != constants.THREAD_IDENTIFIER_FOR_NULL_THREAD {
-		hasAccess, err := utils.CheckThreadAccess(s.DB, threadID, uint(userID))
+		hasAccess, err := utils.CheckThreadAccess(s.DB, threadID, uint(userID), models.Role_EXECUTOR)
 		if err != nil {
 			responses.Error(w, http.StatusInternalServerError, err.Error())
 			return
@@ -192,39 +207,112 @@ func (s *Server) ExecuteThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if threadExecutionParam.ProjectID != "" {
+		hasAccess, err := utils.CheckProjectAccess(s.DB, threadExecutionParam.ProjectID, uint(userID), models.Role_EXECUTOR)
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !hasAccess {
+			responses.Error(w, http.StatusForbidden, "You are not authorized to use these execution params")
+			return
+		}
+	}
+
 	metadataJson, err := json.Marshal(request.Metadata)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	threadMessages := []*models.Message{}
-	for _, message := range request.Messages {
-		messageMetadataJson, err := json.Marshal(message.Metadata)
+	threadMessages, err := newExecutionMessages(request.Messages)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var projectTools []*models.ProjectTool
+	if len(request.ProjectTools) > 0 {
+		projectTools, err = models.GetProjectToolsByName(s.DB, threadExecutionParam.ProjectID, request.ProjectTools)
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				responses.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			responses.Error(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+	}
+
+	templateID, variantName := threadExecutionParam.TemplateID, ""
+	if variant := controllers.SelectTemplateVariant(threadExecutionParam, threadID); variant != nil {
+		templateID, variantName = variant.TemplateID, variant.Name
+	}
+
+	threadExecution, err := controllers.ExecuteThread(s.DB, &controllers.ExecuteThreadRequest{
+		UserID:                         uint(userID),
+		ThreadID:                       threadID,
+		ThreadExecutionParamTemplateID: templateID,
+		TemplateRevisionID:             controllers.PinnedTemplateRevisionID(threadExecutionParam, templateID),
+		FallbackTemplateIDs:            threadExecutionParam.FallbackTemplateIDs,
+		AppendAssistantResponse:        request.AppendAssistantResponse,
+		ThreadExecutionSystemPrompt:    request.ThreadExecutionSystemPrompt,
+		Messages:                       threadMessages,
+		FetchMessagesFromThread:        true,
+		ProjectID:                      threadExecutionParam.ProjectID,
+		Environment:                    threadExecutionParam.Environment,
+		Metadata:                       metadataJson,
+		Tools:                          request.Tools,
+		Stream:                         request.Stream,
+		CallbackURL:                    request.CallbackURL,
+		Variables:                      request.Variables,
+		ThreadExecutionParamsID:        threadExecutionParam.Identifier,
+		Variant:                        variantName,
+		ProjectTools:                   projectTools,
+		MaxToolSteps:                   request.MaxToolSteps,
+		Cache:                          request.Cache,
+		CacheTTL:                       time.Duration(request.CacheTTL) * time.Second,
+	})
+	if err != nil {
+		if errors.Is(err, controllers.ErrBudgetExceeded) {
+			responses.Error(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if errors.Is(err, controllers.ErrPromptRendering) || errors.Is(err, controllers.ErrToolNameConflict) {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, threadExecution)
+}
+
+// newExecutionMessages converts the messages of an execute request to the messages the thread is executed with
+func newExecutionMessages(messages []*createMessage) ([]*models.Message, error) {
+	threadMessages := []*models.Message{}
+	for _, message := range messages {
+		messageMetadataJson, err := json.Marshal(message.Metadata)
+		if err != nil {
+			return nil, err
+		}
 
 		messageContent := map[string]interface{}{
 			"content": message.Content,
 		}
 		messageContentJson, err := json.Marshal(messageContent)
 		if err != nil {
-			responses.Error(w, http.StatusInternalServerError, err.Error())
-			return
+			return nil, err
 		}
 
 		toolCallsJson, err := json.Marshal(message.ToolCalls)
 		if err != nil {
-			responses.Error(w, http.StatusInternalServerError, err.Error())
-			return
+			return nil, err
 		}
 
 		functionCallJson, err := json.Marshal(message.FunctionCall)
 		if err != nil {
-			responses.Error(w, http.StatusInternalServerError, err.Error())
-			return
+			return nil, err
 		}
 
 		threadMessages = append(threadMessages, &models.Message{
@@ -236,24 +324,7 @@ func (s *Server) ExecuteThread(w http.ResponseWriter, r *http.Request) {
 			FunctionCall: functionCallJson,
 		})
 	}
-	threadExecution, err := controllers.ExecuteThread(s.DB, &controllers.ExecuteThreadRequest{
-		UserID:                         uint(userID),
-		ThreadID:                       threadID,
-		ThreadExecutionParamTemplateID: threadExecutionParam.TemplateID,
-		AppendAssistantResponse:        request.AppendAssistantResponse,
-		ThreadExecutionSystemPrompt:    request.ThreadExecutionSystemPrompt,
-		Messages:                       threadMessages,
-		FetchMessagesFromThread:        true,
-		ProjectID:                      threadExecutionParam.ProjectID,
-		Metadata:                       metadataJson,
-		Tools:                          request.Tools,
-	})
-	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	responses.JSON(w, http.StatusOK, threadExecution)
+	return threadMessages, nil
 }
 
 func (s *Server) GetThreadExecutionStatus(w http.ResponseWriter, r *http.Request) {
@@ -270,7 +341,7 @@ func (s *Server) GetThreadExecutionStatus(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -303,7 +374,7 @@ func (s *Server) GetThreadExecutionResponse(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -351,7 +422,7 @@ func (s *Server) RerunThreadExecution(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID))
+	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID), models.Role_EXECUTOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -372,6 +443,20 @@ func (s *Server) RerunThreadExecution(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hasAccess, err = utils.CheckThreadExecutionParamsTemplateAccess(s.DB, request.ThreadExecutionParamTemplateID, uint(userID), models.Role_EXECUTOR)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "template not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to execute this template")
+		return
+	}
+
 	threadExecution, err := controllers.RerunThreadExecution(s.DB, &controllers.RerunThreadExecutionRequest{
 		UserID:                         uint(userID),
 		ExecutionID:                    executionID,
@@ -381,9 +466,149 @@ func (s *Server) RerunThreadExecution(w http.ResponseWriter, r *http.Request) {
 		Tools:                          request.Tools,
 	})
 	if err != nil {
+		if errors.Is(err, controllers.ErrBudgetExceeded) {
+			responses.Error(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if errors.Is(err, controllers.ErrPromptRendering) || errors.Is(err, controllers.ErrTemplateNotInProject) {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	responses.JSON(w, http.StatusOK, threadExecution)
 }
+
+func (s *Server) StreamThreadExecution(w http.ResponseWriter, r *http.Request) {
+	executionID := mux.Vars(r)["id"]
+
+	if executionID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to access this thread execution")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		responses.Error(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	// subscribe before checking the status, so that no event is missed in between
+	replay, events, unsubscribe := stream.Subscribe(executionID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, event := range replay {
+		writeStreamEvent(w, flusher, event)
+		if event.Type != stream.EVENT_TYPE_DELTA {
+			return
+		}
+	}
+
+	// the execution might be running in another process, or might have finished
+	// before we subscribed, so the status is checked periodically as well
+	ticker := time.NewTicker(STREAM_STATUS_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		threadExecution, err := models.GetThreadExecutionByID(s.DB, executionID)
+		if err != nil {
+			writeStreamEvent(w, flusher, stream.Event{Type: stream.EVENT_TYPE_ERROR, Error: err.Error()})
+			return
+		}
+		if threadExecution.Status != models.ThreadExecutionStatus_QUEUED && threadExecution.Status != models.ThreadExecutionStatus_IN_PROGRESS {
+			writeStreamEvent(w, flusher, controllers.GetThreadExecutionResultEvent(threadExecution))
+			return
+		}
+
+	waitForEvents:
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					// the stream is closed, the final state is read from the db
+					events = nil
+					break waitForEvents
+				}
+				writeStreamEvent(w, flusher, event)
+				if event.Type != stream.EVENT_TYPE_DELTA {
+					return
+				}
+			case <-ticker.C:
+				break waitForEvents
+			}
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, flusher http.Flusher, event stream.Event) {
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling stream event: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, eventJson)
+	flusher.Flush()
+}
+
+func (s *Server) CancelThreadExecution(w http.ResponseWriter, r *http.Request) {
+	executionID := mux.Vars(r)["id"]
+
+	if executionID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckThreadExecutionAccess(s.DB, executionID, uint(userID), models.Role_EXECUTOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to access this thread execution")
+		return
+	}
+
+	if err := controllers.CancelThreadExecution(s.DB, executionID); err != nil {
+		if errors.Is(err, controllers.ErrThreadExecutionFinished) {
+			responses.Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, ThreadExecutionStatusResponse{Status: models.ThreadExecutionStatus_CANCELLED})
+}
@@ -2,11 +2,20 @@ package handlers
 
 import (
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/internal/safehttp"
 	"github.com/burnerlee/compextAI/models"
 )
 
+const (
+	// how often the stream endpoint checks the status of the execution
+	STREAM_STATUS_POLL_INTERVAL = 2 * time.Second
+)
+
 type ExecuteThreadRequest struct {
 	ThreadExecutionParamID string `json:"thread_execution_param_id"`
 	// messages to execute the thread with - overrides the thread messages
@@ -15,6 +24,23 @@ type ExecuteThreadRequest struct {
 	AppendAssistantResponse     bool                    `json:"append_assistant_response"`
 	Tools                       []*models.ExecutionTool `json:"tools"`
 	Metadata                    map[string]interface{}  `json:"metadata"`
+	// stream the tokens of the execution, they can be read from GET /threadexec/{id}/stream
+	Stream bool `json:"stream"`
+	// notified with a signed POST once the execution is finished,
+	// signed with the secret from GET /project/{id}/webhooks/secret
+	CallbackURL string `json:"callback_url"`
+	// rendered into the system prompt, the system messages and the user messages whose metadata has
+	// "template": true, referenced as {{.name}}. Rendering fails on variables which are not provided.
+	Variables map[string]interface{} `json:"variables"`
+	// names of the project tools the server runs when the model calls them,
+	// see POST /project/{id}/tools. Their schemas are sent along the tools.
+	ProjectTools []string `json:"project_tools"`
+	// rounds of project tool calls run before the response is returned as it is
+	MaxToolSteps int `json:"max_tool_steps"`
+	// complete the execution with the response of an identical earlier request of the project,
+	// the response is cached for cache_ttl seconds otherwise. See DELETE /project/{id}/cache.
+	Cache    bool `json:"cache"`
+	CacheTTL int  `json:"cache_ttl"`
 }
 
 func (r *ExecuteThreadRequest) Validate(threadID string) error {
@@ -26,6 +52,34 @@ func (r *ExecuteThreadRequest) Validate(threadID string) error {
 		return fmt.Errorf("messages are required, when thread_id is not provided")
 	}
 
+	if r.CallbackURL != "" {
+		if err := safehttp.ValidateURL(r.CallbackURL); err != nil {
+			return fmt.Errorf("invalid callback_url: %v", err)
+		}
+	}
+
+	return validateExecutionOptions(r.ProjectTools, r.MaxToolSteps, r.Cache, r.CacheTTL)
+}
+
+// validateExecutionOptions validates the project tools and the response cache options of an execution
+func validateExecutionOptions(projectTools []string, maxToolSteps int, cache bool, cacheTTL int) error {
+	if maxToolSteps < 0 || maxToolSteps > controllers.MAX_TOOL_STEPS {
+		return fmt.Errorf("max_tool_steps should be between 0 and %d", controllers.MAX_TOOL_STEPS)
+	}
+	for i, name := range projectTools {
+		if slices.Contains(projectTools[:i], name) {
+			return fmt.Errorf("project_tools can not repeat a tool: %s", name)
+		}
+	}
+
+	if cacheTTL < 0 || time.Duration(cacheTTL)*time.Second > controllers.MAX_RESPONSE_CACHE_TTL {
+		return fmt.Errorf("cache_ttl should be between 0 and %d seconds", int(controllers.MAX_RESPONSE_CACHE_TTL.Seconds()))
+	}
+	// the results of the tools run by the server are not part of the request
+	if cache && len(projectTools) > 0 {
+		return fmt.Errorf("cache can not be used with project_tools")
+	}
+
 	return nil
 }
 
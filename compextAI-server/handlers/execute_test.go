@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/burnerlee/compextAI/internal/testdb"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func createTestUser(t *testing.T, db *gorm.DB, username string) uint {
+	t.Helper()
+	user := &models.User{Username: username, Email: fmt.Sprintf("%s@example.com", username), Password: "hash"}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatalf("error creating the user %s: %v", username, err)
+	}
+	return user.ID
+}
+
+// TestExecutionRoles checks the roles the execution and the template routes require,
+// the viewer is a member of the project and the executor a member of its organization.
+func TestExecutionRoles(t *testing.T) {
+	db := testdb.Open(t,
+		&models.User{}, &models.Project{}, &models.ProjectMember{}, &models.OrganizationMember{},
+		&models.Thread{}, &models.ThreadExecution{}, &models.ThreadExecutionParams{},
+		&models.ThreadExecutionParamsTemplate{}, &models.ThreadExecutionParamsTemplateRevision{},
+	)
+	s := &Server{DB: db}
+
+	owner := createTestUser(t, db, "owner")
+	viewer := createTestUser(t, db, "viewer")
+	executor := createTestUser(t, db, "executor")
+	stranger := createTestUser(t, db, "stranger")
+
+	project := &models.Project{UserID: owner, Name: "project", OrganizationID: "org_1"}
+	if err := models.CreateProject(db, project); err != nil {
+		t.Fatalf("error creating the project: %v", err)
+	}
+	if err := models.CreateProjectMember(db, &models.ProjectMember{ProjectID: project.Identifier, UserID: viewer, Role: models.Role_VIEWER}); err != nil {
+		t.Fatalf("error creating the project member: %v", err)
+	}
+	if err := models.CreateOrganizationMember(db, &models.OrganizationMember{OrganizationID: "org_1", UserID: executor, Role: models.Role_EXECUTOR}); err != nil {
+		t.Fatalf("error creating the organization member: %v", err)
+	}
+
+	thread := &models.Thread{UserID: owner, ProjectID: project.Identifier, Title: "thread"}
+	if err := models.CreateThread(db, thread); err != nil {
+		t.Fatalf("error creating the thread: %v", err)
+	}
+	template, err := models.CreateThreadExecutionParamsTemplate(db, &models.ThreadExecutionParamsTemplate{UserID: owner, ProjectID: project.Identifier, Name: "template", Model: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("error creating the template: %v", err)
+	}
+	params, err := models.CreateThreadExecutionParams(db, &models.ThreadExecutionParams{UserID: owner, ProjectID: project.Identifier, Name: "params", Environment: "production", TemplateID: template.Identifier})
+	if err != nil {
+		t.Fatalf("error creating the execution params: %v", err)
+	}
+	threadExecution, err := models.CreateThreadExecution(db, &models.ThreadExecution{
+		UserID:                          owner,
+		ProjectID:                       project.Identifier,
+		ThreadID:                        thread.Identifier,
+		ThreadExecutionParamsTemplateID: template.Identifier,
+		Status:                          models.ThreadExecutionStatus_QUEUED,
+	})
+	if err != nil {
+		t.Fatalf("error creating the execution: %v", err)
+	}
+
+	executeBody := fmt.Sprintf(`{"thread_execution_param_id":%q}`, params.Identifier)
+	rerunBody := fmt.Sprintf(`{"thread_execution_param_template_id":%q}`, template.Identifier)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		userID  uint
+		id      string
+		body    string
+		status  int
+	}{
+		{"viewer reading the status", s.GetThreadExecutionStatus, viewer, threadExecution.Identifier, "", http.StatusOK},
+		{"viewer executing", s.ExecuteThread, viewer, thread.Identifier, executeBody, http.StatusForbidden},
+		{"viewer cancelling", s.CancelThreadExecution, viewer, threadExecution.Identifier, "", http.StatusForbidden},
+		{"viewer rerunning", s.RerunThreadExecution, viewer, threadExecution.Identifier, rerunBody, http.StatusForbidden},
+		{"executor reading the status", s.GetThreadExecutionStatus, executor, threadExecution.Identifier, "", http.StatusOK},
+		{"executor updating the template", s.UpdateThreadExecutionParamsTemplate, executor, template.Identifier, `{"name":"renamed"}`, http.StatusForbidden},
+		{"executor rolling back the template", s.RollbackTemplate, executor, template.Identifier, `{"revision":1}`, http.StatusForbidden},
+		{"executor deleting the template", s.DeleteThreadExecutionParamsTemplate, executor, template.Identifier, "", http.StatusForbidden},
+		{"stranger reading the status", s.GetThreadExecutionStatus, stranger, threadExecution.Identifier, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("X-User-ID", fmt.Sprintf("%d", tt.userID))
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// the denied requests must not have changed anything
+	threadExecution, err = models.GetThreadExecutionByID(db, threadExecution.Identifier)
+	if err != nil || threadExecution.Status != models.ThreadExecutionStatus_QUEUED {
+		t.Fatalf("expected the execution to still be queued, got %+v, %v", threadExecution, err)
+	}
+	template, err = models.GetThreadExecutionParamsTemplateByID(db, template.Identifier)
+	if err != nil || template.Name != "template" {
+		t.Fatalf("expected the template to be unchanged, got %+v, %v", template, err)
+	}
+	var count int64
+	if err := db.Model(&models.ThreadExecution{}).Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("expected no execution to be created, got %d, %v", count, err)
+	}
+}
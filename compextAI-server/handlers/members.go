@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// checkProjectRole writes the error response and returns false if the user
+// doesn't have the role on the project of the request.
+func (s *Server) checkProjectRole(w http.ResponseWriter, r *http.Request, role string) (string, uint, bool) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return "", 0, false
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return "", 0, false
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), role)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return "", 0, false
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return "", 0, false
+	}
+
+	return projectID, uint(userID), true
+}
+
+func (s *Server) ListProjectMembers(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	members, err := models.GetProjectMembers(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, members)
+}
+
+// getProjectMember returns the member of the request after checking it belongs to the project.
+func (s *Server) getProjectMember(w http.ResponseWriter, r *http.Request, projectID string) (*models.ProjectMember, bool) {
+	member, err := models.GetProjectMember(s.DB, mux.Vars(r)["member_id"])
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+
+	if member.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "member does not belong to this project")
+		return nil, false
+	}
+
+	return member, true
+}
+
+func (s *Server) UpdateProjectMember(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	var request UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	member, ok := s.getProjectMember(w, r, projectID)
+	if !ok {
+		return
+	}
+
+	if err := models.UpdateProjectMemberRole(s.DB, member.Identifier, request.Role); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "member updated")
+}
+
+func (s *Server) RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	// members can leave the project, removing other members requires the owner role
+	projectID, userID, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	member, ok := s.getProjectMember(w, r, projectID)
+	if !ok {
+		return
+	}
+
+	if member.UserID != userID {
+		if _, _, ok := s.checkProjectRole(w, r, models.Role_OWNER); !ok {
+			return
+		}
+	}
+
+	if err := models.DeleteProjectMember(s.DB, member.Identifier); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "member removed")
+}
+
+func (s *Server) ListProjectInvitations(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	invitations, err := models.GetProjectInvitations(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, invitations)
+}
+
+func (s *Server) CreateProjectInvitation(w http.ResponseWriter, r *http.Request) {
+	projectID, userID, ok := s.checkProjectRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	var request InviteMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invitation := &models.Invitation{
+		ProjectID: projectID,
+		Email:     request.Email,
+		Role:      request.Role,
+		InvitedBy: userID,
+	}
+
+	if err := models.CreateInvitation(s.DB, invitation); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, invitation)
+}
+
+func (s *Server) DeleteProjectInvitation(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	invitation, err := models.GetInvitation(s.DB, mux.Vars(r)["invitation_id"])
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if invitation.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "invitation does not belong to this project")
+		return
+	}
+
+	if err := models.DeleteInvitation(s.DB, invitation.Identifier); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "invitation deleted")
+}
+
+func (s *Server) ListUserInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	user, err := models.GetUserByID(s.DB, uint(userID))
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	invitations, err := models.GetPendingInvitationsByEmail(s.DB, user.Email)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, invitations)
+}
+
+func (s *Server) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	s.answerInvitation(w, r, true)
+}
+
+func (s *Server) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	s.answerInvitation(w, r, false)
+}
+
+func (s *Server) answerInvitation(w http.ResponseWriter, r *http.Request, accept bool) {
+	invitationID := mux.Vars(r)["id"]
+
+	if invitationID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	user, err := models.GetUserByID(s.DB, uint(userID))
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if accept {
+		_, err = controllers.AcceptInvitation(s.DB, invitationID, user)
+	} else {
+		err = controllers.DeclineInvitation(s.DB, invitationID, user)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			responses.Error(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, controllers.ErrInvitationNotForUser):
+			responses.Error(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, controllers.ErrInvitationNotPending):
+			responses.Error(w, http.StatusBadRequest, err.Error())
+		default:
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if accept {
+		responses.JSON(w, http.StatusOK, "invitation accepted")
+		return
+	}
+	responses.JSON(w, http.StatusOK, "invitation declined")
+}
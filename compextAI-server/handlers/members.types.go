@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *CreateOrganizationRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+type UpdateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *UpdateOrganizationRequest) Validate() error {
+	return nil
+}
+
+type InviteMemberRequest struct {
+	// email of the account of the invited user
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (r *InviteMemberRequest) Validate() error {
+	r.Email = strings.TrimSpace(r.Email)
+	if r.Email == "" {
+		return errors.New("email is required")
+	}
+	return validateRole(r.Role)
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+func (r *UpdateMemberRequest) Validate() error {
+	return validateRole(r.Role)
+}
+
+func validateRole(role string) error {
+	if !slices.Contains(models.Roles, role) {
+		return fmt.Errorf("role should be one of %v", models.Roles)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	organizations, err := models.GetUserOrganizations(s.DB, uint(userID))
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, organizations)
+}
+
+func (s *Server) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var request CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	organization, err := controllers.CreateOrganization(s.DB, uint(userID), request.Name)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, organization)
+}
+
+// checkOrganizationRole writes the error response and returns false if the user
+// doesn't have the role in the organization of the request.
+func (s *Server) checkOrganizationRole(w http.ResponseWriter, r *http.Request, role string) (string, uint, bool) {
+	organizationID := mux.Vars(r)["id"]
+
+	if organizationID == "" {
+		responses.Error(w, http.StatusBadRequest, "organization id is required")
+		return "", 0, false
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return "", 0, false
+	}
+
+	hasAccess, err := utils.CheckOrganizationAccess(s.DB, organizationID, uint(userID), role)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return "", 0, false
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this organization")
+		return "", 0, false
+	}
+
+	return organizationID, uint(userID), true
+}
+
+func (s *Server) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	organization, err := models.GetOrganization(s.DB, organizationID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, organization)
+}
+
+func (s *Server) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	var request UpdateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := models.UpdateOrganization(s.DB, &models.Organization{
+		Base: models.Base{
+			Identifier: organizationID,
+		},
+		Name: request.Name,
+	}); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "organization updated")
+}
+
+func (s *Server) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteOrganization(s.DB, organizationID); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "organization deleted")
+}
+
+func (s *Server) ListOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	members, err := models.GetOrganizationMembers(s.DB, organizationID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, members)
+}
+
+// getOrganizationMember returns the member of the request after checking it belongs to the organization.
+func (s *Server) getOrganizationMember(w http.ResponseWriter, r *http.Request, organizationID string) (*models.OrganizationMember, bool) {
+	member, err := models.GetOrganizationMember(s.DB, mux.Vars(r)["member_id"])
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+
+	if member.OrganizationID != organizationID {
+		responses.Error(w, http.StatusForbidden, "member does not belong to this organization")
+		return nil, false
+	}
+
+	return member, true
+}
+
+func (s *Server) UpdateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	var request UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	member, ok := s.getOrganizationMember(w, r, organizationID)
+	if !ok {
+		return
+	}
+
+	if err := controllers.UpdateOrganizationMemberRole(s.DB, member, request.Role); err != nil {
+		if errors.Is(err, controllers.ErrLastOrganizationOwner) {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "member updated")
+}
+
+func (s *Server) RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	// members can leave the organization, removing other members requires the owner role
+	organizationID, userID, ok := s.checkOrganizationRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	member, ok := s.getOrganizationMember(w, r, organizationID)
+	if !ok {
+		return
+	}
+
+	if member.UserID != userID {
+		if _, _, ok := s.checkOrganizationRole(w, r, models.Role_OWNER); !ok {
+			return
+		}
+	}
+
+	if err := controllers.RemoveOrganizationMember(s.DB, member); err != nil {
+		if errors.Is(err, controllers.ErrLastOrganizationOwner) {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "member removed")
+}
+
+func (s *Server) ListOrganizationInvitations(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	invitations, err := models.GetOrganizationInvitations(s.DB, organizationID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, invitations)
+}
+
+func (s *Server) CreateOrganizationInvitation(w http.ResponseWriter, r *http.Request) {
+	organizationID, userID, ok := s.checkOrganizationRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	var request InviteMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invitation := &models.Invitation{
+		OrganizationID: organizationID,
+		Email:          request.Email,
+		Role:           request.Role,
+		InvitedBy:      userID,
+	}
+
+	if err := models.CreateInvitation(s.DB, invitation); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, invitation)
+}
+
+func (s *Server) DeleteOrganizationInvitation(w http.ResponseWriter, r *http.Request) {
+	organizationID, _, ok := s.checkOrganizationRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	invitation, err := models.GetInvitation(s.DB, mux.Vars(r)["invitation_id"])
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if invitation.OrganizationID != organizationID {
+		responses.Error(w, http.StatusForbidden, "invitation does not belong to this organization")
+		return
+	}
+
+	if err := models.DeleteInvitation(s.DB, invitation.Identifier); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "invitation deleted")
+}
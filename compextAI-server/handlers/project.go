@@ -34,10 +34,17 @@ func (s *Server) CreateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.OrganizationID != "" {
+		if !s.checkOrganizationEditor(w, request.OrganizationID, uint(userID)) {
+			return
+		}
+	}
+
 	project := &models.Project{
-		UserID:      uint(userID),
-		Name:        request.Name,
-		Description: request.Description,
+		UserID:         uint(userID),
+		Name:           request.Name,
+		Description:    request.Description,
+		OrganizationID: request.OrganizationID,
 	}
 
 	if err := models.CreateProject(s.DB, project); err != nil {
@@ -62,7 +69,7 @@ func (s *Server) GetProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID))
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_VIEWER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -112,7 +119,7 @@ func (s *Server) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID))
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -145,7 +152,7 @@ func (s *Server) UpdateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID))
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
 	if err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -173,12 +180,30 @@ func (s *Server) UpdateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// moving the project to an organization shares it with the members of the organization
+	if request.OrganizationID != "" && request.OrganizationID != existingProject.OrganizationID {
+		isOwner, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !isOwner {
+			responses.Error(w, http.StatusForbidden, "only the owners of the project can move it to an organization")
+			return
+		}
+		if !s.checkOrganizationEditor(w, request.OrganizationID, uint(userID)) {
+			return
+		}
+	}
+
 	if err := models.UpdateProject(s.DB, &models.Project{
 		Base: models.Base{
-			ID: existingProject.ID,
+			ID:         existingProject.ID,
+			Identifier: existingProject.Identifier,
 		},
-		Name:        request.Name,
-		Description: request.Description,
+		Name:           request.Name,
+		Description:    request.Description,
+		OrganizationID: request.OrganizationID,
 	}); err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -186,3 +211,18 @@ func (s *Server) UpdateProject(w http.ResponseWriter, r *http.Request) {
 
 	responses.JSON(w, http.StatusOK, existingProject)
 }
+
+// checkOrganizationEditor writes the error response and returns false if the user
+// can't add projects to the organization.
+func (s *Server) checkOrganizationEditor(w http.ResponseWriter, organizationID string, userID uint) bool {
+	hasAccess, err := utils.CheckOrganizationAccess(s.DB, organizationID, userID, models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you can not add projects to this organization")
+		return false
+	}
+	return true
+}
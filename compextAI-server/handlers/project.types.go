@@ -9,6 +9,8 @@ import (
 type CreateProjectRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// the members of the organization get their organization role on the project
+	OrganizationID string `json:"organization_id"`
 }
 
 func (r *CreateProjectRequest) Validate() error {
@@ -28,8 +30,9 @@ func (r *CreateProjectRequest) Validate() error {
 }
 
 type UpdateProjectRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	OrganizationID string `json:"organization_id"`
 }
 
 func (r *UpdateProjectRequest) Validate() error {
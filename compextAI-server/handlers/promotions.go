@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func (s *Server) PromoteThreadExecutionParams(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var request PromoteThreadExecutionParamsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	projectID, err := utils.GetProjectIDFromName(s.DB, request.ProjectName, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	promotion, err := controllers.RequestPromotion(s.DB, &controllers.RequestPromotionRequest{
+		UserID:          uint(userID),
+		ProjectID:       projectID,
+		Name:            request.Name,
+		FromEnvironment: request.FromEnvironment,
+		ToEnvironment:   request.ToEnvironment,
+		Comment:         request.Comment,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrPromotionSameEnvironment):
+			responses.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			responses.Error(w, http.StatusNotFound, "execution params not found in the source environment")
+		default:
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, promotion)
+}
+
+func (s *Server) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+
+	searchFilters := r.URL.Query().Get("filters")
+	var searchFiltersMap map[string]string
+	if searchFilters != "" {
+		searchFilters, err = url.QueryUnescape(searchFilters)
+		if err != nil {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := json.Unmarshal([]byte(searchFilters), &searchFiltersMap); err != nil {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	promotions, total, err := models.GetProjectPromotions(s.DB, projectID, searchFiltersMap, page, limit)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, struct {
+		Promotions []models.Promotion `json:"promotions"`
+		Total      int                `json:"total"`
+	}{
+		Promotions: promotions,
+		Total:      int(total),
+	})
+}
+
+// getProjectPromotion responds with an error and returns nil if the promotion
+// in the path does not exist or the user does not have the role on its project
+func (s *Server) getProjectPromotion(w http.ResponseWriter, r *http.Request, role string) (*models.Promotion, uint) {
+	projectID, userID, ok := s.checkProjectRole(w, r, role)
+	if !ok {
+		return nil, 0
+	}
+
+	promotion, err := models.GetPromotion(s.DB, mux.Vars(r)["promotion_id"])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "promotion not found")
+			return nil, 0
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, 0
+	}
+	if promotion.ProjectID != projectID {
+		responses.Error(w, http.StatusNotFound, "promotion not found")
+		return nil, 0
+	}
+
+	return promotion, userID
+}
+
+func (s *Server) GetPromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, _ := s.getProjectPromotion(w, r, models.Role_VIEWER)
+	if promotion == nil {
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, promotion)
+}
+
+func (s *Server) ApprovePromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, userID := s.getProjectPromotion(w, r, models.Role_EDITOR)
+	if promotion == nil {
+		return
+	}
+
+	promotion, err := controllers.ApprovePromotion(s.DB, promotion.Identifier, userID)
+	if err != nil {
+		respondPromotionError(w, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, promotion)
+}
+
+func (s *Server) RejectPromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, userID := s.getProjectPromotion(w, r, models.Role_EDITOR)
+	if promotion == nil {
+		return
+	}
+
+	promotion, err := controllers.RejectPromotion(s.DB, promotion.Identifier, userID)
+	if err != nil {
+		respondPromotionError(w, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, promotion)
+}
+
+func respondPromotionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, controllers.ErrPromotionNotPending), errors.Is(err, controllers.ErrPromotionAlreadyApproved):
+		responses.Error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, controllers.ErrPromotionSelfApproval):
+		responses.Error(w, http.StatusForbidden, err.Error())
+	default:
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) ListPromotionRules(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	rules, err := models.GetProjectPromotionRules(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, rules)
+}
+
+func (s *Server) SetPromotionRule(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_OWNER)
+	if !ok {
+		return
+	}
+
+	var request SetPromotionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule, err := models.SetPromotionRule(s.DB, projectID, request.Environment, request.RequiredApprovals)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, rule)
+}
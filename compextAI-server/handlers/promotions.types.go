@@ -0,0 +1,43 @@
+package handlers
+
+import "errors"
+
+type PromoteThreadExecutionParamsRequest struct {
+	ProjectName     string `json:"project_name"`
+	Name            string `json:"name"`
+	FromEnvironment string `json:"from_environment"`
+	ToEnvironment   string `json:"to_environment"`
+	Comment         string `json:"comment"`
+}
+
+func (r *PromoteThreadExecutionParamsRequest) Validate() error {
+	if r.ProjectName == "" {
+		return errors.New("project_name is required")
+	}
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.FromEnvironment == "" {
+		return errors.New("from_environment is required")
+	}
+	if r.ToEnvironment == "" {
+		return errors.New("to_environment is required")
+	}
+	return nil
+}
+
+type SetPromotionRuleRequest struct {
+	Environment string `json:"environment"`
+	// 0 lets the promotions to the environment be applied without approvals
+	RequiredApprovals int `json:"required_approvals"`
+}
+
+func (r *SetPromotionRuleRequest) Validate() error {
+	if r.Environment == "" {
+		return errors.New("environment is required")
+	}
+	if r.RequiredApprovals < 0 {
+		return errors.New("required_approvals must not be negative")
+	}
+	return nil
+}
@@ -9,54 +9,139 @@ func (s *Server) InitRoutes() {
 	threadRouter := v1Router.PathPrefix("/thread").Subrouter()
 
 	threadRouter.HandleFunc("/all/{projectname}", middlewares.AuthMiddleware(s.ListThreads, s.DB)).Methods("GET")
-	threadRouter.HandleFunc("", middlewares.AuthMiddleware(s.CreateThread, s.DB)).Methods("POST")
+	threadRouter.HandleFunc("", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateThread, s.DB), s.DB)).Methods("POST")
 	threadRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetThread, s.DB)).Methods("GET")
-	threadRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.UpdateThread, s.DB)).Methods("PUT")
-	threadRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.DeleteThread, s.DB)).Methods("DELETE")
-	threadRouter.HandleFunc("/{id}/execute", middlewares.AuthMiddleware(s.ExecuteThread, s.DB)).Methods("POST")
+	threadRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateThread, s.DB), s.DB)).Methods("PUT")
+	threadRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteThread, s.DB), s.DB)).Methods("DELETE")
+	threadRouter.HandleFunc("/{id}/execute", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.ExecuteThread, s.DB), s.DB)).Methods("POST")
+	threadRouter.HandleFunc("/{id}/usage", middlewares.AuthMiddleware(s.GetThreadUsage, s.DB)).Methods("GET")
 
 	threadExecRouter := v1Router.PathPrefix("/threadexec").Subrouter()
 	threadExecRouter.HandleFunc("/all/{projectname}", middlewares.AuthMiddleware(s.ListThreadExecutions, s.DB)).Methods("GET")
 	threadExecRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetThreadExecution, s.DB)).Methods("GET")
 	threadExecRouter.HandleFunc("/{id}/status", middlewares.AuthMiddleware(s.GetThreadExecutionStatus, s.DB)).Methods("GET")
 	threadExecRouter.HandleFunc("/{id}/response", middlewares.AuthMiddleware(s.GetThreadExecutionResponse, s.DB)).Methods("GET")
-	threadExecRouter.HandleFunc("/{id}/rerun", middlewares.AuthMiddleware(s.RerunThreadExecution, s.DB)).Methods("POST")
+	threadExecRouter.HandleFunc("/{id}/stream", middlewares.AuthMiddleware(s.StreamThreadExecution, s.DB)).Methods("GET")
+	threadExecRouter.HandleFunc("/{id}/rerun", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.RerunThreadExecution, s.DB), s.DB)).Methods("POST")
+	threadExecRouter.HandleFunc("/{id}/cancel", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CancelThreadExecution, s.DB), s.DB)).Methods("POST")
+
+	batchRouter := v1Router.PathPrefix("/batch").Subrouter()
+	batchRouter.HandleFunc("", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateBatch, s.DB), s.DB)).Methods("POST")
+	batchRouter.HandleFunc("/all/{projectname}", middlewares.AuthMiddleware(s.ListBatches, s.DB)).Methods("GET")
+	batchRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetBatch, s.DB)).Methods("GET")
+	batchRouter.HandleFunc("/{id}/results", middlewares.AuthMiddleware(s.GetBatchResults, s.DB)).Methods("GET")
+	batchRouter.HandleFunc("/{id}/cancel", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CancelBatch, s.DB), s.DB)).Methods("POST")
 
 	messageRouter := v1Router.PathPrefix("/message").Subrouter()
 
 	messageRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetMessage, s.DB)).Methods("GET")
-	messageRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.UpdateMessage, s.DB)).Methods("PUT")
-	messageRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.DeleteMessage, s.DB)).Methods("DELETE")
+	messageRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateMessage, s.DB), s.DB)).Methods("PUT")
+	messageRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteMessage, s.DB), s.DB)).Methods("DELETE")
 
 	messageThreadIDRouter := messageRouter.PathPrefix("/thread/{thread_id}").Subrouter()
 
-	messageThreadIDRouter.HandleFunc("", middlewares.AuthMiddleware(s.CreateMessage, s.DB)).Methods("POST")
+	messageThreadIDRouter.HandleFunc("", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateMessage, s.DB), s.DB)).Methods("POST")
 	messageThreadIDRouter.HandleFunc("", middlewares.AuthMiddleware(s.ListMessages, s.DB)).Methods("GET")
 
 	userRouter := v1Router.PathPrefix("/user").Subrouter()
-	userRouter.HandleFunc("/signup", s.CreateUser).Methods("POST")
-	userRouter.HandleFunc("/login", s.Login).Methods("POST")
+	loginLimiter := middlewares.NewRateLimiter(middlewares.LOGIN_RATE_LIMIT, middlewares.LOGIN_RATE_LIMIT_WINDOW)
+	userRouter.HandleFunc("/signup", middlewares.RateLimitMiddleware(s.CreateUser, loginLimiter)).Methods("POST")
+	userRouter.HandleFunc("/login", middlewares.RateLimitMiddleware(s.Login, loginLimiter)).Methods("POST")
+	userRouter.HandleFunc("/password", middlewares.RateLimitMiddleware(middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.ChangePassword, s.DB), s.DB), loginLimiter)).Methods("PUT")
 	userRouter.HandleFunc("/api_keys", middlewares.AuthMiddleware(s.ListAPIKeys, s.DB)).Methods("GET")
-	userRouter.HandleFunc("/api_keys", middlewares.AuthMiddleware(s.UpdateAPIKeys, s.DB)).Methods("PUT")
+	userRouter.HandleFunc("/api_keys", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateAPIKeys, s.DB), s.DB)).Methods("PUT")
+	userRouter.HandleFunc("/api_tokens", middlewares.AuthMiddleware(s.ListAPITokens, s.DB)).Methods("GET")
+	userRouter.HandleFunc("/api_tokens", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateAPIToken, s.DB), s.DB)).Methods("POST")
+	userRouter.HandleFunc("/api_tokens/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.RevokeAPIToken, s.DB), s.DB)).Methods("DELETE")
+	userRouter.HandleFunc("/invitations", middlewares.AuthMiddleware(s.ListUserInvitations, s.DB)).Methods("GET")
+	userRouter.HandleFunc("/invitations/{id}/accept", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.AcceptInvitation, s.DB), s.DB)).Methods("POST")
+	userRouter.HandleFunc("/invitations/{id}/decline", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeclineInvitation, s.DB), s.DB)).Methods("POST")
 
 	threadExecutionParamsRouter := v1Router.PathPrefix("/execparams").Subrouter()
 	threadExecutionParamsRouter.HandleFunc("/fetchall/{projectname}", middlewares.AuthMiddleware(s.ListThreadExecutionParams, s.DB)).Methods("GET")
-	threadExecutionParamsRouter.HandleFunc("/create", middlewares.AuthMiddleware(s.CreateThreadExecutionParams, s.DB)).Methods("POST")
+	threadExecutionParamsRouter.HandleFunc("/create", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateThreadExecutionParams, s.DB), s.DB)).Methods("POST")
 	threadExecutionParamsRouter.HandleFunc("/fetch", middlewares.AuthMiddleware(s.GetThreadExecutionParamsByNameAndEnv, s.DB)).Methods("POST")
-	threadExecutionParamsRouter.HandleFunc("/update", middlewares.AuthMiddleware(s.UpdateThreadExecutionParams, s.DB)).Methods("PUT")
-	threadExecutionParamsRouter.HandleFunc("/delete", middlewares.AuthMiddleware(s.DeleteThreadExecutionParams, s.DB)).Methods("DELETE")
+	threadExecutionParamsRouter.HandleFunc("/update", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateThreadExecutionParams, s.DB), s.DB)).Methods("PUT")
+	threadExecutionParamsRouter.HandleFunc("/delete", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteThreadExecutionParams, s.DB), s.DB)).Methods("DELETE")
+	threadExecutionParamsRouter.HandleFunc("/promote", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.PromoteThreadExecutionParams, s.DB), s.DB)).Methods("POST")
+	threadExecutionParamsRouter.HandleFunc("/{id}/variants/comparison", middlewares.AuthMiddleware(s.CompareThreadExecutionParamsVariants, s.DB)).Methods("GET")
 
 	threadExecutionParamsTemplateRouter := v1Router.PathPrefix("/execparamstemplate").Subrouter()
 	threadExecutionParamsTemplateRouter.HandleFunc("/all/{projectname}", middlewares.AuthMiddleware(s.ListThreadExecutionParamsTemplates, s.DB)).Methods("GET")
-	threadExecutionParamsTemplateRouter.HandleFunc("", middlewares.AuthMiddleware(s.CreateThreadExecutionParamsTemplate, s.DB)).Methods("POST")
+	threadExecutionParamsTemplateRouter.HandleFunc("", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateThreadExecutionParamsTemplate, s.DB), s.DB)).Methods("POST")
 	threadExecutionParamsTemplateRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetThreadExecutionParamsTemplateByID, s.DB)).Methods("GET")
-	threadExecutionParamsTemplateRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.DeleteThreadExecutionParamsTemplate, s.DB)).Methods("DELETE")
-	threadExecutionParamsTemplateRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.UpdateThreadExecutionParamsTemplate, s.DB)).Methods("PUT")
+	threadExecutionParamsTemplateRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteThreadExecutionParamsTemplate, s.DB), s.DB)).Methods("DELETE")
+	threadExecutionParamsTemplateRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateThreadExecutionParamsTemplate, s.DB), s.DB)).Methods("PUT")
+	threadExecutionParamsTemplateRouter.HandleFunc("/{id}/revisions", middlewares.AuthMiddleware(s.ListTemplateRevisions, s.DB)).Methods("GET")
+	threadExecutionParamsTemplateRouter.HandleFunc("/{id}/revisions/diff", middlewares.AuthMiddleware(s.DiffTemplateRevisions, s.DB)).Methods("GET")
+	threadExecutionParamsTemplateRouter.HandleFunc("/{id}/revisions/{revision}", middlewares.AuthMiddleware(s.GetTemplateRevision, s.DB)).Methods("GET")
+	threadExecutionParamsTemplateRouter.HandleFunc("/{id}/rollback", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.RollbackTemplate, s.DB), s.DB)).Methods("POST")
 
 	projectRouter := v1Router.PathPrefix("/project").Subrouter()
 	projectRouter.HandleFunc("", middlewares.AuthMiddleware(s.ListProjects, s.DB)).Methods("GET")
-	projectRouter.HandleFunc("", middlewares.AuthMiddleware(s.CreateProject, s.DB)).Methods("POST")
-	projectRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.DeleteProject, s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateProject, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteProject, s.DB), s.DB)).Methods("DELETE")
 	projectRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetProject, s.DB)).Methods("GET")
-	projectRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.UpdateProject, s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateProject, s.DB), s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}/usage", middlewares.AuthMiddleware(s.GetProjectUsage, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/budgets", middlewares.AuthMiddleware(s.ListProjectBudgets, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/budgets", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateProjectBudget, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/budgets/events", middlewares.AuthMiddleware(s.ListBudgetEvents, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/budgets/{budget_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateProjectBudget, s.DB), s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}/budgets/{budget_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteProjectBudget, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/webhooks", middlewares.AuthMiddleware(s.ListWebhooks, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/webhooks", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateWebhook, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/webhooks/secret", middlewares.AuthMiddleware(s.GetProjectWebhookSecret, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/webhooks/deliveries", middlewares.AuthMiddleware(s.ListWebhookDeliveries, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/webhooks/deliveries/{delivery_id}", middlewares.AuthMiddleware(s.GetWebhookDelivery, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/webhooks/deliveries/{delivery_id}/replay", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.ReplayWebhookDelivery, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/webhooks/{webhook_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateWebhook, s.DB), s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}/webhooks/{webhook_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteWebhook, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/tools", middlewares.AuthMiddleware(s.ListProjectTools, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/tools", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateProjectTool, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/tools/{tool_id}", middlewares.AuthMiddleware(s.GetProjectTool, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/tools/{tool_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateProjectTool, s.DB), s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}/tools/{tool_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteProjectTool, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/datasets", middlewares.AuthMiddleware(s.ListDatasets, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/datasets", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateDataset, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/datasets/{dataset_id}", middlewares.AuthMiddleware(s.GetDataset, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/datasets/{dataset_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteDataset, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/datasets/{dataset_id}/items", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.AddDatasetItems, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/datasets/{dataset_id}/items/{item_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteDatasetItem, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/evals", middlewares.AuthMiddleware(s.ListEvalRuns, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/evals", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateEvalRun, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/evals/{eval_id}", middlewares.AuthMiddleware(s.GetEvalRun, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/evals/{eval_id}/results", middlewares.AuthMiddleware(s.GetEvalRunResults, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/evals/{eval_id}/cancel", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CancelEvalRun, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/cache", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.InvalidateProjectResponseCache, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/credentials", middlewares.AuthMiddleware(s.ListProviderCredentials, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/credentials", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateProviderCredential, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/credentials/{credential_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateProviderCredential, s.DB), s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}/credentials/{credential_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteProviderCredential, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/members", middlewares.AuthMiddleware(s.ListProjectMembers, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/members/{member_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateProjectMember, s.DB), s.DB)).Methods("PUT")
+	projectRouter.HandleFunc("/{id}/members/{member_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.RemoveProjectMember, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/invitations", middlewares.AuthMiddleware(s.ListProjectInvitations, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/invitations", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateProjectInvitation, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/invitations/{invitation_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteProjectInvitation, s.DB), s.DB)).Methods("DELETE")
+	projectRouter.HandleFunc("/{id}/audit", middlewares.AuthMiddleware(s.ListAuditEvents, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/promotions", middlewares.AuthMiddleware(s.ListPromotions, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/promotions/{promotion_id}", middlewares.AuthMiddleware(s.GetPromotion, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/promotions/{promotion_id}/approve", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.ApprovePromotion, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/promotions/{promotion_id}/reject", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.RejectPromotion, s.DB), s.DB)).Methods("POST")
+	projectRouter.HandleFunc("/{id}/promotion_rules", middlewares.AuthMiddleware(s.ListPromotionRules, s.DB)).Methods("GET")
+	projectRouter.HandleFunc("/{id}/promotion_rules", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.SetPromotionRule, s.DB), s.DB)).Methods("PUT")
+
+	organizationRouter := v1Router.PathPrefix("/organization").Subrouter()
+	organizationRouter.HandleFunc("", middlewares.AuthMiddleware(s.ListOrganizations, s.DB)).Methods("GET")
+	organizationRouter.HandleFunc("", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateOrganization, s.DB), s.DB)).Methods("POST")
+	organizationRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(s.GetOrganization, s.DB)).Methods("GET")
+	organizationRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateOrganization, s.DB), s.DB)).Methods("PUT")
+	organizationRouter.HandleFunc("/{id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteOrganization, s.DB), s.DB)).Methods("DELETE")
+	organizationRouter.HandleFunc("/{id}/members", middlewares.AuthMiddleware(s.ListOrganizationMembers, s.DB)).Methods("GET")
+	organizationRouter.HandleFunc("/{id}/members/{member_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.UpdateOrganizationMember, s.DB), s.DB)).Methods("PUT")
+	organizationRouter.HandleFunc("/{id}/members/{member_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.RemoveOrganizationMember, s.DB), s.DB)).Methods("DELETE")
+	organizationRouter.HandleFunc("/{id}/invitations", middlewares.AuthMiddleware(s.ListOrganizationInvitations, s.DB)).Methods("GET")
+	organizationRouter.HandleFunc("/{id}/invitations", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.CreateOrganizationInvitation, s.DB), s.DB)).Methods("POST")
+	organizationRouter.HandleFunc("/{id}/invitations/{invitation_id}", middlewares.AuthMiddleware(middlewares.AuditMiddleware(s.DeleteOrganizationInvitation, s.DB), s.DB)).Methods("DELETE")
 }
@@ -4,7 +4,16 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/internal/batch"
+	"github.com/burnerlee/compextAI/internal/cache"
+	"github.com/burnerlee/compextAI/internal/evals"
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/queue"
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/internal/stream"
+	"github.com/burnerlee/compextAI/internal/webhooks"
+	"github.com/burnerlee/compextAI/middlewares"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"gorm.io/gorm"
@@ -31,6 +40,8 @@ func InitServer(ctx context.Context) (*Server, error) {
 
 	// add logger middleware to the router
 	s.Router.Use(logger.LoggerMiddleware)
+	// every request gets an id, it is recorded in the audit log
+	s.Router.Use(middlewares.RequestIDMiddleware)
 
 	// initialize the database
 	logger.GetLogger().Info("Initializing database")
@@ -40,6 +51,13 @@ func InitServer(ctx context.Context) (*Server, error) {
 		return nil, err
 	}
 
+	// load the master keys used to encrypt the provider keys
+	logger.GetLogger().Info("Initializing secrets")
+	if err := secrets.Init(); err != nil {
+		logger.GetLogger().Errorf("Error initializing secrets: %v", err)
+		return nil, err
+	}
+
 	logger.GetLogger().Info("Migrating database")
 	if err := MigrateDB(s.DB); err != nil {
 		logger.GetLogger().Errorf("Error migrating database: %v", err)
@@ -48,6 +66,34 @@ func InitServer(ctx context.Context) (*Server, error) {
 
 	logger.GetLogger().Info("Database initialized successfully")
 
+	// the response cache is read when the executions are created
+	logger.GetLogger().Info("Initializing response cache")
+	if err := cache.Init(s.DB, cache.ConfigFromEnv()); err != nil {
+		logger.GetLogger().Errorf("Error initializing response cache: %v", err)
+		return nil, err
+	}
+	cache.Start(ctx)
+
+	// relay the streamed tokens between the processes, so any process can stream any execution
+	logger.GetLogger().Info("Starting stream relay")
+	stream.InitRelay(s.DB).Start(ctx)
+
+	// start the workers which run the queued thread executions
+	logger.GetLogger().Info("Starting execution queue")
+	queue.Init(s.DB, controllers.ProcessThreadExecution, controllers.NotifyThreadExecutionFinished, queue.ConfigFromEnv()).Start(ctx)
+
+	// start the scheduler which feeds the items of the batches to the execution queue
+	logger.GetLogger().Info("Starting batch scheduler")
+	batch.Init(s.DB, controllers.ScheduleBatch, batch.ConfigFromEnv()).Start(ctx)
+
+	// start the scorer which scores the outputs of the eval runs as their executions finish
+	logger.GetLogger().Info("Starting eval scorer")
+	evals.Init(s.DB, controllers.ScoreEvalResult, evals.ConfigFromEnv()).Start(ctx)
+
+	// start the workers which send the webhook deliveries
+	logger.GetLogger().Info("Starting webhook dispatcher")
+	webhooks.Init(s.DB, webhooks.ConfigFromEnv()).Start(ctx)
+
 	s.InitRoutes()
 
 	return s, nil
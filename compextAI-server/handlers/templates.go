@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// checkTemplateRole checks that the user has the role on the project of the template in the path.
+// It returns the template id and the user id, or false once the error has been responded.
+func (s *Server) checkTemplateRole(w http.ResponseWriter, r *http.Request, role string) (string, uint, bool) {
+	templateID := mux.Vars(r)["id"]
+	if templateID == "" {
+		responses.Error(w, http.StatusBadRequest, "Template ID is required")
+		return "", 0, false
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return "", 0, false
+	}
+
+	hasAccess, err := utils.CheckThreadExecutionParamsTemplateAccess(s.DB, templateID, uint(userID), role)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "template not found")
+			return "", 0, false
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return "", 0, false
+	}
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You do not have access to this template")
+		return "", 0, false
+	}
+
+	return templateID, uint(userID), true
+}
+
+func (s *Server) ListTemplateRevisions(w http.ResponseWriter, r *http.Request) {
+	templateID, _, ok := s.checkTemplateRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	revisions, err := models.GetTemplateRevisions(s.DB, templateID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, revisions)
+}
+
+func (s *Server) GetTemplateRevision(w http.ResponseWriter, r *http.Request) {
+	templateID, _, ok := s.checkTemplateRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	revision, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, "revision must be a number")
+		return
+	}
+
+	templateRevision, err := models.GetTemplateRevision(s.DB, templateID, revision)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "revision not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, templateRevision)
+}
+
+func (s *Server) DiffTemplateRevisions(w http.ResponseWriter, r *http.Request) {
+	templateID, _, ok := s.checkTemplateRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, "from must be a revision number")
+		return
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, "to must be a revision number")
+		return
+	}
+
+	diff, err := controllers.DiffTemplateRevisions(s.DB, templateID, from, to)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "revision not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, diff)
+}
+
+func (s *Server) RollbackTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, userID, ok := s.checkTemplateRole(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	var request RollbackTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	templateRevision, err := models.RollbackThreadExecutionParamsTemplate(s.DB, templateID, request.Revision, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "revision not found")
+			return
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, templateRevision)
+}
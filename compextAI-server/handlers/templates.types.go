@@ -0,0 +1,15 @@
+package handlers
+
+import "errors"
+
+type RollbackTemplateRequest struct {
+	// revision whose settings are restored
+	Revision int `json:"revision"`
+}
+
+func (r *RollbackTemplateRequest) Validate() error {
+	if r.Revision <= 0 {
+		return errors.New("revision is required")
+	}
+	return nil
+}
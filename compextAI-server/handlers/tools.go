@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/burnerlee/compextAI/internal/tools"
+	"github.com/burnerlee/compextAI/internal/webhooks"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// input schema of the http tools registered without one
+	DEFAULT_TOOL_INPUT_SCHEMA = `{"type":"object","properties":{}}`
+)
+
+func (s *Server) ListProjectTools(w http.ResponseWriter, r *http.Request) {
+	projectID, _, ok := s.checkProjectRole(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	projectTools, err := models.GetProjectTools(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the secrets are only returned when the tools are created
+	for i := range projectTools {
+		projectTools[i].Secret = ""
+	}
+
+	responses.JSON(w, http.StatusOK, projectTools)
+}
+
+func (s *Server) CreateProjectTool(w http.ResponseWriter, r *http.Request) {
+	projectID, userID, ok := s.checkProjectRole(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	var request CreateProjectToolRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	projectTools, err := models.GetProjectToolsByName(s.DB, projectID, []string{request.Name})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(projectTools) > 0 {
+		responses.Error(w, http.StatusConflict, "a tool with this name already exists in the project")
+		return
+	}
+
+	projectTool := &models.ProjectTool{
+		UserID:      userID,
+		ProjectID:   projectID,
+		Name:        request.Name,
+		Description: request.Description,
+		InputSchema: request.InputSchema,
+		Type:        request.Type,
+	}
+	switch request.Type {
+	case models.ProjectToolType_HTTP:
+		projectTool.URL = request.URL
+		projectTool.Timeout = request.Timeout
+		projectTool.Secret, err = webhooks.NewSecret()
+		if err != nil {
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(projectTool.InputSchema) == 0 {
+			projectTool.InputSchema = json.RawMessage(DEFAULT_TOOL_INPUT_SCHEMA)
+		}
+	case models.ProjectToolType_BUILTIN:
+		builtin, _ := tools.GetBuiltin(request.Builtin)
+		projectTool.Builtin = builtin.Name
+		if projectTool.Description == "" {
+			projectTool.Description = builtin.Description
+		}
+		if len(projectTool.InputSchema) == 0 {
+			projectTool.InputSchema = builtin.InputSchema
+		}
+	}
+
+	if err := models.CreateProjectTool(s.DB, projectTool); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, projectTool)
+}
+
+func (s *Server) GetProjectTool(w http.ResponseWriter, r *http.Request) {
+	projectTool, ok := s.getProjectTool(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	projectTool.Secret = ""
+	responses.JSON(w, http.StatusOK, projectTool)
+}
+
+func (s *Server) UpdateProjectTool(w http.ResponseWriter, r *http.Request) {
+	projectTool, ok := s.getProjectTool(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	var request UpdateProjectToolRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updateData := make(map[string]interface{})
+	if request.Description != "" {
+		updateData["description"] = request.Description
+	}
+	if len(request.InputSchema) > 0 {
+		updateData["input_schema"] = request.InputSchema
+	}
+	if projectTool.Type == models.ProjectToolType_HTTP {
+		if request.URL != "" {
+			updateData["url"] = request.URL
+		}
+		if request.Timeout != nil {
+			updateData["timeout"] = *request.Timeout
+		}
+	} else if request.URL != "" || request.Timeout != nil {
+		responses.Error(w, http.StatusBadRequest, "url and timeout can only be set for http tools")
+		return
+	}
+
+	if err := models.UpdateProjectTool(s.DB, projectTool.Identifier, updateData); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "tool updated")
+}
+
+func (s *Server) DeleteProjectTool(w http.ResponseWriter, r *http.Request) {
+	projectTool, ok := s.getProjectTool(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteProjectTool(s.DB, projectTool.Identifier); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "tool deleted")
+}
+
+// getProjectTool loads the tool of the request after checking the role of the user in its project
+func (s *Server) getProjectTool(w http.ResponseWriter, r *http.Request, role string) (*models.ProjectTool, bool) {
+	projectID, _, ok := s.checkProjectRole(w, r, role)
+	if !ok {
+		return nil, false
+	}
+
+	projectTool, err := models.GetProjectTool(s.DB, mux.Vars(r)["tool_id"])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responses.Error(w, http.StatusNotFound, "tool not found")
+			return nil, false
+		}
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if projectTool.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "tool does not belong to this project")
+		return nil, false
+	}
+	return projectTool, true
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/burnerlee/compextAI/internal/safehttp"
+	"github.com/burnerlee/compextAI/internal/tools"
+	"github.com/burnerlee/compextAI/models"
+)
+
+var (
+	// the tool names accepted by the providers
+	toolNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+func validateToolInputSchema(inputSchema json.RawMessage) error {
+	if len(inputSchema) == 0 {
+		return nil
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(inputSchema, &schema); err != nil {
+		return errors.New("input_schema should be a json schema object")
+	}
+	return nil
+}
+
+type CreateProjectToolRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+	// http or builtin
+	Type string `json:"type"`
+	// endpoint the calls are POSTed to, for http tools
+	URL string `json:"url"`
+	// timeout of the calls in seconds, for http tools
+	Timeout int `json:"timeout"`
+	// implementation of the tool, for builtin tools
+	Builtin string `json:"builtin"`
+}
+
+func (r *CreateProjectToolRequest) Validate() error {
+	if !toolNameRegex.MatchString(r.Name) {
+		return errors.New("name should be 1 to 64 letters, digits, underscores or hyphens")
+	}
+	if r.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	switch r.Type {
+	case models.ProjectToolType_HTTP:
+		if r.URL == "" {
+			return errors.New("url is required for http tools")
+		}
+		if err := safehttp.ValidateURL(r.URL); err != nil {
+			return err
+		}
+		if r.Description == "" {
+			return errors.New("description is required for http tools")
+		}
+	case models.ProjectToolType_BUILTIN:
+		if !slices.Contains(tools.BuiltinNames(), r.Builtin) {
+			return fmt.Errorf("builtin should be one of %v", tools.BuiltinNames())
+		}
+	default:
+		return fmt.Errorf("type should be one of %v", []string{models.ProjectToolType_HTTP, models.ProjectToolType_BUILTIN})
+	}
+	return validateToolInputSchema(r.InputSchema)
+}
+
+type UpdateProjectToolRequest struct {
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+	URL         string          `json:"url"`
+	Timeout     *int            `json:"timeout"`
+}
+
+func (r *UpdateProjectToolRequest) Validate() error {
+	if r.URL != "" {
+		if err := safehttp.ValidateURL(r.URL); err != nil {
+			return err
+		}
+	}
+	if r.Timeout != nil && *r.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return validateToolInputSchema(r.InputSchema)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) GetProjectUsage(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	request, err := newGetProjectUsageRequest(r.URL.Query())
+	if err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usage, err := models.GetProjectUsage(s.DB, projectID, request.GroupBy, request.MetadataKey, request.From, request.To)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if usage == nil {
+		usage = make([]models.UsageRollup, 0)
+	}
+
+	responses.JSON(w, http.StatusOK, &GetProjectUsageResponse{
+		GroupBy:     request.GroupBy,
+		MetadataKey: request.MetadataKey,
+		Usage:       usage,
+	})
+}
+
+func (s *Server) GetThreadUsage(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	threadID := mux.Vars(r)["id"]
+
+	if threadID == "" {
+		responses.Error(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	hasAccess, err := utils.CheckThreadAccess(s.DB, threadID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "You are not authorized to access this thread")
+		return
+	}
+
+	usage, err := models.GetThreadUsage(s.DB, threadID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, usage)
+}
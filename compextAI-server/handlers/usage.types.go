@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	// layout of the from and to query params of the usage endpoints
+	USAGE_DATE_LAYOUT = "2006-01-02"
+)
+
+type GetProjectUsageRequest struct {
+	GroupBy     string
+	MetadataKey string
+	// executions created on or after from, and before the end of the to day
+	From *time.Time
+	To   *time.Time
+}
+
+func newGetProjectUsageRequest(query url.Values) (*GetProjectUsageRequest, error) {
+	request := &GetProjectUsageRequest{
+		GroupBy:     query.Get("group_by"),
+		MetadataKey: query.Get("metadata_key"),
+	}
+	if request.GroupBy == "" {
+		request.GroupBy = models.UsageGroupBy_DAY
+	}
+
+	if from := query.Get("from"); from != "" {
+		fromDate, err := time.Parse(USAGE_DATE_LAYOUT, from)
+		if err != nil {
+			return nil, fmt.Errorf("from should be a date in the format %s", USAGE_DATE_LAYOUT)
+		}
+		request.From = &fromDate
+	}
+	if to := query.Get("to"); to != "" {
+		toDate, err := time.Parse(USAGE_DATE_LAYOUT, to)
+		if err != nil {
+			return nil, fmt.Errorf("to should be a date in the format %s", USAGE_DATE_LAYOUT)
+		}
+		toDate = toDate.AddDate(0, 0, 1)
+		request.To = &toDate
+	}
+
+	return request, nil
+}
+
+func (r *GetProjectUsageRequest) Validate() error {
+	groupBys := []string{models.UsageGroupBy_DAY, models.UsageGroupBy_MODEL, models.UsageGroupBy_ENVIRONMENT, models.UsageGroupBy_METADATA}
+	if !slices.Contains(groupBys, r.GroupBy) {
+		return fmt.Errorf("group_by should be one of %v", groupBys)
+	}
+	if r.GroupBy == models.UsageGroupBy_METADATA && r.MetadataKey == "" {
+		return fmt.Errorf("metadata_key is required when grouping by metadata")
+	}
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		return fmt.Errorf("from should not be after to")
+	}
+	return nil
+}
+
+type GetProjectUsageResponse struct {
+	GroupBy     string               `json:"group_by"`
+	MetadataKey string               `json:"metadata_key,omitempty"`
+	Usage       []models.UsageRollup `json:"usage"`
+}
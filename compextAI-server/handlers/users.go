@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/internal/secrets"
 	"github.com/burnerlee/compextAI/models"
 	"github.com/burnerlee/compextAI/utils"
 	"github.com/burnerlee/compextAI/utils/responses"
@@ -22,7 +24,7 @@ func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := controllers.CreateUser(s.DB, &controllers.CreateUserRequest{
+	_, apiToken, err := controllers.CreateUser(s.DB, &controllers.CreateUserRequest{
 		Username: request.Username,
 		Password: request.Password,
 		Email:    request.Email,
@@ -33,7 +35,7 @@ func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responses.JSON(w, http.StatusOK, CreateUserResponse{APIToken: user.APIToken})
+	responses.JSON(w, http.StatusOK, CreateUserResponse{APIToken: apiToken})
 }
 
 func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
@@ -48,17 +50,59 @@ func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := controllers.Login(s.DB, &controllers.LoginRequest{
+	_, apiToken, err := controllers.Login(s.DB, &controllers.LoginRequest{
 		Username: request.Username,
 		Password: request.Password,
 	})
 
 	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidCredentials):
+			responses.Error(w, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, controllers.ErrUserLocked):
+			responses.Error(w, http.StatusTooManyRequests, err.Error())
+		default:
+			responses.Error(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, LoginResponse{APIToken: apiToken})
+}
+
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var request ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	apiToken, err := controllers.ChangePassword(s.DB, &controllers.ChangePasswordRequest{
+		UserID:          uint(userID),
+		CurrentPassword: request.CurrentPassword,
+		NewPassword:     request.NewPassword,
+	})
+	if err != nil {
+		if errors.Is(err, controllers.ErrInvalidCredentials) {
+			responses.Error(w, http.StatusUnauthorized, "current password is invalid")
+			return
+		}
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	responses.JSON(w, http.StatusOK, LoginResponse{APIToken: user.APIToken})
+	responses.JSON(w, http.StatusOK, ChangePasswordResponse{APIToken: apiToken})
 }
 
 func (s *Server) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
@@ -75,8 +119,11 @@ func (s *Server) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 	}
 
 	responses.JSON(w, http.StatusOK, ListAPIKeysResponse{
-		AnthropicKey: user.AnthropicKey,
-		OpenAIKey:    user.OpenAIKey,
+		AnthropicKey:              secrets.Mask(user.AnthropicKey),
+		OpenAIKey:                 secrets.Mask(user.OpenAIKey),
+		AzureKey:                  secrets.Mask(user.AzureKey),
+		AzureEndpoint:             user.AzureEndpoint,
+		GoogleServiceAccountCreds: secrets.MaskJSON(user.GoogleServiceAccountCreds),
 	})
 }
 
@@ -93,12 +140,18 @@ func (s *Server) UpdateAPIKeys(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := models.UpdateUser(s.DB, &models.User{
-		Base: models.Base{
-			ID: uint(userID),
-		},
-		AnthropicKey: request.AnthropicKey,
-		OpenAIKey:    request.OpenAIKey,
+	if len(request.GoogleServiceAccountCreds) > 0 && !json.Valid(request.GoogleServiceAccountCreds) {
+		responses.Error(w, http.StatusBadRequest, "google_service_account_creds should be valid json")
+		return
+	}
+
+	if err := controllers.UpdateUserProviderKeys(s.DB, &controllers.UpdateUserProviderKeysRequest{
+		UserID:                    uint(userID),
+		OpenAIKey:                 request.OpenAIKey,
+		AnthropicKey:              request.AnthropicKey,
+		AzureKey:                  request.AzureKey,
+		AzureEndpoint:             request.AzureEndpoint,
+		GoogleServiceAccountCreds: request.GoogleServiceAccountCreds,
 	}); err != nil {
 		responses.Error(w, http.StatusInternalServerError, err.Error())
 		return
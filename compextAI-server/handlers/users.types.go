@@ -1,6 +1,9 @@
 package handlers
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+)
 
 type CreateUserRequest struct {
 	Username string `json:"username"`
@@ -44,12 +47,39 @@ type LoginResponse struct {
 	APIToken string `json:"api_token"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	if r.CurrentPassword == "" || r.NewPassword == "" {
+		return errors.New("current_password and new_password are required")
+	}
+	if len(r.NewPassword) < 8 {
+		return errors.New("password must be at least 8 characters long")
+	}
+	return nil
+}
+
+type ChangePasswordResponse struct {
+	// the api token is rotated when the password is changed
+	APIToken string `json:"api_token"`
+}
+
+// the keys are masked, only the last characters of a key are returned
 type ListAPIKeysResponse struct {
-	AnthropicKey string `json:"anthropic_key"`
-	OpenAIKey    string `json:"openai_key"`
+	AnthropicKey              string `json:"anthropic_key"`
+	OpenAIKey                 string `json:"openai_key"`
+	AzureKey                  string `json:"azure_key"`
+	AzureEndpoint             string `json:"azure_endpoint"`
+	GoogleServiceAccountCreds string `json:"google_service_account_creds"`
 }
 
 type UpdateAPIKeysRequest struct {
-	AnthropicKey string `json:"anthropic_key"`
-	OpenAIKey    string `json:"openai_key"`
+	AnthropicKey              string          `json:"anthropic_key"`
+	OpenAIKey                 string          `json:"openai_key"`
+	AzureKey                  string          `json:"azure_key"`
+	AzureEndpoint             string          `json:"azure_endpoint"`
+	GoogleServiceAccountCreds json.RawMessage `json:"google_service_account_creds"`
 }
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/burnerlee/compextAI/controllers"
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/internal/webhooks"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	projectWebhooks, err := models.GetProjectWebhooks(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the secrets are only returned when the webhooks are created
+	for i := range projectWebhooks {
+		projectWebhooks[i].Secret = ""
+	}
+
+	responses.JSON(w, http.StatusOK, projectWebhooks)
+}
+
+func (s *Server) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	var request CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	encryptedSecret, err := secrets.Encrypt(secret)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	webhook := &models.Webhook{
+		UserID:    uint(userID),
+		ProjectID: projectID,
+		URL:       request.URL,
+		Secret:    encryptedSecret,
+		Events:    request.Events,
+		Active:    true,
+	}
+
+	if err := models.CreateWebhook(s.DB, webhook); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the secret is only shown when the webhook is created
+	webhook.Secret = secret
+
+	responses.JSON(w, http.StatusOK, webhook)
+}
+
+func (s *Server) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+	webhookID := mux.Vars(r)["webhook_id"]
+
+	if projectID == "" || webhookID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and webhook id are required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	var request UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		responses.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existingWebhook, err := models.GetWebhook(s.DB, webhookID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if existingWebhook.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "webhook does not belong to this project")
+		return
+	}
+
+	if err := models.UpdateWebhook(s.DB, &models.Webhook{
+		Base: models.Base{
+			Identifier: existingWebhook.Identifier,
+		},
+		URL:    request.URL,
+		Events: request.Events,
+	}, request.Active); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "webhook updated")
+}
+
+func (s *Server) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+	webhookID := mux.Vars(r)["webhook_id"]
+
+	if projectID == "" || webhookID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and webhook id are required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_EDITOR)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	existingWebhook, err := models.GetWebhook(s.DB, webhookID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if existingWebhook.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "webhook does not belong to this project")
+		return
+	}
+
+	if err := models.DeleteWebhook(s.DB, webhookID); err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, "webhook deleted")
+}
+
+func (s *Server) GetProjectWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_OWNER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	secret, err := controllers.GetProjectWebhookSecret(s.DB, projectID)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, &WebhookSecretResponse{
+		Secret: secret,
+	})
+}
+
+func (s *Server) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["id"]
+
+	if projectID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id is required")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), models.Role_VIEWER)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+
+	searchFilters := r.URL.Query().Get("filters")
+	var searchFiltersMap map[string]string
+	if searchFilters != "" {
+		searchFilters, err = url.QueryUnescape(searchFilters)
+		if err != nil {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := json.Unmarshal([]byte(searchFilters), &searchFiltersMap); err != nil {
+			responses.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	deliveries, total, err := models.GetProjectWebhookDeliveries(s.DB, projectID, searchFiltersMap, page, limit)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, struct {
+		Deliveries []models.WebhookDelivery `json:"deliveries"`
+		Total      int                      `json:"total"`
+	}{
+		Deliveries: deliveries,
+		Total:      int(total),
+	})
+}
+
+func (s *Server) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := s.getProjectWebhookDelivery(w, r, models.Role_VIEWER)
+	if !ok {
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, delivery)
+}
+
+func (s *Server) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := s.getProjectWebhookDelivery(w, r, models.Role_EDITOR)
+	if !ok {
+		return
+	}
+
+	replay, err := controllers.ReplayWebhookDelivery(s.DB, delivery)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, replay)
+}
+
+// getProjectWebhookDelivery loads the delivery of the request after checking the user has the role on its project.
+// The error response is written if the delivery can't be returned.
+func (s *Server) getProjectWebhookDelivery(w http.ResponseWriter, r *http.Request, role string) (*models.WebhookDelivery, bool) {
+	projectID := mux.Vars(r)["id"]
+	deliveryID := mux.Vars(r)["delivery_id"]
+
+	if projectID == "" || deliveryID == "" {
+		responses.Error(w, http.StatusBadRequest, "project id and delivery id are required")
+		return nil, false
+	}
+
+	userID, err := utils.GetUserIDFromRequest(r)
+	if err != nil {
+		responses.Error(w, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+
+	hasAccess, err := utils.CheckProjectAccess(s.DB, projectID, uint(userID), role)
+	if err != nil {
+		responses.Error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	if !hasAccess {
+		responses.Error(w, http.StatusForbidden, "you do not have access to this project")
+		return nil, false
+	}
+
+	delivery, err := models.GetWebhookDelivery(s.DB, deliveryID)
+	if err != nil {
+		responses.Error(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+
+	if delivery.ProjectID != projectID {
+		responses.Error(w, http.StatusForbidden, "delivery does not belong to this project")
+		return nil, false
+	}
+
+	return delivery, true
+}
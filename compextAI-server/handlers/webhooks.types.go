@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/burnerlee/compextAI/internal/safehttp"
+	"github.com/burnerlee/compextAI/models"
+)
+
+var (
+	webhookEvents = []string{models.WebhookEvent_EXECUTION_COMPLETED, models.WebhookEvent_EXECUTION_FAILED, models.WebhookEvent_EXECUTION_CANCELLED}
+)
+
+func validateWebhookEvents(events []string) error {
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("invalid event %s, events should be one of %v", event, webhookEvents)
+		}
+	}
+	return nil
+}
+
+type CreateWebhookRequest struct {
+	URL string `json:"url"`
+	// events to subscribe to, all the events if empty
+	Events []string `json:"events"`
+}
+
+func (r *CreateWebhookRequest) Validate() error {
+	if r.URL == "" {
+		return errors.New("url is required")
+	}
+	if err := safehttp.ValidateURL(r.URL); err != nil {
+		return err
+	}
+	return validateWebhookEvents(r.Events)
+}
+
+type UpdateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func (r *UpdateWebhookRequest) Validate() error {
+	if r.URL != "" {
+		if err := safehttp.ValidateURL(r.URL); err != nil {
+			return err
+		}
+	}
+	return validateWebhookEvents(r.Events)
+}
+
+type WebhookSecretResponse struct {
+	Secret string `json:"secret"`
+}
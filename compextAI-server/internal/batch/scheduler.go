@@ -0,0 +1,110 @@
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/env"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/worker"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL_SECONDS  = 5
+	DEFAULT_LEASE_DURATION_SECONDS = 60
+)
+
+var (
+	scheduler *Scheduler
+)
+
+// Handler submits the pending items of a leased batch, up to the concurrency of the batch.
+// It is responsible for finishing the batch once all of its items are finished.
+type Handler func(ctx context.Context, db *gorm.DB, batch *models.Batch)
+
+type Config struct {
+	// how often the active batches are scheduled without being notified
+	PollInterval time.Duration
+	// how long a batch stays leased to a scheduler which stopped
+	LeaseDuration time.Duration
+}
+
+func ConfigFromEnv() *Config {
+	return &Config{
+		PollInterval:  env.Seconds("BATCH_POLL_INTERVAL_SECONDS", DEFAULT_POLL_INTERVAL_SECONDS),
+		LeaseDuration: env.Seconds("BATCH_LEASE_SECONDS", DEFAULT_LEASE_DURATION_SECONDS),
+	}
+}
+
+// Scheduler feeds the items of the active batches to the execution queue. A batch is leased
+// to one scheduler at a time, so its concurrency holds when several processes are running.
+// The scheduler runs when a batch is created, when an execution of a batch finishes and
+// every PollInterval, which also picks up the batches of the schedulers which stopped.
+type Scheduler struct {
+	db      *gorm.DB
+	handler Handler
+	config  *Config
+	// identifies this process as the lease owner
+	schedulerID string
+	pool        *worker.Pool
+}
+
+func Init(db *gorm.DB, handler Handler, config *Config) *Scheduler {
+	scheduler = &Scheduler{
+		db:          db,
+		handler:     handler,
+		config:      config,
+		schedulerID: worker.NewID(),
+	}
+	// a single worker schedules all the batches, once per wake up
+	scheduler.pool = worker.NewPool(1, config.PollInterval, func(ctx context.Context) bool {
+		scheduler.schedule(ctx)
+		return false
+	})
+	return scheduler
+}
+
+// Notify wakes up the scheduler to submit the next items of the batches.
+func Notify() {
+	if scheduler == nil {
+		return
+	}
+	scheduler.pool.Notify()
+}
+
+// Start starts the scheduler, it stops once the context is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.pool.Start(ctx)
+	logger.GetLogger().Infof("Batch scheduler started: %s", s.schedulerID)
+}
+
+func (s *Scheduler) schedule(ctx context.Context) {
+	batchIDs, err := models.GetActiveBatchIDs(s.db)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting active batches: %v", err)
+		return
+	}
+
+	for _, batchID := range batchIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		batch, err := models.ClaimBatchLease(s.db, batchID, s.schedulerID, s.config.LeaseDuration)
+		if err != nil {
+			logger.GetLogger().Errorf("Error claiming batch: %s: %v", batchID, err)
+			continue
+		}
+		if batch == nil {
+			// leased to another scheduler or finished meanwhile
+			continue
+		}
+
+		s.handler(ctx, s.db, batch)
+
+		if err := models.ReleaseBatchLease(s.db, batchID, s.schedulerID); err != nil {
+			logger.GetLogger().Errorf("Error releasing batch: %s: %v", batchID, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/env"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	STORE_POSTGRES = "postgres"
+	STORE_REDIS    = "redis"
+
+	DEFAULT_TTL_SECONDS            = 24 * 60 * 60
+	DEFAULT_PURGE_INTERVAL_SECONDS = 10 * 60
+	DEFAULT_REDIS_TIMEOUT_SECONDS  = 5
+	DEFAULT_REDIS_POOL_SIZE        = 10
+)
+
+var (
+	store  Store
+	config *Config
+)
+
+// Entry is a provider response cached for the executions of a project made with the same request
+type Entry struct {
+	ProjectID string `json:"project_id"`
+	// hash of the request, see controllers.responseCacheKey
+	Key      string          `json:"key"`
+	Model    string          `json:"model"`
+	Response json.RawMessage `json:"response"`
+	// execution which produced the response
+	ThreadExecutionID string    `json:"thread_execution_id"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// Store keeps the cached responses, entries are scoped to their project
+type Store interface {
+	// Get returns the entry of the project for the key, nil if there is none or it expired
+	Get(ctx context.Context, projectID, key string) (*Entry, error)
+	// Put creates the entry or replaces the entry of its project for the same key
+	Put(ctx context.Context, entry *Entry) error
+	// Invalidate removes all the entries of the project
+	Invalidate(ctx context.Context, projectID string) error
+}
+
+type Config struct {
+	// postgres or redis
+	Store string
+	// how long the responses are cached when the request does not set a ttl
+	DefaultTTL time.Duration
+	// how often the expired entries are removed from postgres, redis expires them itself
+	PurgeInterval time.Duration
+
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisTimeout  time.Duration
+	// connections opened to redis at most
+	RedisPoolSize int
+}
+
+func ConfigFromEnv() *Config {
+	storeName := os.Getenv("RESPONSE_CACHE_STORE")
+	if storeName == "" {
+		storeName = STORE_POSTGRES
+	}
+	return &Config{
+		Store:         storeName,
+		DefaultTTL:    time.Duration(env.NonNegativeInt("RESPONSE_CACHE_DEFAULT_TTL_SECONDS", DEFAULT_TTL_SECONDS)) * time.Second,
+		PurgeInterval: time.Duration(env.NonNegativeInt("RESPONSE_CACHE_PURGE_INTERVAL_SECONDS", DEFAULT_PURGE_INTERVAL_SECONDS)) * time.Second,
+		RedisAddr:     os.Getenv("REDIS_ADDR"),
+		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		RedisDB:       env.NonNegativeInt("REDIS_DB", 0),
+		RedisTimeout:  time.Duration(env.NonNegativeInt("REDIS_TIMEOUT_SECONDS", DEFAULT_REDIS_TIMEOUT_SECONDS)) * time.Second,
+		RedisPoolSize: env.NonNegativeInt("REDIS_POOL_SIZE", DEFAULT_REDIS_POOL_SIZE),
+	}
+}
+
+// Init creates the store the responses are cached in
+func Init(db *gorm.DB, cfg *Config) error {
+	switch cfg.Store {
+	case STORE_POSTGRES:
+		store = newPostgresStore(db)
+	case STORE_REDIS:
+		if cfg.RedisAddr == "" {
+			return fmt.Errorf("REDIS_ADDR is required for the redis response cache")
+		}
+		store = newRedisStore(newRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.RedisTimeout, cfg.RedisPoolSize))
+	default:
+		return fmt.Errorf("unknown response cache store: %s", cfg.Store)
+	}
+	config = cfg
+	return nil
+}
+
+// Start removes the expired entries from postgres periodically, until the context is cancelled
+func Start(ctx context.Context) {
+	postgres, ok := store.(*postgresStore)
+	if !ok || config.PurgeInterval <= 0 {
+		return
+	}
+	go postgres.purge(ctx, config.PurgeInterval)
+	logger.GetLogger().Infof("Response cache purge started, every %s", config.PurgeInterval)
+}
+
+// DefaultTTL is how long the responses are cached when the request does not set a ttl
+func DefaultTTL() time.Duration {
+	if config == nil || config.DefaultTTL <= 0 {
+		return DEFAULT_TTL_SECONDS * time.Second
+	}
+	return config.DefaultTTL
+}
+
+// Get returns the cached entry of the project for the key, nil when the cache is not initialized
+func Get(ctx context.Context, projectID, key string) (*Entry, error) {
+	if store == nil {
+		return nil, nil
+	}
+	return store.Get(ctx, projectID, key)
+}
+
+func Put(ctx context.Context, entry *Entry) error {
+	if store == nil {
+		return nil
+	}
+	return store.Put(ctx, entry)
+}
+
+func Invalidate(ctx context.Context, projectID string) error {
+	if store == nil {
+		return nil
+	}
+	return store.Invalidate(ctx, projectID)
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+// postgresStore keeps the entries in the response_cache_entries table
+type postgresStore struct {
+	db *gorm.DB
+}
+
+func newPostgresStore(db *gorm.DB) *postgresStore {
+	return &postgresStore{db: db}
+}
+
+func (s *postgresStore) Get(ctx context.Context, projectID, key string) (*Entry, error) {
+	entry, err := models.GetResponseCacheEntry(s.db.WithContext(ctx), projectID, key)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &Entry{
+		ProjectID:         entry.ProjectID,
+		Key:               entry.Key,
+		Model:             entry.Model,
+		Response:          entry.Response,
+		ThreadExecutionID: entry.ThreadExecutionID,
+		ExpiresAt:         entry.ExpiresAt,
+	}, nil
+}
+
+func (s *postgresStore) Put(ctx context.Context, entry *Entry) error {
+	return models.PutResponseCacheEntry(s.db.WithContext(ctx), &models.ResponseCacheEntry{
+		ProjectID:         entry.ProjectID,
+		Key:               entry.Key,
+		Model:             entry.Model,
+		Response:          entry.Response,
+		ThreadExecutionID: entry.ThreadExecutionID,
+		ExpiresAt:         entry.ExpiresAt,
+	})
+}
+
+func (s *postgresStore) Invalidate(ctx context.Context, projectID string) error {
+	_, err := models.DeleteProjectResponseCacheEntries(s.db.WithContext(ctx), projectID)
+	return err
+}
+
+func (s *postgresStore) purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := models.DeleteExpiredResponseCacheEntries(s.db, time.Now())
+			if err != nil {
+				logger.GetLogger().Errorf("Error purging expired response cache entries: %v", err)
+				continue
+			}
+			if purged > 0 {
+				logger.GetLogger().Infof("Purged %d expired response cache entries", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/testdb"
+	"github.com/burnerlee/compextAI/models"
+)
+
+func TestPostgresStore(t *testing.T) {
+	db := testdb.Open(t, &models.ResponseCacheEntry{})
+	store := newPostgresStore(db)
+	ctx := context.Background()
+
+	put := func(projectID, key string, expiresAt time.Time) {
+		t.Helper()
+		if err := store.Put(ctx, &Entry{ProjectID: projectID, Key: key, Model: "gpt-4o", Response: []byte(`{"id":"1"}`), ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("error putting the entry %s: %v", key, err)
+		}
+	}
+	get := func(projectID, key string) *Entry {
+		t.Helper()
+		entry, err := store.Get(ctx, projectID, key)
+		if err != nil {
+			t.Fatalf("error getting the entry %s: %v", key, err)
+		}
+		return entry
+	}
+
+	put("project", "fresh", time.Now().Add(time.Minute))
+	put("project", "expired", time.Now().Add(-time.Minute))
+	put("other", "fresh", time.Now().Add(time.Minute))
+
+	entry := get("project", "fresh")
+	if entry == nil || entry.Model != "gpt-4o" || string(entry.Response) != `{"id":"1"}` {
+		t.Fatalf("expected the fresh entry, got %+v", entry)
+	}
+	if entry := get("project", "expired"); entry != nil {
+		t.Fatalf("expected the expired entry to be filtered out, got %+v", entry)
+	}
+	if entry := get("project", "missing"); entry != nil {
+		t.Fatalf("expected no entry, got %+v", entry)
+	}
+
+	// putting the key again replaces the expired entry
+	put("project", "expired", time.Now().Add(time.Minute))
+	if entry := get("project", "expired"); entry == nil {
+		t.Fatal("expected the replaced entry")
+	}
+
+	put("project", "stale", time.Now().Add(-time.Minute))
+	purged, err := models.DeleteExpiredResponseCacheEntries(db, time.Now())
+	if err != nil || purged != 1 {
+		t.Fatalf("expected the stale entry to be purged, got %d, %v", purged, err)
+	}
+
+	if err := store.Invalidate(ctx, "project"); err != nil {
+		t.Fatalf("error invalidating the project: %v", err)
+	}
+	if entry := get("project", "fresh"); entry != nil {
+		t.Fatalf("expected the entry to be invalidated, got %+v", entry)
+	}
+	if entry := get("other", "fresh"); entry == nil {
+		t.Fatal("expected the entries of the other projects to be kept")
+	}
+}
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// keys of the redis store, entries are namespaced by the generation of their project
+// so a project is invalidated by bumping its generation, the old entries expire on their own
+const (
+	redisGenerationKey = "compext:response_cache:%s:generation"
+	redisEntryKey      = "compext:response_cache:%s:%d:%s"
+)
+
+// redisStore keeps the entries in redis with their ttl
+type redisStore struct {
+	client *redisClient
+}
+
+func newRedisStore(client *redisClient) *redisStore {
+	return &redisStore{client: client}
+}
+
+func (s *redisStore) generation(ctx context.Context, projectID string) (int64, error) {
+	reply, err := s.client.do(ctx, "GET", fmt.Sprintf(redisGenerationKey, projectID))
+	if err != nil || reply == nil {
+		return 0, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return 0, fmt.Errorf("unexpected redis reply: %T", reply)
+	}
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+func (s *redisStore) Get(ctx context.Context, projectID, key string) (*Entry, error) {
+	generation, err := s.generation(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.client.do(ctx, "GET", fmt.Sprintf(redisEntryKey, projectID, generation, key))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis reply: %T", reply)
+	}
+	var entry Entry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *redisStore) Put(ctx context.Context, entry *Entry) error {
+	ttl := time.Until(entry.ExpiresAt).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+	generation, err := s.generation(ctx, entry.ProjectID)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do(ctx, "SET", fmt.Sprintf(redisEntryKey, entry.ProjectID, generation, entry.Key), string(value), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (s *redisStore) Invalidate(ctx context.Context, projectID string) error {
+	_, err := s.client.do(ctx, "INCR", fmt.Sprintf(redisGenerationKey, projectID))
+	return err
+}
+
+// redisError is an error reply of redis
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient is a minimal client of the redis protocol (RESP2). The commands run on a pool of
+// at most poolSize connections, a connection is closed instead of reused after an error.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	// a slot is taken for every open connection
+	slots chan struct{}
+	// the open connections which are not running a command
+	idle chan *redisConn
+}
+
+// redisConn is a connection of the pool, it runs one command at a time
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newRedisClient(addr, password string, db int, timeout time.Duration, poolSize int) *redisClient {
+	if poolSize <= 0 {
+		poolSize = DEFAULT_REDIS_POOL_SIZE
+	}
+	return &redisClient{
+		addr:     addr,
+		password: password,
+		db:       db,
+		timeout:  timeout,
+		slots:    make(chan struct{}, poolSize),
+		idle:     make(chan *redisConn, poolSize),
+	}
+}
+
+// do runs the command and returns its reply: nil, a string for the status replies, an int64,
+// the []byte of a bulk string or an []interface{} of replies
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.slots }()
+
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.roundTrip(ctx, c.timeout, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// the connection is in an unknown state
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+// get returns an idle connection of the pool or dials a new one
+func (c *redisClient) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+		return c.connect(ctx)
+	}
+}
+
+func (c *redisClient) put(conn *redisConn) {
+	select {
+	case c.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+func (c *redisClient) connect(ctx context.Context) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if c.password != "" {
+		if _, err := conn.roundTrip(ctx, c.timeout, []string{"AUTH", c.password}); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.roundTrip(ctx, c.timeout, []string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisConn) roundTrip(ctx context.Context, timeout time.Duration, args []string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.conn.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+// encodeCommand encodes the command as an array of bulk strings
+func encodeCommand(args []string) []byte {
+	command := make([]byte, 0, 64)
+	command = append(command, fmt.Sprintf("*%d\r\n", len(args))...)
+	for _, arg := range args {
+		command = append(command, fmt.Sprintf("$%d\r\n", len(arg))...)
+		command = append(command, arg...)
+		command = append(command, "\r\n"...)
+	}
+	return command
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed redis reply: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis bulk length: %q", line)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		if value[length] != '\r' || value[length+1] != '\n' {
+			return nil, fmt.Errorf("malformed redis bulk string of length %d", length)
+		}
+		return value[:length], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis array length: %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			value, err := readReply(reader)
+			var replyErr redisError
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			if err != nil {
+				value = replyErr
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply: %q", line)
+	}
+}
@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a redis server keeping strings in memory, it implements the commands of the
+// redis store: AUTH, SELECT, GET, SET (with PX, which is ignored) and INCR
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu     sync.Mutex
+	values map[string]string
+	// connections accepted, and open at the same time at most
+	accepted int32
+	open     int32
+	maxOpen  int32
+	// the connections are closed without a reply after this many commands, when set
+	dropAfter int
+	// delay before every reply
+	delay time.Duration
+}
+
+// start starts the fake redis server, point the client at fakeRedis.addr()
+func (s *fakeRedis) start(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	s.listener = listener
+	s.values = make(map[string]string)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	open := atomic.AddInt32(&s.open, 1)
+	defer atomic.AddInt32(&s.open, -1)
+	for {
+		maxOpen := atomic.LoadInt32(&s.maxOpen)
+		if open <= maxOpen || atomic.CompareAndSwapInt32(&s.maxOpen, maxOpen, open) {
+			break
+		}
+	}
+
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for commands := 0; ; commands++ {
+		// the commands are arrays of bulk strings, the reply parser reads them the same way
+		request, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		if s.dropAfter > 0 && commands >= s.dropAfter {
+			return
+		}
+		if s.delay > 0 {
+			time.Sleep(s.delay)
+		}
+
+		args := request.([]interface{})
+		name := strings.ToUpper(string(args[0].([]byte)))
+		if !authenticated && name != "AUTH" {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+
+		s.mu.Lock()
+		var reply string
+		switch name {
+		case "AUTH":
+			if string(args[1].([]byte)) != s.password {
+				reply = "-WRONGPASS invalid password\r\n"
+				break
+			}
+			authenticated = true
+			reply = "+OK\r\n"
+		case "SELECT":
+			reply = "+OK\r\n"
+		case "GET":
+			value, ok := s.values[string(args[1].([]byte))]
+			if !ok {
+				reply = "$-1\r\n"
+				break
+			}
+			reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+		case "SET":
+			s.values[string(args[1].([]byte))] = string(args[2].([]byte))
+			reply = "+OK\r\n"
+		case "INCR":
+			key := string(args[1].([]byte))
+			value, _ := strconv.ParseInt(s.values[key], 10, 64)
+			s.values[key] = strconv.FormatInt(value+1, 10)
+			reply = ":" + s.values[key] + "\r\n"
+		default:
+			reply = "-ERR unknown command '" + name + "'\r\n"
+		}
+		s.mu.Unlock()
+
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    interface{}
+		wantErr string
+	}{
+		{name: "status", input: "+OK\r\n", want: "OK"},
+		{name: "error", input: "-ERR wrong type\r\n", wantErr: "redis: ERR wrong type"},
+		{name: "integer", input: ":42\r\n", want: int64(42)},
+		{name: "negative integer", input: ":-3\r\n", want: int64(-3)},
+		{name: "bulk string", input: "$5\r\nhello\r\n", want: []byte("hello")},
+		{name: "bulk string with crlf", input: "$7\r\nab\r\ncd.\r\n", want: []byte("ab\r\ncd.")},
+		{name: "empty bulk string", input: "$0\r\n\r\n", want: []byte{}},
+		{name: "null bulk string", input: "$-1\r\n", want: nil},
+		{name: "array", input: "*3\r\n:1\r\n$1\r\na\r\n+b\r\n", want: []interface{}{int64(1), []byte("a"), "b"}},
+		{name: "nested array", input: "*2\r\n*1\r\n:1\r\n*0\r\n", want: []interface{}{[]interface{}{int64(1)}, []interface{}{}}},
+		{name: "array with error", input: "*2\r\n:1\r\n-ERR no\r\n", want: []interface{}{int64(1), redisError("ERR no")}},
+		{name: "null array", input: "*-1\r\n", want: nil},
+		{name: "missing cr", input: "+OK\n", wantErr: "malformed redis reply"},
+		{name: "empty line", input: "\r\n", wantErr: "empty redis reply"},
+		{name: "unknown type", input: "!1\r\n", wantErr: "unknown redis reply"},
+		{name: "invalid integer", input: ":x\r\n", wantErr: "invalid syntax"},
+		{name: "invalid bulk length", input: "$x\r\n", wantErr: "malformed redis bulk length"},
+		{name: "bulk string without crlf", input: "$2\r\nabcd", wantErr: "malformed redis bulk string"},
+		{name: "truncated bulk string", input: "$5\r\nhe", wantErr: "unexpected EOF"},
+		{name: "invalid array length", input: "*x\r\n", wantErr: "malformed redis array length"},
+		{name: "truncated array", input: "*2\r\n:1\r\n", wantErr: "EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, err := readReply(bufio.NewReader(strings.NewReader(test.input)))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v (reply %#v)", test.wantErr, err, reply)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(reply, test.want) {
+				t.Fatalf("expected %#v, got %#v", test.want, reply)
+			}
+		})
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	command := encodeCommand([]string{"SET", "key", "a\r\nb", ""})
+	want := "*4\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\n$0\r\n\r\n"
+	if string(command) != want {
+		t.Fatalf("expected %q, got %q", want, command)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	server := (&fakeRedis{password: "secret"}).start(t)
+	store := newRedisStore(newRedisClient(server.addr(), "secret", 1, time.Second, 2))
+	ctx := context.Background()
+
+	entry, err := store.Get(ctx, "project", "key")
+	if err != nil || entry != nil {
+		t.Fatalf("expected no entry, got %v, %v", entry, err)
+	}
+
+	put := &Entry{ProjectID: "project", Key: "key", Model: "gpt-4o", Response: []byte(`{"id":"1"}`), ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.Put(ctx, put); err != nil {
+		t.Fatalf("error putting the entry: %v", err)
+	}
+	entry, err = store.Get(ctx, "project", "key")
+	if err != nil || entry == nil {
+		t.Fatalf("expected the entry, got %v, %v", entry, err)
+	}
+	if entry.Model != put.Model || string(entry.Response) != string(put.Response) {
+		t.Fatalf("expected %+v, got %+v", put, entry)
+	}
+	if entry, _ := store.Get(ctx, "other", "key"); entry != nil {
+		t.Fatalf("expected the entries to be scoped to their project, got %+v", entry)
+	}
+
+	// expired entries are not stored
+	if err := store.Put(ctx, &Entry{ProjectID: "project", Key: "expired", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("error putting the expired entry: %v", err)
+	}
+	if entry, _ := store.Get(ctx, "project", "expired"); entry != nil {
+		t.Fatalf("expected the expired entry to be skipped, got %+v", entry)
+	}
+
+	if err := store.Invalidate(ctx, "project"); err != nil {
+		t.Fatalf("error invalidating the project: %v", err)
+	}
+	if entry, err := store.Get(ctx, "project", "key"); err != nil || entry != nil {
+		t.Fatalf("expected the entry to be invalidated, got %v, %v", entry, err)
+	}
+}
+
+func TestRedisClientWrongPassword(t *testing.T) {
+	server := (&fakeRedis{password: "secret"}).start(t)
+	client := newRedisClient(server.addr(), "wrong", 0, time.Second, 1)
+
+	_, err := client.do(context.Background(), "GET", "key")
+	var replyErr redisError
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("expected a redis error, got %v", err)
+	}
+}
+
+func TestRedisClientErrorReplyKeepsConnection(t *testing.T) {
+	server := (&fakeRedis{}).start(t)
+	client := newRedisClient(server.addr(), "", 0, time.Second, 1)
+	ctx := context.Background()
+
+	if _, err := client.do(ctx, "UNKNOWN"); err == nil {
+		t.Fatal("expected an error reply")
+	}
+	if _, err := client.do(ctx, "SET", "key", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if accepted := atomic.LoadInt32(&server.accepted); accepted != 1 {
+		t.Fatalf("expected the connection to be reused after an error reply, %d were opened", accepted)
+	}
+}
+
+func TestRedisClientRedialsAfterConnectionError(t *testing.T) {
+	server := (&fakeRedis{dropAfter: 1}).start(t)
+	client := newRedisClient(server.addr(), "", 0, time.Second, 1)
+	ctx := context.Background()
+
+	if _, err := client.do(ctx, "SET", "key", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the server drops the connection instead of replying
+	if _, err := client.do(ctx, "GET", "key"); err == nil {
+		t.Fatal("expected an error when the connection is dropped")
+	}
+	reply, err := client.do(ctx, "GET", "key")
+	if err != nil {
+		t.Fatalf("expected the client to redial, got %v", err)
+	}
+	if string(reply.([]byte)) != "value" {
+		t.Fatalf("expected value, got %q", reply)
+	}
+	if accepted := atomic.LoadInt32(&server.accepted); accepted != 2 {
+		t.Fatalf("expected 2 connections, got %d", accepted)
+	}
+}
+
+func TestRedisClientPool(t *testing.T) {
+	server := (&fakeRedis{delay: 10 * time.Millisecond}).start(t)
+	client := newRedisClient(server.addr(), "", 0, time.Second, 3)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.do(ctx, "INCR", "counter"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reply, err := client.do(ctx, "GET", "counter")
+	if err != nil || string(reply.([]byte)) != "20" {
+		t.Fatalf("expected 20 increments, got %v, %v", reply, err)
+	}
+	if maxOpen := atomic.LoadInt32(&server.maxOpen); maxOpen > 3 {
+		t.Fatalf("expected at most 3 connections open, got %d", maxOpen)
+	}
+	if accepted := atomic.LoadInt32(&server.accepted); accepted > 3 {
+		t.Fatalf("expected the connections to be reused, %d were opened", accepted)
+	}
+}
+
+func TestRedisClientWaitsForAConnection(t *testing.T) {
+	server := (&fakeRedis{delay: 200 * time.Millisecond}).start(t)
+	client := newRedisClient(server.addr(), "", 0, time.Second, 1)
+
+	go client.do(context.Background(), "GET", "key")
+	time.Sleep(50 * time.Millisecond)
+
+	// the only connection is busy until the context is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.do(ctx, "GET", "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Package env reads the settings of the server from the environment variables.
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+)
+
+// Int returns the positive integer set in the environment variable,
+// the default value if it is not set or invalid
+func Int(key string, defaultValue int) int {
+	return lookupInt(key, defaultValue, 1)
+}
+
+// NonNegativeInt is Int for the settings where 0 is a valid value, e.g. to disable a feature
+func NonNegativeInt(key string, defaultValue int) int {
+	return lookupInt(key, defaultValue, 0)
+}
+
+// Seconds returns the positive number of seconds set in the environment variable as a duration
+func Seconds(key string, defaultSeconds int) time.Duration {
+	return time.Duration(Int(key, defaultSeconds)) * time.Second
+}
+
+func lookupInt(key string, defaultValue, minValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	valueInt, err := strconv.Atoi(value)
+	if err != nil || valueInt < minValue {
+		logger.GetLogger().Warnf("Invalid value for %s: %s, using default: %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return valueInt
+}
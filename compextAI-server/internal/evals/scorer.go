@@ -0,0 +1,102 @@
+package evals
+
+import (
+	"context"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/env"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/worker"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_CONCURRENCY            = 4
+	DEFAULT_POLL_INTERVAL_SECONDS  = 5
+	DEFAULT_LEASE_DURATION_SECONDS = 60
+)
+
+var (
+	scorer *Scorer
+)
+
+// Handler scores a claimed eval result once the execution of its item is finished,
+// and again once the executions of its llm judges are finished. A result which could not
+// be scored keeps its lease, it is retried once the lease expires.
+type Handler func(ctx context.Context, db *gorm.DB, evalResult *models.EvalResult) error
+
+type Config struct {
+	// number of results scored at the same time by this process
+	Concurrency int
+	// how often idle workers look for results to score
+	PollInterval time.Duration
+	// how long a claimed result stays leased to a worker which stopped
+	LeaseDuration time.Duration
+}
+
+func ConfigFromEnv() *Config {
+	return &Config{
+		Concurrency:   env.Int("EVAL_SCORER_CONCURRENCY", DEFAULT_CONCURRENCY),
+		PollInterval:  env.Seconds("EVAL_SCORER_POLL_INTERVAL_SECONDS", DEFAULT_POLL_INTERVAL_SECONDS),
+		LeaseDuration: env.Seconds("EVAL_SCORER_LEASE_SECONDS", DEFAULT_LEASE_DURATION_SECONDS),
+	}
+}
+
+// Scorer scores the results of the eval runs stored in the eval_results table. A result is
+// claimed with a lease once it is ready to be scored, so it is scored by one worker at a time
+// and picked up again if the worker stops.
+type Scorer struct {
+	db      *gorm.DB
+	handler Handler
+	config  *Config
+	// identifies this process as the lease owner
+	workerID string
+	pool     *worker.Pool
+}
+
+func Init(db *gorm.DB, handler Handler, config *Config) *Scorer {
+	scorer = &Scorer{
+		db:       db,
+		handler:  handler,
+		config:   config,
+		workerID: worker.NewID(),
+	}
+	scorer.pool = worker.NewPool(config.Concurrency, config.PollInterval, scorer.scoreNext)
+	return scorer
+}
+
+// Notify wakes up an idle worker to score the results which became ready.
+func Notify() {
+	if scorer == nil {
+		return
+	}
+	scorer.pool.Notify()
+}
+
+// Start starts the workers, they stop once the context is cancelled.
+func (s *Scorer) Start(ctx context.Context) {
+	s.pool.Start(ctx)
+	logger.GetLogger().Infof("Eval scorer started with %d workers: %s", s.config.Concurrency, s.workerID)
+}
+
+// scoreNext claims and scores the next ready result
+func (s *Scorer) scoreNext(ctx context.Context) bool {
+	evalResult, err := models.ClaimNextEvalResult(s.db, s.workerID, s.config.LeaseDuration)
+	if err != nil {
+		logger.GetLogger().Errorf("Error claiming eval result: %v", err)
+		return false
+	}
+	if evalResult == nil {
+		return false
+	}
+
+	if err := s.handler(ctx, s.db, evalResult); err != nil {
+		logger.GetLogger().Errorf("Error scoring eval result: %s: %d: %v", evalResult.EvalRunID, evalResult.ID, err)
+		return true
+	}
+	if err := models.ReleaseEvalResultLease(s.db, evalResult.ID, s.workerID); err != nil {
+		logger.GetLogger().Errorf("Error releasing eval result: %d: %v", evalResult.ID, err)
+	}
+	return true
+}
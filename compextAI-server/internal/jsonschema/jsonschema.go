@@ -0,0 +1,412 @@
+// Package jsonschema validates json values against the subset of JSON Schema the providers
+// accept for structured outputs: types, enums, consts, object properties, array items,
+// string and number bounds, patterns, the combinators and local $refs.
+// Keywords outside of this subset, e.g. format, are ignored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// max number of errors reported by Validate
+const MAX_ERRORS = 20
+
+// max depth of $refs followed while validating a value, recursive schemas refer to themselves
+const maxRefDepth = 64
+
+type validator struct {
+	root   map[string]interface{}
+	errors []string
+	depth  int
+}
+
+// Validate validates the value against the schema, the value is a decoded json value.
+// It returns the validation errors prefixed with the json path of the invalid value,
+// no errors if the value is valid.
+func Validate(schema json.RawMessage, value interface{}) ([]string, error) {
+	var root interface{}
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("invalid json schema: %v", err)
+	}
+	rootSchema, ok := root.(map[string]interface{})
+	if !ok {
+		if accept, ok := root.(bool); ok {
+			if accept {
+				return nil, nil
+			}
+			return []string{"$: no value is allowed"}, nil
+		}
+		return nil, fmt.Errorf("invalid json schema: should be an object")
+	}
+
+	v := &validator{root: rootSchema}
+	if err := v.validate(rootSchema, value, "$"); err != nil {
+		return nil, err
+	}
+	return v.errors, nil
+}
+
+func (v *validator) addError(path, format string, args ...interface{}) {
+	if len(v.errors) < MAX_ERRORS {
+		v.errors = append(v.errors, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+}
+
+// valid reports whether the value matches the schema, without recording its errors
+func (v *validator) valid(schema interface{}, value interface{}, path string) (bool, error) {
+	errors := v.errors
+	v.errors = nil
+	err := v.validate(schema, value, path)
+	matched := len(v.errors) == 0
+	v.errors = errors
+	return matched, err
+}
+
+func (v *validator) validate(schema interface{}, value interface{}, path string) error {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.addError(path, "no value is allowed")
+		}
+		return nil
+	case map[string]interface{}:
+		return v.validateObjectSchema(s, value, path)
+	default:
+		return fmt.Errorf("invalid json schema at %s: should be an object or a boolean", path)
+	}
+}
+
+func (v *validator) validateObjectSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolveRef(ref)
+		if err != nil {
+			return err
+		}
+		if v.depth >= maxRefDepth {
+			return fmt.Errorf("invalid json schema: $ref %s is nested too deep", ref)
+		}
+		v.depth++
+		err = v.validate(target, value, path)
+		v.depth--
+		if err != nil {
+			return err
+		}
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		v.addError(path, "should be %s, got %s", describeTypes(types), typeOf(value))
+		// the other keywords apply to other types
+		return nil
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.addError(path, "should be one of %s", encode(enum))
+		}
+	}
+	if constant, ok := schema["const"]; ok && !equal(constant, value) {
+		v.addError(path, "should be %s", encode(constant))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if err := v.validateObject(schema, val, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := v.validateArray(schema, val, path); err != nil {
+			return err
+		}
+	case string:
+		if err := v.validateString(schema, val, path); err != nil {
+			return err
+		}
+	case float64:
+		v.validateNumber(schema, val, path)
+	}
+
+	return v.validateCombinators(schema, value, path)
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := object[name]; !ok {
+					v.addError(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	// the properties are validated in order, so the errors are stable
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := fmt.Sprintf("%s.%s", path, name)
+		if propertySchema, ok := properties[name]; ok {
+			if err := v.validate(propertySchema, object[name], propertyPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := schema["additionalProperties"]; ok {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.addError(path, "unexpected property %q", name)
+				continue
+			}
+			if err := v.validate(additional, object[name], propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	if minProperties, ok := number(schema["minProperties"]); ok && float64(len(object)) < minProperties {
+		v.addError(path, "should have at least %v properties", minProperties)
+	}
+	if maxProperties, ok := number(schema["maxProperties"]); ok && float64(len(object)) > maxProperties {
+		v.addError(path, "should have at most %v properties", maxProperties)
+	}
+	return nil
+}
+
+func (v *validator) validateArray(schema map[string]interface{}, array []interface{}, path string) error {
+	if minItems, ok := number(schema["minItems"]); ok && float64(len(array)) < minItems {
+		v.addError(path, "should have at least %v items", minItems)
+	}
+	if maxItems, ok := number(schema["maxItems"]); ok && float64(len(array)) > maxItems {
+		v.addError(path, "should have at most %v items", maxItems)
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := range array {
+			for j := 0; j < i; j++ {
+				if equal(array[i], array[j]) {
+					v.addError(path, "items %d and %d should be unique", j, i)
+				}
+			}
+		}
+	}
+
+	prefixItems, _ := schema["prefixItems"].([]interface{})
+	for i, item := range array {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		if i < len(prefixItems) {
+			if err := v.validate(prefixItems[i], item, itemPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if items, ok := schema["items"]; ok {
+			if err := v.validate(items, item, itemPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateString(schema map[string]interface{}, str string, path string) error {
+	length := float64(len([]rune(str)))
+	if minLength, ok := number(schema["minLength"]); ok && length < minLength {
+		v.addError(path, "should be at least %v characters long", minLength)
+	}
+	if maxLength, ok := number(schema["maxLength"]); ok && length > maxLength {
+		v.addError(path, "should be at most %v characters long", maxLength)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid json schema at %s: invalid pattern %q: %v", path, pattern, err)
+		}
+		if !re.MatchString(str) {
+			v.addError(path, "should match the pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateNumber(schema map[string]interface{}, num float64, path string) {
+	if minimum, ok := number(schema["minimum"]); ok && num < minimum {
+		v.addError(path, "should be at least %v", minimum)
+	}
+	if maximum, ok := number(schema["maximum"]); ok && num > maximum {
+		v.addError(path, "should be at most %v", maximum)
+	}
+	if minimum, ok := number(schema["exclusiveMinimum"]); ok && num <= minimum {
+		v.addError(path, "should be greater than %v", minimum)
+	}
+	if maximum, ok := number(schema["exclusiveMaximum"]); ok && num >= maximum {
+		v.addError(path, "should be less than %v", maximum)
+	}
+	if multipleOf, ok := number(schema["multipleOf"]); ok && multipleOf > 0 {
+		if quotient := num / multipleOf; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.addError(path, "should be a multiple of %v", multipleOf)
+		}
+	}
+}
+
+func (v *validator) validateCombinators(schema map[string]interface{}, value interface{}, path string) error {
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, subschema := range allOf {
+			if err := v.validate(subschema, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, subschema := range anyOf {
+			valid, err := v.valid(subschema, value, path)
+			if err != nil {
+				return err
+			}
+			if valid {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.addError(path, "should match at least one of the anyOf schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, subschema := range oneOf {
+			valid, err := v.valid(subschema, value, path)
+			if err != nil {
+				return err
+			}
+			if valid {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.addError(path, "should match exactly one of the oneOf schemas, matches %d", matches)
+		}
+	}
+	if not, ok := schema["not"]; ok {
+		valid, err := v.valid(not, value, path)
+		if err != nil {
+			return err
+		}
+		if valid {
+			v.addError(path, "should not match the not schema")
+		}
+	}
+	return nil
+}
+
+// resolveRef resolves a $ref pointing into the root schema, e.g. #/$defs/address
+func (v *validator) resolveRef(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("invalid json schema: only local $refs are supported: %s", ref)
+	}
+	var current interface{} = v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid json schema: can not resolve $ref %s", ref)
+		}
+		if current, ok = object[token]; !ok {
+			return nil, fmt.Errorf("invalid json schema: can not resolve $ref %s", ref)
+		}
+	}
+	return current, nil
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSingleType(t, value)
+	case []interface{}:
+		for _, single := range t {
+			if name, ok := single.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func matchesSingleType(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		num, ok := value.(float64)
+		return ok && num == math.Trunc(num)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return typeOf(value) == name
+	}
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func describeTypes(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprintf("%v", name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprintf("%v", types)
+}
+
+func number(value interface{}) (float64, bool) {
+	num, ok := value.(float64)
+	return num, ok
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func encode(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
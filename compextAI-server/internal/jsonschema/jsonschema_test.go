@@ -0,0 +1,231 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateTest struct {
+	name   string
+	schema string
+	value  string
+	// the validation errors, nil when the value is valid
+	errors []string
+	// the schema is invalid when set, Validate returns an error containing it
+	err string
+}
+
+func runValidateTests(t *testing.T, tests []validateTest) {
+	t.Helper()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+				t.Fatalf("invalid test value: %v", err)
+			}
+			errors, err := Validate(json.RawMessage(test.schema), value)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(errors, test.errors) {
+				t.Fatalf("expected the errors %q, got %q", test.errors, errors)
+			}
+		})
+	}
+}
+
+func TestValidateSchemas(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "true schema", schema: `true`, value: `{"a": 1}`},
+		{name: "false schema", schema: `false`, value: `1`, errors: []string{"$: no value is allowed"}},
+		{name: "empty schema", schema: `{}`, value: `[1, "a", null]`},
+		{name: "false subschema", schema: `{"properties": {"a": false}}`, value: `{"a": 1}`, errors: []string{"$.a: no value is allowed"}},
+		{name: "invalid json", schema: `{`, value: `1`, err: "invalid json schema"},
+		{name: "not an object", schema: `[]`, value: `1`, err: "invalid json schema: should be an object"},
+		{name: "invalid subschema", schema: `{"items": 1}`, value: `[1]`, err: "invalid json schema at $[0]"},
+		{name: "unsupported keyword", schema: `{"type": "string", "format": "email"}`, value: `"not an email"`},
+	})
+}
+
+func TestValidateType(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "string", schema: `{"type": "string"}`, value: `"a"`},
+		{name: "not a string", schema: `{"type": "string"}`, value: `1`, errors: []string{"$: should be string, got number"}},
+		{name: "number", schema: `{"type": "number"}`, value: `1.5`},
+		{name: "integer", schema: `{"type": "integer"}`, value: `2`},
+		{name: "integer with a zero fraction", schema: `{"type": "integer"}`, value: `2.0`},
+		{name: "not an integer", schema: `{"type": "integer"}`, value: `2.5`, errors: []string{"$: should be integer, got number"}},
+		{name: "boolean", schema: `{"type": "boolean"}`, value: `false`},
+		{name: "null", schema: `{"type": "null"}`, value: `null`},
+		{name: "not null", schema: `{"type": "null"}`, value: `"null"`, errors: []string{"$: should be null, got string"}},
+		{name: "array", schema: `{"type": "array"}`, value: `[]`},
+		{name: "object", schema: `{"type": "object"}`, value: `{}`},
+		{name: "not an object", schema: `{"type": "object"}`, value: `[]`, errors: []string{"$: should be object, got array"}},
+		{name: "list of types", schema: `{"type": ["string", "null"]}`, value: `null`},
+		{name: "not in the list of types", schema: `{"type": ["string", "null"]}`, value: `true`, errors: []string{"$: should be string or null, got boolean"}},
+		// the keywords of the other types are not checked once the type does not match
+		{name: "type mismatch skips the other keywords", schema: `{"type": "string", "minLength": 2, "enum": ["ab"]}`, value: `1`, errors: []string{"$: should be string, got number"}},
+	})
+}
+
+func TestValidateEnumAndConst(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "enum", schema: `{"enum": ["a", 1, null]}`, value: `1`},
+		{name: "enum null", schema: `{"enum": ["a", 1, null]}`, value: `null`},
+		{name: "not in enum", schema: `{"enum": ["a", 1, null]}`, value: `"b"`, errors: []string{`$: should be one of ["a",1,null]`}},
+		{name: "enum of objects", schema: `{"enum": [{"a": [1]}]}`, value: `{"a": [1]}`},
+		{name: "const", schema: `{"const": "a"}`, value: `"a"`},
+		{name: "not the const", schema: `{"const": {"a": 1}}`, value: `{"a": 2}`, errors: []string{`$: should be {"a":1}`}},
+		{name: "const false", schema: `{"const": false}`, value: `0`, errors: []string{"$: should be false"}},
+	})
+}
+
+func TestValidateObject(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "properties", schema: `{"properties": {"a": {"type": "string"}}}`, value: `{"a": "x", "b": 1}`},
+		{name: "invalid property", schema: `{"properties": {"a": {"type": "string"}}}`, value: `{"a": 1}`, errors: []string{"$.a: should be string, got number"}},
+		{name: "required", schema: `{"required": ["a", "b"]}`, value: `{"a": 1, "b": null}`},
+		{name: "missing required", schema: `{"required": ["a", "b"]}`, value: `{"c": 1}`, errors: []string{`$: missing required property "a"`, `$: missing required property "b"`}},
+		{name: "no additional properties", schema: `{"properties": {"a": {}}, "additionalProperties": false}`, value: `{"a": 1, "c": 2, "b": 3}`, errors: []string{`$: unexpected property "b"`, `$: unexpected property "c"`}},
+		{name: "additional properties schema", schema: `{"properties": {"a": {}}, "additionalProperties": {"type": "integer"}}`, value: `{"a": "x", "b": 1, "c": "y"}`, errors: []string{"$.c: should be integer, got string"}},
+		{name: "min properties", schema: `{"minProperties": 2}`, value: `{"a": 1}`, errors: []string{"$: should have at least 2 properties"}},
+		{name: "max properties", schema: `{"maxProperties": 1}`, value: `{"a": 1, "b": 2}`, errors: []string{"$: should have at most 1 properties"}},
+		{name: "nested paths", schema: `{"properties": {"a": {"properties": {"b": {"type": "string"}}}}}`, value: `{"a": {"b": 1}}`, errors: []string{"$.a.b: should be string, got number"}},
+		{name: "object keywords ignore other types", schema: `{"required": ["a"]}`, value: `"a"`},
+	})
+}
+
+func TestValidateArray(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "items", schema: `{"items": {"type": "number"}}`, value: `[1, 2]`},
+		{name: "invalid items", schema: `{"items": {"type": "number"}}`, value: `[1, "a", true]`, errors: []string{"$[1]: should be number, got string", "$[2]: should be number, got boolean"}},
+		{name: "prefix items", schema: `{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, value: `["a", 1]`},
+		{name: "invalid prefix items", schema: `{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, value: `[1, "a"]`, errors: []string{"$[0]: should be string, got number", "$[1]: should be number, got string"}},
+		{name: "min items", schema: `{"minItems": 1}`, value: `[]`, errors: []string{"$: should have at least 1 items"}},
+		{name: "max items", schema: `{"maxItems": 1}`, value: `[1, 2]`, errors: []string{"$: should have at most 1 items"}},
+		{name: "unique items", schema: `{"uniqueItems": true}`, value: `[1, "1", {"a": 1}]`},
+		{name: "duplicate items", schema: `{"uniqueItems": true}`, value: `[{"a": 1}, 2, {"a": 1}]`, errors: []string{"$: items 0 and 2 should be unique"}},
+		{name: "duplicates allowed", schema: `{"uniqueItems": false}`, value: `[1, 1]`},
+	})
+}
+
+func TestValidateString(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "length", schema: `{"minLength": 2, "maxLength": 3}`, value: `"abc"`},
+		{name: "too short", schema: `{"minLength": 2}`, value: `"a"`, errors: []string{"$: should be at least 2 characters long"}},
+		{name: "too long", schema: `{"maxLength": 2}`, value: `"abc"`, errors: []string{"$: should be at most 2 characters long"}},
+		// the length is counted in characters, not bytes
+		{name: "multibyte length", schema: `{"maxLength": 2}`, value: `"éé"`},
+		{name: "pattern", schema: `{"pattern": "^[a-z]+$"}`, value: `"abc"`},
+		{name: "pattern mismatch", schema: `{"pattern": "^[a-z]+$"}`, value: `"ABC"`, errors: []string{`$: should match the pattern "^[a-z]+$"`}},
+		{name: "unanchored pattern", schema: `{"pattern": "b"}`, value: `"abc"`},
+		{name: "invalid pattern", schema: `{"pattern": "("}`, value: `"a"`, err: `invalid pattern "("`},
+	})
+}
+
+func TestValidateNumber(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "bounds", schema: `{"minimum": 1, "maximum": 3}`, value: `3`},
+		{name: "below minimum", schema: `{"minimum": 1}`, value: `0.5`, errors: []string{"$: should be at least 1"}},
+		{name: "above maximum", schema: `{"maximum": 3}`, value: `4`, errors: []string{"$: should be at most 3"}},
+		{name: "exclusive bounds", schema: `{"exclusiveMinimum": 1, "exclusiveMaximum": 3}`, value: `2`},
+		{name: "exclusive minimum", schema: `{"exclusiveMinimum": 1}`, value: `1`, errors: []string{"$: should be greater than 1"}},
+		{name: "exclusive maximum", schema: `{"exclusiveMaximum": 3}`, value: `3`, errors: []string{"$: should be less than 3"}},
+		{name: "multiple of", schema: `{"multipleOf": 0.1}`, value: `0.3`},
+		{name: "not a multiple of", schema: `{"multipleOf": 2}`, value: `3`, errors: []string{"$: should be a multiple of 2"}},
+	})
+}
+
+func TestValidateCombinators(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "all of", schema: `{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, value: `2`},
+		{name: "not all of", schema: `{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, value: `4`, errors: []string{"$: should be at most 3"}},
+		{name: "any of", schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, value: `1`},
+		{name: "none of any of", schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, value: `true`, errors: []string{"$: should match at least one of the anyOf schemas"}},
+		{name: "one of", schema: `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, value: `1`},
+		{name: "more than one of", schema: `{"oneOf": [{"type": "integer"}, {"type": "number"}]}`, value: `1`, errors: []string{"$: should match exactly one of the oneOf schemas, matches 2"}},
+		{name: "none of one of", schema: `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, value: `null`, errors: []string{"$: should match exactly one of the oneOf schemas, matches 0"}},
+		{name: "not", schema: `{"not": {"type": "string"}}`, value: `1`},
+		{name: "matches not", schema: `{"not": {"type": "string"}}`, value: `"a"`, errors: []string{"$: should not match the not schema"}},
+		// the errors of the subschemas tried by anyOf are not reported
+		{name: "any of errors are dropped", schema: `{"anyOf": [{"required": ["a"]}, {"required": ["b"]}]}`, value: `{"b": 1}`},
+		{name: "invalid schema in any of", schema: `{"anyOf": [{"pattern": "("}]}`, value: `"a"`, err: "invalid pattern"},
+	})
+}
+
+func TestValidateRef(t *testing.T) {
+	runValidateTests(t, []validateTest{
+		{name: "defs", schema: `{"$defs": {"name": {"type": "string"}}, "properties": {"a": {"$ref": "#/$defs/name"}}}`, value: `{"a": "x"}`},
+		{name: "invalid ref value", schema: `{"$defs": {"name": {"type": "string"}}, "properties": {"a": {"$ref": "#/$defs/name"}}}`, value: `{"a": 1}`, errors: []string{"$.a: should be string, got number"}},
+		{name: "escaped pointer", schema: `{"$defs": {"a/b": {"const": 1}, "c~d": {"const": 2}}, "prefixItems": [{"$ref": "#/$defs/a~1b"}, {"$ref": "#/$defs/c~0d"}]}`, value: `[1, 2]`},
+		{name: "ref with siblings", schema: `{"$defs": {"name": {"type": "string"}}, "$ref": "#/$defs/name", "minLength": 2}`, value: `"a"`, errors: []string{"$: should be at least 2 characters long"}},
+		{name: "unresolvable ref", schema: `{"$ref": "#/$defs/missing"}`, value: `1`, err: "can not resolve $ref #/$defs/missing"},
+		{name: "remote ref", schema: `{"$ref": "https://example.com/schema.json"}`, value: `1`, err: "only local $refs are supported"},
+		{name: "ref into a non object", schema: `{"$defs": {"list": [1]}, "$ref": "#/$defs/list/0"}`, value: `1`, err: "can not resolve $ref"},
+	})
+}
+
+// tree returns a value nested depth times in the children of the root
+func tree(depth int) string {
+	value := `{"name": "leaf"}`
+	for i := 0; i < depth; i++ {
+		value = fmt.Sprintf(`{"name": "node", "children": [%s]}`, value)
+	}
+	return value
+}
+
+func TestValidateRecursiveRef(t *testing.T) {
+	treeSchema := `{
+		"$defs": {"node": {
+			"type": "object",
+			"properties": {"name": {"type": "string"}, "children": {"type": "array", "items": {"$ref": "#/$defs/node"}}},
+			"required": ["name"]
+		}},
+		"$ref": "#/$defs/node"
+	}`
+	runValidateTests(t, []validateTest{
+		{name: "recursive schema", schema: treeSchema, value: tree(3)},
+		{name: "invalid nested value", schema: treeSchema, value: `{"name": "root", "children": [{"children": []}]}`, errors: []string{`$.children[0]: missing required property "name"`}},
+		{name: "nesting below the max ref depth", schema: treeSchema, value: tree(maxRefDepth - 1)},
+		// every level of the value follows a $ref, the root takes one more
+		{name: "nesting beyond the max ref depth", schema: treeSchema, value: tree(maxRefDepth), err: "is nested too deep"},
+		{name: "self reference", schema: `{"$ref": "#"}`, value: `1`, err: "$ref # is nested too deep"},
+		{name: "reference cycle", schema: `{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, value: `1`, err: "is nested too deep"},
+		{name: "recursion through combinators", schema: `{"anyOf": [{"type": "number"}, {"$ref": "#"}]}`, value: `"a"`, err: "is nested too deep"},
+	})
+}
+
+func TestValidateMaxErrors(t *testing.T) {
+	values := make([]string, MAX_ERRORS+5)
+	for i := range values {
+		values[i] = `"a"`
+	}
+	errors, err := Validate(json.RawMessage(`{"items": {"type": "number"}}`), mustDecode(t, "["+strings.Join(values, ",")+"]"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errors) != MAX_ERRORS {
+		t.Fatalf("expected %d errors, got %d", MAX_ERRORS, len(errors))
+	}
+	if errors[MAX_ERRORS-1] != fmt.Sprintf("$[%d]: should be number, got string", MAX_ERRORS-1) {
+		t.Fatalf("expected the first errors to be kept, got %q", errors[MAX_ERRORS-1])
+	}
+}
+
+func mustDecode(t *testing.T, value string) interface{} {
+	t.Helper()
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		t.Fatalf("invalid test value: %v", err)
+	}
+	return decoded
+}
@@ -0,0 +1,76 @@
+package prompts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Prompts are go text/template templates, the variables are referenced with {{.name}}.
+// Rendering is strict, referencing a variable which is not provided is an error.
+
+// Render renders the text with the variables
+func Render(text string, variables map[string]interface{}) (string, error) {
+	// skip the parsing of the texts which can not contain any action
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	if variables == nil {
+		variables = make(map[string]interface{})
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, variables); err != nil {
+		return "", fmt.Errorf("error rendering template: %w", err)
+	}
+	return rendered.String(), nil
+}
+
+// RenderContentMap renders the content of a message, stored as {"content": ...}. The content is
+// either a string or a list of parts, the text of the parts is rendered and other parts are kept as is.
+func RenderContentMap(contentMap json.RawMessage, variables map[string]interface{}) (json.RawMessage, error) {
+	if len(contentMap) == 0 {
+		return contentMap, nil
+	}
+
+	var content map[string]interface{}
+	if err := json.Unmarshal(contentMap, &content); err != nil {
+		return nil, fmt.Errorf("error unmarshalling message content: %w", err)
+	}
+
+	switch value := content["content"].(type) {
+	case string:
+		rendered, err := Render(value, variables)
+		if err != nil {
+			return nil, err
+		}
+		content["content"] = rendered
+	case []interface{}:
+		for i, part := range value {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			text, ok := partMap["text"].(string)
+			if !ok {
+				continue
+			}
+			rendered, err := Render(text, variables)
+			if err != nil {
+				return nil, fmt.Errorf("content part %d: %w", i, err)
+			}
+			partMap["text"] = rendered
+		}
+	default:
+		return contentMap, nil
+	}
+
+	return json.Marshal(content)
+}
@@ -1,13 +1,17 @@
 package anthropic
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/burnerlee/compextAI/internal/logger"
 	"github.com/burnerlee/compextAI/internal/providers/chat/base"
+	"github.com/burnerlee/compextAI/internal/secrets"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -34,7 +38,7 @@ func NewClaude35() *Claude35 {
 	return &Claude35{
 		owner:         ANTHROPIC_OWNER,
 		model:         ANTHROPIC_MODEL,
-		allowedRoles:  []string{"user", "assistant", "system"},
+		allowedRoles:  []string{"user", "assistant", "system", "tool"},
 		executorRoute: ANTHROPIC_EXECUTOR_ROUTE,
 	}
 }
@@ -67,6 +71,86 @@ type claude35Message struct {
 	Content interface{} `json:"content"`
 }
 
+// toolCall is the format of Message.ToolCalls, the same as the tool calls of the openai chat completions
+type toolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function toolCallFunction `json:"function"`
+}
+
+type toolCallFunction struct {
+	Name string `json:"name"`
+	// json encoded arguments of the call, a json object is accepted as well
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type claudeTextBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type claudeToolUseBlock struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type claudeToolResultBlock struct {
+	Type      string      `json:"type"`
+	ToolUseID string      `json:"tool_use_id"`
+	Content   interface{} `json:"content"`
+}
+
+// parseToolCalls returns the tool calls of a message, messages without tool calls store null, {} or []
+func parseToolCalls(toolCallsJson json.RawMessage) ([]toolCall, error) {
+	trimmed := bytes.TrimSpace(toolCallsJson)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, nil
+	}
+	var toolCalls []toolCall
+	if err := json.Unmarshal(trimmed, &toolCalls); err != nil {
+		return nil, fmt.Errorf("invalid tool calls: %v", err)
+	}
+	return toolCalls, nil
+}
+
+// toolCallInput converts the arguments of a tool call to the input object of a tool_use block
+func toolCallInput(arguments json.RawMessage) (json.RawMessage, error) {
+	arguments = bytes.TrimSpace(arguments)
+	if len(arguments) > 0 && arguments[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(arguments, &encoded); err != nil {
+			return nil, err
+		}
+		arguments = bytes.TrimSpace([]byte(encoded))
+	}
+	if len(arguments) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	if !json.Valid(arguments) || arguments[0] != '{' {
+		return nil, fmt.Errorf("tool call arguments should be a json object")
+	}
+	return arguments, nil
+}
+
+// contentBlocks returns the content of a message as a list of content blocks
+func contentBlocks(content interface{}) []interface{} {
+	switch c := content.(type) {
+	case nil:
+		return []interface{}{}
+	case string:
+		if c == "" {
+			return []interface{}{}
+		}
+		return []interface{}{claudeTextBlock{Type: "text", Text: c}}
+	case []interface{}:
+		return c
+	default:
+		return []interface{}{c}
+	}
+}
+
 func (g *Claude35) ConvertMessageToProviderFormat(message *models.Message) (interface{}, error) {
 	var contentMap map[string]interface{}
 	if err := json.Unmarshal(message.ContentMap, &contentMap); err != nil {
@@ -76,6 +160,48 @@ func (g *Claude35) ConvertMessageToProviderFormat(message *models.Message) (inte
 	if !ok {
 		return nil, fmt.Errorf("content map does not contain 'content' key")
 	}
+
+	switch message.Role {
+	case "tool":
+		// anthropic has no tool role, the results of the tools are sent by the user
+		if message.ToolCallID == "" {
+			return nil, fmt.Errorf("tool message is missing the tool_call_id")
+		}
+		return claude35Message{
+			Role: "user",
+			Content: []interface{}{claudeToolResultBlock{
+				Type:      "tool_result",
+				ToolUseID: message.ToolCallID,
+				Content:   content,
+			}},
+		}, nil
+	case "assistant":
+		toolCalls, err := parseToolCalls(message.ToolCalls)
+		if err != nil {
+			return nil, err
+		}
+		if len(toolCalls) == 0 {
+			break
+		}
+		blocks := contentBlocks(content)
+		for _, call := range toolCalls {
+			input, err := toolCallInput(call.Function.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("tool call %s: %v", call.ID, err)
+			}
+			blocks = append(blocks, claudeToolUseBlock{
+				Type:  "tool_use",
+				ID:    call.ID,
+				Name:  call.Function.Name,
+				Input: input,
+			})
+		}
+		return claude35Message{
+			Role:    message.Role,
+			Content: blocks,
+		}, nil
+	}
+
 	return claude35Message{
 		Role:    message.Role,
 		Content: content,
@@ -88,16 +214,57 @@ func (g *Claude35) ConvertExecutionResponseToMessage(response interface{}) (*mod
 		return nil, fmt.Errorf("response is not a map")
 	}
 
-	contentChoices := responseMap["content"].([]interface{})
-	if len(contentChoices) == 0 {
+	contentChoices, ok := responseMap["content"].([]interface{})
+	if !ok || len(contentChoices) == 0 {
 		return nil, fmt.Errorf("no content found")
 	}
-	contentChoice := contentChoices[0].(map[string]interface{})
+
+	// the text blocks make the content of the message and the tool_use blocks its tool calls
+	texts := make([]string, 0)
+	toolCalls := make([]toolCall, 0)
+	for _, contentChoice := range contentChoices {
+		block, ok := contentChoice.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("content block is not a map")
+		}
+		switch block["type"] {
+		case "text":
+			text, _ := block["text"].(string)
+			texts = append(texts, text)
+		case "tool_use":
+			id, _ := block["id"].(string)
+			name, _ := block["name"].(string)
+			input := block["input"]
+			if input == nil {
+				input = map[string]interface{}{}
+			}
+			arguments, err := json.Marshal(input)
+			if err != nil {
+				return nil, err
+			}
+			encodedArguments, err := json.Marshal(string(arguments))
+			if err != nil {
+				return nil, err
+			}
+			toolCalls = append(toolCalls, toolCall{
+				ID:   id,
+				Type: "function",
+				Function: toolCallFunction{
+					Name:      name,
+					Arguments: encodedArguments,
+				},
+			})
+		}
+	}
 
 	var content any
-	content, ok = contentChoice["text"].(string)
-	if !ok {
-		content = contentChoice
+	switch {
+	case len(texts) > 0:
+		content = strings.Join(texts, "")
+	case len(toolCalls) > 0:
+		content = ""
+	default:
+		content = contentChoices[0]
 	}
 
 	role, ok := responseMap["role"].(string)
@@ -111,6 +278,7 @@ func (g *Claude35) ConvertExecutionResponseToMessage(response interface{}) (*mod
 	metadata := map[string]interface{}{
 		"anthropic_chat_completion_id": anthropicChatCompletionID,
 		"usage":                        usage,
+		"stop_reason":                  responseMap["stop_reason"],
 	}
 
 	metadataJson, err := json.Marshal(metadata)
@@ -127,11 +295,18 @@ func (g *Claude35) ConvertExecutionResponseToMessage(response interface{}) (*mod
 		return nil, fmt.Errorf("error marshalling content map: %v", err)
 	}
 
-	return &models.Message{
+	message := &models.Message{
 		Role:       role,
 		ContentMap: contentMapJson,
 		Metadata:   metadataJson,
-	}, nil
+	}
+	if len(toolCalls) > 0 {
+		message.ToolCalls, err = json.Marshal(toolCalls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
 }
 
 type claudeTool struct {
@@ -155,10 +330,11 @@ func (d *claude35ExecutionData) Validate() error {
 	return nil
 }
 
-func (g *Claude35) ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error) {
+func (g *Claude35) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
 	systemPrompt := ""
 
 	modelMessages := make([]claude35Message, 0)
+	previousRole := ""
 	for _, message := range messages {
 		modelMessage, err := g.ConvertMessageToProviderFormat(message)
 		if err != nil {
@@ -184,6 +360,13 @@ func (g *Claude35) ExecuteThread(db *gorm.DB, user *models.User, messages []*mod
 			systemPrompt = systemPromptStr
 			continue
 		}
+		// the results of parallel tool calls have to be sent in a single user message
+		if message.Role == "tool" && previousRole == "tool" {
+			previous := &modelMessages[len(modelMessages)-1]
+			previous.Content = append(previous.Content.([]interface{}), modelMessage.(claude35Message).Content.([]interface{})...)
+			continue
+		}
+		previousRole = message.Role
 		modelMessages = append(modelMessages, modelMessage.(claude35Message))
 	}
 
@@ -210,8 +393,14 @@ func (g *Claude35) ExecuteThread(db *gorm.DB, user *models.User, messages []*mod
 			InputSchema: tool.InputSchema,
 		})
 	}
+	anthropicKey, err := secrets.Decrypt(user.AnthropicKey)
+	if err != nil {
+		logger.GetLogger().Errorf("Error decrypting the anthropic key: %v", err)
+		return -1, nil, err
+	}
+
 	executionData := claude35ExecutionData{
-		APIKeys:        map[string]string{g.owner: user.AnthropicKey},
+		APIKeys:        map[string]string{g.owner: anthropicKey},
 		Model:          g.model,
 		Messages:       modelMessages,
 		Temperature:    threadExecutionParamsTemplate.Temperature,
@@ -228,8 +417,10 @@ func (g *Claude35) ExecuteThread(db *gorm.DB, user *models.User, messages []*mod
 	}
 
 	executionParams := &base.ExecuteParams{
-		Timeout: time.Duration(executionData.Timeout) * time.Second,
+		Timeout:     time.Duration(executionData.Timeout) * time.Second,
+		RetryPolicy: &threadExecutionParamsTemplate.RetryPolicy,
+		TemplateID:  threadExecutionParamsTemplate.Identifier,
 	}
 
-	return base.Execute(db, g.executorRoute, executionParams, executionData, threadExecutionIdentifier, modelMessages)
+	return base.Execute(ctx, db, g.executorRoute, executionParams, executionData, threadExecutionIdentifier, modelMessages, streamHandler)
 }
@@ -1,15 +1,19 @@
 package base
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
 
@@ -24,10 +28,31 @@ func getExecutorClient() *executorClient {
 }
 
 type ExecuteParams struct {
-	Timeout time.Duration
+	Timeout     time.Duration
+	RetryPolicy *models.RetryPolicy
+	// template the execution is made with, recorded on the attempts
+	TemplateID string
 }
 
-func (c *executorClient) getRequest(execRoute, method string, data interface{}) (*http.Request, error) {
+// StreamHandler is called with every chunk of content produced by the model,
+// when the execution is streamed
+type StreamHandler func(delta string)
+
+const (
+	// executors serve the streaming variant of a route under this suffix
+	STREAM_ROUTE_SUFFIX = "/stream"
+)
+
+// streamEvent is the payload of a server sent event emitted by the executor stream routes
+type streamEvent struct {
+	Type       string      `json:"type"`
+	Content    string      `json:"content"`
+	Response   interface{} `json:"response"`
+	Error      string      `json:"error"`
+	StatusCode int         `json:"status_code"`
+}
+
+func (c *executorClient) getRequest(ctx context.Context, execRoute, method string, data interface{}) (*http.Request, error) {
 	var body io.Reader
 	if data != nil {
 		dataJson, err := json.Marshal(data)
@@ -36,25 +61,95 @@ func (c *executorClient) getRequest(execRoute, method string, data interface{})
 		}
 		body = bytes.NewBuffer(dataJson)
 	}
-	request, err := http.NewRequest(method, c.BaseURL+execRoute, body)
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+execRoute, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 	return request, nil
 }
 
-func Execute(db *gorm.DB, execRoute string, executeParams *ExecuteParams, threadExecutionData interface{}, threadExecutionIdentifier string, messages interface{}) (int, interface{}, error) {
+// Execute calls the executor, retrying failed calls according to the retry policy.
+// Cancelling the context aborts the in-flight call and the pending retries.
+// The request metadata and the attempts are recorded on the execution unless db is nil,
+// which lets the providers run against a stub executor (EXECUTOR_BASE_URL) without a database.
+func Execute(ctx context.Context, db *gorm.DB, execRoute string, executeParams *ExecuteParams, threadExecutionData interface{}, threadExecutionIdentifier string, messages interface{}, streamHandler StreamHandler) (int, interface{}, error) {
 	executorClient := getExecutorClient()
 
 	// update thread execution metadata
-	if err := UpdateThreadExecutionMetadata(db, threadExecutionIdentifier, threadExecutionData, messages); err != nil {
-		logger.GetLogger().Errorf("Error updating thread execution metadata: %v", err)
-		return -1, nil, err
+	if db != nil {
+		if err := UpdateThreadExecutionMetadata(db, threadExecutionIdentifier, threadExecutionData, messages); err != nil {
+			logger.GetLogger().Errorf("Error updating thread execution metadata: %v", err)
+			return -1, nil, err
+		}
+	}
+
+	if streamHandler != nil {
+		execRoute = execRoute + STREAM_ROUTE_SUFFIX
+	}
+
+	// once a chunk has been streamed to the client, the attempt can't be retried
+	// without sending the same content twice
+	streamed := false
+	if streamHandler != nil {
+		handler := streamHandler
+		streamHandler = func(delta string) {
+			streamed = true
+			handler(delta)
+		}
+	}
+
+	retryPolicy := newRetryPolicy(executeParams.RetryPolicy)
+	for attempt := 1; ; attempt++ {
+		startedAt := time.Now()
+		statusCode, responseData, response, err := executorClient.execute(ctx, execRoute, executeParams, threadExecutionData, streamHandler)
+
+		attemptRecord := &models.ThreadExecutionAttempt{
+			TemplateID: executeParams.TemplateID,
+			Attempt:    attempt,
+			StatusCode: statusCode,
+			StartedAt:  startedAt,
+			Duration:   time.Since(startedAt).Milliseconds(),
+		}
+		if err != nil {
+			attemptRecord.Error = err.Error()
+		} else if statusCode != http.StatusOK {
+			attemptRecord.Error = fmt.Sprintf("%v", responseData)
+		}
+
+		failed := err != nil || statusCode != http.StatusOK
+		retry := failed && attempt < retryPolicy.maxAttempts && !streamed && retryPolicy.isRetryable(statusCode, err) && ctx.Err() == nil
+
+		var backoff time.Duration
+		if retry {
+			backoff, retry = retryPolicy.backoff(attempt, response)
+			attemptRecord.Backoff = backoff.Milliseconds()
+		}
+
+		if db != nil {
+			if recordErr := models.AppendThreadExecutionAttempt(db, threadExecutionIdentifier, attemptRecord); recordErr != nil {
+				logger.GetLogger().Errorf("Error recording thread execution attempt: %s: %v", threadExecutionIdentifier, recordErr)
+			}
+		}
+
+		if !retry {
+			return statusCode, responseData, err
+		}
+
+		logger.GetLogger().Warnf("Retrying thread execution: %s: attempt %d failed: status code: %d: %s, retrying in %v", threadExecutionIdentifier, attempt, statusCode, attemptRecord.Error, backoff)
+		select {
+		case <-ctx.Done():
+			return -1, nil, fmt.Errorf("execution cancelled while waiting to retry: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
 	}
+}
 
-	request, err := executorClient.getRequest(execRoute, "POST", threadExecutionData)
+// execute makes a single call to the executor.
+// The http response is returned to read the headers of failed attempts, its body is already closed.
+func (c *executorClient) execute(ctx context.Context, execRoute string, executeParams *ExecuteParams, threadExecutionData interface{}, streamHandler StreamHandler) (int, interface{}, *http.Response, error) {
+	request, err := c.getRequest(ctx, execRoute, "POST", threadExecutionData)
 	if err != nil {
-		return -1, nil, fmt.Errorf("error getting request: %w", err)
+		return -1, nil, nil, fmt.Errorf("error getting request: %w", err)
 	}
 
 	request.Header.Set("Content-Type", "application/json")
@@ -65,16 +160,59 @@ func Execute(db *gorm.DB, execRoute string, executeParams *ExecuteParams, thread
 
 	response, err := client.Do(request)
 	if err != nil {
-		return -1, nil, fmt.Errorf("error executing request: %w", err)
+		return -1, nil, nil, fmt.Errorf("error executing request: %w", err)
 	}
 
 	defer response.Body.Close()
 
+	if streamHandler != nil && response.StatusCode == http.StatusOK {
+		statusCode, responseData, err := readStream(response.Body, streamHandler)
+		return statusCode, responseData, response, err
+	}
+
 	var responseData interface{}
 	err = json.NewDecoder(response.Body).Decode(&responseData)
 	if err != nil {
-		return response.StatusCode, nil, fmt.Errorf("error decoding response: %w", err)
+		return response.StatusCode, nil, response, fmt.Errorf("error decoding response: %w", err)
 	}
 
-	return response.StatusCode, responseData, nil
+	return response.StatusCode, responseData, response, nil
+}
+
+// readStream reads the server sent events of an executor stream route, passing the content
+// chunks to the stream handler. It returns the complete response sent at the end of the stream.
+func readStream(body io.Reader, streamHandler StreamHandler) (int, interface{}, error) {
+	reader := bufio.NewReader(body)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return -1, nil, fmt.Errorf("error reading stream: %w", readErr)
+		}
+
+		// skip the empty lines separating the events and comments
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			var event streamEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+				return -1, nil, fmt.Errorf("error decoding stream event: %w", err)
+			}
+
+			switch event.Type {
+			case "delta":
+				streamHandler(event.Content)
+			case "response":
+				return http.StatusOK, event.Response, nil
+			case "error":
+				statusCode := event.StatusCode
+				if statusCode == 0 {
+					statusCode = http.StatusInternalServerError
+				}
+				return statusCode, map[string]interface{}{"error": event.Error}, nil
+			}
+		}
+
+		if readErr == io.EOF {
+			return -1, nil, fmt.Errorf("stream ended without a response")
+		}
+	}
 }
@@ -0,0 +1,87 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+// newExecutorStub starts an executor which responds to the successive calls with the status
+// codes, the last one being repeated. It returns the number of calls the executor received.
+func newExecutorStub(t *testing.T, header http.Header, statusCodes ...int) *atomic.Int32 {
+	t.Helper()
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		statusCode := statusCodes[min(call, len(statusCodes))-1]
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		io.WriteString(w, `{"content":"response"}`)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("EXECUTOR_BASE_URL", server.URL)
+	return calls
+}
+
+func TestExecuteRetries(t *testing.T) {
+	retryPolicy := &models.RetryPolicy{MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 5000}
+	tests := []struct {
+		name        string
+		retryPolicy *models.RetryPolicy
+		header      http.Header
+		statusCodes []int
+		statusCode  int
+		calls       int32
+	}{
+		{"success", retryPolicy, nil, []int{http.StatusOK}, http.StatusOK, 1},
+		{"retried until success", retryPolicy, nil, []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, http.StatusOK, 3},
+		{"attempts exhausted", retryPolicy, nil, []int{http.StatusServiceUnavailable}, http.StatusServiceUnavailable, 3},
+		{"not retryable", retryPolicy, nil, []int{http.StatusBadRequest, http.StatusOK}, http.StatusBadRequest, 1},
+		{"single attempt by default", nil, nil, []int{http.StatusServiceUnavailable, http.StatusOK}, http.StatusServiceUnavailable, 1},
+		{"Retry-After longer than the max backoff", retryPolicy, http.Header{"Retry-After": {"60"}}, []int{http.StatusTooManyRequests, http.StatusOK}, http.StatusTooManyRequests, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := newExecutorStub(t, tt.header, tt.statusCodes...)
+			statusCode, response, err := Execute(context.Background(), nil, "/chatcompletion/openai", &ExecuteParams{
+				Timeout:     time.Second,
+				RetryPolicy: tt.retryPolicy,
+			}, map[string]interface{}{}, "exec_1", nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if statusCode != tt.statusCode || response == nil {
+				t.Fatalf("expected %d with the response, got %d, %v", tt.statusCode, statusCode, response)
+			}
+			if calls.Load() != tt.calls {
+				t.Fatalf("expected %d calls, got %d", tt.calls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestExecuteCancelledWhileWaitingToRetry(t *testing.T) {
+	calls := newExecutorStub(t, nil, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	statusCode, _, err := Execute(ctx, nil, "/chatcompletion/openai", &ExecuteParams{
+		Timeout:     time.Second,
+		RetryPolicy: &models.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10000, MaxBackoff: 10000},
+	}, map[string]interface{}{}, "exec_1", nil, nil)
+	if err == nil || statusCode != -1 {
+		t.Fatalf("expected the execution to be cancelled, got %d, %v", statusCode, err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single call, got %d", calls.Load())
+	}
+}
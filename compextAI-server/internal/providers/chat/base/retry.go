@@ -0,0 +1,146 @@
+package base
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS       = 1
+	DEFAULT_RETRY_INITIAL_BACKOFF_MS = 1000
+	DEFAULT_RETRY_MAX_BACKOFF_MS     = 30000
+	DEFAULT_RETRY_BACKOFF_MULTIPLIER = 2
+	DEFAULT_RETRY_JITTER             = 0.2
+)
+
+var (
+	defaultRetryableStatusCodes = []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+// retryPolicy is a models.RetryPolicy with the defaults applied
+type retryPolicy struct {
+	maxAttempts          int
+	initialBackoff       time.Duration
+	maxBackoff           time.Duration
+	backoffMultiplier    float64
+	jitter               float64
+	retryableStatusCodes []int
+	// returns a number in [0, 1) to randomize the backoff with
+	random func() float64
+}
+
+func newRetryPolicy(policy *models.RetryPolicy) *retryPolicy {
+	p := &retryPolicy{
+		maxAttempts:          DEFAULT_RETRY_MAX_ATTEMPTS,
+		initialBackoff:       DEFAULT_RETRY_INITIAL_BACKOFF_MS * time.Millisecond,
+		maxBackoff:           DEFAULT_RETRY_MAX_BACKOFF_MS * time.Millisecond,
+		backoffMultiplier:    DEFAULT_RETRY_BACKOFF_MULTIPLIER,
+		jitter:               DEFAULT_RETRY_JITTER,
+		retryableStatusCodes: defaultRetryableStatusCodes,
+		random:               rand.Float64,
+	}
+	if policy == nil {
+		return p
+	}
+
+	if policy.MaxAttempts > 0 {
+		p.maxAttempts = policy.MaxAttempts
+	}
+	if policy.InitialBackoff > 0 {
+		p.initialBackoff = time.Duration(policy.InitialBackoff) * time.Millisecond
+	}
+	if policy.MaxBackoff > 0 {
+		p.maxBackoff = time.Duration(policy.MaxBackoff) * time.Millisecond
+	}
+	if policy.BackoffMultiplier >= 1 {
+		p.backoffMultiplier = policy.BackoffMultiplier
+	}
+	if policy.Jitter > 0 && policy.Jitter <= 1 {
+		p.jitter = policy.Jitter
+	}
+	if len(policy.RetryableStatusCodes) > 0 {
+		p.retryableStatusCodes = policy.RetryableStatusCodes
+	}
+	return p
+}
+
+// isRetryable reports if a failed attempt should be retried. Attempts which failed without
+// a response are only retried for transport errors, e.g. timeouts and connection resets.
+func (p *retryPolicy) isRetryable(statusCode int, err error) bool {
+	if statusCode <= 0 {
+		return isTransportError(err)
+	}
+	return slices.Contains(p.retryableStatusCodes, statusCode)
+}
+
+// isTransportError reports if the call failed on the connection to the executor, as opposed
+// to failing to build the request or to read a response, which fails the same way every time
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the time to wait after the given attempt (starting at 1) failed. It returns
+// false when the Retry-After header of the response asks to wait longer than the max backoff.
+func (p *retryPolicy) backoff(attempt int, response *http.Response) (time.Duration, bool) {
+	backoff := float64(p.initialBackoff) * math.Pow(p.backoffMultiplier, float64(attempt-1))
+	backoff = math.Min(backoff, float64(p.maxBackoff))
+
+	// spread the retries of concurrent executions over [backoff * (1 - jitter), backoff * (1 + jitter)]
+	backoff = math.Min(backoff*(1+p.jitter*(2*p.random()-1)), float64(p.maxBackoff))
+
+	// honour the Retry-After header of rate limited responses
+	if response != nil {
+		if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+			if retryAfter > p.maxBackoff {
+				return 0, false
+			}
+			backoff = math.Max(backoff, float64(retryAfter))
+		}
+	}
+
+	return time.Duration(backoff), true
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or an http date.
+// It returns false if the header is not set or invalid.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
@@ -0,0 +1,221 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+// fixedRandom makes the jitter deterministic
+func fixedRandom(value float64) func() float64 {
+	return func() float64 { return value }
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	p := newRetryPolicy(nil)
+	if p.maxAttempts != DEFAULT_RETRY_MAX_ATTEMPTS || p.initialBackoff != time.Second || p.maxBackoff != 30*time.Second ||
+		p.backoffMultiplier != DEFAULT_RETRY_BACKOFF_MULTIPLIER || p.jitter != DEFAULT_RETRY_JITTER ||
+		!slices.Equal(p.retryableStatusCodes, defaultRetryableStatusCodes) {
+		t.Fatalf("expected the defaults, got %+v", p)
+	}
+
+	p = newRetryPolicy(&models.RetryPolicy{
+		MaxAttempts:          5,
+		InitialBackoff:       200,
+		MaxBackoff:           2000,
+		BackoffMultiplier:    3,
+		Jitter:               0.5,
+		RetryableStatusCodes: models.StatusCodes{http.StatusTooManyRequests},
+	})
+	if p.maxAttempts != 5 || p.initialBackoff != 200*time.Millisecond || p.maxBackoff != 2*time.Second ||
+		p.backoffMultiplier != 3 || p.jitter != 0.5 || !slices.Equal(p.retryableStatusCodes, []int{http.StatusTooManyRequests}) {
+		t.Fatalf("expected the policy to override the defaults, got %+v", p)
+	}
+
+	// out of range values keep the defaults
+	p = newRetryPolicy(&models.RetryPolicy{MaxAttempts: -1, BackoffMultiplier: 0.5, Jitter: 1.5})
+	if p.maxAttempts != DEFAULT_RETRY_MAX_ATTEMPTS || p.backoffMultiplier != DEFAULT_RETRY_BACKOFF_MULTIPLIER || p.jitter != DEFAULT_RETRY_JITTER {
+		t.Fatalf("expected the invalid values to be ignored, got %+v", p)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := newRetryPolicy(&models.RetryPolicy{InitialBackoff: 100, MaxBackoff: 1000, BackoffMultiplier: 2})
+	// the middle of the jitter range is the backoff itself
+	p.random = fixedRandom(0.5)
+
+	for attempt, expected := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		backoff, ok := p.backoff(attempt+1, nil)
+		if !ok || backoff != expected {
+			t.Errorf("attempt %d: expected %v, got %v, %t", attempt+1, expected, backoff, ok)
+		}
+	}
+}
+
+func TestBackoffJitterBounds(t *testing.T) {
+	p := newRetryPolicy(&models.RetryPolicy{InitialBackoff: 1000, MaxBackoff: 10000, BackoffMultiplier: 2, Jitter: 0.2})
+
+	tests := []struct {
+		random  float64
+		attempt int
+		backoff time.Duration
+	}{
+		{0, 1, 800 * time.Millisecond},
+		{0.25, 1, 900 * time.Millisecond},
+		{0.75, 1, 1100 * time.Millisecond},
+		{1, 1, 1200 * time.Millisecond},
+		{0, 2, 1600 * time.Millisecond},
+		{1, 2, 2400 * time.Millisecond},
+		// the jitter does not go above the max backoff
+		{0, 5, 8000 * time.Millisecond},
+		{1, 5, 10000 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		p.random = fixedRandom(tt.random)
+		if backoff, _ := p.backoff(tt.attempt, nil); backoff != tt.backoff {
+			t.Errorf("random %v, attempt %d: expected %v, got %v", tt.random, tt.attempt, tt.backoff, backoff)
+		}
+	}
+
+	p = newRetryPolicy(&models.RetryPolicy{InitialBackoff: 1000, MaxBackoff: 10000, Jitter: 0.2})
+	for i := 0; i < 1000; i++ {
+		if backoff, _ := p.backoff(1, nil); backoff < 800*time.Millisecond || backoff > 1200*time.Millisecond {
+			t.Fatalf("expected the backoff within the jitter range, got %v", backoff)
+		}
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	p := newRetryPolicy(&models.RetryPolicy{InitialBackoff: 100, MaxBackoff: 5000})
+	p.random = fixedRandom(0.5)
+
+	response := func(retryAfter string) *http.Response {
+		header := http.Header{}
+		if retryAfter != "" {
+			header.Set("Retry-After", retryAfter)
+		}
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}
+	}
+
+	tests := []struct {
+		retryAfter string
+		backoff    time.Duration
+		retry      bool
+	}{
+		{"", 100 * time.Millisecond, true},
+		{"invalid", 100 * time.Millisecond, true},
+		// shorter than the backoff
+		{"0", 100 * time.Millisecond, true},
+		{"2", 2 * time.Second, true},
+		{"5", 5 * time.Second, true},
+		// longer than the max backoff, the execution fails instead of waiting
+		{"6", 0, false},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 0, false},
+	}
+	for _, tt := range tests {
+		backoff, retry := p.backoff(1, response(tt.retryAfter))
+		if backoff != tt.backoff || retry != tt.retry {
+			t.Errorf("Retry-After %q: expected %v, %t, got %v, %t", tt.retryAfter, tt.backoff, tt.retry, backoff, retry)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header     string
+		retryAfter time.Duration
+		ok         bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"soon", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		// a date in the past asks to retry right away
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		retryAfter, ok := parseRetryAfter(tt.header, now)
+		if retryAfter != tt.retryAfter || ok != tt.ok {
+			t.Errorf("%q: expected %v, %t, got %v, %t", tt.header, tt.retryAfter, tt.ok, retryAfter, ok)
+		}
+	}
+}
+
+// timeoutError is a net.Error which timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	p := newRetryPolicy(nil)
+	for _, statusCode := range []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	} {
+		if !p.isRetryable(statusCode, nil) {
+			t.Errorf("expected %d to be retryable", statusCode)
+		}
+	}
+	for _, statusCode := range []int{
+		http.StatusOK,
+		http.StatusBadRequest,
+		http.StatusUnauthorized,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusUnprocessableEntity,
+		http.StatusNotImplemented,
+	} {
+		if p.isRetryable(statusCode, nil) {
+			t.Errorf("expected %d not to be retryable", statusCode)
+		}
+	}
+
+	custom := newRetryPolicy(&models.RetryPolicy{RetryableStatusCodes: models.StatusCodes{http.StatusConflict}})
+	if !custom.isRetryable(http.StatusConflict, nil) || custom.isRetryable(http.StatusServiceUnavailable, nil) {
+		t.Error("expected only the status codes of the policy to be retryable")
+	}
+
+	// the attempts which failed without a response
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{fmt.Errorf("error executing request: %w", timeoutError{}), true},
+		{fmt.Errorf("error executing request: %w", syscall.ECONNRESET), true},
+		{fmt.Errorf("error executing request: %w", syscall.ECONNREFUSED), true},
+		{fmt.Errorf("error executing request: %w", io.ErrUnexpectedEOF), true},
+		{fmt.Errorf("error executing request: %w", io.EOF), true},
+		{fmt.Errorf("error getting request: %w", errors.New("error marshalling data")), false},
+	}
+	for _, tt := range tests {
+		if retryable := p.isRetryable(-1, tt.err); retryable != tt.retryable {
+			t.Errorf("%v: expected retryable to be %t, got %t", tt.err, tt.retryable, retryable)
+		}
+	}
+}
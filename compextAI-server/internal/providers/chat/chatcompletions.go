@@ -1,8 +1,10 @@
 package chat
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -18,7 +20,7 @@ type ChatCompletionsProvider interface {
 	GetProviderOwner() string
 	GetProviderModel() string
 	GetProviderIdentifier() string
-	ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error)
+	ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error)
 }
 
 type ChatCompletionsProvider_Enum string
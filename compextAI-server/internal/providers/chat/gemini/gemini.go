@@ -0,0 +1,633 @@
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	GEMINI_OWNER          = "google"
+	GEMINI_EXECUTOR_ROUTE = "/chatcompletion/gemini"
+
+	GEMINI_15_PRO_MODEL        = "gemini-1.5-pro-002"
+	GEMINI_15_PRO_IDENTIFIER   = "gemini-1.5-pro"
+	GEMINI_15_FLASH_MODEL      = "gemini-1.5-flash-002"
+	GEMINI_15_FLASH_IDENTIFIER = "gemini-1.5-flash"
+	GEMINI_20_FLASH_MODEL      = "gemini-2.0-flash-001"
+	GEMINI_20_FLASH_IDENTIFIER = "gemini-2.0-flash"
+
+	DEFAULT_TEMPERATURE = 0.5
+	DEFAULT_MAX_TOKENS  = 8192
+	DEFAULT_TIMEOUT     = 600
+
+	// gemini does not identify the function calls, the ids of the tool calls are generated
+	TOOL_CALL_ID_PREFIX = "call_"
+)
+
+// Gemini executes the gemini models on vertex ai with the google service account credentials of the user
+type Gemini struct {
+	owner         string
+	model         string
+	identifier    string
+	allowedRoles  []string
+	executorRoute string
+}
+
+func newGemini(model, identifier string) *Gemini {
+	return &Gemini{
+		owner:         GEMINI_OWNER,
+		model:         model,
+		identifier:    identifier,
+		allowedRoles:  []string{"user", "assistant", "system", "tool"},
+		executorRoute: GEMINI_EXECUTOR_ROUTE,
+	}
+}
+
+func NewGemini15Pro() *Gemini {
+	return newGemini(GEMINI_15_PRO_MODEL, GEMINI_15_PRO_IDENTIFIER)
+}
+
+func NewGemini15Flash() *Gemini {
+	return newGemini(GEMINI_15_FLASH_MODEL, GEMINI_15_FLASH_IDENTIFIER)
+}
+
+func NewGemini20Flash() *Gemini {
+	return newGemini(GEMINI_20_FLASH_MODEL, GEMINI_20_FLASH_IDENTIFIER)
+}
+
+func (g *Gemini) GetProviderOwner() string {
+	return g.owner
+}
+
+func (g *Gemini) GetProviderModel() string {
+	return g.model
+}
+
+func (g *Gemini) GetProviderIdentifier() string {
+	return g.identifier
+}
+
+func (g *Gemini) ValidateMessage(message *models.Message) error {
+	if message.ContentMap == nil {
+		return fmt.Errorf("message content is empty")
+	}
+
+	if !slices.Contains(g.allowedRoles, message.Role) {
+		return fmt.Errorf("message role is invalid, only %v are allowed", g.allowedRoles)
+	}
+	return nil
+}
+
+// geminiContent is a turn of the conversation, or the system instruction which has no role
+type geminiContent struct {
+	Role  string        `json:"role,omitempty"`
+	Parts []interface{} `json:"parts"`
+}
+
+type geminiTextPart struct {
+	Text string `json:"text"`
+}
+
+type geminiInlineDataPart struct {
+	InlineData geminiBlob `json:"inlineData"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	// base64 encoded
+	Data string `json:"data"`
+}
+
+type geminiFileDataPart struct {
+	FileData geminiFileData `json:"fileData"`
+}
+
+type geminiFileData struct {
+	MimeType string `json:"mimeType"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionCallPart struct {
+	FunctionCall geminiFunctionCall `json:"functionCall"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
+}
+
+type geminiFunctionResponsePart struct {
+	FunctionResponse geminiFunctionResponse `json:"functionResponse"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string      `json:"name"`
+	Response interface{} `json:"response"`
+}
+
+// toolCall is the format of Message.ToolCalls, the same as the tool calls of the openai chat completions
+type toolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function toolCallFunction `json:"function"`
+}
+
+type toolCallFunction struct {
+	Name string `json:"name"`
+	// json encoded arguments of the call, a json object is accepted as well
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// parseToolCalls returns the tool calls of a message, messages without tool calls store null, {} or []
+func parseToolCalls(toolCallsJson json.RawMessage) ([]toolCall, error) {
+	trimmed := bytes.TrimSpace(toolCallsJson)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, nil
+	}
+	var toolCalls []toolCall
+	if err := json.Unmarshal(trimmed, &toolCalls); err != nil {
+		return nil, fmt.Errorf("invalid tool calls: %v", err)
+	}
+	return toolCalls, nil
+}
+
+// toolCallArgs converts the arguments of a tool call to the args object of a function call
+func toolCallArgs(arguments json.RawMessage) (json.RawMessage, error) {
+	arguments = bytes.TrimSpace(arguments)
+	if len(arguments) > 0 && arguments[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(arguments, &encoded); err != nil {
+			return nil, err
+		}
+		arguments = bytes.TrimSpace([]byte(encoded))
+	}
+	if len(arguments) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	if !json.Valid(arguments) || arguments[0] != '{' {
+		return nil, fmt.Errorf("tool call arguments should be a json object")
+	}
+	return arguments, nil
+}
+
+// contentParts returns the content of a message as gemini parts. The content is a string
+// or a list of openai content parts, the parts already in the gemini format are kept.
+func contentParts(content interface{}) ([]interface{}, error) {
+	switch c := content.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case string:
+		if c == "" {
+			return []interface{}{}, nil
+		}
+		return []interface{}{geminiTextPart{Text: c}}, nil
+	case []interface{}:
+		parts := make([]interface{}, 0, len(c))
+		for _, item := range c {
+			part, err := contentPart(item)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		return parts, nil
+	default:
+		return nil, fmt.Errorf("message content should be a string or a list of content parts")
+	}
+}
+
+func contentPart(item interface{}) (interface{}, error) {
+	part, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("content part is not an object")
+	}
+	switch part["type"] {
+	case "text":
+		text, _ := part["text"].(string)
+		return geminiTextPart{Text: text}, nil
+	case "image_url":
+		url, _ := part["image_url"].(string)
+		if imageURL, ok := part["image_url"].(map[string]interface{}); ok {
+			url, _ = imageURL["url"].(string)
+		}
+		return urlPart(url)
+	case nil:
+		for _, key := range []string{"text", "inlineData", "fileData"} {
+			if _, ok := part[key]; ok {
+				return part, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported content part: %v", part["type"])
+}
+
+// urlPart returns the part of an image url, data urls are sent inline
+// and the other urls are referenced as files
+func urlPart(url string) (interface{}, error) {
+	if url == "" {
+		return nil, fmt.Errorf("image_url content part is missing the url")
+	}
+	if dataURL, ok := strings.CutPrefix(url, "data:"); ok {
+		mediaType, data, ok := strings.Cut(dataURL, ",")
+		mimeType, isBase64 := strings.CutSuffix(mediaType, ";base64")
+		if !ok || !isBase64 {
+			return nil, fmt.Errorf("image data urls should be base64 encoded")
+		}
+		return geminiInlineDataPart{InlineData: geminiBlob{MimeType: mimeType, Data: data}}, nil
+	}
+
+	mimeType := mime.TypeByExtension(path.Ext(strings.SplitN(url, "?", 2)[0]))
+	if mimeType == "" {
+		return nil, fmt.Errorf("could not determine the mime type of %s", url)
+	}
+	return geminiFileDataPart{FileData: geminiFileData{MimeType: strings.SplitN(mimeType, ";", 2)[0], FileURI: url}}, nil
+}
+
+// functionResponse returns the response object of a tool result, gemini only accepts
+// objects so the other results are wrapped
+func functionResponse(content interface{}) interface{} {
+	if text, ok := content.(string); ok {
+		var response map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &response); err == nil {
+			return response
+		}
+	}
+	if response, ok := content.(map[string]interface{}); ok {
+		return response
+	}
+	return map[string]interface{}{"content": content}
+}
+
+func (g *Gemini) ConvertMessageToProviderFormat(message *models.Message) (interface{}, error) {
+	return convertMessage(message, nil)
+}
+
+// convertMessage converts a message to a gemini content. The function responses of gemini are
+// matched to the calls by name, the names of the tool calls are looked up by id in toolCallNames.
+func convertMessage(message *models.Message, toolCallNames map[string]string) (*geminiContent, error) {
+	var contentMap map[string]interface{}
+	if err := json.Unmarshal(message.ContentMap, &contentMap); err != nil {
+		return nil, err
+	}
+	content, ok := contentMap["content"]
+	if !ok {
+		return nil, fmt.Errorf("content map does not contain 'content' key")
+	}
+
+	switch message.Role {
+	case "system":
+		parts, err := contentParts(content)
+		if err != nil {
+			return nil, err
+		}
+		return &geminiContent{Parts: parts}, nil
+	case "tool":
+		if message.ToolCallID == "" {
+			return nil, fmt.Errorf("tool message is missing the tool_call_id")
+		}
+		name, ok := toolCallNames[message.ToolCallID]
+		if !ok {
+			return nil, fmt.Errorf("tool message %s does not follow the tool call it responds to", message.ToolCallID)
+		}
+		// gemini has no tool role, the results of the functions are sent by the user
+		return &geminiContent{
+			Role: "user",
+			Parts: []interface{}{geminiFunctionResponsePart{
+				FunctionResponse: geminiFunctionResponse{
+					Name:     name,
+					Response: functionResponse(content),
+				},
+			}},
+		}, nil
+	case "assistant":
+		parts, err := contentParts(content)
+		if err != nil {
+			return nil, err
+		}
+		toolCalls, err := parseToolCalls(message.ToolCalls)
+		if err != nil {
+			return nil, err
+		}
+		for _, call := range toolCalls {
+			args, err := toolCallArgs(call.Function.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("tool call %s: %v", call.ID, err)
+			}
+			parts = append(parts, geminiFunctionCallPart{
+				FunctionCall: geminiFunctionCall{
+					Name: call.Function.Name,
+					Args: args,
+				},
+			})
+		}
+		return &geminiContent{
+			Role:  "model",
+			Parts: parts,
+		}, nil
+	default:
+		parts, err := contentParts(content)
+		if err != nil {
+			return nil, err
+		}
+		return &geminiContent{
+			Role:  message.Role,
+			Parts: parts,
+		}, nil
+	}
+}
+
+func (g *Gemini) ConvertExecutionResponseToMessage(response interface{}) (*models.Message, error) {
+	responseMap, ok := response.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response is not a map")
+	}
+
+	candidates, _ := responseMap["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		if promptFeedback, ok := responseMap["promptFeedback"].(map[string]interface{}); ok && promptFeedback["blockReason"] != nil {
+			return nil, fmt.Errorf("the prompt was blocked: %v", promptFeedback["blockReason"])
+		}
+		return nil, fmt.Errorf("no candidates found")
+	}
+	candidate, ok := candidates[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("candidate is not a map")
+	}
+	// the content is missing when the response is blocked
+	candidateContent, _ := candidate["content"].(map[string]interface{})
+	parts, _ := candidateContent["parts"].([]interface{})
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no content found, finish reason: %v", candidate["finishReason"])
+	}
+
+	// the text parts make the content of the message and the function calls its tool calls
+	texts := make([]string, 0)
+	toolCalls := make([]toolCall, 0)
+	for _, partChoice := range parts {
+		part, ok := partChoice.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("content part is not a map")
+		}
+		if thought, _ := part["thought"].(bool); thought {
+			continue
+		}
+		if text, ok := part["text"].(string); ok {
+			texts = append(texts, text)
+		}
+		if functionCall, ok := part["functionCall"].(map[string]interface{}); ok {
+			name, _ := functionCall["name"].(string)
+			args := functionCall["args"]
+			if args == nil {
+				args = map[string]interface{}{}
+			}
+			arguments, err := json.Marshal(args)
+			if err != nil {
+				return nil, err
+			}
+			encodedArguments, err := json.Marshal(string(arguments))
+			if err != nil {
+				return nil, err
+			}
+			id, _ := functionCall["id"].(string)
+			if id == "" {
+				id = TOOL_CALL_ID_PREFIX + uuid.New().String()
+			}
+			toolCalls = append(toolCalls, toolCall{
+				ID:   id,
+				Type: "function",
+				Function: toolCallFunction{
+					Name:      name,
+					Arguments: encodedArguments,
+				},
+			})
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"gemini_response_id": responseMap["responseId"],
+		"model_version":      responseMap["modelVersion"],
+		"usage":              responseMap["usageMetadata"],
+		"finish_reason":      candidate["finishReason"],
+	}
+	metadataJson, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	contentMap := map[string]interface{}{
+		"content": strings.Join(texts, ""),
+	}
+	contentMapJson, err := json.Marshal(contentMap)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling content map: %v", err)
+		return nil, fmt.Errorf("error marshalling content map: %v", err)
+	}
+
+	message := &models.Message{
+		Role:       "assistant",
+		ContentMap: contentMapJson,
+		Metadata:   metadataJson,
+	}
+	if len(toolCalls) > 0 {
+		message.ToolCalls, err = json.Marshal(toolCalls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []*geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      float64                `json:"temperature"`
+	MaxOutputTokens  int                    `json:"maxOutputTokens"`
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
+}
+
+type geminiExecutionData struct {
+	APIKeys           map[string]interface{}  `json:"api_keys"`
+	Model             string                  `json:"model"`
+	Contents          []*geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent          `json:"system_instruction"`
+	Tools             []*geminiTool           `json:"tools"`
+	GenerationConfig  *geminiGenerationConfig `json:"generation_config"`
+	Timeout           int                     `json:"timeout"`
+}
+
+func (d *geminiExecutionData) Validate() error {
+	if len(d.Contents) == 0 {
+		return fmt.Errorf("at least one message other than the system prompt is required")
+	}
+	return nil
+}
+
+// setResponseFormat asks gemini for json when the response format of the template does,
+// the json_schema response formats are sent as the response schema
+func setResponseFormat(generationConfig *geminiGenerationConfig, responseFormat json.RawMessage) error {
+	var format struct {
+		Type       string `json:"type"`
+		JsonSchema struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	}
+	trimmed := bytes.TrimSpace(responseFormat)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+	if err := json.Unmarshal(trimmed, &format); err != nil {
+		return fmt.Errorf("invalid response format: %v", err)
+	}
+
+	switch format.Type {
+	case "json_object":
+		generationConfig.ResponseMimeType = "application/json"
+	case "json_schema":
+		responseSchema, err := convertSchema(format.JsonSchema.Schema)
+		if err != nil {
+			return fmt.Errorf("invalid response format: %v", err)
+		}
+		generationConfig.ResponseMimeType = "application/json"
+		generationConfig.ResponseSchema = responseSchema
+	}
+	return nil
+}
+
+func (g *Gemini) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
+	var systemInstruction *geminiContent
+
+	contents := make([]*geminiContent, 0)
+	toolCallNames := make(map[string]string)
+	previousRole := ""
+	for _, message := range messages {
+		content, err := convertMessage(message, toolCallNames)
+		if err != nil {
+			logger.GetLogger().Errorf("Error converting message to provider format: %v", err)
+			return -1, nil, err
+		}
+		if message.Role == "system" {
+			systemInstruction = content
+			continue
+		}
+		if message.Role == "assistant" {
+			toolCalls, _ := parseToolCalls(message.ToolCalls)
+			for _, call := range toolCalls {
+				toolCallNames[call.ID] = call.Function.Name
+			}
+		}
+		// the responses to parallel function calls have to be sent in a single content
+		if message.Role == "tool" && previousRole == "tool" {
+			previous := contents[len(contents)-1]
+			previous.Parts = append(previous.Parts, content.Parts...)
+			continue
+		}
+		previousRole = message.Role
+		contents = append(contents, content)
+	}
+
+	// override the system prompt if it is provided for execution
+	if threadExecutionParamsTemplate.SystemPrompt != "" {
+		systemInstruction = &geminiContent{
+			Parts: []interface{}{geminiTextPart{Text: threadExecutionParamsTemplate.SystemPrompt}},
+		}
+	}
+
+	if threadExecutionParamsTemplate.Temperature <= 0 {
+		threadExecutionParamsTemplate.Temperature = DEFAULT_TEMPERATURE
+	}
+	if threadExecutionParamsTemplate.MaxTokens <= 0 {
+		threadExecutionParamsTemplate.MaxTokens = DEFAULT_MAX_TOKENS
+	}
+	if threadExecutionParamsTemplate.Timeout <= 0 {
+		threadExecutionParamsTemplate.Timeout = DEFAULT_TIMEOUT
+	}
+
+	generationConfig := &geminiGenerationConfig{
+		Temperature:     threadExecutionParamsTemplate.Temperature,
+		MaxOutputTokens: threadExecutionParamsTemplate.MaxTokens,
+	}
+	if err := setResponseFormat(generationConfig, threadExecutionParamsTemplate.ResponseFormat); err != nil {
+		logger.GetLogger().Errorf("Error converting response format: %v", err)
+		return -1, nil, err
+	}
+
+	// gemini takes all the functions in a single tool
+	var geminiTools []*geminiTool
+	if len(tools) > 0 {
+		functionDeclarations := make([]*geminiFunctionDeclaration, 0, len(tools))
+		for _, tool := range tools {
+			functionDeclaration := &geminiFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+			}
+			if len(tool.InputSchema) > 0 {
+				parameters, err := convertSchema(tool.InputSchema)
+				if err != nil {
+					logger.GetLogger().Errorf("Error converting the input schema of tool %s: %v", tool.Name, err)
+					return -1, nil, fmt.Errorf("tool %s: %v", tool.Name, err)
+				}
+				if hasProperties(parameters) {
+					functionDeclaration.Parameters = parameters
+				}
+			}
+			functionDeclarations = append(functionDeclarations, functionDeclaration)
+		}
+		geminiTools = []*geminiTool{{FunctionDeclarations: functionDeclarations}}
+	}
+
+	googleServiceAccountCreds, err := secrets.DecryptJSON(user.GoogleServiceAccountCreds)
+	if err != nil {
+		logger.GetLogger().Errorf("Error decrypting the google service account credentials: %v", err)
+		return -1, nil, err
+	}
+	googleServiceAccountCreds = bytes.TrimSpace(googleServiceAccountCreds)
+	if len(googleServiceAccountCreds) == 0 || string(googleServiceAccountCreds) == "null" || string(googleServiceAccountCreds) == "{}" {
+		return -1, nil, fmt.Errorf("google service account credentials are required to execute %s", g.identifier)
+	}
+
+	executionData := geminiExecutionData{
+		APIKeys:           map[string]interface{}{"google_service_account_creds": googleServiceAccountCreds},
+		Model:             g.model,
+		Contents:          contents,
+		SystemInstruction: systemInstruction,
+		Tools:             geminiTools,
+		GenerationConfig:  generationConfig,
+		Timeout:           threadExecutionParamsTemplate.Timeout,
+	}
+
+	if err := executionData.Validate(); err != nil {
+		logger.GetLogger().Errorf("Error validating execution data: %v", err)
+		return -1, nil, err
+	}
+
+	executionParams := &base.ExecuteParams{
+		Timeout:     time.Duration(executionData.Timeout) * time.Second,
+		RetryPolicy: &threadExecutionParamsTemplate.RetryPolicy,
+		TemplateID:  threadExecutionParamsTemplate.Identifier,
+	}
+
+	return base.Execute(ctx, db, g.executorRoute, executionParams, executionData, threadExecutionIdentifier, contents, streamHandler)
+}
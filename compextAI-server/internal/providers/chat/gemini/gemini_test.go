@@ -0,0 +1,258 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+// newExecutorStub starts an executor which records the request sent to the gemini route and
+// responds with the given generateContent response. The providers call the executor at
+// EXECUTOR_BASE_URL, and base.Execute skips recording the execution when it is given a nil db.
+func newExecutorStub(t *testing.T, response string) *map[string]interface{} {
+	t.Helper()
+	request := make(map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != GEMINI_EXECUTOR_ROUTE {
+			t.Errorf("unexpected executor call: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading the request: %v", err)
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("error decoding the request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("EXECUTOR_BASE_URL", server.URL)
+	return &request
+}
+
+func message(role, content string) *models.Message {
+	contentMap, _ := json.Marshal(map[string]interface{}{"content": content})
+	return &models.Message{Role: role, ContentMap: contentMap}
+}
+
+func assertJSON(t *testing.T, name string, got interface{}, want string) {
+	t.Helper()
+	var wantValue interface{}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("invalid expected %s: %v", name, err)
+	}
+	gotJSON, _ := json.Marshal(got)
+	var gotValue interface{}
+	json.Unmarshal(gotJSON, &gotValue)
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("unexpected %s:\n got: %s\nwant: %s", name, gotJSON, want)
+	}
+}
+
+func TestExecuteThread(t *testing.T) {
+	request := newExecutorStub(t, `{
+		"responseId": "response-1",
+		"modelVersion": "gemini-2.0-flash-001",
+		"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5},
+		"candidates": [{
+			"finishReason": "STOP",
+			"content": {"role": "model", "parts": [
+				{"text": "thinking", "thought": true},
+				{"text": "It is "},
+				{"text": "sunny."},
+				{"functionCall": {"name": "get_time", "args": {"city": "Paris"}}}
+			]}
+		}]
+	}`)
+
+	assistant := message("assistant", "")
+	assistant.ToolCalls = json.RawMessage(`[
+		{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+		{"id": "call_2", "type": "function", "function": {"name": "get_time", "arguments": {"city": "Paris"}}}
+	]`)
+	weather := message("tool", `{"weather":"sunny"}`)
+	weather.ToolCallID = "call_1"
+	clock := message("tool", "12:00")
+	clock.ToolCallID = "call_2"
+	messages := []*models.Message{
+		message("system", "You are a weather assistant."),
+		message("user", "What is the weather in Paris?"),
+		assistant,
+		weather,
+		clock,
+		message("user", "Thanks"),
+	}
+
+	template := &models.ThreadExecutionParamsTemplate{
+		Temperature: 0.2,
+		ResponseFormat: json.RawMessage(`{"type": "json_schema", "json_schema": {"name": "weather", "schema": {
+			"type": "object",
+			"additionalProperties": false,
+			"properties": {
+				"summary": {"type": "string", "minLength": 1},
+				"kind": {"const": "weather"},
+				"temperature": {"type": ["number", "null"]},
+				"tags": {"type": "array", "items": {"type": "string", "enum": ["hot", "cold"]}}
+			},
+			"required": ["summary"]
+		}}}`),
+	}
+	tools := []*models.ExecutionTool{
+		{
+			Name:        "get_weather",
+			Description: "Returns the weather of a city",
+			InputSchema: json.RawMessage(`{"type": "object", "$schema": "http://json-schema.org/draft-07/schema#", "properties": {"city": {"type": "string"}}, "required": ["city"]}`),
+		},
+		{
+			Name:        "get_time",
+			InputSchema: json.RawMessage(`{"type": "object", "properties": {}}`),
+		},
+	}
+	user := &models.User{GoogleServiceAccountCreds: json.RawMessage(`{"project_id": "project"}`)}
+
+	statusCode, response, err := NewGemini20Flash().ExecuteThread(context.Background(), nil, user, messages, template, "execution", tools, nil)
+	if err != nil || statusCode != http.StatusOK {
+		t.Fatalf("unexpected result: %d, %v", statusCode, err)
+	}
+
+	assertJSON(t, "model", (*request)["model"], `"gemini-2.0-flash-001"`)
+	assertJSON(t, "api keys", (*request)["api_keys"], `{"google_service_account_creds": {"project_id": "project"}}`)
+	assertJSON(t, "timeout", (*request)["timeout"], `600`)
+	assertJSON(t, "system instruction", (*request)["system_instruction"], `{"parts": [{"text": "You are a weather assistant."}]}`)
+	// the assistant is the model, and the results of the parallel calls are sent in a single user content
+	assertJSON(t, "contents", (*request)["contents"], `[
+		{"role": "user", "parts": [{"text": "What is the weather in Paris?"}]},
+		{"role": "model", "parts": [
+			{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+			{"functionCall": {"name": "get_time", "args": {"city": "Paris"}}}
+		]},
+		{"role": "user", "parts": [
+			{"functionResponse": {"name": "get_weather", "response": {"weather": "sunny"}}},
+			{"functionResponse": {"name": "get_time", "response": {"content": "12:00"}}}
+		]},
+		{"role": "user", "parts": [{"text": "Thanks"}]}
+	]`)
+	// the unsupported keywords are dropped and the functions without parameters have none
+	assertJSON(t, "tools", (*request)["tools"], `[{"functionDeclarations": [
+		{
+			"name": "get_weather",
+			"description": "Returns the weather of a city",
+			"parameters": {"type": "OBJECT", "properties": {"city": {"type": "STRING"}}, "required": ["city"]}
+		},
+		{"name": "get_time"}
+	]}]`)
+	assertJSON(t, "generation config", (*request)["generation_config"], `{
+		"temperature": 0.2,
+		"maxOutputTokens": 8192,
+		"responseMimeType": "application/json",
+		"responseSchema": {
+			"type": "OBJECT",
+			"properties": {
+				"summary": {"type": "STRING", "minLength": 1},
+				"kind": {"type": "STRING", "enum": ["weather"]},
+				"temperature": {"type": "NUMBER", "nullable": true},
+				"tags": {"type": "ARRAY", "items": {"type": "STRING", "enum": ["hot", "cold"]}}
+			},
+			"required": ["summary"]
+		}
+	}`)
+
+	responseMessage, err := NewGemini20Flash().ConvertExecutionResponseToMessage(response)
+	if err != nil {
+		t.Fatalf("error converting the response: %v", err)
+	}
+	assertJSON(t, "response content", responseMessage.ContentMap, `{"content": "It is sunny."}`)
+	var toolCalls []toolCall
+	if err := json.Unmarshal(responseMessage.ToolCalls, &toolCalls); err != nil || len(toolCalls) != 1 {
+		t.Fatalf("expected a tool call, got %s: %v", responseMessage.ToolCalls, err)
+	}
+	if toolCalls[0].Function.Name != "get_time" || string(toolCalls[0].Function.Arguments) != `"{\"city\":\"Paris\"}"` {
+		t.Errorf("unexpected tool call: %+v", toolCalls[0])
+	}
+}
+
+func TestExecuteThreadSystemPromptOverride(t *testing.T) {
+	request := newExecutorStub(t, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}}]}`)
+
+	messages := []*models.Message{
+		message("system", "You are a weather assistant."),
+		message("user", "Hello"),
+	}
+	template := &models.ThreadExecutionParamsTemplate{
+		SystemPrompt:   "You are a travel assistant.",
+		ResponseFormat: json.RawMessage(`{"type": "json_object"}`),
+	}
+	user := &models.User{GoogleServiceAccountCreds: json.RawMessage(`{"project_id": "project"}`)}
+
+	if _, _, err := NewGemini15Pro().ExecuteThread(context.Background(), nil, user, messages, template, "execution", nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertJSON(t, "system instruction", (*request)["system_instruction"], `{"parts": [{"text": "You are a travel assistant."}]}`)
+	assertJSON(t, "contents", (*request)["contents"], `[{"role": "user", "parts": [{"text": "Hello"}]}]`)
+	assertJSON(t, "tools", (*request)["tools"], `null`)
+	assertJSON(t, "generation config", (*request)["generation_config"], `{"temperature": 0.5, "maxOutputTokens": 8192, "responseMimeType": "application/json"}`)
+}
+
+func TestExecuteThreadRejectedRequests(t *testing.T) {
+	newExecutorStub(t, `{}`)
+	user := &models.User{GoogleServiceAccountCreds: json.RawMessage(`{"project_id": "project"}`)}
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		messages []*models.Message
+		template *models.ThreadExecutionParamsTemplate
+		tools    []*models.ExecutionTool
+	}{
+		{
+			name:     "only a system message",
+			user:     user,
+			messages: []*models.Message{message("system", "You are a weather assistant.")},
+			template: &models.ThreadExecutionParamsTemplate{},
+		},
+		{
+			name:     "tool result without its call",
+			user:     user,
+			messages: []*models.Message{{Role: "tool", ToolCallID: "call_1", ContentMap: json.RawMessage(`{"content": "sunny"}`)}},
+			template: &models.ThreadExecutionParamsTemplate{},
+		},
+		{
+			name:     "response schema with a $ref",
+			user:     user,
+			messages: []*models.Message{message("user", "Hello")},
+			template: &models.ThreadExecutionParamsTemplate{
+				ResponseFormat: json.RawMessage(`{"type": "json_schema", "json_schema": {"schema": {"$ref": "#/$defs/weather"}}}`),
+			},
+		},
+		{
+			name:     "tool schema with a list of types",
+			user:     user,
+			messages: []*models.Message{message("user", "Hello")},
+			template: &models.ThreadExecutionParamsTemplate{},
+			tools:    []*models.ExecutionTool{{Name: "get_weather", InputSchema: json.RawMessage(`{"type": ["string", "number"]}`)}},
+		},
+		{
+			name:     "missing credentials",
+			user:     &models.User{},
+			messages: []*models.Message{message("user", "Hello")},
+			template: &models.ThreadExecutionParamsTemplate{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statusCode, _, err := NewGemini20Flash().ExecuteThread(context.Background(), nil, test.user, test.messages, test.template, "execution", test.tools, nil)
+			if err == nil || statusCode != -1 {
+				t.Fatalf("expected the request to be rejected, got %d, %v", statusCode, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// keywords of the openapi schema subset accepted by gemini, the others are dropped
+	schemaKeywords = []string{
+		"type", "format", "title", "description", "nullable", "enum",
+		"properties", "required", "propertyOrdering", "items", "anyOf",
+		"minItems", "maxItems", "minLength", "maxLength", "pattern", "minimum", "maximum",
+	}
+)
+
+// convertSchema converts a json schema, of a tool or a response format, to a gemini schema
+func convertSchema(schema json.RawMessage) (map[string]interface{}, error) {
+	var schemaMap map[string]interface{}
+	if err := json.Unmarshal(schema, &schemaMap); err != nil {
+		return nil, errors.New("schema should be a json schema object")
+	}
+	return convertSchemaObject(schemaMap, "$")
+}
+
+func convertSchemaObject(schema map[string]interface{}, path string) (map[string]interface{}, error) {
+	if _, ok := schema["$ref"]; ok {
+		return nil, fmt.Errorf("%s: $ref is not supported by gemini schemas", path)
+	}
+
+	converted := make(map[string]interface{})
+	for keyword, value := range schema {
+		if !slices.Contains(schemaKeywords, keyword) {
+			continue
+		}
+		switch keyword {
+		case "type":
+			schemaType, nullable, err := convertSchemaType(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			converted["type"] = schemaType
+			if nullable {
+				converted["nullable"] = true
+			}
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: properties should be an object", path)
+			}
+			convertedProperties := make(map[string]interface{}, len(properties))
+			for name, property := range properties {
+				propertySchema, ok := property.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%s.%s: schema should be an object", path, name)
+				}
+				convertedProperty, err := convertSchemaObject(propertySchema, path+"."+name)
+				if err != nil {
+					return nil, err
+				}
+				convertedProperties[name] = convertedProperty
+			}
+			converted["properties"] = convertedProperties
+		case "items":
+			items, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: items should be a schema object", path)
+			}
+			convertedItems, err := convertSchemaObject(items, path+"[]")
+			if err != nil {
+				return nil, err
+			}
+			converted["items"] = convertedItems
+		case "anyOf":
+			schemas, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: anyOf should be a list of schemas", path)
+			}
+			convertedSchemas := make([]interface{}, 0, len(schemas))
+			for i, anyOfSchema := range schemas {
+				anyOfSchemaMap, ok := anyOfSchema.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%s.anyOf[%d]: schema should be an object", path, i)
+				}
+				convertedSchema, err := convertSchemaObject(anyOfSchemaMap, fmt.Sprintf("%s.anyOf[%d]", path, i))
+				if err != nil {
+					return nil, err
+				}
+				convertedSchemas = append(convertedSchemas, convertedSchema)
+			}
+			converted["anyOf"] = convertedSchemas
+		default:
+			converted[keyword] = value
+		}
+	}
+
+	// gemini has no const, a single value enum is the equivalent
+	if value, ok := schema["const"]; ok {
+		if _, ok := converted["enum"]; !ok {
+			converted["enum"] = []interface{}{value}
+		}
+		if _, ok := converted["type"]; !ok {
+			if _, ok := value.(string); ok {
+				converted["type"] = "STRING"
+			}
+		}
+	}
+	return converted, nil
+}
+
+// convertSchemaType returns the gemini type of a json schema type. A list of types is accepted
+// when it is a single type with null, e.g. ["string", "null"], which gemini marks as nullable.
+func convertSchemaType(value interface{}) (string, bool, error) {
+	switch schemaType := value.(type) {
+	case string:
+		return strings.ToUpper(schemaType), false, nil
+	case []interface{}:
+		types := make([]string, 0, len(schemaType))
+		nullable := false
+		for _, t := range schemaType {
+			typeName, ok := t.(string)
+			if !ok {
+				return "", false, errors.New("type should be a string or a list of strings")
+			}
+			if typeName == "null" {
+				nullable = true
+				continue
+			}
+			types = append(types, typeName)
+		}
+		if len(types) != 1 {
+			return "", false, errors.New("a list of types is only supported for a single type with null")
+		}
+		return strings.ToUpper(types[0]), nullable, nil
+	default:
+		return "", false, errors.New("type should be a string or a list of strings")
+	}
+}
+
+// hasProperties reports whether the converted schema is an object schema with properties,
+// gemini rejects the function parameters of type object without properties
+func hasProperties(schema map[string]interface{}) bool {
+	properties, _ := schema["properties"].(map[string]interface{})
+	return len(properties) > 0
+}
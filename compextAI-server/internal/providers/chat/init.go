@@ -2,19 +2,23 @@ package chat
 
 import (
 	"github.com/burnerlee/compextAI/internal/providers/chat/anthropic"
+	"github.com/burnerlee/compextAI/internal/providers/chat/gemini"
 	"github.com/burnerlee/compextAI/internal/providers/chat/litellm"
 	"github.com/burnerlee/compextAI/internal/providers/chat/openai"
 )
 
 // add all the provider enums here
 const (
-	GPT4O     ChatCompletionsProvider_Enum = openai.GPT4O_IDENTIFIER
-	GPT4      ChatCompletionsProvider_Enum = openai.GPT4_IDENTIFIER
-	CLAUDE35  ChatCompletionsProvider_Enum = anthropic.ANTHROPIC_IDENTIFIER
-	O1PREVIEW ChatCompletionsProvider_Enum = openai.O1_PREVIEW_IDENTIFIER
-	O1MINI    ChatCompletionsProvider_Enum = openai.O1_MINI_IDENTIFIER
-	O1        ChatCompletionsProvider_Enum = openai.O1_IDENTIFIER
-	LITELLM   ChatCompletionsProvider_Enum = litellm.LITELLM_IDENTIFIER
+	GPT4O         ChatCompletionsProvider_Enum = openai.GPT4O_IDENTIFIER
+	GPT4          ChatCompletionsProvider_Enum = openai.GPT4_IDENTIFIER
+	CLAUDE35      ChatCompletionsProvider_Enum = anthropic.ANTHROPIC_IDENTIFIER
+	O1PREVIEW     ChatCompletionsProvider_Enum = openai.O1_PREVIEW_IDENTIFIER
+	O1MINI        ChatCompletionsProvider_Enum = openai.O1_MINI_IDENTIFIER
+	O1            ChatCompletionsProvider_Enum = openai.O1_IDENTIFIER
+	LITELLM       ChatCompletionsProvider_Enum = litellm.LITELLM_IDENTIFIER
+	GEMINI15PRO   ChatCompletionsProvider_Enum = gemini.GEMINI_15_PRO_IDENTIFIER
+	GEMINI15FLASH ChatCompletionsProvider_Enum = gemini.GEMINI_15_FLASH_IDENTIFIER
+	GEMINI20FLASH ChatCompletionsProvider_Enum = gemini.GEMINI_20_FLASH_IDENTIFIER
 )
 
 func init() {
@@ -32,6 +36,11 @@ func init() {
 	// anthropic providers
 	chatCompletionsProviderRegistry.register(anthropic.NewClaude35())
 
+	// gemini providers
+	chatCompletionsProviderRegistry.register(gemini.NewGemini15Pro())
+	chatCompletionsProviderRegistry.register(gemini.NewGemini15Flash())
+	chatCompletionsProviderRegistry.register(gemini.NewGemini20Flash())
+
 	// litellm provider
 	chatCompletionsProviderRegistry.register(litellm.NewLitellm())
 }
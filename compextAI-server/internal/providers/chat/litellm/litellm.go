@@ -1,7 +1,11 @@
 package litellm
 
 import (
+	"context"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/internal/providers/chat/openai"
+	"github.com/burnerlee/compextAI/internal/secrets"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -53,16 +57,45 @@ func (l *Litellm) ConvertExecutionResponseToMessage(response interface{}) (*mode
 	return l.openaiProvider.ConvertExecutionResponseToMessage(response)
 }
 
-func (l *Litellm) ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error) {
+func (l *Litellm) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
 	l.model = threadExecutionParamsTemplate.Model
-	return openai.BaseExecuteThread(db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &openai.ExecuteParamConfigs{
+
+	apiKeys, err := getLitellmAPIKeys(user)
+	if err != nil {
+		logger.GetLogger().Errorf("Error decrypting the api keys: %v", err)
+		return -1, nil, err
+	}
+
+	return openai.BaseExecuteThread(ctx, db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &openai.ExecuteParamConfigs{
 		Model:         l.model,
 		ExecutorRoute: l.executorRoute,
-	}, tools, map[string]interface{}{
-		"openai":                       user.OpenAIKey,
-		"anthropic":                    user.AnthropicKey,
-		"azure":                        user.AzureKey,
+	}, tools, apiKeys, streamHandler)
+}
+
+// getLitellmAPIKeys returns the api keys of the execution with the provider keys of the user decrypted
+func getLitellmAPIKeys(user *models.User) (map[string]interface{}, error) {
+	openaiKey, err := secrets.Decrypt(user.OpenAIKey)
+	if err != nil {
+		return nil, err
+	}
+	anthropicKey, err := secrets.Decrypt(user.AnthropicKey)
+	if err != nil {
+		return nil, err
+	}
+	azureKey, err := secrets.Decrypt(user.AzureKey)
+	if err != nil {
+		return nil, err
+	}
+	googleServiceAccountCreds, err := secrets.DecryptJSON(user.GoogleServiceAccountCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"openai":                       openaiKey,
+		"anthropic":                    anthropicKey,
+		"azure":                        azureKey,
 		"azure_endpoint":               user.AzureEndpoint,
-		"google_service_account_creds": user.GoogleServiceAccountCreds,
-	})
+		"google_service_account_creds": googleServiceAccountCreds,
+	}, nil
 }
@@ -1,6 +1,9 @@
 package openai
 
 import (
+	"context"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -56,14 +59,18 @@ func (g *GPT4) ConvertExecutionResponseToMessage(response interface{}) (*models.
 	return convertExecutionResponseToMessage(response)
 }
 
-func (g *GPT4) ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error) {
-	return BaseExecuteThread(db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
+func (g *GPT4) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
+	apiKeys, err := getOpenaiAPIKeys(g.owner, user)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting the api keys: %v", err)
+		return -1, nil, err
+	}
+
+	return BaseExecuteThread(ctx, db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
 		Model:                      g.model,
 		ExecutorRoute:              g.executorRoute,
 		DefaultTemperature:         GPT4_DEFAULT_TEMPERATURE,
 		DefaultMaxCompletionTokens: GPT4_DEFAULT_MAX_COMPLETION_TOKENS,
 		DefaultTimeout:             GPT4_DEFAULT_TIMEOUT,
-	}, tools, map[string]interface{}{
-		g.owner: user.OpenAIKey,
-	})
+	}, tools, apiKeys, streamHandler)
 }
@@ -1,7 +1,9 @@
 package openai
 
 import (
+	"context"
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -57,7 +59,7 @@ func (g *O1Mini) ConvertExecutionResponseToMessage(response interface{}) (*model
 	return convertExecutionResponseToMessage(response)
 }
 
-func (g *O1Mini) ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error) {
+func (g *O1Mini) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
 	// o1 models don't support system prompts, so we need to handle it here
 	messages, err := handleSystemPromptForO1(messages, threadExecutionParamsTemplate)
 	if err != nil {
@@ -65,13 +67,17 @@ func (g *O1Mini) ExecuteThread(db *gorm.DB, user *models.User, messages []*model
 		return -1, nil, err
 	}
 
-	return BaseExecuteThread(db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
+	apiKeys, err := getOpenaiAPIKeys(g.owner, user)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting the api keys: %v", err)
+		return -1, nil, err
+	}
+
+	return BaseExecuteThread(ctx, db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
 		Model:                      g.model,
 		ExecutorRoute:              g.executorRoute,
 		DefaultTemperature:         O1_MINI_DEFAULT_TEMPERATURE,
 		DefaultMaxCompletionTokens: O1_MINI_DEFAULT_MAX_COMPLETION_TOKENS,
 		DefaultTimeout:             O1_MINI_DEFAULT_TIMEOUT,
-	}, tools, map[string]interface{}{
-		g.owner: user.OpenAIKey,
-	})
+	}, tools, apiKeys, streamHandler)
 }
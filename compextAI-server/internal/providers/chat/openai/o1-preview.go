@@ -1,9 +1,11 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -59,22 +61,26 @@ func (g *O1Preview) ConvertExecutionResponseToMessage(response interface{}) (*mo
 	return convertExecutionResponseToMessage(response)
 }
 
-func (g *O1Preview) ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error) {
+func (g *O1Preview) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
 	messages, err := handleSystemPromptForO1(messages, threadExecutionParamsTemplate)
 	if err != nil {
 		logger.GetLogger().Errorf("Error handling system prompt for o1: %v", err)
 		return -1, nil, err
 	}
 
-	return BaseExecuteThread(db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
+	apiKeys, err := getOpenaiAPIKeys(g.owner, user)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting the api keys: %v", err)
+		return -1, nil, err
+	}
+
+	return BaseExecuteThread(ctx, db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
 		Model:                      g.model,
 		ExecutorRoute:              g.executorRoute,
 		DefaultTemperature:         O1_PREVIEW_DEFAULT_TEMPERATURE,
 		DefaultMaxCompletionTokens: O1_PREVIEW_DEFAULT_MAX_COMPLETION_TOKENS,
 		DefaultTimeout:             O1_PREVIEW_DEFAULT_TIMEOUT,
-	}, tools, map[string]interface{}{
-		g.owner: user.OpenAIKey,
-	})
+	}, tools, apiKeys, streamHandler)
 }
 
 func handleSystemPromptForO1(messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate) ([]*models.Message, error) {
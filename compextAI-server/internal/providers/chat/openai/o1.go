@@ -1,7 +1,9 @@
 package openai
 
 import (
+	"context"
 	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/providers/chat/base"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -57,20 +59,24 @@ func (g *O1) ConvertExecutionResponseToMessage(response interface{}) (*models.Me
 	return convertExecutionResponseToMessage(response)
 }
 
-func (g *O1) ExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool) (int, interface{}, error) {
+func (g *O1) ExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, tools []*models.ExecutionTool, streamHandler base.StreamHandler) (int, interface{}, error) {
 	messages, err := handleSystemPromptForO1(messages, threadExecutionParamsTemplate)
 	if err != nil {
 		logger.GetLogger().Errorf("Error handling system prompt for o1: %v", err)
 		return -1, nil, err
 	}
 
-	return BaseExecuteThread(db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
+	apiKeys, err := getOpenaiAPIKeys(g.owner, user)
+	if err != nil {
+		logger.GetLogger().Errorf("Error getting the api keys: %v", err)
+		return -1, nil, err
+	}
+
+	return BaseExecuteThread(ctx, db, user, messages, threadExecutionParamsTemplate, threadExecutionIdentifier, &ExecuteParamConfigs{
 		Model:                      g.model,
 		ExecutorRoute:              g.executorRoute,
 		DefaultTemperature:         O1_PREVIEW_DEFAULT_TEMPERATURE,
 		DefaultMaxCompletionTokens: O1_PREVIEW_DEFAULT_MAX_COMPLETION_TOKENS,
 		DefaultTimeout:             O1_PREVIEW_DEFAULT_TIMEOUT,
-	}, tools, map[string]interface{}{
-		g.owner: user.OpenAIKey,
-	})
+	}, tools, apiKeys, streamHandler)
 }
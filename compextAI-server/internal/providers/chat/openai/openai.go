@@ -1,6 +1,7 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"slices"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/burnerlee/compextAI/internal/logger"
 	"github.com/burnerlee/compextAI/internal/providers/chat/base"
+	"github.com/burnerlee/compextAI/internal/secrets"
 	"github.com/burnerlee/compextAI/models"
 	"gorm.io/gorm"
 )
@@ -30,10 +32,26 @@ func validateMessage(message *models.Message) error {
 type OpenaiMessage struct {
 	Role         string                 `json:"role"`
 	Content      interface{}            `json:"content"`
-	ToolCallID   string                 `json:"tool_call_id"`
+	ToolCallID   string                 `json:"tool_call_id,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata"`
-	ToolCalls    interface{}            `json:"tool_calls"`
-	FunctionCall interface{}            `json:"function_call"`
+	ToolCalls    interface{}            `json:"tool_calls,omitempty"`
+	FunctionCall interface{}            `json:"function_call,omitempty"`
+}
+
+// unmarshalOptional decodes an optional json field of a message, the messages store
+// nothing, null or {} when they do not have it
+func unmarshalOptional(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if valueMap, ok := value.(map[string]interface{}); ok && len(valueMap) == 0 {
+		return nil, nil
+	}
+	return value, nil
 }
 
 func convertMessageToProviderFormat(message *models.Message) (interface{}, error) {
@@ -53,13 +71,13 @@ func convertMessageToProviderFormat(message *models.Message) (interface{}, error
 		return nil, fmt.Errorf("content map does not contain 'content' key")
 	}
 
-	var toolCalls interface{}
-	if err := json.Unmarshal(message.ToolCalls, &toolCalls); err != nil {
+	toolCalls, err := unmarshalOptional(message.ToolCalls)
+	if err != nil {
 		return nil, err
 	}
 
-	var functionCall interface{}
-	if err := json.Unmarshal(message.FunctionCall, &functionCall); err != nil {
+	functionCall, err := unmarshalOptional(message.FunctionCall)
+	if err != nil {
 		return nil, err
 	}
 
@@ -90,8 +108,10 @@ func convertExecutionResponseToMessage(response interface{}) (*models.Message, e
 	if !ok {
 		return nil, fmt.Errorf("message role is not a string")
 	}
+	// the content is null when the model only calls tools
+	toolCalls, hasToolCalls := message["tool_calls"].([]interface{})
 	content, ok := message["content"].(string)
-	if !ok {
+	if !ok && !(message["content"] == nil && hasToolCalls) {
 		return nil, fmt.Errorf("message content is not a string")
 	}
 
@@ -116,11 +136,18 @@ func convertExecutionResponseToMessage(response interface{}) (*models.Message, e
 		return nil, err
 	}
 
-	return &models.Message{
+	responseMessage := &models.Message{
 		Role:       role,
 		ContentMap: contentMapJson,
 		Metadata:   metadataJson,
-	}, nil
+	}
+	if len(toolCalls) > 0 {
+		responseMessage.ToolCalls, err = json.Marshal(toolCalls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return responseMessage, nil
 }
 
 type openaiFunction struct {
@@ -197,7 +224,18 @@ func filterNonSystemMessages(messages []*models.Message) []*models.Message {
 	return nonSystemMessages
 }
 
-func BaseExecuteThread(db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, configs *ExecuteParamConfigs, tools []*models.ExecutionTool, apiKeys map[string]interface{}) (int, interface{}, error) {
+// getOpenaiAPIKeys returns the api keys of the execution with the openai key of the user decrypted
+func getOpenaiAPIKeys(owner string, user *models.User) (map[string]interface{}, error) {
+	openaiKey, err := secrets.Decrypt(user.OpenAIKey)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		owner: openaiKey,
+	}, nil
+}
+
+func BaseExecuteThread(ctx context.Context, db *gorm.DB, user *models.User, messages []*models.Message, threadExecutionParamsTemplate *models.ThreadExecutionParamsTemplate, threadExecutionIdentifier string, configs *ExecuteParamConfigs, tools []*models.ExecutionTool, apiKeys map[string]interface{}, streamHandler base.StreamHandler) (int, interface{}, error) {
 	systemPrompt := ""
 
 	modelMessages := make([]OpenaiMessage, 0)
@@ -279,8 +317,10 @@ func BaseExecuteThread(db *gorm.DB, user *models.User, messages []*models.Messag
 	}
 
 	executionParams := &base.ExecuteParams{
-		Timeout: time.Duration(executionData.Timeout) * time.Second,
+		Timeout:     time.Duration(executionData.Timeout) * time.Second,
+		RetryPolicy: &threadExecutionParamsTemplate.RetryPolicy,
+		TemplateID:  threadExecutionParamsTemplate.Identifier,
 	}
 
-	return base.Execute(db, configs.ExecutorRoute, executionParams, executionData, threadExecutionIdentifier, modelMessages)
+	return base.Execute(ctx, db, configs.ExecutorRoute, executionParams, executionData, threadExecutionIdentifier, modelMessages, streamHandler)
 }
@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/env"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/worker"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_CONCURRENCY            = 10
+	DEFAULT_LEASE_DURATION_SECONDS = 60
+	DEFAULT_POLL_INTERVAL_SECONDS  = 2
+	DEFAULT_MAX_CLAIMS             = 3
+)
+
+var (
+	executionQueue *ExecutionQueue
+)
+
+// Handler runs a claimed thread execution.
+// It is responsible for moving the execution to a terminal status.
+type Handler func(ctx context.Context, db *gorm.DB, threadExecution *models.ThreadExecution)
+
+// FailureHandler runs the side effects of an execution the queue marked as failed itself,
+// e.g. an orphaned execution claimed too many times, as the handler does for the executions it runs.
+type FailureHandler func(db *gorm.DB, threadExecution *models.ThreadExecution)
+
+type Config struct {
+	// number of executions that can run at the same time in this process
+	Concurrency int
+	// how long a claimed execution stays leased to a worker without a heartbeat
+	LeaseDuration time.Duration
+	// how often idle workers look for queued executions
+	PollInterval time.Duration
+	// number of times an execution can be claimed before it is marked as failed
+	MaxClaims uint
+}
+
+func ConfigFromEnv() *Config {
+	return &Config{
+		Concurrency:   env.Int("EXECUTION_QUEUE_CONCURRENCY", DEFAULT_CONCURRENCY),
+		LeaseDuration: env.Seconds("EXECUTION_QUEUE_LEASE_SECONDS", DEFAULT_LEASE_DURATION_SECONDS),
+		PollInterval:  env.Seconds("EXECUTION_QUEUE_POLL_INTERVAL_SECONDS", DEFAULT_POLL_INTERVAL_SECONDS),
+		MaxClaims:     uint(env.Int("EXECUTION_QUEUE_MAX_CLAIMS", DEFAULT_MAX_CLAIMS)),
+	}
+}
+
+// ExecutionQueue is a worker pool backed by the thread_executions table.
+// Executions are created with the queued status and claimed by workers with a lease,
+// which is renewed by a heartbeat while the execution runs. If a worker dies, its lease
+// expires and the execution is put back in the queue.
+type ExecutionQueue struct {
+	db             *gorm.DB
+	handler        Handler
+	failureHandler FailureHandler
+	config         *Config
+	// identifies this process as the lease owner
+	workerID string
+	pool     *worker.Pool
+
+	// cancel functions of the executions running in this process
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func Init(db *gorm.DB, handler Handler, failureHandler FailureHandler, config *Config) *ExecutionQueue {
+	executionQueue = &ExecutionQueue{
+		db:             db,
+		handler:        handler,
+		failureHandler: failureHandler,
+		config:         config,
+		workerID:       worker.NewID(),
+		running:        make(map[string]context.CancelFunc),
+	}
+	executionQueue.pool = worker.NewPool(config.Concurrency, config.PollInterval, executionQueue.runNext)
+	return executionQueue
+}
+
+// LeaseDuration returns how long an execution stays leased without a heartbeat.
+func LeaseDuration() time.Duration {
+	if executionQueue == nil {
+		return DEFAULT_LEASE_DURATION_SECONDS * time.Second
+	}
+	return executionQueue.config.LeaseDuration
+}
+
+// Notify wakes up an idle worker to pick up a newly queued execution.
+func Notify() {
+	if executionQueue == nil {
+		return
+	}
+	executionQueue.pool.Notify()
+}
+
+// Cancel interrupts an execution if it is running in this process.
+// Executions running in other processes are interrupted by their heartbeat,
+// once it notices that the execution is no longer in progress.
+func Cancel(executionID string) bool {
+	if executionQueue == nil {
+		return false
+	}
+	executionQueue.mu.Lock()
+	defer executionQueue.mu.Unlock()
+
+	cancel, ok := executionQueue.running[executionID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Start recovers orphaned executions and starts the workers.
+// The workers stop once the context is cancelled.
+func (q *ExecutionQueue) Start(ctx context.Context) {
+	q.recover()
+
+	q.pool.Start(ctx)
+
+	go q.reap(ctx)
+
+	logger.GetLogger().Infof("Execution queue started with %d workers: %s", q.config.Concurrency, q.workerID)
+}
+
+func (q *ExecutionQueue) recover() {
+	requeued, failed, err := models.RecoverOrphanedThreadExecutions(q.db, q.config.MaxClaims)
+	if err != nil {
+		logger.GetLogger().Errorf("Error recovering orphaned thread executions: %v", err)
+		return
+	}
+	if requeued > 0 || len(failed) > 0 {
+		logger.GetLogger().Infof("Recovered orphaned thread executions: requeued: %d, failed: %d", requeued, len(failed))
+		// wake the workers up for the requeued executions
+		for i := int64(0); i < requeued; i++ {
+			Notify()
+		}
+	}
+	for i := range failed {
+		q.failureHandler(q.db, &failed[i])
+	}
+}
+
+// reap periodically requeues executions whose worker stopped sending heartbeats.
+func (q *ExecutionQueue) reap(ctx context.Context) {
+	ticker := time.NewTicker(q.config.LeaseDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.recover()
+		}
+	}
+}
+
+// runNext claims and runs the next queued execution
+func (q *ExecutionQueue) runNext(ctx context.Context) bool {
+	threadExecution, err := models.ClaimNextThreadExecution(q.db, q.workerID, q.config.LeaseDuration)
+	if err != nil {
+		logger.GetLogger().Errorf("Error claiming thread execution: %v", err)
+		return false
+	}
+	if threadExecution == nil {
+		return false
+	}
+	q.run(ctx, threadExecution)
+	return true
+}
+
+func (q *ExecutionQueue) run(ctx context.Context, threadExecution *models.ThreadExecution) {
+	logger.GetLogger().Infof("Running thread execution: %s: claim: %d", threadExecution.Identifier, threadExecution.ClaimCount)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	q.mu.Lock()
+	q.running[threadExecution.Identifier] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, threadExecution.Identifier)
+		q.mu.Unlock()
+	}()
+
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		q.heartbeat(jobCtx, cancel, threadExecution.Identifier)
+	}()
+
+	q.handler(jobCtx, q.db, threadExecution)
+
+	cancel()
+	<-heartbeatDone
+
+	if err := models.ReleaseThreadExecutionLease(q.db, threadExecution.Identifier, q.workerID); err != nil {
+		logger.GetLogger().Errorf("Error releasing thread execution lease: %s: %v", threadExecution.Identifier, err)
+	}
+}
+
+// heartbeat renews the lease of a running execution until the context is cancelled.
+// If the lease is lost or the execution was cancelled, the execution context is cancelled.
+func (q *ExecutionQueue) heartbeat(ctx context.Context, cancel context.CancelFunc, executionID string) {
+	ticker := time.NewTicker(q.config.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			held, err := models.RenewThreadExecutionLease(q.db, executionID, q.workerID, q.config.LeaseDuration)
+			if err != nil {
+				logger.GetLogger().Errorf("Error renewing thread execution lease: %s: %v", executionID, err)
+				continue
+			}
+			if !held {
+				logger.GetLogger().Warnf("Lost lease on thread execution: %s", executionID)
+				cancel()
+				return
+			}
+		}
+	}
+}
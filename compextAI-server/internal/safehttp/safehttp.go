@@ -0,0 +1,123 @@
+package safehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// redirects followed by the clients, every one of them is dialed through the same checks
+	MAX_REDIRECTS = 5
+	// time allowed to resolve the host of a url when it is validated
+	RESOLVE_TIMEOUT = 5 * time.Second
+)
+
+var (
+	// ErrDestinationNotAllowed is returned when a url resolves to a loopback, private or link-local address
+	ErrDestinationNotAllowed = errors.New("destination address is not allowed")
+
+	// shared address space of the carrier grade NATs, not covered by net.IP.IsPrivate
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+// allowPrivateNetworks lets the urls reach the local and private networks, for local setups
+// where the webhooks and tools run next to the server. It must not be set in production.
+func allowPrivateNetworks() bool {
+	return os.Getenv("ALLOW_PRIVATE_NETWORK_URLS") == "true"
+}
+
+// IsAllowedIP reports whether the server may connect to the address on behalf of a user
+func IsAllowedIP(ip net.IP) bool {
+	if allowPrivateNetworks() {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// ValidateURL checks that the url is an http or https url whose host resolves to allowed addresses only.
+// The addresses are checked again when connecting, a host can resolve differently by then.
+func ValidateURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return errors.New("url should be an http or https url")
+	}
+	host := parsedURL.Hostname()
+	if host == "" {
+		return errors.New("url should have a host")
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsAllowedIP(ip) {
+			return fmt.Errorf("url host %s: %w", host, ErrDestinationNotAllowed)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RESOLVE_TIMEOUT)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("error resolving the url host %s: %v", host, err)
+	}
+	for _, address := range addresses {
+		if !IsAllowedIP(address.IP) {
+			return fmt.Errorf("url host %s resolves to %s: %w", host, address.IP, ErrDestinationNotAllowed)
+		}
+	}
+	return nil
+}
+
+// control rejects the connections to addresses which are not allowed, it runs after the
+// name resolution for every address dialed, including the ones of the redirects
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsAllowedIP(ip) {
+		return fmt.Errorf("%s: %w", address, ErrDestinationNotAllowed)
+	}
+	return nil
+}
+
+// NewClient returns an http client for the user provided urls, it can only connect to public addresses.
+// The proxy of the environment is not used, the client would otherwise only check the address of the proxy.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= MAX_REDIRECTS {
+				return fmt.Errorf("stopped after %d redirects", MAX_REDIRECTS)
+			}
+			if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+				return errors.New("redirect to a non http url")
+			}
+			return nil
+		},
+	}
+}
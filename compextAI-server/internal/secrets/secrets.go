@@ -0,0 +1,482 @@
+// Package secrets encrypts the provider credentials stored in the database.
+//
+// Values are envelope encrypted: every value is encrypted with its own data key,
+// and the data key is encrypted (wrapped) with a master key. Master keys are read
+// from SECRETS_MASTER_KEYS or from the key file at SECRETS_MASTER_KEY_FILE, both
+// hold comma or newline separated <key id>:<base64 encoded 32 byte key> pairs.
+// For local development SECRETS_GENERATE_DEV_KEY=true generates a key file when
+// none is configured, the server does not start without a master key otherwise.
+// The first key is the primary key used to wrap new data keys, the others are only
+// used to unwrap the data keys of older values. To rotate the master key, add a new
+// key in front and re-wrap the stored values with Rewrap.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+)
+
+const (
+	// encrypted values are formatted as enc:v2:<key id>:<hint>:<wrapped data key>:<ciphertext>.
+	// The key id and the hint are authenticated with the wrapped data key, and the hint with the
+	// ciphertext. The v1 values authenticate neither, they are upgraded when they are re-wrapped.
+	ENCRYPTED_VALUE_PREFIX    = "enc:v2:"
+	ENCRYPTED_VALUE_PREFIX_V1 = "enc:v1:"
+
+	DEFAULT_MASTER_KEY_FILE = "secrets.key"
+	MASTER_KEY_SIZE         = 32
+	// number of trailing characters of a value kept in clear to mask it
+	HINT_LENGTH  = 4
+	MASKED_VALUE = "********"
+)
+
+var (
+	ErrNotInitialized   = errors.New("secrets are not initialized")
+	ErrInvalidValue     = errors.New("invalid encrypted value")
+	ErrUnknownMasterKey = errors.New("unknown master key")
+	ErrNoMasterKey      = errors.New("no master key configured, set SECRETS_MASTER_KEYS or SECRETS_MASTER_KEY_FILE, or SECRETS_GENERATE_DEV_KEY=true to generate a development key")
+
+	keyIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	encoding   = base64.RawURLEncoding
+
+	mu         sync.RWMutex
+	masterKeys map[string][]byte
+	primaryKey string
+)
+
+// Init loads the master keys. Without a configured master key, a key file is only
+// created when SECRETS_GENERATE_DEV_KEY is set, for local development.
+func Init() error {
+	keys := os.Getenv("SECRETS_MASTER_KEYS")
+	if keys == "" {
+		keyFile := os.Getenv("SECRETS_MASTER_KEY_FILE")
+		generateDevKey := os.Getenv("SECRETS_GENERATE_DEV_KEY") == "true"
+		if keyFile == "" {
+			if !generateDevKey {
+				return ErrNoMasterKey
+			}
+			keyFile = DEFAULT_MASTER_KEY_FILE
+		}
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) || !generateDevKey {
+				return fmt.Errorf("failed to read master key file: %w", err)
+			}
+			logger.GetLogger().Warnf("No master key configured, generating a development master key in %s", keyFile)
+			content, err = generateKeyFile(keyFile)
+			if err != nil {
+				return err
+			}
+		}
+		keys = string(content)
+	}
+
+	return loadKeys(keys)
+}
+
+func generateKeyFile(keyFile string) ([]byte, error) {
+	key := make([]byte, MASTER_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	content := []byte(fmt.Sprintf("k%d:%s\n", time.Now().Unix(), base64.StdEncoding.EncodeToString(key)))
+	if err := os.WriteFile(keyFile, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write master key file: %w", err)
+	}
+	return content, nil
+}
+
+func loadKeys(keys string) error {
+	loadedKeys := make(map[string][]byte)
+	primary := ""
+	for _, entry := range strings.FieldsFunc(keys, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		keyID, encodedKey, ok := strings.Cut(entry, ":")
+		if !ok || !keyIDRegex.MatchString(keyID) {
+			return fmt.Errorf("invalid master key entry, expected <key id>:<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != MASTER_KEY_SIZE {
+			return fmt.Errorf("invalid master key %s, expected a base64 encoded %d byte key", keyID, MASTER_KEY_SIZE)
+		}
+		if _, ok := loadedKeys[keyID]; ok {
+			return fmt.Errorf("duplicate master key %s", keyID)
+		}
+		loadedKeys[keyID] = key
+		if primary == "" {
+			primary = keyID
+		}
+	}
+	if primary == "" {
+		return errors.New("no master key configured")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	masterKeys = loadedKeys
+	primaryKey = primary
+	return nil
+}
+
+func getMasterKey(keyID string) ([]byte, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if masterKeys == nil {
+		return nil, ErrNotInitialized
+	}
+	key, ok := masterKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, keyID)
+	}
+	return key, nil
+}
+
+func getPrimaryKeyID() (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if masterKeys == nil {
+		return "", ErrNotInitialized
+	}
+	return primaryKey, nil
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrInvalidValue
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+type envelope struct {
+	// false for the v1 values, which have no additional authenticated data
+	authenticated bool
+	keyID         string
+	hint          string
+	wrappedKey    []byte
+	ciphertext    []byte
+}
+
+// wrapAAD is the additional data authenticated with the wrapped data key
+func (e *envelope) wrapAAD() []byte {
+	if !e.authenticated {
+		return nil
+	}
+	return []byte(e.keyID + ":" + e.hint)
+}
+
+// valueAAD is the additional data authenticated with the ciphertext, the key id is not part of it
+// as the data key is wrapped with another master key on rotation without re-encrypting the value
+func (e *envelope) valueAAD() []byte {
+	if !e.authenticated {
+		return nil
+	}
+	return []byte(e.hint)
+}
+
+func (e *envelope) String() string {
+	return ENCRYPTED_VALUE_PREFIX + strings.Join([]string{
+		e.keyID,
+		encoding.EncodeToString([]byte(e.hint)),
+		encoding.EncodeToString(e.wrappedKey),
+		encoding.EncodeToString(e.ciphertext),
+	}, ":")
+}
+
+func parseEnvelope(value string) (*envelope, error) {
+	authenticated := strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX)
+	if !authenticated && !strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX_V1) {
+		return nil, ErrInvalidValue
+	}
+	parts := strings.Split(value[len(ENCRYPTED_VALUE_PREFIX):], ":")
+	if len(parts) != 4 {
+		return nil, ErrInvalidValue
+	}
+	hint, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	wrappedKey, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	ciphertext, err := encoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	return &envelope{
+		authenticated: authenticated,
+		keyID:         parts[0],
+		hint:          string(hint),
+		wrappedKey:    wrappedKey,
+		ciphertext:    ciphertext,
+	}, nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX) || strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX_V1)
+}
+
+func encrypt(plaintext []byte, hint string) (string, error) {
+	keyID, err := getPrimaryKeyID()
+	if err != nil {
+		return "", err
+	}
+	masterKey, err := getMasterKey(keyID)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, MASTER_KEY_SIZE)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	e := &envelope{
+		authenticated: true,
+		keyID:         keyID,
+		hint:          hint,
+	}
+	e.ciphertext, err = seal(dataKey, plaintext, e.valueAAD())
+	if err != nil {
+		return "", err
+	}
+	e.wrappedKey, err = seal(masterKey, dataKey, e.wrapAAD())
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+func decrypt(value string) ([]byte, error) {
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := getMasterKey(e.keyID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(masterKey, e.wrappedKey, e.wrapAAD())
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := open(dataKey, e.ciphertext, e.valueAAD())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Encrypt encrypts the value with a new data key. Empty and already encrypted
+// values are returned as is.
+func Encrypt(value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	hint := ""
+	if len(value) > HINT_LENGTH*3 {
+		hint = value[len(value)-HINT_LENGTH:]
+	}
+	return encrypt([]byte(value), hint)
+}
+
+// Decrypt returns the plain text of an encrypted value. Values stored before the
+// encryption was added are returned as is.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	plaintext, err := decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptJSON encrypts a json value, the encrypted value is stored as a json string.
+func EncryptJSON(value json.RawMessage) (json.RawMessage, error) {
+	if isEmptyJSON(value) {
+		return value, nil
+	}
+	if _, ok := encryptedJSONString(value); ok {
+		return value, nil
+	}
+	encrypted, err := encrypt(value, "")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encrypted)
+}
+
+// DecryptJSON returns the json value of a value encrypted with EncryptJSON.
+func DecryptJSON(value json.RawMessage) (json.RawMessage, error) {
+	encrypted, ok := encryptedJSONString(value)
+	if !ok {
+		return value, nil
+	}
+	return decrypt(encrypted)
+}
+
+func isEmptyJSON(value json.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(value))
+	return trimmed == "" || trimmed == "null"
+}
+
+func encryptedJSONString(value json.RawMessage) (string, bool) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", false
+	}
+	return s, IsEncrypted(s)
+}
+
+// NeedsRewrap reports whether the value is not encrypted with the primary master key,
+// or is a v1 value without authenticated data.
+func NeedsRewrap(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	primary, err := getPrimaryKeyID()
+	if err != nil {
+		return false
+	}
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return false
+	}
+	return !e.authenticated || e.keyID != primary
+}
+
+// NeedsRewrapJSON is NeedsRewrap for the values encrypted with EncryptJSON.
+func NeedsRewrapJSON(value json.RawMessage) bool {
+	if isEmptyJSON(value) {
+		return false
+	}
+	encrypted, ok := encryptedJSONString(value)
+	if !ok {
+		return true
+	}
+	return NeedsRewrap(encrypted)
+}
+
+// Rewrap wraps the data key of the value with the primary master key, the value
+// itself is not re-encrypted. Plain text and v1 values are encrypted again.
+func Rewrap(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return Encrypt(value)
+	}
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+	if !e.authenticated {
+		plaintext, err := decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return encrypt(plaintext, e.hint)
+	}
+	masterKey, err := getMasterKey(e.keyID)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(masterKey, e.wrappedKey, e.wrapAAD())
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	primary, err := getPrimaryKeyID()
+	if err != nil {
+		return "", err
+	}
+	primaryMasterKey, err := getMasterKey(primary)
+	if err != nil {
+		return "", err
+	}
+	e.keyID = primary
+	e.wrappedKey, err = seal(primaryMasterKey, dataKey, e.wrapAAD())
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// RewrapJSON is Rewrap for the values encrypted with EncryptJSON.
+func RewrapJSON(value json.RawMessage) (json.RawMessage, error) {
+	encrypted, ok := encryptedJSONString(value)
+	if !ok {
+		return EncryptJSON(value)
+	}
+	rewrapped, err := Rewrap(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rewrapped)
+}
+
+// Mask returns a masked version of the value that is safe to return from the api,
+// the value is not decrypted.
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	hint := ""
+	if IsEncrypted(value) {
+		if e, err := parseEnvelope(value); err == nil {
+			hint = e.hint
+		}
+	} else if len(value) > HINT_LENGTH*3 {
+		hint = value[len(value)-HINT_LENGTH:]
+	}
+	return MASKED_VALUE + hint
+}
+
+// MaskJSON returns a masked version of a json value.
+func MaskJSON(value json.RawMessage) string {
+	if isEmptyJSON(value) {
+		return ""
+	}
+	return MASKED_VALUE
+}
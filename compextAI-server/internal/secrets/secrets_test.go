@@ -0,0 +1,320 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, MASTER_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("error generating the key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// useKeys loads the master keys for the test, the first one is the primary key
+func useKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	if err := loadKeys(strings.Join(keys, ",")); err != nil {
+		t.Fatalf("error loading the keys: %v", err)
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		masterKeys = nil
+		primaryKey = ""
+	})
+}
+
+func mustEncrypt(t *testing.T, value string) string {
+	t.Helper()
+	encrypted, err := Encrypt(value)
+	if err != nil {
+		t.Fatalf("error encrypting the value: %v", err)
+	}
+	return encrypted
+}
+
+func mustParse(t *testing.T, value string) *envelope {
+	t.Helper()
+	e, err := parseEnvelope(value)
+	if err != nil {
+		t.Fatalf("error parsing the value: %v", err)
+	}
+	return e
+}
+
+// encryptV1 encrypts the value the way the v1 values were, without authenticated data
+func encryptV1(t *testing.T, value, keyID string) string {
+	t.Helper()
+	masterKey, err := getMasterKey(keyID)
+	if err != nil {
+		t.Fatalf("error getting the key: %v", err)
+	}
+	dataKey := make([]byte, MASTER_KEY_SIZE)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatalf("error generating the data key: %v", err)
+	}
+	e := &envelope{keyID: keyID}
+	if e.ciphertext, err = seal(dataKey, []byte(value), nil); err != nil {
+		t.Fatalf("error encrypting the value: %v", err)
+	}
+	if e.wrappedKey, err = seal(masterKey, dataKey, nil); err != nil {
+		t.Fatalf("error wrapping the data key: %v", err)
+	}
+	return ENCRYPTED_VALUE_PREFIX_V1 + strings.TrimPrefix(e.String(), ENCRYPTED_VALUE_PREFIX)
+}
+
+func TestLoadKeys(t *testing.T) {
+	key := newKey(t)
+	tests := []struct {
+		keys  string
+		valid bool
+	}{
+		{"k1:" + key, true},
+		{"k2:" + key + ",k1:" + key, true},
+		{"k2:" + key + "\n k1:" + key + "\n", true},
+		{"", false},
+		{key, false},
+		{"k 1:" + key, false},
+		{"k1:not-base64", false},
+		{"k1:" + base64.StdEncoding.EncodeToString([]byte("short")), false},
+		{"k1:" + key + ",k1:" + key, false},
+	}
+	for _, tt := range tests {
+		if err := loadKeys(tt.keys); (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid to be %t, got %v", tt.keys, tt.valid, err)
+		}
+	}
+
+	useKeys(t, "k2:"+key, "k1:"+key)
+	if primary, _ := getPrimaryKeyID(); primary != "k2" {
+		t.Fatalf("expected the first key to be the primary key, got %s", primary)
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	if _, err := Encrypt("sk-1234567890abcdef"); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	useKeys(t, "k1:"+newKey(t))
+
+	for _, value := range []string{"sk-1234567890abcdef", "short", "ünïcödé:with:colons"} {
+		encrypted := mustEncrypt(t, value)
+		if !strings.HasPrefix(encrypted, ENCRYPTED_VALUE_PREFIX) || strings.Contains(encrypted, value) {
+			t.Fatalf("%q: unexpected encrypted value: %s", value, encrypted)
+		}
+		if again := mustEncrypt(t, value); again == encrypted {
+			t.Fatalf("%q: expected every encryption to use a new data key", value)
+		}
+		decrypted, err := Decrypt(encrypted)
+		if err != nil || decrypted != value {
+			t.Fatalf("%q: expected the value back, got %q, %v", value, decrypted, err)
+		}
+	}
+
+	// only the values long enough keep a hint in clear
+	if e := mustParse(t, mustEncrypt(t, "sk-1234567890abcdef")); e.hint != "cdef" || e.keyID != "k1" || !e.authenticated {
+		t.Fatalf("unexpected envelope: %+v", e)
+	}
+	if e := mustParse(t, mustEncrypt(t, "short")); e.hint != "" {
+		t.Fatalf("expected no hint for a short value, got %q", e.hint)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	useKeys(t, "k1:"+newKey(t))
+
+	encrypted := mustEncrypt(t, "sk-1234567890abcdef")
+	for _, value := range []string{"", encrypted} {
+		if got := mustEncrypt(t, value); got != value {
+			t.Fatalf("expected %q to be returned as is, got %q", value, got)
+		}
+	}
+	// values stored before the encryption was added
+	if decrypted, err := Decrypt("sk-plain"); err != nil || decrypted != "sk-plain" {
+		t.Fatalf("expected the plain text value as is, got %q, %v", decrypted, err)
+	}
+
+	if _, err := Decrypt(ENCRYPTED_VALUE_PREFIX + "k1:broken"); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestDecryptAuthenticatesTheEnvelope(t *testing.T) {
+	// the same key under two ids, so only the authenticated data tells them apart
+	key := newKey(t)
+	useKeys(t, "k1:"+key, "k2:"+key)
+
+	e := mustParse(t, mustEncrypt(t, "sk-1234567890abcdef"))
+	tampered := []struct {
+		name   string
+		tamper func(e *envelope)
+	}{
+		{"key id", func(e *envelope) { e.keyID = "k2" }},
+		{"hint", func(e *envelope) { e.hint = "abcd" }},
+		{"wrapped key", func(e *envelope) { e.wrappedKey[len(e.wrappedKey)-1] ^= 1 }},
+		{"ciphertext", func(e *envelope) { e.ciphertext[len(e.ciphertext)-1] ^= 1 }},
+	}
+	for _, tt := range tampered {
+		copied := *e
+		copied.wrappedKey = append([]byte(nil), e.wrappedKey...)
+		copied.ciphertext = append([]byte(nil), e.ciphertext...)
+		tt.tamper(&copied)
+		if _, err := Decrypt(copied.String()); err == nil {
+			t.Errorf("expected the value with a tampered %s not to decrypt", tt.name)
+		}
+	}
+	if decrypted, err := Decrypt(e.String()); err != nil || decrypted != "sk-1234567890abcdef" {
+		t.Fatalf("expected the untampered value to decrypt, got %q, %v", decrypted, err)
+	}
+}
+
+func TestV1Values(t *testing.T) {
+	useKeys(t, "k1:"+newKey(t))
+
+	v1 := encryptV1(t, "sk-1234567890abcdef", "k1")
+	if !IsEncrypted(v1) {
+		t.Fatal("expected the v1 value to be encrypted")
+	}
+	if decrypted, err := Decrypt(v1); err != nil || decrypted != "sk-1234567890abcdef" {
+		t.Fatalf("expected the v1 value to decrypt, got %q, %v", decrypted, err)
+	}
+	if !NeedsRewrap(v1) {
+		t.Fatal("expected the v1 value to need a re-wrap")
+	}
+
+	rewrapped, err := Rewrap(v1)
+	if err != nil {
+		t.Fatalf("error re-wrapping the v1 value: %v", err)
+	}
+	if !strings.HasPrefix(rewrapped, ENCRYPTED_VALUE_PREFIX) || NeedsRewrap(rewrapped) {
+		t.Fatalf("expected the v1 value to be upgraded, got %s", rewrapped)
+	}
+	if decrypted, err := Decrypt(rewrapped); err != nil || decrypted != "sk-1234567890abcdef" {
+		t.Fatalf("expected the upgraded value to decrypt, got %q, %v", decrypted, err)
+	}
+}
+
+func TestRewrapOnRotation(t *testing.T) {
+	key1, key2 := "k1:"+newKey(t), "k2:"+newKey(t)
+	useKeys(t, key1)
+
+	encrypted := mustEncrypt(t, "sk-1234567890abcdef")
+	if NeedsRewrap(encrypted) {
+		t.Fatal("expected a value of the primary key not to need a re-wrap")
+	}
+	if !NeedsRewrap("sk-plain") || NeedsRewrap("") {
+		t.Fatal("expected only the non empty plain text values to need a re-wrap")
+	}
+
+	// the new key is added in front of the old one
+	useKeys(t, key2, key1)
+	if !NeedsRewrap(encrypted) {
+		t.Fatal("expected a value of the old key to need a re-wrap")
+	}
+	if decrypted, err := Decrypt(encrypted); err != nil || decrypted != "sk-1234567890abcdef" {
+		t.Fatalf("expected the value of the old key to decrypt, got %q, %v", decrypted, err)
+	}
+
+	rewrapped, err := Rewrap(encrypted)
+	if err != nil {
+		t.Fatalf("error re-wrapping the value: %v", err)
+	}
+	before, after := mustParse(t, encrypted), mustParse(t, rewrapped)
+	if after.keyID != "k2" || after.hint != before.hint || string(after.ciphertext) != string(before.ciphertext) {
+		t.Fatalf("expected only the data key to be wrapped again, got %+v", after)
+	}
+	if NeedsRewrap(rewrapped) {
+		t.Fatal("expected the re-wrapped value not to need a re-wrap")
+	}
+
+	// once the old key is removed, only the re-wrapped value can be decrypted
+	useKeys(t, key2)
+	if decrypted, err := Decrypt(rewrapped); err != nil || decrypted != "sk-1234567890abcdef" {
+		t.Fatalf("expected the re-wrapped value to decrypt, got %q, %v", decrypted, err)
+	}
+	if _, err := Decrypt(encrypted); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("expected ErrUnknownMasterKey, got %v", err)
+	}
+	if _, err := Rewrap(encrypted); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("expected ErrUnknownMasterKey, got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	key1, key2 := "k1:"+newKey(t), "k2:"+newKey(t)
+	useKeys(t, key1)
+
+	value := json.RawMessage(`{"type":"service_account","private_key":"secret"}`)
+	encrypted, err := EncryptJSON(value)
+	if err != nil {
+		t.Fatalf("error encrypting the value: %v", err)
+	}
+	var s string
+	if err := json.Unmarshal(encrypted, &s); err != nil || !IsEncrypted(s) {
+		t.Fatalf("expected the value to be stored as an encrypted json string, got %s", encrypted)
+	}
+	if decrypted, err := DecryptJSON(encrypted); err != nil || string(decrypted) != string(value) {
+		t.Fatalf("expected the value back, got %s, %v", decrypted, err)
+	}
+
+	for _, value := range []json.RawMessage{nil, json.RawMessage("null"), encrypted} {
+		if got, err := EncryptJSON(value); err != nil || string(got) != string(value) {
+			t.Fatalf("expected %s to be returned as is, got %s, %v", value, got, err)
+		}
+	}
+	if decrypted, err := DecryptJSON(value); err != nil || string(decrypted) != string(value) {
+		t.Fatalf("expected the plain json value as is, got %s, %v", decrypted, err)
+	}
+
+	if NeedsRewrapJSON(encrypted) || NeedsRewrapJSON(json.RawMessage("null")) || !NeedsRewrapJSON(value) {
+		t.Fatal("expected only the plain json values to need a re-wrap")
+	}
+	useKeys(t, key2, key1)
+	if !NeedsRewrapJSON(encrypted) {
+		t.Fatal("expected a value of the old key to need a re-wrap")
+	}
+	rewrapped, err := RewrapJSON(encrypted)
+	if err != nil {
+		t.Fatalf("error re-wrapping the value: %v", err)
+	}
+	if NeedsRewrapJSON(rewrapped) {
+		t.Fatal("expected the re-wrapped value not to need a re-wrap")
+	}
+	if decrypted, err := DecryptJSON(rewrapped); err != nil || string(decrypted) != string(value) {
+		t.Fatalf("expected the re-wrapped value to decrypt, got %s, %v", decrypted, err)
+	}
+}
+
+func TestMask(t *testing.T) {
+	useKeys(t, "k1:"+newKey(t))
+
+	tests := []struct {
+		value  string
+		masked string
+	}{
+		{"", ""},
+		{"short", MASKED_VALUE},
+		{"sk-1234567890abcdef", MASKED_VALUE + "cdef"},
+		{mustEncrypt(t, "sk-1234567890abcdef"), MASKED_VALUE + "cdef"},
+		{mustEncrypt(t, "short"), MASKED_VALUE},
+	}
+	for _, tt := range tests {
+		if masked := Mask(tt.value); masked != tt.masked {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.masked, masked)
+		}
+	}
+	if masked := MaskJSON(json.RawMessage("null")); masked != "" {
+		t.Errorf("expected an empty mask for null, got %q", masked)
+	}
+}
@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/worker"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+const (
+	// postgres channel the events are relayed on between the processes
+	RELAY_CHANNEL = "compext_thread_execution_stream"
+	// postgres rejects notification payloads of 8000 bytes or more
+	MAX_RELAY_PAYLOAD_BYTES = 7900
+	// events waiting to be relayed, the events published beyond it are not relayed
+	RELAY_BUFFER_SIZE = 1024
+	// queued events sent in a single pass, the consecutive deltas of an execution are merged
+	RELAY_BATCH_SIZE = 64
+	// wait before listening again once the connection is lost
+	RELAY_RECONNECT_INTERVAL = 5 * time.Second
+)
+
+var (
+	relay *Relay
+)
+
+// relayMessage is the payload of a notification, the content of the done events is not
+// relayed as it can exceed the payload limit, the subscribers read it from the database
+type relayMessage struct {
+	// process which published the event, it already delivered the event to its subscribers
+	Origin      string `json:"origin"`
+	ExecutionID string `json:"execution_id"`
+	Type        string `json:"type"`
+	Content     string `json:"content,omitempty"`
+}
+
+// Relay forwards the events published in this process to the subscribers of the other
+// processes with postgres LISTEN/NOTIFY, so an execution can be streamed from any process
+// whichever worker runs it.
+type Relay struct {
+	db       *gorm.DB
+	origin   string
+	outgoing chan relayMessage
+}
+
+func InitRelay(db *gorm.DB) *Relay {
+	relay = &Relay{
+		db:       db,
+		origin:   worker.NewID(),
+		outgoing: make(chan relayMessage, RELAY_BUFFER_SIZE),
+	}
+	return relay
+}
+
+// Start starts sending and receiving the relayed events, until the context is cancelled.
+func (r *Relay) Start(ctx context.Context) {
+	go r.send(ctx)
+	go r.listen(ctx)
+	logger.GetLogger().Infof("Stream relay started: %s", r.origin)
+}
+
+// relayEvent queues an event published in this process to be relayed
+func relayEvent(executionID string, event Event) {
+	if relay == nil {
+		return
+	}
+	message := relayMessage{
+		Origin:      relay.origin,
+		ExecutionID: executionID,
+		Type:        event.Type,
+	}
+	if event.Type == EVENT_TYPE_DELTA {
+		message.Content = event.Content
+	}
+	select {
+	case relay.outgoing <- message:
+	default:
+		// the subscribers of the other processes pick up the final state from the database
+		logger.GetLogger().Warnf("Stream relay is full, dropping %s event: %s", event.Type, executionID)
+	}
+}
+
+func (r *Relay) send(ctx context.Context) {
+	for {
+		var message relayMessage
+		select {
+		case <-ctx.Done():
+			return
+		case message = <-r.outgoing:
+		}
+
+		messages := []relayMessage{message}
+	drain:
+		for len(messages) < RELAY_BATCH_SIZE {
+			select {
+			case message := <-r.outgoing:
+				last := &messages[len(messages)-1]
+				if message.Type == EVENT_TYPE_DELTA && last.Type == EVENT_TYPE_DELTA && last.ExecutionID == message.ExecutionID {
+					last.Content += message.Content
+					continue
+				}
+				messages = append(messages, message)
+			default:
+				break drain
+			}
+		}
+
+		for _, message := range messages {
+			for _, payload := range relayPayloads(message) {
+				if err := r.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", RELAY_CHANNEL, string(payload)).Error; err != nil {
+					logger.GetLogger().Errorf("Error relaying %s event: %s: %v", message.Type, message.ExecutionID, err)
+					break
+				}
+			}
+		}
+	}
+}
+
+// relayPayloads encodes the message, the deltas too large for a notification are split
+func relayPayloads(message relayMessage) [][]byte {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		logger.GetLogger().Errorf("Error marshalling relayed event: %v", err)
+		return nil
+	}
+	if len(payload) < MAX_RELAY_PAYLOAD_BYTES || utf8.RuneCountInString(message.Content) < 2 {
+		return [][]byte{payload}
+	}
+
+	// split the content in half on a character boundary
+	half := len(message.Content) / 2
+	for !utf8.RuneStart(message.Content[half]) {
+		half--
+	}
+	first, second := message, message
+	first.Content, second.Content = message.Content[:half], message.Content[half:]
+	return append(relayPayloads(first), relayPayloads(second)...)
+}
+
+// listen receives the events relayed by the other processes, it reconnects until the context is cancelled
+func (r *Relay) listen(ctx context.Context) {
+	for {
+		err := r.listenOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.GetLogger().Errorf("Error listening for relayed stream events, reconnecting in %s: %v", RELAY_RECONNECT_INTERVAL, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RELAY_RECONNECT_INTERVAL):
+		}
+	}
+}
+
+func (r *Relay) listenOnce(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	// the connection is dedicated to the notifications while it listens
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("the stream relay requires the pgx driver")
+		}
+		pgConn := stdlibConn.Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+RELAY_CHANNEL); err != nil {
+			return err
+		}
+		// the connection goes back to the pool afterwards
+		defer pgConn.Exec(context.Background(), "UNLISTEN "+RELAY_CHANNEL)
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			r.receive([]byte(notification.Payload))
+		}
+	})
+}
+
+func (r *Relay) receive(payload []byte) {
+	var message relayMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		logger.GetLogger().Errorf("Error decoding relayed stream event: %v", err)
+		return
+	}
+	if message.Origin == r.origin {
+		return
+	}
+	publishRelayed(message.ExecutionID, Event{
+		Type:    message.Type,
+		Content: message.Content,
+	})
+}
@@ -0,0 +1,168 @@
+package stream
+
+import (
+	"sync"
+)
+
+const (
+	EVENT_TYPE_DELTA = "delta"
+	EVENT_TYPE_DONE  = "done"
+	EVENT_TYPE_ERROR = "error"
+
+	// number of events buffered per subscriber before events are dropped for it
+	SUBSCRIBER_BUFFER_SIZE = 256
+)
+
+var (
+	broker = &Broker{
+		topics: make(map[string]*topic),
+	}
+)
+
+type Event struct {
+	Type    string `json:"-"`
+	Content string `json:"content,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type topic struct {
+	// all the events published so far, replayed to late subscribers
+	events      []Event
+	subscribers map[chan Event]struct{}
+	// closed topics don't accept any more events
+	closed bool
+	// set when an execution in this process is publishing to the topic
+	open bool
+}
+
+// Broker fans out the tokens of the thread executions to the clients streaming them from
+// this process. The tokens of the executions running in other processes are received through
+// the relay, see relay.go.
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+func (b *Broker) getOrCreateTopic(executionID string) *topic {
+	t, ok := b.topics[executionID]
+	if !ok {
+		t = &topic{
+			subscribers: make(map[chan Event]struct{}),
+		}
+		b.topics[executionID] = t
+	}
+	return t
+}
+
+// Open marks the execution as streaming from this process.
+func Open(executionID string) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	broker.getOrCreateTopic(executionID).open = true
+}
+
+// Publish sends an event to all the subscribers of the execution, in this process and,
+// through the relay, in the other processes. Done and error events close the topic.
+func Publish(executionID string, event Event) {
+	broker.mu.Lock()
+	t, ok := broker.topics[executionID]
+	if ok && !t.closed {
+		broker.publish(executionID, t, event)
+	}
+	broker.mu.Unlock()
+
+	if ok {
+		relayEvent(executionID, event)
+	}
+}
+
+// PublishResult sends the final event of an execution which is not streamed from this process,
+// e.g. an execution whose worker stopped, to its subscribers in all the processes.
+func PublishResult(executionID string, event Event) {
+	broker.mu.Lock()
+	if t, ok := broker.topics[executionID]; ok && !t.closed {
+		broker.publish(executionID, t, event)
+	}
+	broker.mu.Unlock()
+
+	relayEvent(executionID, event)
+}
+
+// publishRelayed sends an event relayed from the process running the execution to the
+// subscribers of this process. A done or error event closes the topic without being sent,
+// the subscribers read the final state of the execution from the database.
+func publishRelayed(executionID string, event Event) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	t, ok := broker.topics[executionID]
+	if !ok || t.closed || t.open {
+		return
+	}
+	if event.Type == EVENT_TYPE_DELTA {
+		broker.publish(executionID, t, event)
+		return
+	}
+	broker.close(executionID, t)
+}
+
+func (b *Broker) publish(executionID string, t *topic, event Event) {
+	t.events = append(t.events, event)
+	for subscriber := range t.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// slow subscriber, it will pick up the final content from the done event
+		}
+	}
+
+	if event.Type == EVENT_TYPE_DONE || event.Type == EVENT_TYPE_ERROR {
+		b.close(executionID, t)
+	}
+}
+
+func (b *Broker) close(executionID string, t *topic) {
+	t.closed = true
+	for subscriber := range t.subscribers {
+		close(subscriber)
+	}
+	t.subscribers = make(map[chan Event]struct{})
+	delete(b.topics, executionID)
+}
+
+// Subscribe returns the events published so far, a channel for the upcoming events
+// and a function to unsubscribe. The channel is closed once the execution is done.
+// The deltas of executions running in another process are relayed from the subscription on
+// and are dropped when the relay lags behind, so subscribers should not rely on the channel
+// being closed.
+func Subscribe(executionID string) ([]Event, <-chan Event, func()) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	t := broker.getOrCreateTopic(executionID)
+	subscriber := make(chan Event, SUBSCRIBER_BUFFER_SIZE)
+	t.subscribers[subscriber] = struct{}{}
+
+	replay := make([]Event, len(t.events))
+	copy(replay, t.events)
+
+	unsubscribe := func() {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+
+		if _, ok := t.subscribers[subscriber]; !ok {
+			return
+		}
+		delete(t.subscribers, subscriber)
+		close(subscriber)
+		// drop topics created by subscribers for executions which never streamed here
+		if !t.open && len(t.subscribers) == 0 && broker.topics[executionID] == t {
+			delete(broker.topics, executionID)
+		}
+	}
+
+	return replay, subscriber, unsubscribe
+}
@@ -0,0 +1,68 @@
+// Package testdb opens the postgres database the tests of the queries run against.
+// The tests are skipped unless TEST_POSTGRES_DSN is set, e.g.
+//
+//	TEST_POSTGRES_DSN="host=localhost port=5433 user=postgres password=postgres dbname=postgres sslmode=disable" go test ./...
+//
+// Every test gets its own schema, which is dropped once the test is done.
+package testdb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const DSN_ENV = "TEST_POSTGRES_DSN"
+
+// Open returns a database on a new schema with the tables of the given models.
+func Open(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(DSN_ENV)
+	if dsn == "" {
+		t.Skipf("%s is not set", DSN_ENV)
+	}
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatalf("error connecting to the test database: %v", err)
+	}
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if err := admin.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema)).Error; err != nil {
+		t.Fatalf("error creating the test schema: %v", err)
+	}
+
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", DSN_ENV, err)
+	}
+	connConfig.RuntimeParams["search_path"] = schema
+	sqlDB := stdlib.OpenDB(*connConfig)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), config)
+	if err != nil {
+		t.Fatalf("error connecting to the test schema: %v", err)
+	}
+
+	t.Cleanup(func() {
+		sqlDB.Close()
+		if err := admin.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)).Error; err != nil {
+			t.Errorf("error dropping the test schema: %v", err)
+		}
+		if adminDB, err := admin.DB(); err == nil {
+			adminDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("error migrating the test schema: %v", err)
+	}
+	return db
+}
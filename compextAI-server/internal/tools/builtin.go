@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	BUILTIN_CURRENT_TIME = "current_time"
+	BUILTIN_CALCULATOR   = "calculator"
+)
+
+// Builtin is a tool implemented by the server. The description and the input schema are
+// used for the project tools which do not set their own.
+type Builtin struct {
+	Name        string
+	Description string
+	InputSchema json.RawMessage
+	Call        func(ctx context.Context, input json.RawMessage) (string, error)
+}
+
+var builtins = map[string]*Builtin{
+	BUILTIN_CURRENT_TIME: {
+		Name:        BUILTIN_CURRENT_TIME,
+		Description: "Returns the current date and time in RFC3339 format, in UTC unless an IANA timezone is given.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"IANA timezone, e.g. Europe/Paris"}}}`),
+		Call:        currentTime,
+	},
+	BUILTIN_CALCULATOR: {
+		Name:        BUILTIN_CALCULATOR,
+		Description: "Evaluates an arithmetic expression with + - * / % ^ and parentheses, and returns the result.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"arithmetic expression, e.g. (2 + 3) * 4"}},"required":["expression"]}`),
+		Call:        calculator,
+	},
+}
+
+func GetBuiltin(name string) (*Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// BuiltinNames returns the names of the built-in tools, sorted
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func currentTime(ctx context.Context, input json.RawMessage) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if len(input) > 0 {
+		if err := json.Unmarshal(input, &args); err != nil {
+			return "", fmt.Errorf("invalid input: %v", err)
+		}
+	}
+
+	location := time.UTC
+	if args.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("invalid timezone: %s", args.Timezone)
+		}
+	}
+	return time.Now().In(location).Format(time.RFC3339), nil
+}
+
+func calculator(ctx context.Context, input json.RawMessage) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", fmt.Errorf("invalid input: %v", err)
+	}
+	if args.Expression == "" {
+		return "", fmt.Errorf("expression is required")
+	}
+
+	result, err := evaluate(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(result, 'g', -1, 64), nil
+}
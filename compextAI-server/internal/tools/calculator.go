@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// expressionParser evaluates arithmetic expressions with a recursive descent:
+//
+//	expression = term { ("+" | "-") term }
+//	term       = unary { ("*" | "/" | "%") unary }
+//	unary      = ( "-" | "+" ) unary | power
+//	power      = primary [ "^" unary ]
+//	primary    = number | "(" expression ")"
+type expressionParser struct {
+	input []rune
+	pos   int
+}
+
+func evaluate(expression string) (float64, error) {
+	p := &expressionParser{input: []rune(expression)}
+	result, err := p.expression()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, fmt.Errorf("the result is not a finite number")
+	}
+	return result, nil
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// accept consumes the next rune if it is one of the given runes
+func (p *expressionParser) accept(runes ...rune) (rune, bool) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0, false
+	}
+	for _, r := range runes {
+		if p.input[p.pos] == r {
+			p.pos++
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+func (p *expressionParser) expression() (float64, error) {
+	left, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		operator, ok := p.accept('+', '-')
+		if !ok {
+			return left, nil
+		}
+		right, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if operator == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (p *expressionParser) term() (float64, error) {
+	left, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		operator, ok := p.accept('*', '/', '%')
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+func (p *expressionParser) power() (float64, error) {
+	base, err := p.primary()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := p.accept('^'); !ok {
+		return base, nil
+	}
+	exponent, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+func (p *expressionParser) unary() (float64, error) {
+	if operator, ok := p.accept('-', '+'); ok {
+		value, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		if operator == '-' {
+			return -value, nil
+		}
+		return value, nil
+	}
+	return p.power()
+}
+
+func (p *expressionParser) primary() (float64, error) {
+	if _, ok := p.accept('('); ok {
+		value, err := p.expression()
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := p.accept(')'); !ok {
+			return 0, fmt.Errorf("missing closing parenthesis at position %d", p.pos)
+		}
+		return value, nil
+	}
+
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, fmt.Errorf("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", string(p.input[start:p.pos]))
+	}
+	return value, nil
+}
@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/safehttp"
+	"github.com/burnerlee/compextAI/internal/webhooks"
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	// id of the tool call, also sent in the body
+	HEADER_TOOL_CALL = "X-Compext-Tool-Call"
+	// number of bytes of the body of a failed call kept in the logs
+	MAX_LOGGED_RESPONSE_BYTES = 1024
+)
+
+var (
+	// the tool urls are set by the users, the client does not connect to the private networks of the server
+	httpClient = safehttp.NewClient(0)
+)
+
+// httpToolRequest is the body POSTed to the http tools
+type httpToolRequest struct {
+	Tool              string          `json:"tool"`
+	ToolCallID        string          `json:"tool_call_id"`
+	ThreadExecutionID string          `json:"thread_execution_id"`
+	Input             json.RawMessage `json:"input"`
+}
+
+// invokeHTTP POSTs the call to the endpoint of the tool, signed like the webhook deliveries.
+// The body of a 2xx response is the output of the tool.
+func invokeHTTP(ctx context.Context, tool *models.ProjectTool, call *Call) (string, error) {
+	input := call.Input
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+	body, err := json.Marshal(&httpToolRequest{
+		Tool:              tool.Name,
+		ToolCallID:        call.ID,
+		ThreadExecutionID: call.ThreadExecutionID,
+		Input:             input,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(tool.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT_SECONDS * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tool.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "compextAI-tools")
+	request.Header.Set(HEADER_TOOL_CALL, call.ID)
+	request.Header.Set(webhooks.HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(webhooks.HEADER_SIGNATURE, webhooks.Sign(tool.Secret, timestamp, body))
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("error calling tool: %w", err)
+	}
+	defer response.Body.Close()
+
+	output, err := io.ReadAll(io.LimitReader(response.Body, MAX_OUTPUT_BYTES))
+	if err != nil {
+		return "", fmt.Errorf("error reading tool response: %w", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		// the error is sent to the model, the body of the failed call is only logged
+		if len(output) > MAX_LOGGED_RESPONSE_BYTES {
+			output = output[:MAX_LOGGED_RESPONSE_BYTES]
+		}
+		logger.GetLogger().Warnf("Tool %s responded with status code %d: %s", tool.Name, response.StatusCode, output)
+		return "", fmt.Errorf("tool responded with status code %d", response.StatusCode)
+	}
+	return string(output), nil
+}
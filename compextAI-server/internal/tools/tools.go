@@ -0,0 +1,50 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+const (
+	// timeout of the calls of http tools which do not set one
+	DEFAULT_TIMEOUT_SECONDS = 30
+	// tool outputs are truncated to this size before being sent to the model
+	MAX_OUTPUT_BYTES = 64 << 10
+)
+
+// Call is a call of a tool requested by the model
+type Call struct {
+	ID    string
+	Name  string
+	Input json.RawMessage
+	// execution the call is made for, sent to the http tools
+	ThreadExecutionID string
+}
+
+// Invoke runs the call with the tool and returns the output to send back to the model
+func Invoke(ctx context.Context, tool *models.ProjectTool, call *Call) (string, error) {
+	var output string
+	var err error
+	switch tool.Type {
+	case models.ProjectToolType_HTTP:
+		output, err = invokeHTTP(ctx, tool, call)
+	case models.ProjectToolType_BUILTIN:
+		builtin, ok := GetBuiltin(tool.Builtin)
+		if !ok {
+			return "", fmt.Errorf("unknown built-in tool: %s", tool.Builtin)
+		}
+		output, err = builtin.Call(ctx, call.Input)
+	default:
+		return "", fmt.Errorf("unknown tool type: %s", tool.Type)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(output) > MAX_OUTPUT_BYTES {
+		output = output[:MAX_OUTPUT_BYTES]
+	}
+	return output, nil
+}
@@ -0,0 +1,65 @@
+package usage
+
+import (
+	"strings"
+)
+
+// Price is the price of a model in USD per million tokens
+type Price struct {
+	Input       float64
+	CachedInput float64
+	Output      float64
+}
+
+var (
+	// keyed by the model of the templates, which is the provider identifier
+	// for the native providers and the model name for litellm
+	prices = map[string]Price{
+		"gpt-4o":                     {Input: 2.5, CachedInput: 1.25, Output: 10},
+		"gpt-4o-mini":                {Input: 0.15, CachedInput: 0.075, Output: 0.6},
+		"gpt-4":                      {Input: 30, CachedInput: 30, Output: 60},
+		"gpt4":                       {Input: 30, CachedInput: 30, Output: 60},
+		"gpt-4-turbo":                {Input: 10, CachedInput: 10, Output: 30},
+		"gpt-3.5-turbo":              {Input: 0.5, CachedInput: 0.5, Output: 1.5},
+		"o1":                         {Input: 15, CachedInput: 7.5, Output: 60},
+		"o1-preview":                 {Input: 15, CachedInput: 7.5, Output: 60},
+		"o1-mini":                    {Input: 3, CachedInput: 1.5, Output: 12},
+		"claude-3-5-sonnet":          {Input: 3, CachedInput: 0.3, Output: 15},
+		"claude-3-5-sonnet-20240620": {Input: 3, CachedInput: 0.3, Output: 15},
+		"claude-3-5-sonnet-20241022": {Input: 3, CachedInput: 0.3, Output: 15},
+		"claude-3-5-sonnet-latest":   {Input: 3, CachedInput: 0.3, Output: 15},
+		"claude-3-5-haiku-20241022":  {Input: 0.8, CachedInput: 0.08, Output: 4},
+		"claude-3-opus-20240229":     {Input: 15, CachedInput: 1.5, Output: 75},
+		"claude-3-haiku-20240307":    {Input: 0.25, CachedInput: 0.03, Output: 1.25},
+		"gemini-1.5-pro":             {Input: 1.25, CachedInput: 0.3125, Output: 5},
+		"gemini-1.5-flash":           {Input: 0.075, CachedInput: 0.01875, Output: 0.3},
+		"gemini-2.0-flash":           {Input: 0.1, CachedInput: 0.025, Output: 0.4},
+	}
+)
+
+// GetPrice returns the price of a model. Litellm models can be prefixed
+// with their provider (e.g. azure/gpt-4o), the prefix is ignored.
+func GetPrice(model string) (Price, bool) {
+	if price, ok := prices[model]; ok {
+		return price, true
+	}
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		price, ok := prices[model[i+1:]]
+		return price, ok
+	}
+	return Price{}, false
+}
+
+// Cost returns the cost of the token usage in USD, and false if the price of the model is unknown
+func Cost(model string, tokenUsage *TokenUsage) (float64, bool) {
+	price, ok := GetPrice(model)
+	if !ok {
+		return 0, false
+	}
+
+	uncachedTokens := tokenUsage.PromptTokens - tokenUsage.CachedTokens
+	cost := float64(uncachedTokens)*price.Input +
+		float64(tokenUsage.CachedTokens)*price.CachedInput +
+		float64(tokenUsage.CompletionTokens)*price.Output
+	return cost / 1_000_000, true
+}
@@ -0,0 +1,74 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TokenUsage is the token count of an execution, normalised across providers.
+// PromptTokens includes the cached tokens.
+type TokenUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	CachedTokens     int
+}
+
+// providerUsage holds the fields of the openai, anthropic and gemini usage objects
+type providerUsage struct {
+	// openai
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+
+	// anthropic
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+
+	// gemini
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+}
+
+// ParseUsage reads the provider usage object saved in the metadata of an execution response message
+func ParseUsage(messageMetadata json.RawMessage) (*TokenUsage, error) {
+	var metadata struct {
+		Usage *providerUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(messageMetadata, &metadata); err != nil {
+		return nil, fmt.Errorf("error unmarshalling message metadata: %w", err)
+	}
+	if metadata.Usage == nil {
+		return nil, fmt.Errorf("message metadata does not contain usage")
+	}
+	u := metadata.Usage
+
+	// anthropic reports the cached tokens separately from the input tokens
+	if u.InputTokens > 0 || u.OutputTokens > 0 {
+		return &TokenUsage{
+			PromptTokens:     u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+			CompletionTokens: u.OutputTokens,
+			CachedTokens:     u.CacheReadInputTokens,
+		}, nil
+	}
+
+	// gemini includes the cached tokens in the prompt tokens, the thinking tokens are billed as output
+	if u.PromptTokenCount > 0 || u.CandidatesTokenCount > 0 {
+		return &TokenUsage{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			CachedTokens:     u.CachedContentTokenCount,
+		}, nil
+	}
+
+	return &TokenUsage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     u.PromptTokensDetails.CachedTokens,
+	}, nil
+}
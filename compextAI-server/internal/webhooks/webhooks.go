@@ -0,0 +1,226 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/burnerlee/compextAI/internal/env"
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/internal/safehttp"
+	"github.com/burnerlee/compextAI/internal/secrets"
+	"github.com/burnerlee/compextAI/internal/worker"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_CONCURRENCY           = 4
+	DEFAULT_MAX_ATTEMPTS          = 6
+	DEFAULT_TIMEOUT_SECONDS       = 10
+	DEFAULT_POLL_INTERVAL_SECONDS = 5
+	DEFAULT_INITIAL_BACKOFF       = 30 * time.Second
+	DEFAULT_MAX_BACKOFF           = time.Hour
+
+	// headers sent with every delivery
+	HEADER_EVENT     = "X-Compext-Event"
+	HEADER_DELIVERY  = "X-Compext-Delivery"
+	HEADER_TIMESTAMP = "X-Compext-Timestamp"
+	// hex encoded HMAC-SHA256 of "<timestamp>.<body>", prefixed with sha256=
+	HEADER_SIGNATURE = "X-Compext-Signature"
+
+	// number of bytes of the response body kept in the delivery log
+	MAX_LOGGED_RESPONSE_BYTES = 1024
+)
+
+var (
+	dispatcher *Dispatcher
+)
+
+type Config struct {
+	// number of deliveries sent at the same time by this process
+	Concurrency int
+	// number of POSTs made for a delivery before it is marked as failed
+	MaxAttempts int
+	Timeout     time.Duration
+	// how often idle workers look for due deliveries
+	PollInterval time.Duration
+}
+
+func ConfigFromEnv() *Config {
+	return &Config{
+		Concurrency:  env.Int("WEBHOOK_CONCURRENCY", DEFAULT_CONCURRENCY),
+		MaxAttempts:  env.Int("WEBHOOK_MAX_ATTEMPTS", DEFAULT_MAX_ATTEMPTS),
+		Timeout:      env.Seconds("WEBHOOK_TIMEOUT_SECONDS", DEFAULT_TIMEOUT_SECONDS),
+		PollInterval: env.Seconds("WEBHOOK_POLL_INTERVAL_SECONDS", DEFAULT_POLL_INTERVAL_SECONDS),
+	}
+}
+
+// Dispatcher sends the pending webhook deliveries stored in the webhook_deliveries table.
+// Failed deliveries are retried with an exponential backoff until MaxAttempts is reached.
+type Dispatcher struct {
+	db     *gorm.DB
+	config *Config
+	client *http.Client
+	pool   *worker.Pool
+}
+
+func Init(db *gorm.DB, config *Config) *Dispatcher {
+	dispatcher = &Dispatcher{
+		db:     db,
+		config: config,
+		// the urls are set by the users, the client does not connect to the private networks of the server
+		client: safehttp.NewClient(config.Timeout),
+	}
+	dispatcher.pool = worker.NewPool(config.Concurrency, config.PollInterval, dispatcher.deliverNext)
+	return dispatcher
+}
+
+// Notify wakes up an idle worker to send a newly created delivery.
+func Notify() {
+	if dispatcher == nil {
+		return
+	}
+	dispatcher.pool.Notify()
+}
+
+// NewSecret generates a secret to sign deliveries with
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return constants.WEBHOOK_SECRET_PREFIX + hex.EncodeToString(secret), nil
+}
+
+// Sign returns the signature of a delivery body sent at the given unix timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start starts the workers, they stop once the context is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.pool.Start(ctx)
+	logger.GetLogger().Infof("Webhook dispatcher started with %d workers", d.config.Concurrency)
+}
+
+// deliverNext claims and sends the next due delivery
+func (d *Dispatcher) deliverNext(ctx context.Context) bool {
+	// deliveries stay claimed for longer than a POST can take
+	delivery, err := models.ClaimNextWebhookDelivery(d.db, 2*d.config.Timeout)
+	if err != nil {
+		logger.GetLogger().Errorf("Error claiming webhook delivery: %v", err)
+		return false
+	}
+	if delivery == nil {
+		return false
+	}
+	d.deliver(ctx, delivery)
+	return true
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	attempt := &models.WebhookDeliveryAttempt{
+		Attempt:   delivery.AttemptCount + 1,
+		StartedAt: time.Now(),
+	}
+
+	secret, err := d.getSecret(delivery)
+	if err != nil {
+		// the webhook or the project is gone, there is nothing to retry
+		attempt.Error = fmt.Sprintf("error getting signing secret: %v", err)
+		d.record(delivery, attempt, false)
+		return
+	}
+
+	attempt.StatusCode, attempt.Response, err = d.post(ctx, delivery, secret)
+	attempt.Duration = time.Since(attempt.StartedAt).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+	} else if attempt.StatusCode < 200 || attempt.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected status code: %d", attempt.StatusCode)
+	}
+
+	d.record(delivery, attempt, attempt.Error != "" && attempt.Attempt < d.config.MaxAttempts)
+}
+
+func (d *Dispatcher) record(delivery *models.WebhookDelivery, attempt *models.WebhookDeliveryAttempt, retry bool) {
+	status := models.WebhookDeliveryStatus_SUCCEEDED
+	var nextAttemptAt *time.Time
+	if attempt.Error != "" {
+		status = models.WebhookDeliveryStatus_FAILED
+		if retry {
+			status = models.WebhookDeliveryStatus_PENDING
+			next := time.Now().Add(backoff(attempt.Attempt))
+			nextAttemptAt = &next
+		}
+		logger.GetLogger().Warnf("Webhook delivery failed: %s: attempt %d: %s", delivery.Identifier, attempt.Attempt, attempt.Error)
+	}
+
+	if err := models.RecordWebhookDeliveryAttempt(d.db, delivery.Identifier, attempt, status, nextAttemptAt); err != nil {
+		logger.GetLogger().Errorf("Error recording webhook delivery attempt: %s: %v", delivery.Identifier, err)
+	}
+}
+
+// getSecret returns the secret of the webhook, or of the project for callback url deliveries
+func (d *Dispatcher) getSecret(delivery *models.WebhookDelivery) (string, error) {
+	if delivery.WebhookID != "" {
+		webhook, err := models.GetWebhook(d.db, delivery.WebhookID)
+		if err != nil {
+			return "", err
+		}
+		return secrets.Decrypt(webhook.Secret)
+	}
+
+	project, err := models.GetProject(d.db, delivery.ProjectID)
+	if err != nil {
+		return "", err
+	}
+	if project.WebhookSecret == "" {
+		return "", fmt.Errorf("project %s has no webhook secret", delivery.ProjectID)
+	}
+	return secrets.Decrypt(project.WebhookSecret)
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery, secret string) (int, string, error) {
+	timestamp := time.Now().Unix()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return -1, "", fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "compextAI-webhooks")
+	request.Header.Set(HEADER_EVENT, delivery.Event)
+	request.Header.Set(HEADER_DELIVERY, delivery.Identifier)
+	request.Header.Set(HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HEADER_SIGNATURE, Sign(secret, timestamp, delivery.Payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return -1, "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, MAX_LOGGED_RESPONSE_BYTES))
+	return response.StatusCode, string(body), nil
+}
+
+// backoff returns the time to wait after the given attempt (starting at 1) failed
+func backoff(attempt int) time.Duration {
+	backoff := float64(DEFAULT_INITIAL_BACKOFF) * math.Pow(2, float64(attempt-1))
+	return time.Duration(math.Min(backoff, float64(DEFAULT_MAX_BACKOFF)))
+}
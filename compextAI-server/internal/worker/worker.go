@@ -0,0 +1,78 @@
+// Package worker runs the background workers which claim their jobs from the database:
+// the execution queue, the batch scheduler, the eval scorer and the webhook dispatcher.
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewID returns the id a process claims its leases with, unique across the processes and restarts
+func NewID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "compext"
+	}
+	return fmt.Sprintf("%s-%s", hostname, uuid.New().String())
+}
+
+// ClaimFunc claims and handles the next job. It returns false when there was no job to claim,
+// or claiming failed, so the worker goes back to sleep.
+type ClaimFunc func(ctx context.Context) bool
+
+// Pool runs workers which handle the jobs they can claim, then sleep until they are
+// notified or the poll interval elapses. Polling picks up the jobs created by the other
+// processes and the leases which expired.
+type Pool struct {
+	concurrency  int
+	pollInterval time.Duration
+	claim        ClaimFunc
+	wakeup       chan struct{}
+}
+
+func NewPool(concurrency int, pollInterval time.Duration, claim ClaimFunc) *Pool {
+	return &Pool{
+		concurrency:  concurrency,
+		pollInterval: pollInterval,
+		claim:        claim,
+		wakeup:       make(chan struct{}, concurrency),
+	}
+}
+
+// Notify wakes up an idle worker to claim a newly created job.
+func (p *Pool) Notify() {
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+		// all the workers are already awake
+	}
+}
+
+// Start starts the workers, they stop once the context is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	for i := 0; i < p.concurrency; i++ {
+		go p.work(ctx)
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// drain the jobs before going back to sleep
+		for ctx.Err() == nil && p.claim(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wakeup:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls the condition until it holds or the timeout elapses
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the workers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPool(t *testing.T) {
+	var jobs, claims, handled atomic.Int32
+	claim := func(ctx context.Context) bool {
+		claims.Add(1)
+		if jobs.Load() == 0 {
+			return false
+		}
+		jobs.Add(-1)
+		handled.Add(1)
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// the poll interval is long enough for the workers to only wake up when notified
+	pool := NewPool(2, time.Hour, claim)
+	pool.Start(ctx)
+
+	// the workers look for jobs as soon as they start
+	waitFor(t, func() bool { return claims.Load() == 2 })
+
+	// a notified worker drains the jobs before going back to sleep
+	jobs.Store(5)
+	pool.Notify()
+	waitFor(t, func() bool { return handled.Load() == 5 })
+
+	// notifying more than the idle workers does not block
+	for i := 0; i < 10; i++ {
+		pool.Notify()
+	}
+
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	stopped := claims.Load()
+	jobs.Store(1)
+	pool.Notify()
+	time.Sleep(10 * time.Millisecond)
+	if claims.Load() != stopped || handled.Load() != 5 {
+		t.Fatalf("expected the workers to stop once the context is cancelled, got %d claims", claims.Load()-stopped)
+	}
+}
+
+func TestPoolPolls(t *testing.T) {
+	var claims atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	NewPool(1, 5*time.Millisecond, func(ctx context.Context) bool {
+		claims.Add(1)
+		return false
+	}).Start(ctx)
+
+	// the jobs created by the other processes are picked up without a notification
+	waitFor(t, func() bool { return claims.Load() >= 3 })
+}
@@ -0,0 +1,441 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/burnerlee/compextAI/internal/logger"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// bodies larger than this are not inspected by the audit, the request is still recorded
+	AUDIT_MAX_BODY_SIZE = 1 << 20
+
+	AuditResource_THREAD                           = "thread"
+	AuditResource_THREAD_EXECUTION                 = "thread_execution"
+	AuditResource_MESSAGE                          = "message"
+	AuditResource_THREAD_EXECUTION_PARAMS          = "thread_execution_params"
+	AuditResource_THREAD_EXECUTION_PARAMS_TEMPLATE = "thread_execution_params_template"
+	AuditResource_PROJECT                          = "project"
+	AuditResource_PROJECT_BUDGET                   = "project_budget"
+	AuditResource_WEBHOOK                          = "webhook"
+	AuditResource_WEBHOOK_DELIVERY                 = "webhook_delivery"
+	AuditResource_PROVIDER_CREDENTIAL              = "provider_credential"
+	AuditResource_PROJECT_MEMBER                   = "project_member"
+	AuditResource_INVITATION                       = "invitation"
+	AuditResource_ORGANIZATION                     = "organization"
+	AuditResource_ORGANIZATION_MEMBER              = "organization_member"
+	AuditResource_API_TOKEN                        = "api_token"
+	AuditResource_USER                             = "user"
+	AuditResource_PROMOTION                        = "promotion"
+	AuditResource_PROMOTION_RULE                   = "promotion_rule"
+	AuditResource_PROJECT_TOOL                     = "project_tool"
+	AuditResource_BATCH                            = "batch"
+	AuditResource_DATASET                          = "dataset"
+	AuditResource_EVAL_RUN                         = "eval_run"
+)
+
+// auditRoute describes the resource a mutating route acts on. The resource is
+// identified by the idVar path variable, or by the response for the creations.
+// The action defaults to create, update or delete depending on the method.
+type auditRoute struct {
+	resourceType string
+	idVar        string
+	action       string
+}
+
+// keyed by the path template of the route, without the /api/v1 prefix
+var auditRoutes = map[string]auditRoute{
+	"/thread":                     {AuditResource_THREAD, "", ""},
+	"/thread/{id}":                {AuditResource_THREAD, "id", ""},
+	"/thread/{id}/execute":        {AuditResource_THREAD_EXECUTION, "", "execute"},
+	"/threadexec/{id}/rerun":      {AuditResource_THREAD_EXECUTION, "id", "rerun"},
+	"/threadexec/{id}/cancel":     {AuditResource_THREAD_EXECUTION, "id", "cancel"},
+	"/message/{id}":               {AuditResource_MESSAGE, "id", ""},
+	"/message/thread/{thread_id}": {AuditResource_MESSAGE, "", ""},
+	"/batch":                      {AuditResource_BATCH, "", ""},
+	"/batch/{id}/cancel":          {AuditResource_BATCH, "id", "cancel"},
+
+	"/user/password":                 {AuditResource_USER, "", ""},
+	"/user/api_keys":                 {AuditResource_USER, "", ""},
+	"/user/api_tokens":               {AuditResource_API_TOKEN, "", ""},
+	"/user/api_tokens/{id}":          {AuditResource_API_TOKEN, "id", ""},
+	"/user/invitations/{id}/accept":  {AuditResource_INVITATION, "id", "accept"},
+	"/user/invitations/{id}/decline": {AuditResource_INVITATION, "id", "decline"},
+
+	// the execution params are identified by the name, environment and project name of the body
+	"/execparams/create":  {AuditResource_THREAD_EXECUTION_PARAMS, "", ""},
+	"/execparams/update":  {AuditResource_THREAD_EXECUTION_PARAMS, "", ""},
+	"/execparams/delete":  {AuditResource_THREAD_EXECUTION_PARAMS, "", ""},
+	"/execparams/promote": {AuditResource_PROMOTION, "", "promote"},
+
+	"/execparamstemplate":               {AuditResource_THREAD_EXECUTION_PARAMS_TEMPLATE, "", ""},
+	"/execparamstemplate/{id}":          {AuditResource_THREAD_EXECUTION_PARAMS_TEMPLATE, "id", ""},
+	"/execparamstemplate/{id}/rollback": {AuditResource_THREAD_EXECUTION_PARAMS_TEMPLATE, "id", "rollback"},
+
+	"/project":                            {AuditResource_PROJECT, "", ""},
+	"/project/{id}":                       {AuditResource_PROJECT, "id", ""},
+	"/project/{id}/budgets":               {AuditResource_PROJECT_BUDGET, "", ""},
+	"/project/{id}/budgets/{budget_id}":   {AuditResource_PROJECT_BUDGET, "budget_id", ""},
+	"/project/{id}/webhooks":              {AuditResource_WEBHOOK, "", ""},
+	"/project/{id}/webhooks/{webhook_id}": {AuditResource_WEBHOOK, "webhook_id", ""},
+	"/project/{id}/webhooks/deliveries/{delivery_id}/replay": {AuditResource_WEBHOOK_DELIVERY, "delivery_id", "replay"},
+	"/project/{id}/tools":                                 {AuditResource_PROJECT_TOOL, "", ""},
+	"/project/{id}/tools/{tool_id}":                       {AuditResource_PROJECT_TOOL, "tool_id", ""},
+	"/project/{id}/datasets":                              {AuditResource_DATASET, "", ""},
+	"/project/{id}/datasets/{dataset_id}":                 {AuditResource_DATASET, "dataset_id", ""},
+	"/project/{id}/datasets/{dataset_id}/items":           {AuditResource_DATASET, "dataset_id", "add_items"},
+	"/project/{id}/datasets/{dataset_id}/items/{item_id}": {AuditResource_DATASET, "dataset_id", "delete_item"},
+	"/project/{id}/evals":                                 {AuditResource_EVAL_RUN, "", ""},
+	"/project/{id}/evals/{eval_id}/cancel":                {AuditResource_EVAL_RUN, "eval_id", "cancel"},
+	"/project/{id}/cache":                                 {AuditResource_PROJECT, "id", "invalidate_cache"},
+	"/project/{id}/credentials":                           {AuditResource_PROVIDER_CREDENTIAL, "", ""},
+	"/project/{id}/credentials/{credential_id}":           {AuditResource_PROVIDER_CREDENTIAL, "credential_id", ""},
+	"/project/{id}/members/{member_id}":                   {AuditResource_PROJECT_MEMBER, "member_id", ""},
+	"/project/{id}/invitations":                           {AuditResource_INVITATION, "", ""},
+	"/project/{id}/invitations/{invitation_id}":           {AuditResource_INVITATION, "invitation_id", ""},
+	"/project/{id}/promotions/{promotion_id}/approve":     {AuditResource_PROMOTION, "promotion_id", "approve"},
+	"/project/{id}/promotions/{promotion_id}/reject":      {AuditResource_PROMOTION, "promotion_id", "reject"},
+	"/project/{id}/promotion_rules":                       {AuditResource_PROMOTION_RULE, "", ""},
+
+	"/organization":                                  {AuditResource_ORGANIZATION, "", ""},
+	"/organization/{id}":                             {AuditResource_ORGANIZATION, "id", ""},
+	"/organization/{id}/members/{member_id}":         {AuditResource_ORGANIZATION_MEMBER, "member_id", ""},
+	"/organization/{id}/invitations":                 {AuditResource_INVITATION, "", ""},
+	"/organization/{id}/invitations/{invitation_id}": {AuditResource_INVITATION, "invitation_id", ""},
+}
+
+// fields which are never written to the audit log
+var auditRedactedFields = []string{"api_token", "secret", "webhook_secret", "key", "password", "new_password"}
+
+// AuditMiddleware records the successful mutating calls of the handler in the audit log,
+// with the changes made to the resource. It has to run after the AuthMiddleware.
+func AuditMiddleware(next http.HandlerFunc, db *gorm.DB) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := utils.GetUserIDFromRequest(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		routeTemplate := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			routeTemplate, _ = route.GetPathTemplate()
+		}
+		routeTemplate = strings.TrimPrefix(routeTemplate, "/api/v1")
+		spec, ok := auditRoutes[routeTemplate]
+		if !ok {
+			logger.GetLogger().Warnf("No audit resource for route %s %s", r.Method, routeTemplate)
+		}
+
+		body := readAuditBody(r)
+		vars := mux.Vars(r)
+
+		resourceID := resolveAuditResourceID(db, spec, vars, body, uint(userID))
+		before := loadAuditState(db, spec.resourceType, resourceID)
+
+		recorder := &auditResponseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode < 200 || recorder.statusCode >= 300 {
+			return
+		}
+
+		if resourceID == "" {
+			resourceID = getAuditResponseIdentifier(recorder.body.Bytes())
+		}
+		if resourceID == "" {
+			// the execution params created or updated from the body of the request
+			resourceID = resolveAuditResourceID(db, spec, vars, body, uint(userID))
+		}
+		after := loadAuditState(db, spec.resourceType, resourceID)
+
+		changes, err := json.Marshal(diffAuditStates(before, after))
+		if err != nil {
+			logger.GetLogger().Errorf("Error marshalling audit changes: %v", err)
+			changes = []byte("{}")
+		}
+
+		event := &models.AuditEvent{
+			ProjectID:    resolveAuditProjectID(db, routeTemplate, spec, vars, body, before, after, resourceID, uint(userID)),
+			ActorID:      uint(userID),
+			APITokenID:   r.Header.Get(API_TOKEN_ID_HEADER),
+			Action:       getAuditAction(r.Method, spec),
+			Method:       r.Method,
+			Route:        routeTemplate,
+			ResourceType: spec.resourceType,
+			ResourceID:   resourceID,
+			Changes:      changes,
+			RequestID:    r.Header.Get(REQUEST_ID_HEADER),
+			StatusCode:   recorder.statusCode,
+		}
+		if err := models.CreateAuditEvent(db, event); err != nil {
+			logger.GetLogger().Errorf("Error recording audit event for %s %s: %v", r.Method, routeTemplate, err)
+		}
+	})
+}
+
+// auditResponseRecorder keeps the status code and the beginning of the response body
+type auditResponseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *auditResponseRecorder) WriteHeader(statusCode int) {
+	if !rec.wroteHeader {
+		rec.statusCode = statusCode
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *auditResponseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	if remaining := AUDIT_MAX_BODY_SIZE - rec.body.Len(); remaining > 0 {
+		rec.body.Write(p[:min(len(p), remaining)])
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *auditResponseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// readAuditBody reads the request body and puts it back for the handler
+func readAuditBody(r *http.Request) map[string]interface{} {
+	if r.Body == nil {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, AUDIT_MAX_BODY_SIZE))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+	if err != nil {
+		return nil
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil
+	}
+	return body
+}
+
+func resolveAuditResourceID(db *gorm.DB, spec auditRoute, vars map[string]string, body map[string]interface{}, userID uint) string {
+	switch {
+	case spec.idVar != "":
+		return vars[spec.idVar]
+	case spec.resourceType == AuditResource_USER:
+		return strconv.FormatUint(uint64(userID), 10)
+	case spec.resourceType == AuditResource_THREAD_EXECUTION_PARAMS:
+		name, _ := body["name"].(string)
+		environment, _ := body["environment"].(string)
+		projectName, _ := body["project_name"].(string)
+		if name == "" || environment == "" || projectName == "" {
+			return ""
+		}
+		projectID, err := utils.GetProjectIDFromName(db, projectName, userID, models.Role_VIEWER)
+		if err != nil {
+			return ""
+		}
+		params, err := models.GetThreadExecutionParamsByNameAndEnvironment(db, name, environment, projectID)
+		if err != nil {
+			return ""
+		}
+		return params.Identifier
+	}
+	return ""
+}
+
+func newAuditModel(resourceType string) interface{} {
+	switch resourceType {
+	case AuditResource_THREAD:
+		return &models.Thread{}
+	case AuditResource_THREAD_EXECUTION:
+		return &models.ThreadExecution{}
+	case AuditResource_MESSAGE:
+		return &models.Message{}
+	case AuditResource_THREAD_EXECUTION_PARAMS:
+		return &models.ThreadExecutionParams{}
+	case AuditResource_THREAD_EXECUTION_PARAMS_TEMPLATE:
+		return &models.ThreadExecutionParamsTemplate{}
+	case AuditResource_PROJECT:
+		return &models.Project{}
+	case AuditResource_PROJECT_BUDGET:
+		return &models.ProjectBudget{}
+	case AuditResource_WEBHOOK:
+		return &models.Webhook{}
+	case AuditResource_WEBHOOK_DELIVERY:
+		return &models.WebhookDelivery{}
+	case AuditResource_PROVIDER_CREDENTIAL:
+		return &models.ProviderCredential{}
+	case AuditResource_PROJECT_MEMBER:
+		return &models.ProjectMember{}
+	case AuditResource_INVITATION:
+		return &models.Invitation{}
+	case AuditResource_ORGANIZATION:
+		return &models.Organization{}
+	case AuditResource_ORGANIZATION_MEMBER:
+		return &models.OrganizationMember{}
+	case AuditResource_API_TOKEN:
+		return &models.APIToken{}
+	case AuditResource_USER:
+		return &models.User{}
+	case AuditResource_PROMOTION:
+		return &models.Promotion{}
+	case AuditResource_PROMOTION_RULE:
+		return &models.PromotionRule{}
+	case AuditResource_PROJECT_TOOL:
+		return &models.ProjectTool{}
+	case AuditResource_BATCH:
+		return &models.Batch{}
+	case AuditResource_DATASET:
+		return &models.Dataset{}
+	case AuditResource_EVAL_RUN:
+		return &models.EvalRun{}
+	}
+	return nil
+}
+
+// loadAuditState returns the resource as it is serialized by the api, nil if it does not exist
+func loadAuditState(db *gorm.DB, resourceType, resourceID string) map[string]interface{} {
+	model := newAuditModel(resourceType)
+	if model == nil || resourceID == "" {
+		return nil
+	}
+
+	query := db.Where("identifier = ?", resourceID)
+	if resourceType == AuditResource_USER {
+		query = db.Where("id = ?", resourceID)
+	}
+	if err := query.First(model).Error; err != nil {
+		return nil
+	}
+	return getAuditState(model)
+}
+
+// getAuditState serializes the resource without the redacted fields
+func getAuditState(model interface{}) map[string]interface{} {
+	data, err := json.Marshal(model)
+	if err != nil {
+		return nil
+	}
+	var state map[string]interface{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	for _, field := range auditRedactedFields {
+		delete(state, field)
+	}
+	return state
+}
+
+type auditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+func diffAuditStates(before, after map[string]interface{}) map[string]auditChange {
+	changes := make(map[string]auditChange)
+	for field, value := range before {
+		if !reflect.DeepEqual(value, after[field]) {
+			changes[field] = auditChange{Before: value, After: after[field]}
+		}
+	}
+	for field, value := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = auditChange{Before: nil, After: value}
+		}
+	}
+	delete(changes, "updated_at")
+	return changes
+}
+
+// getAuditResponseIdentifier returns the identifier of the resource returned by the handler,
+// the identifiers are joined with commas when a list of resources is returned.
+func getAuditResponseIdentifier(body []byte) string {
+	var object struct {
+		Identifier string `json:"identifier"`
+	}
+	if err := json.Unmarshal(body, &object); err == nil {
+		return object.Identifier
+	}
+
+	var list []struct {
+		Identifier string `json:"identifier"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return ""
+	}
+	identifiers := make([]string, 0, len(list))
+	for _, item := range list {
+		if item.Identifier != "" {
+			identifiers = append(identifiers, item.Identifier)
+		}
+	}
+	return strings.Join(identifiers, ",")
+}
+
+func resolveAuditProjectID(db *gorm.DB, routeTemplate string, spec auditRoute, vars map[string]string, body, before, after map[string]interface{}, resourceID string, userID uint) string {
+	if spec.resourceType == AuditResource_PROJECT {
+		return resourceID
+	}
+	if strings.HasPrefix(routeTemplate, "/project/{id}") {
+		return vars["id"]
+	}
+
+	for _, state := range []map[string]interface{}{after, before} {
+		if projectID, _ := state["project_id"].(string); projectID != "" {
+			return projectID
+		}
+	}
+
+	// messages belong to the project of their thread
+	threadID := vars["thread_id"]
+	for _, state := range []map[string]interface{}{after, before} {
+		if id, _ := state["thread_id"].(string); id != "" && threadID == "" {
+			threadID = id
+		}
+	}
+	if threadID != "" {
+		if thread, _ := loadAuditState(db, AuditResource_THREAD, threadID)["project_id"].(string); thread != "" {
+			return thread
+		}
+	}
+
+	if projectName, _ := body["project_name"].(string); projectName != "" {
+		if projectID, err := utils.GetProjectIDFromName(db, projectName, userID, models.Role_VIEWER); err == nil {
+			return projectID
+		}
+	}
+	return ""
+}
+
+func getAuditAction(method string, spec auditRoute) string {
+	if spec.action != "" {
+		return spec.action
+	}
+	switch method {
+	case http.MethodPost:
+		return models.AuditAction_CREATE
+	case http.MethodDelete:
+		return models.AuditAction_DELETE
+	}
+	return models.AuditAction_UPDATE
+}
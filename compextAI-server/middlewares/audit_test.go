@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/burnerlee/compextAI/models"
+)
+
+func TestGetAuditStateRedactsSecrets(t *testing.T) {
+	// a resource serialized with every redacted field
+	resource := struct {
+		Identifier    string `json:"identifier"`
+		Name          string `json:"name"`
+		APIToken      string `json:"api_token"`
+		Secret        string `json:"secret"`
+		WebhookSecret string `json:"webhook_secret"`
+		Key           string `json:"key"`
+		Password      string `json:"password"`
+		NewPassword   string `json:"new_password"`
+	}{"resource_1", "name", "token", "secret", "webhook-secret", "sk-key", "password", "new-password"}
+
+	state := getAuditState(resource)
+	for _, field := range auditRedactedFields {
+		if value, ok := state[field]; ok {
+			t.Errorf("expected the %s field to be redacted, got %v", field, value)
+		}
+	}
+	if state["identifier"] != "resource_1" || state["name"] != "name" {
+		t.Fatalf("expected the other fields to be kept, got %v", state)
+	}
+
+	for _, field := range []string{"api_token", "secret", "webhook_secret", "key", "password", "new_password"} {
+		if !slices.Contains(auditRedactedFields, field) {
+			t.Errorf("expected the %s field to be redacted", field)
+		}
+	}
+
+	// the secrets of the models are not serialized in the first place
+	credential := getAuditState(&models.ProviderCredential{Provider: models.CredentialProvider_OPENAI, Key: "sk-key"})
+	for field, value := range credential {
+		if value == "sk-key" {
+			t.Errorf("expected the key of the credential not to be in the state, got it in %s", field)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/burnerlee/compextAI/utils/responses"
+)
+
+const (
+	// requests allowed per client on the login routes in a window
+	LOGIN_RATE_LIMIT        = 10
+	LOGIN_RATE_LIMIT_WINDOW = time.Minute
+)
+
+// RateLimiter allows a fixed number of requests per client address in a time window.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*rateLimitWindow
+}
+
+type rateLimitWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*rateLimitWindow),
+	}
+}
+
+// Allow records a request of the client and reports whether it is within the limit.
+func (l *RateLimiter) Allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// drop the expired windows so the map does not grow with every client seen
+	for key, w := range l.clients {
+		if now.Sub(w.start) >= l.window {
+			delete(l.clients, key)
+		}
+	}
+
+	w, ok := l.clients[client]
+	if !ok {
+		w = &rateLimitWindow{start: now}
+		l.clients[client] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+func RateLimitMiddleware(next http.HandlerFunc, limiter *RateLimiter) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow(getClientAddress(r)) {
+			responses.Error(w, http.StatusTooManyRequests, "Too many requests, try again later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getClientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
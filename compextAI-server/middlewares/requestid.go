@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+)
+
+// request ids sent by the clients are reused only when they are reasonably short and printable
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware makes sure every request carries a request id. The id sent by the
+// client is kept, otherwise a new one is generated. It is echoed in the response headers.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		r.Header.Set(REQUEST_ID_HEADER, requestID)
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,15 +1,28 @@
 package middlewares
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
+	"github.com/burnerlee/compextAI/internal/logger"
 	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
 	"github.com/burnerlee/compextAI/utils/responses"
+	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
+const (
+	// set on the requests authenticated with an api token
+	API_TOKEN_ID_HEADER = "X-API-Token-ID"
+)
+
 func AuthMiddleware(next http.HandlerFunc, db *gorm.DB) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
@@ -19,14 +32,143 @@ func AuthMiddleware(next http.HandlerFunc, db *gorm.DB) http.HandlerFunc {
 		}
 
 		token = strings.TrimPrefix(token, "Bearer ")
+		r.Header.Del(API_TOKEN_ID_HEADER)
 
-		userID, err := models.GetUserIDByAPIToken(db, token)
+		prefix, ok := utils.ParseAPIToken(token)
+		if !ok {
+			// the tokens of the users created before the api tokens are stored as admin api tokens
+			prefix = utils.LegacyAPITokenPrefix(token)
+		}
+		apiToken, status, err := authenticateAPIToken(db, r, token, prefix)
 		if err != nil {
-			responses.Error(w, http.StatusUnauthorized, "Authenticated token is invalid")
+			responses.Error(w, status, err.Error())
 			return
 		}
-
-		r.Header.Set("X-User-ID", fmt.Sprintf("%d", userID))
+		r.Header.Set("X-User-ID", fmt.Sprintf("%d", apiToken.UserID))
+		r.Header.Set(API_TOKEN_ID_HEADER, apiToken.Identifier)
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticateAPIToken verifies an api token and that its scopes and project restriction allow the request.
+// The status code to respond with is returned along with the error.
+func authenticateAPIToken(db *gorm.DB, r *http.Request, token, prefix string) (*models.APIToken, int, error) {
+	apiToken, err := models.GetAPITokenByPrefix(db, prefix)
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("Authenticated token is invalid")
+	}
+	if subtle.ConstantTimeCompare([]byte(apiToken.HashedSecret), []byte(utils.HashAPIToken(token))) != 1 {
+		return nil, http.StatusUnauthorized, errors.New("Authenticated token is invalid")
+	}
+	if !apiToken.IsActive() {
+		return nil, http.StatusUnauthorized, errors.New("Authenticated token is expired or revoked")
+	}
+
+	if scope := getRequiredScope(r); !apiToken.HasScope(scope) {
+		return nil, http.StatusForbidden, fmt.Errorf("the token does not have the %s scope required for this request", scope)
+	}
+
+	if apiToken.ProjectID != "" {
+		projectID, err := getRequestProjectID(db, r, apiToken.UserID)
+		if err != nil {
+			return nil, http.StatusForbidden, fmt.Errorf("the token is restricted to a project: %v", err)
+		}
+		if projectID != apiToken.ProjectID {
+			return nil, http.StatusForbidden, errors.New("the token is restricted to another project")
+		}
+	}
+
+	if err := models.TouchAPIToken(db, apiToken); err != nil {
+		logger.GetLogger().Errorf("Error updating api token last used: %s: %v", apiToken.Identifier, err)
+	}
+	return apiToken, http.StatusOK, nil
+}
+
+// getRequiredScope returns the scope an api token needs for the request.
+// Reads need the read only scope, running executions and editing threads and
+// messages need the execute scope and everything else needs the admin scope.
+func getRequiredScope(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+
+	// the user routes manage credentials
+	if strings.HasPrefix(path, "/user/") {
+		return models.APITokenScope_ADMIN
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return models.APITokenScope_READ_ONLY
+	}
+
+	// covers the /thread, /threadexec and /message routes
+	if strings.HasPrefix(path, "/thread") || strings.HasPrefix(path, "/message/") {
+		return models.APITokenScope_EXECUTE
+	}
+	return models.APITokenScope_ADMIN
+}
+
+// getRequestProjectID finds the project a request is about, from its route variables or its body
+func getRequestProjectID(db *gorm.DB, r *http.Request, userID uint) (string, error) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	vars := mux.Vars(r)
+
+	if projectName, ok := vars["projectname"]; ok {
+		return utils.GetProjectIDFromName(db, projectName, userID, models.Role_VIEWER)
+	}
+
+	if threadID, ok := vars["thread_id"]; ok {
+		thread, err := models.GetThread(db, threadID)
+		if err != nil {
+			return "", err
+		}
+		return thread.ProjectID, nil
+	}
+
+	if id, ok := vars["id"]; ok {
+		switch {
+		case strings.HasPrefix(path, "/project/"):
+			return id, nil
+		case strings.HasPrefix(path, "/threadexec/"):
+			threadExecution, err := models.GetThreadExecutionByID(db, id)
+			if err != nil {
+				return "", err
+			}
+			return threadExecution.ProjectID, nil
+		case strings.HasPrefix(path, "/thread/"):
+			thread, err := models.GetThread(db, id)
+			if err != nil {
+				return "", err
+			}
+			return thread.ProjectID, nil
+		case strings.HasPrefix(path, "/message/"):
+			message, err := models.GetMessage(db, id)
+			if err != nil {
+				return "", err
+			}
+			return message.Thread.ProjectID, nil
+		case strings.HasPrefix(path, "/execparamstemplate/"):
+			template, err := models.GetThreadExecutionParamsTemplateByID(db, id)
+			if err != nil {
+				return "", err
+			}
+			return template.ProjectID, nil
+		}
+	}
+
+	// the other routes name the project in their body
+	if r.Body != nil && r.Method != http.MethodGet {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var projectBody struct {
+			ProjectName string `json:"project_name"`
+		}
+		if err := json.Unmarshal(body, &projectBody); err == nil && projectBody.ProjectName != "" {
+			return utils.GetProjectIDFromName(db, projectBody.ProjectName, userID, models.Role_VIEWER)
+		}
+	}
+
+	return "", errors.New("the project of the request could not be determined")
+}
@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/testdb"
+	"github.com/burnerlee/compextAI/models"
+	"github.com/burnerlee/compextAI/utils"
+	"github.com/gorilla/mux"
+)
+
+func TestGetRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{http.MethodGet, "/api/v1/thread/thread_1", models.APITokenScope_READ_ONLY},
+		{http.MethodHead, "/api/v1/project/project_1", models.APITokenScope_READ_ONLY},
+		{http.MethodPost, "/api/v1/thread", models.APITokenScope_EXECUTE},
+		{http.MethodPost, "/api/v1/thread/thread_1/execute", models.APITokenScope_EXECUTE},
+		{http.MethodPost, "/api/v1/threadexec/exec_1/cancel", models.APITokenScope_EXECUTE},
+		{http.MethodPut, "/api/v1/message/message_1", models.APITokenScope_EXECUTE},
+		{http.MethodPost, "/api/v1/project", models.APITokenScope_ADMIN},
+		{http.MethodPut, "/api/v1/execparamstemplate/template_1", models.APITokenScope_ADMIN},
+		// the user routes manage credentials, even to read them
+		{http.MethodGet, "/api/v1/user/api_tokens", models.APITokenScope_ADMIN},
+		{http.MethodPost, "/api/v1/user/api_tokens", models.APITokenScope_ADMIN},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if scope := getRequiredScope(r); scope != tt.scope {
+			t.Errorf("%s %s: expected the %s scope, got %s", tt.method, tt.path, tt.scope, scope)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	db := testdb.Open(t, &models.APIToken{})
+	const userID = 7
+
+	createToken := func(apiToken *models.APIToken) string {
+		t.Helper()
+		token, prefix, err := utils.GenerateAPIToken()
+		if err != nil {
+			t.Fatalf("error generating the token: %v", err)
+		}
+		apiToken.UserID = userID
+		apiToken.Prefix = prefix
+		apiToken.HashedSecret = utils.HashAPIToken(token)
+		if err := models.CreateAPIToken(db, apiToken); err != nil {
+			t.Fatalf("error creating the token: %v", err)
+		}
+		return token
+	}
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	adminToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_ADMIN}})
+	readOnlyToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_READ_ONLY}})
+	executeToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_EXECUTE}})
+	revokedToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_ADMIN}, RevokedAt: &past})
+	expiredToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_ADMIN}, ExpiresAt: &past})
+	unexpiredToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_ADMIN}, ExpiresAt: &future})
+	projectToken := createToken(&models.APIToken{Scopes: models.StringList{models.APITokenScope_ADMIN}, ProjectID: "project_a"})
+
+	legacyToken := "legacy-user-token"
+	if err := models.CreateAPIToken(db, &models.APIToken{
+		UserID:       userID,
+		Prefix:       utils.LegacyAPITokenPrefix(legacyToken),
+		HashedSecret: utils.HashAPIToken(legacyToken),
+		Scopes:       models.StringList{models.APITokenScope_ADMIN},
+	}); err != nil {
+		t.Fatalf("error creating the legacy token: %v", err)
+	}
+
+	// a token with the prefix of a valid token and another secret
+	prefix, _ := utils.ParseAPIToken(adminToken)
+	forgedToken := "cpx_" + prefix + "_forged"
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-User-ID") != "7" || r.Header.Get(API_TOKEN_ID_HEADER) == "" {
+			t.Errorf("expected the user and the token to be set on the request, got %q, %q",
+				r.Header.Get("X-User-ID"), r.Header.Get(API_TOKEN_ID_HEADER))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/project/{id}", AuthMiddleware(ok, db)).Methods("GET", "PUT")
+	router.HandleFunc("/api/v1/thread", AuthMiddleware(ok, db)).Methods("POST")
+	router.HandleFunc("/api/v1/user/api_tokens", AuthMiddleware(ok, db)).Methods("GET")
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		status int
+	}{
+		{"missing token", "", http.MethodGet, "/api/v1/project/project_a", http.StatusUnauthorized},
+		{"unknown token", "cpx_unknown_secret", http.MethodGet, "/api/v1/project/project_a", http.StatusUnauthorized},
+		{"wrong secret", forgedToken, http.MethodGet, "/api/v1/project/project_a", http.StatusUnauthorized},
+		{"revoked token", revokedToken, http.MethodGet, "/api/v1/project/project_a", http.StatusUnauthorized},
+		{"expired token", expiredToken, http.MethodGet, "/api/v1/project/project_a", http.StatusUnauthorized},
+		{"unexpired token", unexpiredToken, http.MethodGet, "/api/v1/project/project_a", http.StatusOK},
+		{"admin token", adminToken, http.MethodPut, "/api/v1/project/project_a", http.StatusOK},
+		{"read only token reading", readOnlyToken, http.MethodGet, "/api/v1/project/project_a", http.StatusOK},
+		{"read only token writing", readOnlyToken, http.MethodPut, "/api/v1/project/project_a", http.StatusForbidden},
+		{"read only token executing", readOnlyToken, http.MethodPost, "/api/v1/thread", http.StatusForbidden},
+		{"execute token executing", executeToken, http.MethodPost, "/api/v1/thread", http.StatusOK},
+		{"execute token editing a project", executeToken, http.MethodPut, "/api/v1/project/project_a", http.StatusForbidden},
+		{"execute token listing the tokens", executeToken, http.MethodGet, "/api/v1/user/api_tokens", http.StatusForbidden},
+		{"project token on its project", projectToken, http.MethodGet, "/api/v1/project/project_a", http.StatusOK},
+		{"project token on another project", projectToken, http.MethodGet, "/api/v1/project/project_b", http.StatusForbidden},
+		{"project token without a project", projectToken, http.MethodGet, "/api/v1/user/api_tokens", http.StatusForbidden},
+		{"legacy token", legacyToken, http.MethodGet, "/api/v1/project/project_a", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}"))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			// set by the client, it must not be trusted
+			r.Header.Set(API_TOKEN_ID_HEADER, "spoofed")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	APITokenScope_READ_ONLY = "read_only"
+	APITokenScope_EXECUTE   = "execute"
+	APITokenScope_ADMIN     = "admin"
+
+	// last used timestamps are only written once per interval, to avoid a write per request
+	API_TOKEN_LAST_USED_RESOLUTION = time.Minute
+)
+
+// APIToken is a named api key of a user. Only a hash of the secret is stored,
+// the prefix identifies the token without revealing it.
+type APIToken struct {
+	Base
+	UserID       uint       `json:"user_id" gorm:"index"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix" gorm:"uniqueIndex"`
+	HashedSecret string     `json:"-"`
+	Scopes       StringList `json:"scopes" gorm:"type:jsonb"`
+	// restricts the token to a single project, if set
+	ProjectID  string     `json:"project_id"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	// issued by a login, they are revoked when the password of the user changes
+	Login bool `json:"login"`
+}
+
+// HasScope reports if the token grants the scope.
+// Scopes are hierarchical: admin grants execute, which grants read only.
+func (t *APIToken) HasScope(scope string) bool {
+	switch scope {
+	case APITokenScope_READ_ONLY:
+		return slices.Contains(t.Scopes, APITokenScope_READ_ONLY) || t.HasScope(APITokenScope_EXECUTE)
+	case APITokenScope_EXECUTE:
+		return slices.Contains(t.Scopes, APITokenScope_EXECUTE) || t.HasScope(APITokenScope_ADMIN)
+	default:
+		return slices.Contains(t.Scopes, scope)
+	}
+}
+
+// IsActive reports if the token can be used to authenticate
+func (t *APIToken) IsActive() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || t.ExpiresAt.After(time.Now())
+}
+
+func CreateAPIToken(db *gorm.DB, apiToken *APIToken) error {
+	apiTokenID := uuid.New().String()
+	apiToken.Identifier = fmt.Sprintf("%s%s", constants.API_TOKEN_ID_PREFIX, apiTokenID)
+	return db.Create(apiToken).Error
+}
+
+func GetAPIToken(db *gorm.DB, apiTokenID string) (*APIToken, error) {
+	var apiToken APIToken
+	if err := db.First(&apiToken, "identifier = ?", apiTokenID).Error; err != nil {
+		return nil, err
+	}
+	return &apiToken, nil
+}
+
+func GetAPITokenByPrefix(db *gorm.DB, prefix string) (*APIToken, error) {
+	var apiToken APIToken
+	if err := db.First(&apiToken, "prefix = ?", prefix).Error; err != nil {
+		return nil, err
+	}
+	return &apiToken, nil
+}
+
+func GetUserAPITokens(db *gorm.DB, userID uint) ([]APIToken, error) {
+	var apiTokens []APIToken
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&apiTokens).Error; err != nil {
+		return nil, err
+	}
+	return apiTokens, nil
+}
+
+func RevokeAPIToken(db *gorm.DB, apiTokenID string) error {
+	return db.Model(&APIToken{}).
+		Where("identifier = ? AND revoked_at IS NULL", apiTokenID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserLoginAPITokens revokes the tokens issued by the logins of the user
+func RevokeUserLoginAPITokens(db *gorm.DB, userID uint) error {
+	return db.Model(&APIToken{}).
+		Where("user_id = ? AND login = ? AND revoked_at IS NULL", userID, true).
+		Update("revoked_at", time.Now()).Error
+}
+
+// TouchAPIToken records that the token was used
+func TouchAPIToken(db *gorm.DB, apiToken *APIToken) error {
+	now := time.Now()
+	if apiToken.LastUsedAt != nil && now.Sub(*apiToken.LastUsedAt) < API_TOKEN_LAST_USED_RESOLUTION {
+		return nil
+	}
+	return db.Model(&APIToken{}).Where("id = ?", apiToken.ID).Update("last_used_at", now).Error
+}
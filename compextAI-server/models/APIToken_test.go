@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPITokenHasScope(t *testing.T) {
+	tests := []struct {
+		scopes StringList
+		scope  string
+		has    bool
+	}{
+		{StringList{APITokenScope_ADMIN}, APITokenScope_ADMIN, true},
+		{StringList{APITokenScope_ADMIN}, APITokenScope_EXECUTE, true},
+		{StringList{APITokenScope_ADMIN}, APITokenScope_READ_ONLY, true},
+		{StringList{APITokenScope_EXECUTE}, APITokenScope_ADMIN, false},
+		{StringList{APITokenScope_EXECUTE}, APITokenScope_EXECUTE, true},
+		{StringList{APITokenScope_EXECUTE}, APITokenScope_READ_ONLY, true},
+		{StringList{APITokenScope_READ_ONLY}, APITokenScope_ADMIN, false},
+		{StringList{APITokenScope_READ_ONLY}, APITokenScope_EXECUTE, false},
+		{StringList{APITokenScope_READ_ONLY}, APITokenScope_READ_ONLY, true},
+		{StringList{}, APITokenScope_READ_ONLY, false},
+		{StringList{APITokenScope_ADMIN}, "unknown", false},
+	}
+	for _, tt := range tests {
+		apiToken := &APIToken{Scopes: tt.scopes}
+		if has := apiToken.HasScope(tt.scope); has != tt.has {
+			t.Errorf("scopes %v, scope %s: expected %t, got %t", tt.scopes, tt.scope, tt.has, has)
+		}
+	}
+}
+
+func TestAPITokenIsActive(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		apiToken *APIToken
+		active   bool
+	}{
+		{"no expiry", &APIToken{}, true},
+		{"unexpired", &APIToken{ExpiresAt: &future}, true},
+		{"expired", &APIToken{ExpiresAt: &past}, false},
+		{"revoked", &APIToken{RevokedAt: &past}, false},
+		{"revoked before its expiry", &APIToken{ExpiresAt: &future, RevokedAt: &past}, false},
+	}
+	for _, tt := range tests {
+		if active := tt.apiToken.IsActive(); active != tt.active {
+			t.Errorf("%s: expected %t, got %t", tt.name, tt.active, active)
+		}
+	}
+}
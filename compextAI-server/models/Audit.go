@@ -0,0 +1,100 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditAction_CREATE = "create"
+	AuditAction_UPDATE = "update"
+	AuditAction_DELETE = "delete"
+)
+
+// AuditEvent records a mutating api call. Audit events are append only, they have
+// no updated_at or deleted_at and a trigger rejects updates and deletes of the table.
+type AuditEvent struct {
+	ID         uint      `json:"-" gorm:"primary_key"`
+	Identifier string    `json:"identifier" gorm:"unique"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+	// empty for the calls which are not about a project, like the user routes
+	ProjectID string `json:"project_id" gorm:"index"`
+	ActorID   uint   `json:"actor_id" gorm:"index"`
+	// set when the call was authenticated with an api token
+	APITokenID   string `json:"api_token_id"`
+	Action       string `json:"action"`
+	Method       string `json:"method"`
+	Route        string `json:"route"`
+	ResourceType string `json:"resource_type" gorm:"index"`
+	ResourceID   string `json:"resource_id" gorm:"index"`
+	// changed fields of the resource, {"<field>": {"before": ..., "after": ...}}
+	Changes    json.RawMessage `json:"changes" gorm:"type:jsonb"`
+	RequestID  string          `json:"request_id" gorm:"index"`
+	StatusCode int             `json:"status_code"`
+}
+
+func CreateAuditEvent(db *gorm.DB, event *AuditEvent) error {
+	eventID := uuid.New().String()
+	event.Identifier = fmt.Sprintf("%s%s", constants.AUDIT_EVENT_ID_PREFIX, eventID)
+	return db.Create(event).Error
+}
+
+// GetProjectAuditEvents returns the audit events of the project, newest first.
+// from and to are ignored when they are zero.
+func GetProjectAuditEvents(db *gorm.DB, projectID string, searchFiltersMap map[string]string, from, to time.Time, page, limit int) ([]AuditEvent, int64, error) {
+	offset := (page - 1) * limit
+	var total int64
+
+	query := db.Model(&AuditEvent{}).Where("project_id = ?", projectID)
+
+	allowedFilters := []string{"actor_id", "api_token_id", "action", "method", "route", "resource_type", "resource_id", "request_id"}
+	for key, value := range searchFiltersMap {
+		if slices.Contains(allowedFilters, key) {
+			query = query.Where(fmt.Sprintf("%s = ?", key), value)
+		}
+	}
+	if !from.IsZero() {
+		query = query.Where("created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("created_at < ?", to)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []AuditEvent
+	if err := query.Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// EnsureAuditEventsAppendOnly installs the trigger rejecting updates and deletes of the audit events.
+func EnsureAuditEventsAppendOnly(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append only';
+END;
+$$ LANGUAGE plpgsql`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`).Error
+	})
+}
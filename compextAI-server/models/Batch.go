@@ -0,0 +1,340 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	BatchStatus_IN_PROGRESS = "in_progress"
+	BatchStatus_COMPLETED   = "completed"
+	BatchStatus_CANCELLED   = "cancelled"
+
+	// status of the items which have not been submitted, see BatchResult
+	BatchItemStatus_PENDING = "pending"
+
+	// number of items created per insert
+	batchItemsInsertSize = 500
+)
+
+// Batch executes many threads or message sets with the same execution params. Its items are
+// submitted as thread executions by the batch scheduler, with at most Concurrency of them
+// queued or running at the same time.
+type Batch struct {
+	Base
+	UserID                  uint   `json:"user_id"`
+	ProjectID               string `json:"project_id" gorm:"index"`
+	ThreadExecutionParamsID string `json:"thread_execution_params_id"`
+	// template the items are executed with instead of the execution params, set for the eval runs
+	TemplateID string `json:"template_id"`
+	// eval run the batch executes a template of, see EvalRun
+	EvalRunID   string `json:"eval_run_id" gorm:"index"`
+	Status      string `json:"status" gorm:"index"`
+	Concurrency int    `json:"concurrency"`
+	Total       int    `json:"total"`
+	// execution options shared by the items, see controllers.BatchOptions
+	Options json.RawMessage `json:"options" gorm:"type:jsonb;default:'{}'"`
+	// set once every item is finished or the batch is cancelled
+	FinishedAt *time.Time `json:"finished_at"`
+
+	// the scheduler holding the lease is the only one submitting the items of the batch
+	LeaseOwner     string     `json:"-"`
+	LeaseExpiresAt *time.Time `json:"-"`
+}
+
+// BatchItem is a thread or an inline message set of a batch
+type BatchItem struct {
+	ID      uint   `json:"-" gorm:"primary_key"`
+	BatchID string `json:"batch_id" gorm:"uniqueIndex:idx_batch_item"`
+	Index   int    `json:"index" gorm:"uniqueIndex:idx_batch_item"`
+	// thread executed by the item, empty for the inline message sets
+	ThreadID string          `json:"thread_id"`
+	Messages json.RawMessage `json:"messages" gorm:"type:jsonb"`
+	// execution of the item, empty until it is submitted
+	ThreadExecutionID string `json:"thread_execution_id" gorm:"index"`
+	// set when the execution of the item could not be created
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BatchProgress counts the items of a batch by the status of their execution
+type BatchProgress struct {
+	Total int64 `json:"total"`
+	// items not submitted yet
+	Pending    int64 `json:"pending"`
+	Queued     int64 `json:"queued"`
+	InProgress int64 `json:"in_progress"`
+	Completed  int64 `json:"completed"`
+	// items whose execution failed or could not be created
+	Failed           int64   `json:"failed"`
+	Cancelled        int64   `json:"cancelled"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Finished reports whether no item of the batch is pending, queued or running
+func (p *BatchProgress) Finished() bool {
+	return p.Pending == 0 && p.Queued == 0 && p.InProgress == 0
+}
+
+// BatchResult is an item of a batch with the result of its execution
+type BatchResult struct {
+	Index             int             `json:"index"`
+	ThreadID          string          `json:"thread_id,omitempty"`
+	ThreadExecutionID string          `json:"thread_execution_id,omitempty"`
+	Status            string          `json:"status"`
+	Content           string          `json:"content,omitempty"`
+	ParsedOutput      json.RawMessage `json:"parsed_output,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	PromptTokens      int             `json:"prompt_tokens"`
+	CompletionTokens  int             `json:"completion_tokens"`
+	Cost              float64         `json:"cost"`
+}
+
+const batchProgressColumns = "COUNT(*) AS total, " +
+	"COUNT(*) FILTER (WHERE batch_items.thread_execution_id = '' AND batch_items.error = '') AS pending, " +
+	"COUNT(*) FILTER (WHERE thread_executions.status = ?) AS queued, " +
+	"COUNT(*) FILTER (WHERE thread_executions.status = ?) AS in_progress, " +
+	"COUNT(*) FILTER (WHERE thread_executions.status = ?) AS completed, " +
+	"COUNT(*) FILTER (WHERE thread_executions.status = ? OR batch_items.error <> '') AS failed, " +
+	"COUNT(*) FILTER (WHERE thread_executions.status = ?) AS cancelled, " +
+	"COALESCE(SUM(thread_executions.prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(thread_executions.completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(thread_executions.cost), 0) AS cost"
+
+const batchItemsExecutionsJoin = "LEFT JOIN thread_executions ON thread_executions.identifier = batch_items.thread_execution_id AND batch_items.thread_execution_id <> ''"
+
+// CreateBatch creates the batch with its items, the items are numbered in order
+func CreateBatch(db *gorm.DB, batch *Batch, items []*BatchItem) (*Batch, error) {
+	batch.Identifier = fmt.Sprintf("%s%s", constants.BATCH_ID_PREFIX, uuid.New().String())
+	batch.Status = BatchStatus_IN_PROGRESS
+	batch.Total = len(items)
+	for i, item := range items {
+		item.BatchID = batch.Identifier
+		item.Index = i
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(items, batchItemsInsertSize).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func GetBatch(db *gorm.DB, batchID string) (*Batch, error) {
+	var batch Batch
+	if err := db.Where("identifier = ?", batchID).First(&batch).Error; err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func GetProjectBatches(db *gorm.DB, projectID string) ([]Batch, error) {
+	var batches []Batch
+	if err := db.Where("project_id = ?", projectID).Order("created_at DESC").Find(&batches).Error; err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// GetActiveBatchIDs returns the batches which still have items to submit or to wait for
+func GetActiveBatchIDs(db *gorm.DB) ([]string, error) {
+	var batchIDs []string
+	if err := db.Model(&Batch{}).Where("status = ?", BatchStatus_IN_PROGRESS).Order("created_at ASC").Pluck("identifier", &batchIDs).Error; err != nil {
+		return nil, err
+	}
+	return batchIDs, nil
+}
+
+// ClaimBatchLease leases an active batch to a scheduler, it returns nil if the batch is finished
+// or leased to another scheduler
+func ClaimBatchLease(db *gorm.DB, batchID, leaseOwner string, leaseDuration time.Duration) (*Batch, error) {
+	now := time.Now()
+	result := db.Model(&Batch{}).
+		Where("identifier = ? AND status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ? OR lease_owner = ?)", batchID, BatchStatus_IN_PROGRESS, now, leaseOwner).
+		Updates(map[string]interface{}{
+			"lease_owner":      leaseOwner,
+			"lease_expires_at": now.Add(leaseDuration),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return GetBatch(db, batchID)
+}
+
+func ReleaseBatchLease(db *gorm.DB, batchID, leaseOwner string) error {
+	return db.Model(&Batch{}).Where("identifier = ? AND lease_owner = ?", batchID, leaseOwner).
+		Updates(map[string]interface{}{
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}).Error
+}
+
+// FinishBatch moves an active batch to the given status, it returns false if the batch was already finished
+func FinishBatch(db *gorm.DB, batchID, status string) (bool, error) {
+	result := db.Model(&Batch{}).Where("identifier = ? AND status = ?", batchID, BatchStatus_IN_PROGRESS).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetBatchProgress(db *gorm.DB, batchID string) (*BatchProgress, error) {
+	var progress BatchProgress
+	if err := db.Table("batch_items").
+		Select(batchProgressColumns,
+			ThreadExecutionStatus_QUEUED, ThreadExecutionStatus_IN_PROGRESS, ThreadExecutionStatus_COMPLETED,
+			ThreadExecutionStatus_FAILED, ThreadExecutionStatus_CANCELLED).
+		Joins(batchItemsExecutionsJoin).
+		Where("batch_items.batch_id = ?", batchID).
+		Scan(&progress).Error; err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// CountActiveBatchItems counts the items of the batch whose execution is queued or running
+func CountActiveBatchItems(db *gorm.DB, batchID string) (int64, error) {
+	var count int64
+	if err := db.Table("batch_items").
+		Joins(batchItemsExecutionsJoin).
+		Where("batch_items.batch_id = ? AND thread_executions.status IN ?", batchID,
+			[]string{ThreadExecutionStatus_QUEUED, ThreadExecutionStatus_IN_PROGRESS}).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func GetBatchItem(db *gorm.DB, batchID string, index int) (*BatchItem, error) {
+	var item BatchItem
+	if err := db.Where("batch_id = ? AND index = ?", batchID, index).First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// GetPendingBatchItems returns the first items of the batch which have not been submitted
+func GetPendingBatchItems(db *gorm.DB, batchID string, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	if err := db.Where("batch_id = ? AND thread_execution_id = '' AND error = ''", batchID).
+		Order("index ASC").Limit(limit).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetActiveBatchExecutionIDs returns the executions of the batch which are queued or running
+func GetActiveBatchExecutionIDs(db *gorm.DB, batchID string) ([]string, error) {
+	var executionIDs []string
+	if err := db.Table("batch_items").
+		Joins(batchItemsExecutionsJoin).
+		Where("batch_items.batch_id = ? AND thread_executions.status IN ?", batchID,
+			[]string{ThreadExecutionStatus_QUEUED, ThreadExecutionStatus_IN_PROGRESS}).
+		Pluck("batch_items.thread_execution_id", &executionIDs).Error; err != nil {
+		return nil, err
+	}
+	return executionIDs, nil
+}
+
+// UpdateBatchItemSubmission records the execution created for the item, or why it could not be created
+func UpdateBatchItemSubmission(db *gorm.DB, itemID uint, threadExecutionID, submissionErr string) error {
+	if threadExecutionID == "" && submissionErr == "" {
+		return errors.New("the execution or the error of the item is required")
+	}
+	return db.Model(&BatchItem{}).Where("id = ?", itemID).Updates(map[string]interface{}{
+		"thread_execution_id": threadExecutionID,
+		"error":               submissionErr,
+	}).Error
+}
+
+// GetBatchResults returns up to limit items of the batch after the given index with their results
+func GetBatchResults(db *gorm.DB, batchID string, afterIndex, limit int) ([]*BatchResult, error) {
+	var rows []struct {
+		Index             int
+		ThreadID          string
+		ThreadExecutionID string
+		ItemError         string
+		Status            *string
+		Content           *string
+		ParsedOutput      json.RawMessage
+		Output            json.RawMessage
+		PromptTokens      *int
+		CompletionTokens  *int
+		Cost              *float64
+	}
+	if err := db.Table("batch_items").
+		Select("batch_items.index, batch_items.thread_id, batch_items.thread_execution_id, batch_items.error AS item_error, "+
+			"thread_executions.status, thread_executions.content, thread_executions.parsed_output, thread_executions.output, "+
+			"thread_executions.prompt_tokens, thread_executions.completion_tokens, thread_executions.cost").
+		Joins(batchItemsExecutionsJoin).
+		Where("batch_items.batch_id = ? AND batch_items.index > ?", batchID, afterIndex).
+		Order("batch_items.index ASC").Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*BatchResult, 0, len(rows))
+	for _, row := range rows {
+		result := &BatchResult{
+			Index:             row.Index,
+			ThreadID:          row.ThreadID,
+			ThreadExecutionID: row.ThreadExecutionID,
+			Status:            BatchItemStatus_PENDING,
+			Error:             row.ItemError,
+			ParsedOutput:      row.ParsedOutput,
+		}
+		if row.ItemError != "" {
+			result.Status = ThreadExecutionStatus_FAILED
+		}
+		if row.Status != nil {
+			result.Status = *row.Status
+		}
+		if row.Content != nil {
+			result.Content = *row.Content
+		}
+		if row.PromptTokens != nil {
+			result.PromptTokens = *row.PromptTokens
+		}
+		if row.CompletionTokens != nil {
+			result.CompletionTokens = *row.CompletionTokens
+		}
+		if row.Cost != nil {
+			result.Cost = *row.Cost
+		}
+		if result.Status == ThreadExecutionStatus_FAILED && result.Error == "" {
+			result.Error = executionOutputError(row.Output)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// CountProjectThreads counts the threads of the project among the given threads
+func CountProjectThreads(db *gorm.DB, projectID string, threadIDs []string) (int64, error) {
+	var count int64
+	if err := db.Model(&Thread{}).Where("project_id = ? AND identifier IN ?", projectID, threadIDs).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	BudgetType_TOKENS = "tokens"
+	BudgetType_COST   = "cost"
+
+	BudgetEventType_SOFT_CAP_REACHED = "soft_cap_reached"
+	BudgetEventType_HARD_CAP_REACHED = "hard_cap_reached"
+
+	// budgets are reset at the start of every month (UTC), periods are formatted with this layout
+	BUDGET_PERIOD_LAYOUT = "2006-01"
+)
+
+// ProjectBudget caps the monthly usage of a project.
+// Budgets without an environment apply to all the executions of the project,
+// the ones with an environment only to the executions of that environment.
+type ProjectBudget struct {
+	Base
+	UserID      uint   `json:"user_id"`
+	ProjectID   string `json:"project_id" gorm:"index"`
+	Environment string `json:"environment"`
+	// tokens caps the prompt + completion tokens, cost caps the cost in USD
+	Type         string  `json:"type"`
+	MonthlyLimit float64 `json:"monthly_limit"`
+	// fractions of the monthly limit, crossing the soft threshold emits a warning event
+	// and executions are refused once the hard threshold is reached
+	SoftThreshold float64 `json:"soft_threshold"`
+	HardThreshold float64 `json:"hard_threshold"`
+	// last period a soft cap warning was emitted for, so it is emitted once per month
+	SoftCapNotifiedPeriod string `json:"soft_cap_notified_period"`
+	HardCapNotifiedPeriod string `json:"hard_cap_notified_period"`
+}
+
+// BudgetEvent is emitted when the usage of a project crosses a threshold of one of its budgets
+type BudgetEvent struct {
+	Base
+	ProjectID   string  `json:"project_id" gorm:"index"`
+	BudgetID    string  `json:"budget_id"`
+	Environment string  `json:"environment"`
+	Type        string  `json:"type"`
+	Period      string  `json:"period"`
+	Usage       float64 `json:"usage"`
+	Limit       float64 `json:"limit"`
+}
+
+// GetBudgetPeriod returns the period a time belongs to and the start of the period
+func GetBudgetPeriod(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Format(BUDGET_PERIOD_LAYOUT), start
+}
+
+func CreateProjectBudget(db *gorm.DB, budget *ProjectBudget) error {
+	budgetID := uuid.New().String()
+	budget.Identifier = fmt.Sprintf("%s%s", constants.PROJECT_BUDGET_ID_PREFIX, budgetID)
+	return db.Create(budget).Error
+}
+
+func GetProjectBudget(db *gorm.DB, budgetID string) (*ProjectBudget, error) {
+	var budget ProjectBudget
+	if err := db.First(&budget, "identifier = ?", budgetID).Error; err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+func GetProjectBudgets(db *gorm.DB, projectID string) ([]ProjectBudget, error) {
+	var budgets []ProjectBudget
+	if err := db.Where("project_id = ?", projectID).Order("created_at ASC").Find(&budgets).Error; err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// GetApplicableProjectBudgets returns the project wide budgets and the budgets of the environment
+func GetApplicableProjectBudgets(db *gorm.DB, projectID, environment string) ([]ProjectBudget, error) {
+	var budgets []ProjectBudget
+	if err := db.Where("project_id = ? AND (environment = '' OR environment = ?)", projectID, environment).Find(&budgets).Error; err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+func UpdateProjectBudget(db *gorm.DB, budget *ProjectBudget) error {
+	var updateData = make(map[string]interface{})
+	if budget.Type != "" {
+		updateData["type"] = budget.Type
+	}
+	if budget.MonthlyLimit != 0 {
+		updateData["monthly_limit"] = budget.MonthlyLimit
+	}
+	if budget.SoftThreshold != 0 {
+		updateData["soft_threshold"] = budget.SoftThreshold
+	}
+	if budget.HardThreshold != 0 {
+		updateData["hard_threshold"] = budget.HardThreshold
+	}
+	return db.Model(&ProjectBudget{}).Where("identifier = ?", budget.Identifier).Updates(updateData).Error
+}
+
+func DeleteProjectBudget(db *gorm.DB, budgetID string) error {
+	return db.Delete(&ProjectBudget{}, "identifier = ?", budgetID).Error
+}
+
+// GetBudgetUsage returns the usage counted against a budget since the start of the period
+func GetBudgetUsage(db *gorm.DB, budget *ProjectBudget, periodStart time.Time) (float64, error) {
+	column := "prompt_tokens + completion_tokens"
+	if budget.Type == BudgetType_COST {
+		column = "cost"
+	}
+
+	query := db.Model(&ThreadExecution{}).
+		Where("project_id = ? AND created_at >= ?", budget.ProjectID, periodStart)
+	if budget.Environment != "" {
+		query = query.Where("environment = ?", budget.Environment)
+	}
+
+	var usage float64
+	if err := query.Select(fmt.Sprintf("COALESCE(SUM(%s), 0)", column)).Scan(&usage).Error; err != nil {
+		return 0, err
+	}
+	return usage, nil
+}
+
+// MarkProjectBudgetNotified records that the event of a threshold was emitted for the period.
+// It returns false if it was already recorded, so concurrent executions emit a single event.
+func MarkProjectBudgetNotified(db *gorm.DB, budgetID, eventType, period string) (bool, error) {
+	column := "soft_cap_notified_period"
+	if eventType == BudgetEventType_HARD_CAP_REACHED {
+		column = "hard_cap_notified_period"
+	}
+
+	result := db.Model(&ProjectBudget{}).
+		Where(fmt.Sprintf("identifier = ? AND %s <> ?", column), budgetID, period).
+		Update(column, period)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func CreateBudgetEvent(db *gorm.DB, event *BudgetEvent) error {
+	eventID := uuid.New().String()
+	event.Identifier = fmt.Sprintf("%s%s", constants.BUDGET_EVENT_ID_PREFIX, eventID)
+	return db.Create(event).Error
+}
+
+func GetBudgetEvents(db *gorm.DB, projectID string, limit int) ([]BudgetEvent, error) {
+	var events []BudgetEvent
+	if err := db.Where("project_id = ?", projectID).Order("created_at DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
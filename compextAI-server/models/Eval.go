@@ -0,0 +1,435 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EvalRunStatus_IN_PROGRESS = "in_progress"
+	EvalRunStatus_COMPLETED   = "completed"
+	EvalRunStatus_CANCELLED   = "cancelled"
+
+	// waiting for the execution of the item
+	EvalResultStatus_PENDING = "pending"
+	// waiting for the executions of the llm judges
+	EvalResultStatus_JUDGING   = "judging"
+	EvalResultStatus_SCORED    = "scored"
+	EvalResultStatus_CANCELLED = "cancelled"
+)
+
+// Dataset is a set of inputs of a project with their expected outputs, evaluated by the eval runs
+type Dataset struct {
+	Base
+	UserID      uint   `json:"user_id"`
+	ProjectID   string `json:"project_id" gorm:"uniqueIndex:idx_dataset_name"`
+	Name        string `json:"name" gorm:"uniqueIndex:idx_dataset_name"`
+	Description string `json:"description"`
+}
+
+// DatasetItem is an input of a dataset, the messages are stored as they were given to the api
+type DatasetItem struct {
+	Base
+	DatasetID string          `json:"dataset_id" gorm:"index"`
+	Messages  json.RawMessage `json:"messages" gorm:"type:jsonb"`
+	// compared to the outputs by the scorers which need it, empty when there is none
+	ExpectedOutput string          `json:"expected_output"`
+	Metadata       json.RawMessage `json:"metadata" gorm:"type:jsonb;default:'{}'"`
+}
+
+// EvalRun executes the items of a dataset with one or more templates and scores the outputs.
+// The items are executed by a batch per template.
+type EvalRun struct {
+	Base
+	UserID      uint       `json:"user_id"`
+	ProjectID   string     `json:"project_id" gorm:"index"`
+	Name        string     `json:"name"`
+	DatasetID   string     `json:"dataset_id" gorm:"index"`
+	TemplateIDs StringList `json:"template_ids" gorm:"type:jsonb"`
+	// scorers of the outputs, see controllers.EvalScorer
+	Scorers json.RawMessage `json:"scorers" gorm:"type:jsonb;default:'[]'"`
+	Status  string          `json:"status" gorm:"index"`
+	// number of dataset items of the run
+	Total      int        `json:"total"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// EvalResult is the output of a template for an item of an eval run, with its scores
+type EvalResult struct {
+	ID         uint   `json:"-" gorm:"primary_key"`
+	EvalRunID  string `json:"eval_run_id" gorm:"uniqueIndex:idx_eval_result"`
+	TemplateID string `json:"template_id" gorm:"uniqueIndex:idx_eval_result"`
+	ItemIndex  int    `json:"item_index" gorm:"uniqueIndex:idx_eval_result"`
+	// dataset item the result is for, the expected output is copied from it when the run is created
+	DatasetItemID  string `json:"dataset_item_id"`
+	ExpectedOutput string `json:"expected_output"`
+	// batch executing the item, at ItemIndex
+	BatchID string `json:"batch_id"`
+	Status  string `json:"status" gorm:"index"`
+	// scores in the order of the scorers of the run, see controllers.EvalScore
+	Scores json.RawMessage `json:"scores" gorm:"type:jsonb;default:'[]'"`
+	// executions of the llm judges, the result is scored once they are finished
+	JudgeExecutionIDs StringList `json:"judge_execution_ids" gorm:"type:jsonb"`
+	// whether every scorer passed the output, null until it is scored or when no scorer decides
+	Passed    *bool     `json:"passed"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// the scorer holding the lease is the only one scoring the result
+	LeaseOwner     string     `json:"-"`
+	LeaseExpiresAt *time.Time `json:"-"`
+}
+
+// EvalResultRow is an eval result with the execution of its item
+type EvalResultRow struct {
+	EvalResult
+	ThreadExecutionID string  `json:"thread_execution_id"`
+	ExecutionStatus   string  `json:"execution_status"`
+	Content           string  `json:"content"`
+	Error             string  `json:"error,omitempty"`
+	PromptTokens      int     `json:"prompt_tokens"`
+	CompletionTokens  int     `json:"completion_tokens"`
+	Cost              float64 `json:"cost"`
+	// in seconds
+	ExecutionTime uint `json:"execution_time"`
+}
+
+func CreateDataset(db *gorm.DB, dataset *Dataset, items []*DatasetItem) (*Dataset, error) {
+	dataset.Identifier = fmt.Sprintf("%s%s", constants.DATASET_ID_PREFIX, uuid.New().String())
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(dataset).Error; err != nil {
+			return err
+		}
+		return createDatasetItems(tx, dataset.Identifier, items)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataset, nil
+}
+
+func AddDatasetItems(db *gorm.DB, datasetID string, items []*DatasetItem) error {
+	return createDatasetItems(db, datasetID, items)
+}
+
+func createDatasetItems(db *gorm.DB, datasetID string, items []*DatasetItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	for _, item := range items {
+		item.Identifier = fmt.Sprintf("%s%s", constants.DATASET_ITEM_ID_PREFIX, uuid.New().String())
+		item.DatasetID = datasetID
+	}
+	return db.CreateInBatches(items, batchItemsInsertSize).Error
+}
+
+func GetDataset(db *gorm.DB, projectID, datasetID string) (*Dataset, error) {
+	var dataset Dataset
+	if err := db.Where("identifier = ? AND project_id = ?", datasetID, projectID).First(&dataset).Error; err != nil {
+		return nil, err
+	}
+	return &dataset, nil
+}
+
+func GetDatasetByName(db *gorm.DB, projectID, name string) (*Dataset, error) {
+	var dataset Dataset
+	if err := db.Where("project_id = ? AND name = ?", projectID, name).First(&dataset).Error; err != nil {
+		return nil, err
+	}
+	return &dataset, nil
+}
+
+func GetProjectDatasets(db *gorm.DB, projectID string) ([]Dataset, error) {
+	var datasets []Dataset
+	if err := db.Where("project_id = ?", projectID).Order("created_at DESC").Find(&datasets).Error; err != nil {
+		return nil, err
+	}
+	return datasets, nil
+}
+
+// GetDatasetItems returns the items of the dataset in the order they were added
+func GetDatasetItems(db *gorm.DB, datasetID string) ([]*DatasetItem, error) {
+	var items []*DatasetItem
+	if err := db.Where("dataset_id = ?", datasetID).Order("id ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func CountDatasetItems(db *gorm.DB, datasetID string) (int64, error) {
+	var count int64
+	if err := db.Model(&DatasetItem{}).Where("dataset_id = ?", datasetID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteDataset deletes the dataset and its items, the eval runs of the dataset keep their results
+func DeleteDataset(db *gorm.DB, datasetID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("dataset_id = ?", datasetID).Delete(&DatasetItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("identifier = ?", datasetID).Delete(&Dataset{}).Error
+	})
+}
+
+func DeleteDatasetItem(db *gorm.DB, datasetID, itemID string) error {
+	result := db.Unscoped().Where("identifier = ? AND dataset_id = ?", itemID, datasetID).Delete(&DatasetItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreateEvalRun creates the eval run with its results, the identifier of the run is set beforehand
+// so the batches of the run can refer to it
+func CreateEvalRun(db *gorm.DB, evalRun *EvalRun, results []*EvalResult) (*EvalRun, error) {
+	evalRun.Status = EvalRunStatus_IN_PROGRESS
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(evalRun).Error; err != nil {
+			return err
+		}
+		for _, result := range results {
+			result.EvalRunID = evalRun.Identifier
+			result.Status = EvalResultStatus_PENDING
+		}
+		return tx.CreateInBatches(results, batchItemsInsertSize).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return evalRun, nil
+}
+
+func NewEvalRunIdentifier() string {
+	return fmt.Sprintf("%s%s", constants.EVAL_RUN_ID_PREFIX, uuid.New().String())
+}
+
+func GetEvalRun(db *gorm.DB, projectID, evalRunID string) (*EvalRun, error) {
+	var evalRun EvalRun
+	if err := db.Where("identifier = ? AND project_id = ?", evalRunID, projectID).First(&evalRun).Error; err != nil {
+		return nil, err
+	}
+	return &evalRun, nil
+}
+
+func GetEvalRunByID(db *gorm.DB, evalRunID string) (*EvalRun, error) {
+	var evalRun EvalRun
+	if err := db.Where("identifier = ?", evalRunID).First(&evalRun).Error; err != nil {
+		return nil, err
+	}
+	return &evalRun, nil
+}
+
+func GetProjectEvalRuns(db *gorm.DB, projectID string) ([]EvalRun, error) {
+	var evalRuns []EvalRun
+	if err := db.Where("project_id = ?", projectID).Order("created_at DESC").Find(&evalRuns).Error; err != nil {
+		return nil, err
+	}
+	return evalRuns, nil
+}
+
+// GetEvalRunBatchIDs returns the batches executing the templates of the eval run
+func GetEvalRunBatchIDs(db *gorm.DB, evalRunID string) ([]string, error) {
+	var batchIDs []string
+	if err := db.Model(&Batch{}).Where("eval_run_id = ?", evalRunID).Pluck("identifier", &batchIDs).Error; err != nil {
+		return nil, err
+	}
+	return batchIDs, nil
+}
+
+// a pending result is ready once its item is finished or could not be submitted,
+// a judged result once none of its judge executions is queued or running
+const evalResultReadyCondition = "(eval_results.status = ? AND EXISTS (SELECT 1 FROM batch_items " + batchItemsExecutionsJoin +
+	" WHERE batch_items.batch_id = eval_results.batch_id AND batch_items.index = eval_results.item_index" +
+	" AND (batch_items.error <> '' OR thread_executions.status IN ?)))" +
+	" OR (eval_results.status = ? AND NOT EXISTS (SELECT 1 FROM thread_executions" +
+	" WHERE thread_executions.identifier IN (SELECT jsonb_array_elements_text(eval_results.judge_execution_ids))" +
+	" AND thread_executions.status IN ?))"
+
+// ClaimNextEvalResult leases the next result which can be scored, nil if there is none
+func ClaimNextEvalResult(db *gorm.DB, leaseOwner string, leaseDuration time.Duration) (*EvalResult, error) {
+	var evalResult EvalResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// skip locked rows so that concurrent scorers never claim the same result
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("eval_results.lease_expires_at IS NULL OR eval_results.lease_expires_at < ?", now).
+			Where(evalResultReadyCondition,
+				EvalResultStatus_PENDING, []string{ThreadExecutionStatus_COMPLETED, ThreadExecutionStatus_FAILED, ThreadExecutionStatus_CANCELLED},
+				EvalResultStatus_JUDGING, []string{ThreadExecutionStatus_QUEUED, ThreadExecutionStatus_IN_PROGRESS}).
+			Order("eval_results.id ASC").
+			First(&evalResult).Error; err != nil {
+			return err
+		}
+
+		leaseExpiresAt := now.Add(leaseDuration)
+		evalResult.LeaseOwner = leaseOwner
+		evalResult.LeaseExpiresAt = &leaseExpiresAt
+		return tx.Model(&EvalResult{}).Where("id = ?", evalResult.ID).Updates(map[string]interface{}{
+			"lease_owner":      evalResult.LeaseOwner,
+			"lease_expires_at": evalResult.LeaseExpiresAt,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &evalResult, nil
+}
+
+func ReleaseEvalResultLease(db *gorm.DB, evalResultID uint, leaseOwner string) error {
+	return db.Model(&EvalResult{}).Where("id = ? AND lease_owner = ?", evalResultID, leaseOwner).
+		Updates(map[string]interface{}{
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}).Error
+}
+
+// UpdateEvalResultScores records the scores of a result which still has the given status,
+// it returns false if the result moved on meanwhile, e.g. because the run was cancelled
+func UpdateEvalResultScores(db *gorm.DB, evalResult *EvalResult, previousStatus string) (bool, error) {
+	result := db.Model(&EvalResult{}).Where("id = ? AND status = ?", evalResult.ID, previousStatus).
+		Updates(map[string]interface{}{
+			"status":              evalResult.Status,
+			"scores":              evalResult.Scores,
+			"judge_execution_ids": evalResult.JudgeExecutionIDs,
+			"passed":              evalResult.Passed,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CompleteEvalRun completes the eval run once all of its results are scored,
+// it returns false if some results are not scored yet or the run is already finished
+func CompleteEvalRun(db *gorm.DB, evalRunID string) (bool, error) {
+	result := db.Model(&EvalRun{}).
+		Where("identifier = ? AND status = ?", evalRunID, EvalRunStatus_IN_PROGRESS).
+		Where("NOT EXISTS (SELECT 1 FROM eval_results WHERE eval_results.eval_run_id = ? AND eval_results.status IN ?)",
+			evalRunID, []string{EvalResultStatus_PENDING, EvalResultStatus_JUDGING}).
+		Updates(map[string]interface{}{
+			"status":      EvalRunStatus_COMPLETED,
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CancelEvalRun cancels the eval run and its results which are not scored yet,
+// it returns false if the run is already finished
+func CancelEvalRun(db *gorm.DB, evalRunID string) (bool, error) {
+	cancelled := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&EvalRun{}).Where("identifier = ? AND status = ?", evalRunID, EvalRunStatus_IN_PROGRESS).
+			Updates(map[string]interface{}{
+				"status":      EvalRunStatus_CANCELLED,
+				"finished_at": time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		cancelled = true
+		return tx.Model(&EvalResult{}).
+			Where("eval_run_id = ? AND status IN ?", evalRunID, []string{EvalResultStatus_PENDING, EvalResultStatus_JUDGING}).
+			Update("status", EvalResultStatus_CANCELLED).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return cancelled, nil
+}
+
+// GetEvalResultRows returns the results of the eval run for the items in [fromIndex, toIndex),
+// with the executions of the items, ordered by item
+func GetEvalResultRows(db *gorm.DB, evalRunID string, fromIndex, toIndex int) ([]*EvalResultRow, error) {
+	var rows []struct {
+		EvalResult
+		ThreadExecutionID string
+		ItemError         string
+		ExecutionStatus   *string
+		Content           *string
+		Output            json.RawMessage
+		PromptTokens      *int
+		CompletionTokens  *int
+		Cost              *float64
+		ExecutionTime     *uint
+	}
+	if err := db.Table("eval_results").
+		Select("eval_results.*, batch_items.thread_execution_id, batch_items.error AS item_error, "+
+			"thread_executions.status AS execution_status, thread_executions.content, thread_executions.output, "+
+			"thread_executions.prompt_tokens, thread_executions.completion_tokens, thread_executions.cost, thread_executions.execution_time").
+		Joins("LEFT JOIN batch_items ON batch_items.batch_id = eval_results.batch_id AND batch_items.index = eval_results.item_index").
+		Joins(batchItemsExecutionsJoin).
+		Where("eval_results.eval_run_id = ? AND eval_results.item_index >= ? AND eval_results.item_index < ?", evalRunID, fromIndex, toIndex).
+		Order("eval_results.item_index ASC, eval_results.id ASC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*EvalResultRow, 0, len(rows))
+	for _, row := range rows {
+		result := &EvalResultRow{
+			EvalResult:        row.EvalResult,
+			ThreadExecutionID: row.ThreadExecutionID,
+			ExecutionStatus:   BatchItemStatus_PENDING,
+			Error:             row.ItemError,
+		}
+		if row.ItemError != "" {
+			result.ExecutionStatus = ThreadExecutionStatus_FAILED
+		}
+		if row.ExecutionStatus != nil {
+			result.ExecutionStatus = *row.ExecutionStatus
+		}
+		if row.Content != nil {
+			result.Content = *row.Content
+		}
+		if row.PromptTokens != nil {
+			result.PromptTokens = *row.PromptTokens
+		}
+		if row.CompletionTokens != nil {
+			result.CompletionTokens = *row.CompletionTokens
+		}
+		if row.Cost != nil {
+			result.Cost = *row.Cost
+		}
+		if row.ExecutionTime != nil {
+			result.ExecutionTime = *row.ExecutionTime
+		}
+		if result.ExecutionStatus == ThreadExecutionStatus_FAILED && result.Error == "" {
+			result.Error = executionOutputError(row.Output)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// executionOutputError returns the error a failed execution recorded in its output
+func executionOutputError(output json.RawMessage) string {
+	var outputError struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(output, &outputError); err != nil {
+		return ""
+	}
+	return outputError.Error
+}
@@ -1,19 +1,25 @@
 package models
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/burnerlee/compextAI/constants"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
+	ThreadExecutionStatus_QUEUED      = "queued"
 	ThreadExecutionStatus_IN_PROGRESS = "in_progress"
 	ThreadExecutionStatus_COMPLETED   = "completed"
 	ThreadExecutionStatus_FAILED      = "failed"
+	ThreadExecutionStatus_CANCELLED   = "cancelled"
 )
 
 type ThreadExecution struct {
@@ -24,7 +30,9 @@ type ThreadExecution struct {
 	Thread                          Thread                        `json:"thread" gorm:"foreignKey:ThreadID;references:Identifier"`
 	ThreadExecutionParamsTemplateID string                        `json:"thread_execution_params_template_id"`
 	ThreadExecutionParamsTemplate   ThreadExecutionParamsTemplate `json:"thread_execution_params_template" gorm:"foreignKey:ThreadExecutionParamsTemplateID;references:Identifier"`
-	Status                          string                        `json:"status"`
+	// revision of the template the execution was queued with, see ThreadExecutionParamsTemplateRevision
+	ThreadExecutionParamsTemplateRevisionID string `json:"thread_execution_params_template_revision_id"`
+	Status                                  string `json:"status"`
 	// default value should be {}
 	InputMessages             json.RawMessage `json:"input_messages" gorm:"type:jsonb;default:'{}'"`
 	Output                    json.RawMessage `json:"output" gorm:"type:jsonb;default:'{}'"`
@@ -38,6 +46,91 @@ type ThreadExecution struct {
 	// this is displayed in the UI and can be used for filtering
 	Metadata json.RawMessage `json:"metadata" gorm:"type:jsonb;default:'{}'"`
 	Tools    json.RawMessage `json:"tools" gorm:"type:jsonb;default:'{}'"`
+
+	// the fields below are used by the execution queue to hand out executions to workers
+	// job payload holds everything a worker needs to run the execution
+	JobPayload json.RawMessage `json:"-" gorm:"type:jsonb;default:'{}'"`
+	// lease owner is the worker currently running the execution
+	LeaseOwner     string     `json:"lease_owner"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at"`
+	// claim count is the number of times a worker has picked up the execution
+	ClaimCount uint `json:"claim_count" gorm:"default:0"`
+
+	// every call made to the executor for this execution, see RetryPolicy
+	Attempts     json.RawMessage `json:"attempts" gorm:"type:jsonb;default:'[]'"`
+	AttemptCount uint            `json:"attempt_count" gorm:"default:0"`
+
+	// fallback templates tried in order when the template of the execution fails
+	FallbackTemplateIDs StringList `json:"fallback_template_ids" gorm:"type:jsonb"`
+	// template which produced the output, differs from ThreadExecutionParamsTemplateID if a fallback was used
+	ExecutedTemplateID string `json:"executed_template_id"`
+	// revision of the executed template
+	ExecutedTemplateRevisionID string `json:"executed_template_revision_id"`
+	// templates which failed before the executed template, see SkippedTemplate
+	SkippedTemplates json.RawMessage `json:"skipped_templates" gorm:"type:jsonb;default:'[]'"`
+
+	// environment of the execution params the execution was made with
+	Environment string `json:"environment" gorm:"index"`
+	// model of the executed template
+	Model string `json:"model" gorm:"index"`
+	// token usage reported by the provider, prompt tokens include the cached tokens
+	PromptTokens     int `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int `json:"completion_tokens" gorm:"default:0"`
+	CachedTokens     int `json:"cached_tokens" gorm:"default:0"`
+	// cost of the execution in USD, computed from the price table of the model
+	Cost float64 `json:"cost" gorm:"default:0"`
+
+	// url notified with a signed POST once the execution is finished, see WebhookDelivery
+	CallbackURL string `json:"callback_url"`
+
+	// variables the prompts were rendered with, null if the prompts were not templated
+	Variables json.RawMessage `json:"variables" gorm:"type:jsonb"`
+	// system prompt sent to the provider, after rendering the variables into it
+	RenderedSystemPrompt string `json:"rendered_system_prompt"`
+
+	// execution params the execution was made with, empty for reruns
+	ThreadExecutionParamsID string `json:"thread_execution_params_id" gorm:"index"`
+	// variant of the execution params picked for the execution, empty if they had no variants
+	Variant string `json:"variant" gorm:"index"`
+
+	// tool calls run by the server before the model gave its final answer, see ThreadExecutionToolStep
+	ToolSteps json.RawMessage `json:"tool_steps" gorm:"type:jsonb;default:'[]'"`
+
+	// content of the response parsed as json, set when the template has a json response format
+	// and the response matched it
+	ParsedOutput json.RawMessage `json:"parsed_output" gorm:"type:jsonb"`
+	// re-prompts made because the response did not match the response format
+	ResponseFormatRetries int `json:"response_format_retries" gorm:"default:0"`
+
+	// set when the execution was completed with the cached response of an earlier execution,
+	// see internal/cache. The key is set on the executions which opted in to the cache.
+	CacheHit              bool   `json:"cache_hit" gorm:"default:false"`
+	CacheKey              string `json:"cache_key"`
+	CachedFromExecutionID string `json:"cached_from_execution_id"`
+
+	// batch the execution was submitted for, empty for the executions requested one by one
+	BatchID string `json:"batch_id" gorm:"index"`
+}
+
+// SkippedTemplate records why a template of a fallback chain did not produce the output
+type SkippedTemplate struct {
+	TemplateID string `json:"template_id"`
+	Model      string `json:"model"`
+	StatusCode int    `json:"status_code"`
+	Reason     string `json:"reason"`
+}
+
+// ThreadExecutionAttempt records a single call to the executor
+type ThreadExecutionAttempt struct {
+	TemplateID string    `json:"template_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	// duration of the attempt in milliseconds
+	Duration int64 `json:"duration"`
+	// time waited before the next attempt in milliseconds, 0 if the attempt was not retried
+	Backoff int64 `json:"backoff"`
 }
 
 // ThreadExecutionParams are the parameters for executing a thread
@@ -49,6 +142,85 @@ type ThreadExecutionParams struct {
 	Environment string                        `json:"environment"`
 	TemplateID  string                        `json:"template_id"`
 	Template    ThreadExecutionParamsTemplate `json:"template" gorm:"foreignKey:TemplateID;references:Identifier"`
+	// revision of the template approved by the promotion to an environment requiring approvals,
+	// the executions use it instead of the current revision of the template when set
+	TemplateRevisionID string `json:"template_revision_id"`
+	// templates tried in order when the template fails or is rate limited
+	FallbackTemplateIDs StringList `json:"fallback_template_ids" gorm:"type:jsonb"`
+	// weighted split of the executions between templates, the template is used when empty.
+	// A thread always gets the same variant as long as the variants do not change.
+	Variants TemplateVariants `json:"variants" gorm:"type:jsonb"`
+}
+
+// StatusCodes is a list of http status codes stored as a json array
+type StatusCodes []int
+
+func (s StatusCodes) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	statusCodesJson, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(statusCodesJson), nil
+}
+
+func (s *StatusCodes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("unsupported type for status codes: %T", value)
+	}
+}
+
+// StringList is a list of strings stored as a json array
+type StringList []string
+
+func (s StringList) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	stringsJson, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(stringsJson), nil
+}
+
+func (s *StringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("unsupported type for string list: %T", value)
+	}
+}
+
+// RetryPolicy configures how failed calls to the executor are retried.
+// Zero values fall back to the defaults of the executor client.
+type RetryPolicy struct {
+	// total number of attempts, including the first one
+	MaxAttempts int `json:"max_attempts"`
+	// backoff before the first retry in milliseconds
+	InitialBackoff int `json:"initial_backoff"`
+	// upper bound for the backoff in milliseconds
+	MaxBackoff        int     `json:"max_backoff"`
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+	// fraction of the backoff which is randomized, between 0 and 1
+	Jitter               float64     `json:"jitter"`
+	RetryableStatusCodes StatusCodes `json:"retryable_status_codes" gorm:"type:jsonb"`
 }
 
 type ThreadExecutionParamsTemplate struct {
@@ -66,6 +238,12 @@ type ThreadExecutionParamsTemplate struct {
 	ResponseFormat      json.RawMessage `json:"response_format" gorm:"type:jsonb;default:'{}'"`
 	SystemPrompt        string          `json:"system_prompt"`
 	UseLiteLLM          bool            `json:"use_litellm" gorm:"default:true"`
+	RetryPolicy         RetryPolicy     `json:"retry_policy" gorm:"embedded;embeddedPrefix:retry_"`
+	// times the model is re-prompted with the validation errors when its response does not
+	// match the json response format, the execution fails once they are used up
+	ResponseFormatRetries int `json:"response_format_retries" gorm:"default:0"`
+	// current revision of the template, see ThreadExecutionParamsTemplateRevision
+	Revision int `json:"revision" gorm:"default:0"`
 }
 
 func CreateThreadExecution(db *gorm.DB, threadExecution *ThreadExecution) (*ThreadExecution, error) {
@@ -79,7 +257,58 @@ func CreateThreadExecution(db *gorm.DB, threadExecution *ThreadExecution) (*Thre
 	return threadExecution, nil
 }
 
+// CancelThreadExecution moves a queued or running execution to the cancelled status.
+// It returns the status of the execution before the cancellation and false if it was already finished.
+func CancelThreadExecution(db *gorm.DB, executionID string) (string, bool, error) {
+	var threadExecution ThreadExecution
+	cancelled := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Where("identifier = ?", executionID).
+			First(&threadExecution).Error; err != nil {
+			return err
+		}
+		if threadExecution.Status != ThreadExecutionStatus_QUEUED && threadExecution.Status != ThreadExecutionStatus_IN_PROGRESS {
+			return nil
+		}
+
+		cancelled = true
+		return tx.Model(&ThreadExecution{}).Where("id = ?", threadExecution.ID).Updates(map[string]interface{}{
+			"status":         ThreadExecutionStatus_CANCELLED,
+			"output":         json.RawMessage(`{"error":"execution was cancelled"}`),
+			"execution_time": uint(time.Since(threadExecution.CreatedAt).Seconds()),
+		}).Error
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return threadExecution.Status, cancelled, nil
+}
+
+// CompleteThreadExecution writes the result of a running execution along with the completed status.
+// It returns false if the execution is no longer running under the given lease owner, e.g. it was
+// cancelled, or its lease expired and it was claimed by another worker.
+func CompleteThreadExecution(db *gorm.DB, threadExecution *ThreadExecution, leaseOwner string) (bool, error) {
+	updateData := threadExecutionUpdates(threadExecution)
+	updateData["status"] = ThreadExecutionStatus_COMPLETED
+	result := db.Model(&ThreadExecution{}).
+		Where("identifier = ? AND lease_owner = ? AND status = ?", threadExecution.Identifier, leaseOwner, ThreadExecutionStatus_IN_PROGRESS).
+		Updates(updateData)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
 func UpdateThreadExecution(db *gorm.DB, threadExecution *ThreadExecution) error {
+	// cancelled executions are final, the worker which was running them must not overwrite them
+	return db.Model(&ThreadExecution{}).
+		Where("identifier = ? AND status <> ?", threadExecution.Identifier, ThreadExecutionStatus_CANCELLED).
+		Updates(threadExecutionUpdates(threadExecution)).Error
+}
+
+// threadExecutionUpdates returns the columns of the fields set on the execution
+func threadExecutionUpdates(threadExecution *ThreadExecution) map[string]interface{} {
 	updateData := make(map[string]interface{})
 	if threadExecution.Status != "" {
 		updateData["status"] = threadExecution.Status
@@ -105,7 +334,60 @@ func UpdateThreadExecution(db *gorm.DB, threadExecution *ThreadExecution) error
 	if threadExecution.ExecutionTime != 0 {
 		updateData["execution_time"] = threadExecution.ExecutionTime
 	}
-	return db.Model(&ThreadExecution{}).Where("identifier = ?", threadExecution.Identifier).Updates(updateData).Error
+	if threadExecution.ExecutedTemplateID != "" {
+		updateData["executed_template_id"] = threadExecution.ExecutedTemplateID
+	}
+	if threadExecution.RenderedSystemPrompt != "" {
+		updateData["rendered_system_prompt"] = threadExecution.RenderedSystemPrompt
+	}
+	if threadExecution.ExecutedTemplateRevisionID != "" {
+		updateData["executed_template_revision_id"] = threadExecution.ExecutedTemplateRevisionID
+	}
+	if threadExecution.SkippedTemplates != nil {
+		updateData["skipped_templates"] = threadExecution.SkippedTemplates
+	}
+	if threadExecution.Model != "" {
+		updateData["model"] = threadExecution.Model
+	}
+	if threadExecution.PromptTokens != 0 {
+		updateData["prompt_tokens"] = threadExecution.PromptTokens
+	}
+	if threadExecution.CompletionTokens != 0 {
+		updateData["completion_tokens"] = threadExecution.CompletionTokens
+	}
+	if threadExecution.CachedTokens != 0 {
+		updateData["cached_tokens"] = threadExecution.CachedTokens
+	}
+	if threadExecution.ParsedOutput != nil {
+		updateData["parsed_output"] = threadExecution.ParsedOutput
+	}
+	if threadExecution.ResponseFormatRetries != 0 {
+		updateData["response_format_retries"] = threadExecution.ResponseFormatRetries
+	}
+	if threadExecution.Cost != 0 {
+		updateData["cost"] = threadExecution.Cost
+	}
+	return updateData
+}
+
+func AppendThreadExecutionAttempt(db *gorm.DB, executionID string, attempt *ThreadExecutionAttempt) error {
+	attemptJson, err := json.Marshal([]*ThreadExecutionAttempt{attempt})
+	if err != nil {
+		return err
+	}
+	return db.Model(&ThreadExecution{}).Where("identifier = ?", executionID).Updates(map[string]interface{}{
+		"attempts":      gorm.Expr("COALESCE(attempts, '[]'::jsonb) || ?::jsonb", string(attemptJson)),
+		"attempt_count": gorm.Expr("attempt_count + 1"),
+	}).Error
+}
+
+func AppendThreadExecutionToolStep(db *gorm.DB, executionID string, step *ThreadExecutionToolStep) error {
+	stepJson, err := json.Marshal([]*ThreadExecutionToolStep{step})
+	if err != nil {
+		return err
+	}
+	return db.Model(&ThreadExecution{}).Where("identifier = ?", executionID).
+		Update("tool_steps", gorm.Expr("COALESCE(tool_steps, '[]'::jsonb) || ?::jsonb", string(stepJson))).Error
 }
 
 func GetThreadExecutionByID(db *gorm.DB, executionID string) (*ThreadExecution, error) {
@@ -124,11 +406,11 @@ func GetThreadExecutionParamsByID(db *gorm.DB, threadExecutionParamsID string) (
 	return &threadExecutionParams, nil
 }
 
-func GetAllThreadExecutionParams(db *gorm.DB, userID uint, projectID string) ([]ThreadExecutionParams, error) {
+func GetAllThreadExecutionParams(db *gorm.DB, projectID string) ([]ThreadExecutionParams, error) {
 	var threadExecutionParams []ThreadExecutionParams
 	// preload the template
 	// this request is too slow, need to optimize
-	if err := db.Where("user_id = ? AND project_id = ?", userID, projectID).Preload("Template").Find(&threadExecutionParams).Error; err != nil {
+	if err := db.Where("project_id = ?", projectID).Preload("Template").Find(&threadExecutionParams).Error; err != nil {
 		return nil, err
 	}
 	return threadExecutionParams, nil
@@ -148,9 +430,9 @@ func DeleteThreadExecutionParams(db *gorm.DB, threadExecutionParamsID string) er
 	return db.Delete(&ThreadExecutionParams{}, "identifier = ?", threadExecutionParamsID).Error
 }
 
-func GetThreadExecutionParamsByUserIDAndNameAndEnvironment(db *gorm.DB, userID uint, name, environment, projectID string) (*ThreadExecutionParams, error) {
+func GetThreadExecutionParamsByNameAndEnvironment(db *gorm.DB, name, environment, projectID string) (*ThreadExecutionParams, error) {
 	var threadExecutionParams ThreadExecutionParams
-	if err := db.Where("user_id = ? AND name = ? AND environment = ? AND project_id = ?", userID, name, environment, projectID).Preload("Template").First(&threadExecutionParams).Error; err != nil {
+	if err := db.Where("name = ? AND environment = ? AND project_id = ?", name, environment, projectID).Preload("Template").First(&threadExecutionParams).Error; err != nil {
 		return nil, err
 	}
 	return &threadExecutionParams, nil
@@ -160,7 +442,17 @@ func CreateThreadExecutionParamsTemplate(db *gorm.DB, threadExecutionParamsTempl
 	threadExecutionParamsTemplateIDUniqueIdentifier := uuid.New().String()
 	threadExecutionParamsTemplateID := fmt.Sprintf("%s%s", constants.THREAD_EXECUTION_PARAMS_TEMPLATE_ID_PREFIX, threadExecutionParamsTemplateIDUniqueIdentifier)
 	threadExecutionParamsTemplate.Identifier = threadExecutionParamsTemplateID
-	if err := db.Create(threadExecutionParamsTemplate).Error; err != nil {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(threadExecutionParamsTemplate).Error; err != nil {
+			return err
+		}
+		revision, err := createTemplateRevision(tx, threadExecutionParamsTemplate.Identifier, threadExecutionParamsTemplate.UserID, 0)
+		if err != nil {
+			return err
+		}
+		threadExecutionParamsTemplate.Revision = revision.Revision
+		return nil
+	}); err != nil {
 		return nil, err
 	}
 	return threadExecutionParamsTemplate, nil
@@ -178,7 +470,8 @@ func GetThreadExecutionParamsTemplateByID(db *gorm.DB, threadExecutionParamsTemp
 	return &threadExecutionParamsTemplate, nil
 }
 
-func UpdateThreadExecutionParamsTemplate(db *gorm.DB, threadExecutionParamsTemplate *ThreadExecutionParamsTemplate) error {
+// UpdateThreadExecutionParamsTemplate updates the template and records the change as a new revision
+func UpdateThreadExecutionParamsTemplate(db *gorm.DB, threadExecutionParamsTemplate *ThreadExecutionParamsTemplate, userID uint) error {
 	updateData := make(map[string]interface{})
 	if threadExecutionParamsTemplate.Name != "" {
 		updateData["name"] = threadExecutionParamsTemplate.Name
@@ -210,21 +503,63 @@ func UpdateThreadExecutionParamsTemplate(db *gorm.DB, threadExecutionParamsTempl
 	if threadExecutionParamsTemplate.SystemPrompt != "" {
 		updateData["system_prompt"] = threadExecutionParamsTemplate.SystemPrompt
 	}
+	if threadExecutionParamsTemplate.ResponseFormatRetries != 0 {
+		updateData["response_format_retries"] = threadExecutionParamsTemplate.ResponseFormatRetries
+	}
+	if threadExecutionParamsTemplate.RetryPolicy.MaxAttempts != 0 {
+		updateData["retry_max_attempts"] = threadExecutionParamsTemplate.RetryPolicy.MaxAttempts
+	}
+	if threadExecutionParamsTemplate.RetryPolicy.InitialBackoff != 0 {
+		updateData["retry_initial_backoff"] = threadExecutionParamsTemplate.RetryPolicy.InitialBackoff
+	}
+	if threadExecutionParamsTemplate.RetryPolicy.MaxBackoff != 0 {
+		updateData["retry_max_backoff"] = threadExecutionParamsTemplate.RetryPolicy.MaxBackoff
+	}
+	if threadExecutionParamsTemplate.RetryPolicy.BackoffMultiplier != 0 {
+		updateData["retry_backoff_multiplier"] = threadExecutionParamsTemplate.RetryPolicy.BackoffMultiplier
+	}
+	if threadExecutionParamsTemplate.RetryPolicy.Jitter != 0 {
+		updateData["retry_jitter"] = threadExecutionParamsTemplate.RetryPolicy.Jitter
+	}
+	if threadExecutionParamsTemplate.RetryPolicy.RetryableStatusCodes != nil {
+		updateData["retry_retryable_status_codes"] = threadExecutionParamsTemplate.RetryPolicy.RetryableStatusCodes
+	}
 
-	return db.Model(&ThreadExecutionParamsTemplate{}).Where("identifier = ?", threadExecutionParamsTemplate.Identifier).Updates(updateData).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ThreadExecutionParamsTemplate{}).Where("identifier = ?", threadExecutionParamsTemplate.Identifier).Updates(updateData).Error; err != nil {
+			return err
+		}
+		changed, err := templateChangedSinceRevision(tx, threadExecutionParamsTemplate.Identifier)
+		if err != nil || !changed {
+			return err
+		}
+		_, err = createTemplateRevision(tx, threadExecutionParamsTemplate.Identifier, userID, 0)
+		return err
+	})
 }
 
-func GetAllThreadExecutionParamsTemplates(db *gorm.DB, userID uint, projectID string) ([]ThreadExecutionParamsTemplate, error) {
+func GetAllThreadExecutionParamsTemplates(db *gorm.DB, projectID string) ([]ThreadExecutionParamsTemplate, error) {
 	var threadExecutionParamsTemplates []ThreadExecutionParamsTemplate
-	if err := db.Where("user_id = ? AND project_id = ?", userID, projectID).Find(&threadExecutionParamsTemplates).Error; err != nil {
+	if err := db.Where("project_id = ?", projectID).Find(&threadExecutionParamsTemplates).Error; err != nil {
 		return nil, err
 	}
 	return threadExecutionParamsTemplates, nil
 }
 
 func GetThreadExecutionParamsByTemplateID(db *gorm.DB, templateID string) ([]ThreadExecutionParams, error) {
+	fallbackTemplateIDs, err := json.Marshal([]string{templateID})
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := json.Marshal([]map[string]string{{"template_id": templateID}})
+	if err != nil {
+		return nil, err
+	}
+
 	var threadExecutionParams []ThreadExecutionParams
-	if err := db.Where("template_id = ?", templateID).Find(&threadExecutionParams).Error; err != nil {
+	// the template is in use if it is the template, one of the fallbacks or one of the variants of the execution params
+	if err := db.Where("template_id = ? OR fallback_template_ids @> ?::jsonb OR variants @> ?::jsonb", templateID, string(fallbackTemplateIDs), string(variants)).Find(&threadExecutionParams).Error; err != nil {
 		return nil, err
 	}
 	return threadExecutionParams, nil
@@ -234,6 +569,18 @@ func UpdateThreadExecutionParamsTemplateID(db *gorm.DB, threadExecutionParamsID,
 	return db.Model(&ThreadExecutionParams{}).Where("identifier = ?", threadExecutionParamsID).Update("template_id", templateID).Error
 }
 
+func UpdateThreadExecutionParamsTemplateRevisionID(db *gorm.DB, threadExecutionParamsID, templateRevisionID string) error {
+	return db.Model(&ThreadExecutionParams{}).Where("identifier = ?", threadExecutionParamsID).Update("template_revision_id", templateRevisionID).Error
+}
+
+func UpdateThreadExecutionParamsFallbackTemplateIDs(db *gorm.DB, threadExecutionParamsID string, fallbackTemplateIDs []string) error {
+	return db.Model(&ThreadExecutionParams{}).Where("identifier = ?", threadExecutionParamsID).Update("fallback_template_ids", StringList(fallbackTemplateIDs)).Error
+}
+
+func UpdateThreadExecutionParamsVariants(db *gorm.DB, threadExecutionParamsID string, variants []TemplateVariant) error {
+	return db.Model(&ThreadExecutionParams{}).Where("identifier = ?", threadExecutionParamsID).Update("variants", TemplateVariants(variants)).Error
+}
+
 func GetAllThreadExecutionsByProjectID(db *gorm.DB, projectID string, searchQuery string, searchParamsMap map[string]string, page, limit int) ([]ThreadExecution, int64, error) {
 
 	offset := (page - 1) * limit
@@ -274,3 +621,108 @@ func GetAllThreadExecutionsByProjectID(db *gorm.DB, projectID string, searchQuer
 
 	return threadExecutions, total, nil
 }
+
+// ClaimNextThreadExecution picks the oldest queued execution and leases it to the given owner.
+// It returns nil if there is nothing to claim.
+func ClaimNextThreadExecution(db *gorm.DB, leaseOwner string, leaseDuration time.Duration) (*ThreadExecution, error) {
+	var threadExecution ThreadExecution
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// skip locked rows so that concurrent workers never claim the same execution
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ?", ThreadExecutionStatus_QUEUED).
+			Order("created_at ASC").
+			First(&threadExecution).Error; err != nil {
+			return err
+		}
+
+		leaseExpiresAt := time.Now().Add(leaseDuration)
+		threadExecution.Status = ThreadExecutionStatus_IN_PROGRESS
+		threadExecution.LeaseOwner = leaseOwner
+		threadExecution.LeaseExpiresAt = &leaseExpiresAt
+		threadExecution.ClaimCount++
+
+		return tx.Model(&ThreadExecution{}).Where("id = ?", threadExecution.ID).Updates(map[string]interface{}{
+			"status":           threadExecution.Status,
+			"lease_owner":      threadExecution.LeaseOwner,
+			"lease_expires_at": threadExecution.LeaseExpiresAt,
+			"claim_count":      threadExecution.ClaimCount,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &threadExecution, nil
+}
+
+// RenewThreadExecutionLease extends the lease of a running execution.
+// It returns false if the lease is no longer held by the given owner.
+func RenewThreadExecutionLease(db *gorm.DB, executionID, leaseOwner string, leaseDuration time.Duration) (bool, error) {
+	result := db.Model(&ThreadExecution{}).
+		Where("identifier = ? AND lease_owner = ? AND status = ?", executionID, leaseOwner, ThreadExecutionStatus_IN_PROGRESS).
+		Update("lease_expires_at", time.Now().Add(leaseDuration))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ReleaseThreadExecutionLease gives up the lease of an execution once its worker stopped running it.
+// An execution left in progress, e.g. interrupted by a shutdown, is put back in the queue.
+func ReleaseThreadExecutionLease(db *gorm.DB, executionID, leaseOwner string) error {
+	return db.Model(&ThreadExecution{}).
+		Where("identifier = ? AND lease_owner = ?", executionID, leaseOwner).
+		Updates(map[string]interface{}{
+			"status":           gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END", ThreadExecutionStatus_IN_PROGRESS, ThreadExecutionStatus_QUEUED),
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}).Error
+}
+
+// RecoverOrphanedThreadExecutions puts in progress executions whose lease has expired back in the queue.
+// Executions which have already been claimed maxClaims times are marked as failed instead, so that they
+// are not stuck forever. The in progress executions always hold a lease, except the ones created before
+// the queue existed, which have no job payload and are marked as failed too.
+// It returns the number of requeued executions and the failed executions, whose terminal side
+// effects are left to the caller.
+func RecoverOrphanedThreadExecutions(db *gorm.DB, maxClaims uint) (int64, []ThreadExecution, error) {
+	now := time.Now()
+
+	errJson, err := json.Marshal(struct {
+		Error string `json:"error"`
+	}{
+		Error: "execution was interrupted and could not be recovered",
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var failed []ThreadExecution
+	if err := db.Model(&failed).
+		Clauses(clause.Returning{}).
+		Where("status = ?", ThreadExecutionStatus_IN_PROGRESS).
+		Where("(lease_expires_at < ? AND claim_count >= ?) OR ((lease_expires_at IS NULL OR lease_expires_at < ?) AND (job_payload IS NULL OR job_payload = ?))", now, maxClaims, now, "{}").
+		Updates(map[string]interface{}{
+			"status":           ThreadExecutionStatus_FAILED,
+			"output":           json.RawMessage(errJson),
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}).Error; err != nil {
+		return 0, nil, err
+	}
+
+	requeued := db.Model(&ThreadExecution{}).
+		Where("status = ? AND lease_expires_at < ?", ThreadExecutionStatus_IN_PROGRESS, now).
+		Updates(map[string]interface{}{
+			"status":           ThreadExecutionStatus_QUEUED,
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		})
+	if requeued.Error != nil {
+		return 0, nil, requeued.Error
+	}
+
+	return requeued.RowsAffected, failed, nil
+}
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burnerlee/compextAI/internal/testdb"
+	"gorm.io/gorm"
+)
+
+const testLeaseDuration = time.Minute
+
+// openQueueDB returns a database with a thread and a template for the executions to reference
+func openQueueDB(t *testing.T) (*gorm.DB, func(threadExecution *ThreadExecution) *ThreadExecution) {
+	t.Helper()
+	db := testdb.Open(t, &User{}, &Thread{}, &ThreadExecutionParamsTemplate{}, &ThreadExecutionParamsTemplateRevision{}, &ThreadExecution{})
+
+	user := &User{Username: "user", Email: "user@example.com", Password: "hash"}
+	if err := CreateUser(db, user); err != nil {
+		t.Fatalf("error creating the user: %v", err)
+	}
+	thread := &Thread{UserID: user.ID, Title: "thread"}
+	if err := CreateThread(db, thread); err != nil {
+		t.Fatalf("error creating the thread: %v", err)
+	}
+	template, err := CreateThreadExecutionParamsTemplate(db, &ThreadExecutionParamsTemplate{UserID: user.ID, Name: "template", Model: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("error creating the template: %v", err)
+	}
+
+	create := func(threadExecution *ThreadExecution) *ThreadExecution {
+		t.Helper()
+		threadExecution.UserID = user.ID
+		threadExecution.ThreadID = thread.Identifier
+		threadExecution.ThreadExecutionParamsTemplateID = template.Identifier
+		if threadExecution.JobPayload == nil {
+			threadExecution.JobPayload = json.RawMessage(`{"messages":[]}`)
+		}
+		threadExecution, err := CreateThreadExecution(db, threadExecution)
+		if err != nil {
+			t.Fatalf("error creating the execution: %v", err)
+		}
+		return threadExecution
+	}
+	return db, create
+}
+
+func getTestExecution(t *testing.T, db *gorm.DB, executionID string) *ThreadExecution {
+	t.Helper()
+	var threadExecution ThreadExecution
+	if err := db.First(&threadExecution, "identifier = ?", executionID).Error; err != nil {
+		t.Fatalf("error getting the execution: %v", err)
+	}
+	return &threadExecution
+}
+
+func claim(t *testing.T, db *gorm.DB, leaseOwner string) *ThreadExecution {
+	t.Helper()
+	threadExecution, err := ClaimNextThreadExecution(db, leaseOwner, testLeaseDuration)
+	if err != nil {
+		t.Fatalf("error claiming an execution: %v", err)
+	}
+	return threadExecution
+}
+
+func TestClaimNextThreadExecution(t *testing.T) {
+	db, create := openQueueDB(t)
+
+	if threadExecution := claim(t, db, "worker-1"); threadExecution != nil {
+		t.Fatalf("expected nothing to claim, got %s", threadExecution.Identifier)
+	}
+
+	first := create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	second := create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	create(&ThreadExecution{Status: ThreadExecutionStatus_COMPLETED})
+
+	claimed := claim(t, db, "worker-1")
+	if claimed == nil || claimed.Identifier != first.Identifier {
+		t.Fatalf("expected the oldest queued execution to be claimed, got %+v", claimed)
+	}
+	stored := getTestExecution(t, db, first.Identifier)
+	if stored.Status != ThreadExecutionStatus_IN_PROGRESS || stored.LeaseOwner != "worker-1" || stored.ClaimCount != 1 ||
+		stored.LeaseExpiresAt == nil || time.Until(*stored.LeaseExpiresAt) <= 0 || time.Until(*stored.LeaseExpiresAt) > testLeaseDuration {
+		t.Fatalf("expected the execution to be leased to the worker, got %+v", stored)
+	}
+
+	if claimed := claim(t, db, "worker-2"); claimed == nil || claimed.Identifier != second.Identifier {
+		t.Fatalf("expected the next queued execution to be claimed, got %+v", claimed)
+	}
+	if claimed := claim(t, db, "worker-3"); claimed != nil {
+		t.Fatalf("expected the running and completed executions not to be claimed, got %s", claimed.Identifier)
+	}
+}
+
+func TestClaimNextThreadExecutionConcurrently(t *testing.T) {
+	db, create := openQueueDB(t)
+
+	const executions, workers = 20, 5
+	for i := 0; i < executions; i++ {
+		create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	}
+
+	var mu sync.Mutex
+	claims := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(leaseOwner string) {
+			defer wg.Done()
+			for {
+				threadExecution, err := ClaimNextThreadExecution(db, leaseOwner, testLeaseDuration)
+				if err != nil {
+					t.Errorf("error claiming an execution: %v", err)
+					return
+				}
+				if threadExecution == nil {
+					return
+				}
+				mu.Lock()
+				claims[threadExecution.Identifier]++
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("worker-%d", i))
+	}
+	wg.Wait()
+
+	if len(claims) != executions {
+		t.Fatalf("expected all the executions to be claimed, got %d", len(claims))
+	}
+	for executionID, count := range claims {
+		if count != 1 {
+			t.Errorf("expected %s to be claimed once, got %d", executionID, count)
+		}
+	}
+}
+
+func TestThreadExecutionLease(t *testing.T) {
+	db, create := openQueueDB(t)
+
+	threadExecution := create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	claim(t, db, "worker-1")
+	leaseExpiresAt := *getTestExecution(t, db, threadExecution.Identifier).LeaseExpiresAt
+
+	// the heartbeat of the owner renews the lease, the other workers can't
+	held, err := RenewThreadExecutionLease(db, threadExecution.Identifier, "worker-1", 2*testLeaseDuration)
+	if err != nil || !held {
+		t.Fatalf("expected the owner to renew the lease, got %t, %v", held, err)
+	}
+	if renewed := getTestExecution(t, db, threadExecution.Identifier).LeaseExpiresAt; !renewed.After(leaseExpiresAt) {
+		t.Fatalf("expected the lease to be extended, got %v", renewed)
+	}
+	if held, err := RenewThreadExecutionLease(db, threadExecution.Identifier, "worker-2", testLeaseDuration); err != nil || held {
+		t.Fatalf("expected another worker not to renew the lease, got %t, %v", held, err)
+	}
+
+	// only the owner completes the execution
+	result := &ThreadExecution{Base: Base{Identifier: threadExecution.Identifier}, Content: "response"}
+	if completed, err := CompleteThreadExecution(db, result, "worker-2"); err != nil || completed {
+		t.Fatalf("expected another worker not to complete the execution, got %t, %v", completed, err)
+	}
+	if completed, err := CompleteThreadExecution(db, result, "worker-1"); err != nil || !completed {
+		t.Fatalf("expected the owner to complete the execution, got %t, %v", completed, err)
+	}
+	if held, err := RenewThreadExecutionLease(db, threadExecution.Identifier, "worker-1", testLeaseDuration); err != nil || held {
+		t.Fatalf("expected the lease of a completed execution not to be renewed, got %t, %v", held, err)
+	}
+
+	// releasing the lease of a finished execution keeps its status
+	if err := ReleaseThreadExecutionLease(db, threadExecution.Identifier, "worker-1"); err != nil {
+		t.Fatalf("error releasing the lease: %v", err)
+	}
+	stored := getTestExecution(t, db, threadExecution.Identifier)
+	if stored.Status != ThreadExecutionStatus_COMPLETED || stored.Content != "response" || stored.LeaseOwner != "" || stored.LeaseExpiresAt != nil {
+		t.Fatalf("expected a completed execution without lease, got %+v", stored)
+	}
+}
+
+func TestReleaseThreadExecutionLease(t *testing.T) {
+	db, create := openQueueDB(t)
+
+	// an execution interrupted by a shutdown goes back in the queue
+	interrupted := create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	claim(t, db, "worker-1")
+	if err := ReleaseThreadExecutionLease(db, interrupted.Identifier, "worker-2"); err != nil {
+		t.Fatalf("error releasing the lease: %v", err)
+	}
+	if stored := getTestExecution(t, db, interrupted.Identifier); stored.Status != ThreadExecutionStatus_IN_PROGRESS || stored.LeaseOwner != "worker-1" {
+		t.Fatalf("expected another worker not to release the lease, got %+v", stored)
+	}
+	if err := ReleaseThreadExecutionLease(db, interrupted.Identifier, "worker-1"); err != nil {
+		t.Fatalf("error releasing the lease: %v", err)
+	}
+	if stored := getTestExecution(t, db, interrupted.Identifier); stored.Status != ThreadExecutionStatus_QUEUED || stored.LeaseOwner != "" || stored.LeaseExpiresAt != nil {
+		t.Fatalf("expected the execution to be queued again, got %+v", stored)
+	}
+}
+
+func TestCancelledThreadExecutionLease(t *testing.T) {
+	db, create := openQueueDB(t)
+
+	// a cancelled execution stays cancelled and its worker can't complete it
+	cancelled := create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	claim(t, db, "worker-1")
+	if _, ok, err := CancelThreadExecution(db, cancelled.Identifier); err != nil || !ok {
+		t.Fatalf("expected the execution to be cancelled, got %t, %v", ok, err)
+	}
+	if held, err := RenewThreadExecutionLease(db, cancelled.Identifier, "worker-1", testLeaseDuration); err != nil || held {
+		t.Fatalf("expected the lease of a cancelled execution not to be renewed, got %t, %v", held, err)
+	}
+	if completed, err := CompleteThreadExecution(db, &ThreadExecution{Base: Base{Identifier: cancelled.Identifier}}, "worker-1"); err != nil || completed {
+		t.Fatalf("expected a cancelled execution not to be completed, got %t, %v", completed, err)
+	}
+	if err := ReleaseThreadExecutionLease(db, cancelled.Identifier, "worker-1"); err != nil {
+		t.Fatalf("error releasing the lease: %v", err)
+	}
+	if stored := getTestExecution(t, db, cancelled.Identifier); stored.Status != ThreadExecutionStatus_CANCELLED {
+		t.Fatalf("expected the execution to stay cancelled, got %s", stored.Status)
+	}
+}
+
+func TestRecoverOrphanedThreadExecutions(t *testing.T) {
+	db, create := openQueueDB(t)
+
+	expired := time.Now().Add(-time.Minute)
+	live := time.Now().Add(time.Minute)
+	// the worker died, the execution can be claimed again
+	orphaned := create(&ThreadExecution{Status: ThreadExecutionStatus_IN_PROGRESS, LeaseOwner: "dead", LeaseExpiresAt: &expired, ClaimCount: 1})
+	// the worker died too many times
+	exhausted := create(&ThreadExecution{Status: ThreadExecutionStatus_IN_PROGRESS, LeaseOwner: "dead", LeaseExpiresAt: &expired, ClaimCount: 3})
+	running := create(&ThreadExecution{Status: ThreadExecutionStatus_IN_PROGRESS, LeaseOwner: "alive", LeaseExpiresAt: &live, ClaimCount: 3})
+	// in progress without a lease and with a job payload, e.g. completed from the response cache
+	leaseless := create(&ThreadExecution{Status: ThreadExecutionStatus_IN_PROGRESS})
+	// created before the queue, without a job payload it can't be run again
+	legacy := create(&ThreadExecution{Status: ThreadExecutionStatus_IN_PROGRESS, JobPayload: json.RawMessage(`{}`)})
+	queued := create(&ThreadExecution{Status: ThreadExecutionStatus_QUEUED})
+	completed := create(&ThreadExecution{Status: ThreadExecutionStatus_COMPLETED, LeaseExpiresAt: &expired})
+
+	requeued, failed, err := RecoverOrphanedThreadExecutions(db, 3)
+	if err != nil {
+		t.Fatalf("error recovering the executions: %v", err)
+	}
+	if requeued != 1 {
+		t.Errorf("expected a single execution to be requeued, got %d", requeued)
+	}
+	failedIDs := make(map[string]bool)
+	for _, threadExecution := range failed {
+		failedIDs[threadExecution.Identifier] = true
+	}
+	if len(failed) != 2 || !failedIDs[exhausted.Identifier] || !failedIDs[legacy.Identifier] {
+		t.Errorf("expected the exhausted and the legacy executions to fail, got %v", failedIDs)
+	}
+
+	for _, tt := range []struct {
+		name            string
+		threadExecution *ThreadExecution
+		status          string
+		leaseOwner      string
+	}{
+		{"orphaned", orphaned, ThreadExecutionStatus_QUEUED, ""},
+		{"exhausted", exhausted, ThreadExecutionStatus_FAILED, ""},
+		{"running", running, ThreadExecutionStatus_IN_PROGRESS, "alive"},
+		{"lease-less", leaseless, ThreadExecutionStatus_IN_PROGRESS, ""},
+		{"legacy", legacy, ThreadExecutionStatus_FAILED, ""},
+		{"queued", queued, ThreadExecutionStatus_QUEUED, ""},
+		{"completed", completed, ThreadExecutionStatus_COMPLETED, ""},
+	} {
+		stored := getTestExecution(t, db, tt.threadExecution.Identifier)
+		if stored.Status != tt.status || stored.LeaseOwner != tt.leaseOwner {
+			t.Errorf("%s: expected the %s status and the lease owner %q, got %s, %q", tt.name, tt.status, tt.leaseOwner, stored.Status, stored.LeaseOwner)
+		}
+	}
+	if stored := getTestExecution(t, db, exhausted.Identifier); string(stored.Output) == "{}" {
+		t.Errorf("expected the failed execution to have an error output")
+	}
+
+	// the requeued execution is claimed again, the running and the lease-less ones are not
+	if claimed := claim(t, db, "worker-1"); claimed == nil || claimed.Identifier != orphaned.Identifier {
+		t.Fatalf("expected the requeued execution to be claimed, got %+v", claimed)
+	}
+	if claimed := claim(t, db, "worker-1"); claimed == nil || claimed.Identifier != queued.Identifier {
+		t.Fatalf("expected the queued execution to be claimed, got %+v", claimed)
+	}
+	if claimed := claim(t, db, "worker-1"); claimed != nil {
+		t.Fatalf("expected nothing else to be claimed, got %s", claimed.Identifier)
+	}
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// roles of the members of projects and organizations, from the most to the least privileged
+	Role_OWNER    = "owner"
+	Role_EDITOR   = "editor"
+	Role_EXECUTOR = "executor"
+	Role_VIEWER   = "viewer"
+
+	INVITATION_EXPIRY = 7 * 24 * time.Hour
+)
+
+var (
+	Roles = []string{Role_OWNER, Role_EDITOR, Role_EXECUTOR, Role_VIEWER}
+)
+
+// HasRole reports whether the role grants the permissions of the required role.
+// Owners can manage the members, editors the configuration of the project, executors
+// can run the threads of the project and viewers can only read it.
+func HasRole(role, requiredRole string) bool {
+	roleRank := slices.Index(Roles, role)
+	requiredRank := slices.Index(Roles, requiredRole)
+	return roleRank != -1 && requiredRank != -1 && roleRank <= requiredRank
+}
+
+// HighestRole returns the most privileged of the roles, or an empty string if there is none.
+func HighestRole(roles ...string) string {
+	highest := ""
+	for _, role := range roles {
+		if role == "" {
+			continue
+		}
+		if highest == "" || HasRole(role, highest) {
+			highest = role
+		}
+	}
+	return highest
+}
+
+type ProjectMember struct {
+	Base
+	ProjectID string `json:"project_id" gorm:"index"`
+	UserID    uint   `json:"user_id" gorm:"index"`
+	Role      string `json:"role"`
+	// only loaded by GetProjectMembers
+	Username string `json:"username,omitempty" gorm:"->;-:migration"`
+}
+
+// Invitation invites a user, identified by the email of the account, to a project
+// or to an organization.
+type Invitation struct {
+	Base
+	ProjectID      string     `json:"project_id,omitempty" gorm:"index"`
+	OrganizationID string     `json:"organization_id,omitempty" gorm:"index"`
+	Email          string     `json:"email" gorm:"index"`
+	Role           string     `json:"role"`
+	InvitedBy      uint       `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	DeclinedAt     *time.Time `json:"declined_at"`
+}
+
+func (i *Invitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.DeclinedAt == nil && i.ExpiresAt.After(time.Now())
+}
+
+// GetProjectRole returns the role of the user on the project, or an empty string if
+// the user has no access. The creator of the project is always an owner, other users get
+// the highest of their project role and their role in the organization of the project.
+func GetProjectRole(db *gorm.DB, project *Project, userID uint) (string, error) {
+	if project.UserID == userID {
+		return Role_OWNER, nil
+	}
+
+	var projectRole string
+	if err := db.Model(&ProjectMember{}).Select("role").
+		Where("project_id = ? AND user_id = ?", project.Identifier, userID).
+		Limit(1).Scan(&projectRole).Error; err != nil {
+		return "", err
+	}
+
+	var organizationRole string
+	if project.OrganizationID != "" {
+		if err := db.Model(&OrganizationMember{}).Select("role").
+			Where("organization_id = ? AND user_id = ?", project.OrganizationID, userID).
+			Limit(1).Scan(&organizationRole).Error; err != nil {
+			return "", err
+		}
+	}
+
+	return HighestRole(projectRole, organizationRole), nil
+}
+
+// accessibleProjectsQuery returns the identifiers of the projects the user is a member of,
+// directly or through an organization
+func accessibleProjectsQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&Project{}).Select("identifier").Where(
+		"user_id = ? OR identifier IN (?) OR organization_id IN (?)",
+		userID,
+		db.Model(&ProjectMember{}).Select("project_id").Where("user_id = ?", userID),
+		db.Model(&OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID),
+	)
+}
+
+func CreateProjectMember(db *gorm.DB, member *ProjectMember) error {
+	memberID := uuid.New().String()
+	member.Identifier = fmt.Sprintf("%s%s", constants.PROJECT_MEMBER_ID_PREFIX, memberID)
+	return db.Create(member).Error
+}
+
+func GetProjectMember(db *gorm.DB, memberID string) (*ProjectMember, error) {
+	var member ProjectMember
+	if err := db.First(&member, "identifier = ?", memberID).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func GetProjectMemberByUserID(db *gorm.DB, projectID string, userID uint) (*ProjectMember, error) {
+	var member ProjectMember
+	if err := db.First(&member, "project_id = ? AND user_id = ?", projectID, userID).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func GetProjectMembers(db *gorm.DB, projectID string) ([]ProjectMember, error) {
+	var members []ProjectMember
+	if err := db.Model(&ProjectMember{}).
+		Select("project_members.*, users.username").
+		Joins("JOIN users ON users.id = project_members.user_id").
+		Where("project_members.project_id = ?", projectID).
+		Order("project_members.created_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func UpdateProjectMemberRole(db *gorm.DB, memberID, role string) error {
+	return db.Model(&ProjectMember{}).Where("identifier = ?", memberID).Update("role", role).Error
+}
+
+func DeleteProjectMember(db *gorm.DB, memberID string) error {
+	return db.Delete(&ProjectMember{}, "identifier = ?", memberID).Error
+}
+
+func CreateInvitation(db *gorm.DB, invitation *Invitation) error {
+	invitationID := uuid.New().String()
+	invitation.Identifier = fmt.Sprintf("%s%s", constants.INVITATION_ID_PREFIX, invitationID)
+	invitation.ExpiresAt = time.Now().Add(INVITATION_EXPIRY)
+	return db.Create(invitation).Error
+}
+
+func GetInvitation(db *gorm.DB, invitationID string) (*Invitation, error) {
+	var invitation Invitation
+	if err := db.First(&invitation, "identifier = ?", invitationID).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// GetPendingInvitationsByEmail returns the invitations sent to the email which are not answered or expired
+func GetPendingInvitationsByEmail(db *gorm.DB, email string) ([]Invitation, error) {
+	var invitations []Invitation
+	if err := db.Where("email = ? AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > ?", email, time.Now()).
+		Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func GetProjectInvitations(db *gorm.DB, projectID string) ([]Invitation, error) {
+	var invitations []Invitation
+	if err := db.Where("project_id = ?", projectID).Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func GetOrganizationInvitations(db *gorm.DB, organizationID string) ([]Invitation, error) {
+	var invitations []Invitation
+	if err := db.Where("organization_id = ?", organizationID).Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// MarkInvitationAnswered sets accepted_at or declined_at if the invitation is still pending,
+// it returns false if the invitation was already answered.
+func MarkInvitationAnswered(db *gorm.DB, invitationID string, accepted bool) (bool, error) {
+	column := "declined_at"
+	if accepted {
+		column = "accepted_at"
+	}
+	result := db.Model(&Invitation{}).
+		Where("identifier = ? AND accepted_at IS NULL AND declined_at IS NULL", invitationID).
+		Update(column, time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func DeleteInvitation(db *gorm.DB, invitationID string) error {
+	return db.Delete(&Invitation{}, "identifier = ?", invitationID).Error
+}
@@ -0,0 +1,48 @@
+package models
+
+import "testing"
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role         string
+		requiredRole string
+		hasRole      bool
+	}{
+		{Role_OWNER, Role_OWNER, true},
+		{Role_OWNER, Role_VIEWER, true},
+		{Role_EDITOR, Role_OWNER, false},
+		{Role_EDITOR, Role_EXECUTOR, true},
+		{Role_EXECUTOR, Role_EDITOR, false},
+		{Role_EXECUTOR, Role_EXECUTOR, true},
+		{Role_VIEWER, Role_EXECUTOR, false},
+		{Role_VIEWER, Role_VIEWER, true},
+		{"", Role_VIEWER, false},
+		{"admin", Role_VIEWER, false},
+		{Role_OWNER, "admin", false},
+	}
+	for _, tt := range tests {
+		if hasRole := HasRole(tt.role, tt.requiredRole); hasRole != tt.hasRole {
+			t.Errorf("%q for %q: expected %t, got %t", tt.role, tt.requiredRole, tt.hasRole, hasRole)
+		}
+	}
+}
+
+func TestHighestRole(t *testing.T) {
+	tests := []struct {
+		roles   []string
+		highest string
+	}{
+		{nil, ""},
+		{[]string{"", ""}, ""},
+		{[]string{Role_VIEWER, ""}, Role_VIEWER},
+		{[]string{"", Role_EXECUTOR}, Role_EXECUTOR},
+		{[]string{Role_VIEWER, Role_EDITOR}, Role_EDITOR},
+		{[]string{Role_EDITOR, Role_VIEWER}, Role_EDITOR},
+		{[]string{Role_EXECUTOR, Role_OWNER, Role_VIEWER}, Role_OWNER},
+	}
+	for _, tt := range tests {
+		if highest := HighestRole(tt.roles...); highest != tt.highest {
+			t.Errorf("%v: expected %q, got %q", tt.roles, tt.highest, highest)
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 type Message struct {
 	Base
 	ContentMap   json.RawMessage `json:"content_map" gorm:"not null;type:jsonb;default:'{}'"`
-	Content      string          `json:"content""`
+	Content      string          `json:"content"`
 	ToolCallID   string          `json:"tool_call_id"`
 	Role         string          `json:"role" gorm:"not null"`
 	ThreadID     string          `json:"thread_id" gorm:"not null;index"`
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Organization groups the projects of a team, its members get their role on all
+// the projects of the organization.
+type Organization struct {
+	Base
+	Name string `json:"name"`
+	// the user who created the organization
+	UserID uint `json:"user_id"`
+}
+
+type OrganizationMember struct {
+	Base
+	OrganizationID string `json:"organization_id" gorm:"index"`
+	UserID         uint   `json:"user_id" gorm:"index"`
+	Role           string `json:"role"`
+	// only loaded by GetOrganizationMembers
+	Username string `json:"username,omitempty" gorm:"->;-:migration"`
+}
+
+func CreateOrganization(db *gorm.DB, organization *Organization) error {
+	organizationID := uuid.New().String()
+	organization.Identifier = fmt.Sprintf("%s%s", constants.ORGANIZATION_ID_PREFIX, organizationID)
+	return db.Create(organization).Error
+}
+
+func GetOrganization(db *gorm.DB, organizationID string) (*Organization, error) {
+	var organization Organization
+	if err := db.First(&organization, "identifier = ?", organizationID).Error; err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// GetUserOrganizations returns the organizations the user is a member of
+func GetUserOrganizations(db *gorm.DB, userID uint) ([]Organization, error) {
+	var organizations []Organization
+	if err := db.Where("identifier IN (?)", db.Model(&OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID)).
+		Order("created_at ASC").Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+func UpdateOrganization(db *gorm.DB, organization *Organization) error {
+	var updateData = make(map[string]interface{})
+	if organization.Name != "" {
+		updateData["name"] = organization.Name
+	}
+	return db.Model(&Organization{}).Where("identifier = ?", organization.Identifier).Updates(updateData).Error
+}
+
+// DeleteOrganization deletes the organization and its memberships, the projects of the
+// organization are kept and stay accessible to their owners and project members.
+func DeleteOrganization(db *gorm.DB, organizationID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Project{}).Where("organization_id = ?", organizationID).Update("organization_id", "").Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&OrganizationMember{}, "organization_id = ?", organizationID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Invitation{}, "organization_id = ? AND accepted_at IS NULL", organizationID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Organization{}, "identifier = ?", organizationID).Error
+	})
+}
+
+func CreateOrganizationMember(db *gorm.DB, member *OrganizationMember) error {
+	memberID := uuid.New().String()
+	member.Identifier = fmt.Sprintf("%s%s", constants.ORGANIZATION_MEMBER_ID_PREFIX, memberID)
+	return db.Create(member).Error
+}
+
+func GetOrganizationMember(db *gorm.DB, memberID string) (*OrganizationMember, error) {
+	var member OrganizationMember
+	if err := db.First(&member, "identifier = ?", memberID).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func GetOrganizationMemberByUserID(db *gorm.DB, organizationID string, userID uint) (*OrganizationMember, error) {
+	var member OrganizationMember
+	if err := db.First(&member, "organization_id = ? AND user_id = ?", organizationID, userID).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func GetOrganizationMembers(db *gorm.DB, organizationID string) ([]OrganizationMember, error) {
+	var members []OrganizationMember
+	if err := db.Model(&OrganizationMember{}).
+		Select("organization_members.*, users.username").
+		Joins("JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", organizationID).
+		Order("organization_members.created_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func CountOrganizationOwners(db *gorm.DB, organizationID string) (int64, error) {
+	var count int64
+	if err := db.Model(&OrganizationMember{}).Where("organization_id = ? AND role = ?", organizationID, Role_OWNER).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func UpdateOrganizationMemberRole(db *gorm.DB, memberID, role string) error {
+	return db.Model(&OrganizationMember{}).Where("identifier = ?", memberID).Update("role", role).Error
+}
+
+func DeleteOrganizationMember(db *gorm.DB, memberID string) error {
+	return db.Delete(&OrganizationMember{}, "identifier = ?", memberID).Error
+}
@@ -13,6 +13,10 @@ type Project struct {
 	UserID      uint   `json:"user_id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// the members of the organization get their organization role on the project
+	OrganizationID string `json:"organization_id" gorm:"index"`
+	// signs the deliveries to the callback urls of the project executions
+	WebhookSecret string `json:"-"`
 }
 
 func CreateProject(db *gorm.DB, project *Project) error {
@@ -30,17 +34,22 @@ func GetProject(db *gorm.DB, projectID string) (*Project, error) {
 	return &project, nil
 }
 
+// GetProjectByName returns the project with the name among the projects the user has access to,
+// the projects created by the user come first.
 func GetProjectByName(db *gorm.DB, name string, userID uint) (*Project, error) {
 	var project Project
-	if err := db.First(&project, "name = ? AND user_id = ?", name, userID).Error; err != nil {
+	if err := db.Where("name = ? AND identifier IN (?)", name, accessibleProjectsQuery(db, userID)).
+		Order(gorm.Expr("user_id = ? DESC", userID)).Order("created_at ASC").
+		First(&project).Error; err != nil {
 		return nil, err
 	}
 	return &project, nil
 }
 
+// GetAllProjects returns the projects the user has access to
 func GetAllProjects(db *gorm.DB, userID uint) ([]Project, error) {
 	var projects []Project
-	if err := db.Where("user_id = ?", userID).Find(&projects).Error; err != nil {
+	if err := db.Where("identifier IN (?)", accessibleProjectsQuery(db, userID)).Find(&projects).Error; err != nil {
 		return nil, err
 	}
 	return projects, nil
@@ -58,5 +67,35 @@ func UpdateProject(db *gorm.DB, project *Project) error {
 	if project.Description != "" {
 		updateData["description"] = project.Description
 	}
+	if project.OrganizationID != "" {
+		updateData["organization_id"] = project.OrganizationID
+	}
 	return db.Model(&Project{}).Where("identifier = ?", project.Identifier).Updates(updateData).Error
 }
+
+// SetProjectWebhookSecret sets the webhook secret of a project if it doesn't have one yet
+// and returns the secret of the project
+func SetProjectWebhookSecret(db *gorm.DB, projectID, secret string) (string, error) {
+	if err := db.Model(&Project{}).
+		Where("identifier = ? AND (webhook_secret IS NULL OR webhook_secret = '')", projectID).
+		Update("webhook_secret", secret).Error; err != nil {
+		return "", err
+	}
+	project, err := GetProject(db, projectID)
+	if err != nil {
+		return "", err
+	}
+	return project.WebhookSecret, nil
+}
+
+func GetProjectsWithWebhookSecret(db *gorm.DB) ([]Project, error) {
+	var projects []Project
+	if err := db.Where("webhook_secret IS NOT NULL AND webhook_secret <> ''").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func UpdateProjectWebhookSecret(db *gorm.DB, projectID, secret string) error {
+	return db.Model(&Project{}).Where("identifier = ?", projectID).Update("webhook_secret", secret).Error
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PromotionStatus_PENDING  = "pending"
+	PromotionStatus_APPLIED  = "applied"
+	PromotionStatus_REJECTED = "rejected"
+)
+
+// Promotion copies the template binding of named execution params from one environment to another.
+// Promotions to an environment with a PromotionRule wait for the required approvals before being applied.
+type Promotion struct {
+	Base
+	ProjectID       string `json:"project_id" gorm:"index"`
+	Name            string `json:"name"`
+	FromEnvironment string `json:"from_environment"`
+	ToEnvironment   string `json:"to_environment"`
+	// binding of the source execution params when the promotion was requested, this is what gets applied
+	TemplateID          string           `json:"template_id"`
+	TemplateRevisionID  string           `json:"template_revision_id"`
+	FallbackTemplateIDs StringList       `json:"fallback_template_ids" gorm:"type:jsonb"`
+	Variants            TemplateVariants `json:"variants" gorm:"type:jsonb"`
+	// binding of the target execution params before the promotion was applied, empty if they did not exist
+	PreviousTemplateID          string           `json:"previous_template_id"`
+	PreviousTemplateRevisionID  string           `json:"previous_template_revision_id"`
+	PreviousFallbackTemplateIDs StringList       `json:"previous_fallback_template_ids" gorm:"type:jsonb"`
+	PreviousVariants            TemplateVariants `json:"previous_variants" gorm:"type:jsonb"`
+
+	Status            string `json:"status" gorm:"index"`
+	RequiredApprovals int    `json:"required_approvals"`
+	RequestedBy       uint   `json:"requested_by"`
+	Comment           string `json:"comment"`
+	// set when the promotion is rejected
+	RejectedBy *uint      `json:"rejected_by"`
+	RejectedAt *time.Time `json:"rejected_at"`
+	// set when the binding has been copied to the target environment
+	AppliedAt *time.Time `json:"applied_at"`
+
+	Approvals []PromotionApproval `json:"approvals" gorm:"foreignKey:PromotionID;references:Identifier"`
+}
+
+type PromotionApproval struct {
+	ID          uint      `json:"-" gorm:"primary_key"`
+	PromotionID string    `json:"promotion_id" gorm:"uniqueIndex:idx_promotion_approval"`
+	UserID      uint      `json:"user_id" gorm:"uniqueIndex:idx_promotion_approval"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PromotionRule requires approvals for the promotions to an environment of a project
+type PromotionRule struct {
+	Base
+	ProjectID   string `json:"project_id" gorm:"uniqueIndex:idx_promotion_rule"`
+	Environment string `json:"environment" gorm:"uniqueIndex:idx_promotion_rule"`
+	// approvals from project editors other than the requester, 0 applies the promotions right away
+	RequiredApprovals int `json:"required_approvals"`
+}
+
+func CreatePromotion(db *gorm.DB, promotion *Promotion) error {
+	promotionID := uuid.New().String()
+	promotion.Identifier = fmt.Sprintf("%s%s", constants.PROMOTION_ID_PREFIX, promotionID)
+	return db.Create(promotion).Error
+}
+
+func GetPromotion(db *gorm.DB, promotionID string) (*Promotion, error) {
+	var promotion Promotion
+	if err := db.Preload("Approvals").First(&promotion, "identifier = ?", promotionID).Error; err != nil {
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+// LockPromotion loads the promotion for update, it must be called in a transaction
+func LockPromotion(tx *gorm.DB, promotionID string) (*Promotion, error) {
+	var promotion Promotion
+	if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		First(&promotion, "identifier = ?", promotionID).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("promotion_id = ?", promotionID).Find(&promotion.Approvals).Error; err != nil {
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+func GetProjectPromotions(db *gorm.DB, projectID string, searchParamsMap map[string]string, page, limit int) ([]Promotion, int64, error) {
+	offset := (page - 1) * limit
+	var total int64
+
+	query := db.Model(&Promotion{}).Where("project_id = ?", projectID)
+
+	allowedFilters := []string{"status", "name", "from_environment", "to_environment", "requested_by"}
+	for _, key := range allowedFilters {
+		if value, ok := searchParamsMap[key]; ok {
+			query = query.Where(fmt.Sprintf("%s = ?", key), value)
+		}
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var promotions []Promotion
+	if err := query.Preload("Approvals").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&promotions).Error; err != nil {
+		return nil, 0, err
+	}
+	return promotions, total, nil
+}
+
+func UpdatePromotion(db *gorm.DB, promotionID string, updateData map[string]interface{}) error {
+	return db.Model(&Promotion{}).Where("identifier = ?", promotionID).Updates(updateData).Error
+}
+
+func CreatePromotionApproval(db *gorm.DB, approval *PromotionApproval) error {
+	return db.Create(approval).Error
+}
+
+// GetPromotionRule returns the rule of the environment, nil if the environment has none
+func GetPromotionRule(db *gorm.DB, projectID, environment string) (*PromotionRule, error) {
+	var rule PromotionRule
+	if err := db.First(&rule, "project_id = ? AND environment = ?", projectID, environment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func GetProjectPromotionRules(db *gorm.DB, projectID string) ([]PromotionRule, error) {
+	var rules []PromotionRule
+	if err := db.Where("project_id = ?", projectID).Order("environment ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SetPromotionRule creates or updates the rule of the environment
+func SetPromotionRule(db *gorm.DB, projectID, environment string, requiredApprovals int) (*PromotionRule, error) {
+	rule, err := GetPromotionRule(db, projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		ruleID := uuid.New().String()
+		rule = &PromotionRule{
+			Base: Base{
+				Identifier: fmt.Sprintf("%s%s", constants.PROMOTION_RULE_ID_PREFIX, ruleID),
+			},
+			ProjectID:         projectID,
+			Environment:       environment,
+			RequiredApprovals: requiredApprovals,
+		}
+		if err := db.Create(rule).Error; err != nil {
+			return nil, err
+		}
+		return rule, nil
+	}
+
+	if err := db.Model(&PromotionRule{}).Where("id = ?", rule.ID).Update("required_approvals", requiredApprovals).Error; err != nil {
+		return nil, err
+	}
+	rule.RequiredApprovals = requiredApprovals
+	return rule, nil
+}
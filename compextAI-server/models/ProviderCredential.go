@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	CredentialProvider_OPENAI    = "openai"
+	CredentialProvider_ANTHROPIC = "anthropic"
+	CredentialProvider_AZURE     = "azure"
+	// the key of google credentials is the service account json
+	CredentialProvider_GOOGLE = "google"
+)
+
+// ProviderCredential is a provider key of a project. Credentials without an environment
+// apply to all the executions of the project, the ones with an environment only to the
+// executions of that environment. The key is encrypted, see internal/secrets.
+type ProviderCredential struct {
+	Base
+	UserID      uint   `json:"user_id"`
+	ProjectID   string `json:"project_id" gorm:"index"`
+	Environment string `json:"environment"`
+	Provider    string `json:"provider"`
+	Key         string `json:"-"`
+	// only used by azure credentials
+	Endpoint string `json:"endpoint"`
+}
+
+func CreateProviderCredential(db *gorm.DB, credential *ProviderCredential) error {
+	credentialID := uuid.New().String()
+	credential.Identifier = fmt.Sprintf("%s%s", constants.PROVIDER_CREDENTIAL_ID_PREFIX, credentialID)
+	return db.Create(credential).Error
+}
+
+func GetProviderCredential(db *gorm.DB, credentialID string) (*ProviderCredential, error) {
+	var credential ProviderCredential
+	if err := db.First(&credential, "identifier = ?", credentialID).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func GetProviderCredentials(db *gorm.DB, projectID string) ([]ProviderCredential, error) {
+	var credentials []ProviderCredential
+	if err := db.Where("project_id = ?", projectID).Order("created_at ASC").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// GetApplicableProviderCredentials returns the project wide credentials and the credentials of the environment
+func GetApplicableProviderCredentials(db *gorm.DB, projectID, environment string) ([]ProviderCredential, error) {
+	var credentials []ProviderCredential
+	if err := db.Where("project_id = ? AND (environment = '' OR environment = ?)", projectID, environment).Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func UpdateProviderCredential(db *gorm.DB, credential *ProviderCredential) error {
+	var updateData = make(map[string]interface{})
+	if credential.Key != "" {
+		updateData["key"] = credential.Key
+	}
+	if credential.Endpoint != "" {
+		updateData["endpoint"] = credential.Endpoint
+	}
+	return db.Model(&ProviderCredential{}).Where("identifier = ?", credential.Identifier).Updates(updateData).Error
+}
+
+func DeleteProviderCredential(db *gorm.DB, credentialID string) error {
+	return db.Delete(&ProviderCredential{}, "identifier = ?", credentialID).Error
+}
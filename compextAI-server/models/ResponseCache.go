@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ResponseCacheEntry is a provider response cached for the executions of a project
+// made with the same request, see internal/cache
+type ResponseCacheEntry struct {
+	Base
+	ProjectID string `json:"project_id" gorm:"uniqueIndex:idx_response_cache_key"`
+	// hash of the request the response was returned for
+	Key   string `json:"key" gorm:"uniqueIndex:idx_response_cache_key"`
+	Model string `json:"model"`
+	// response of the provider, as stored in the output of the execution
+	Response json.RawMessage `json:"response" gorm:"type:jsonb"`
+	// execution which produced the response
+	ThreadExecutionID string    `json:"thread_execution_id"`
+	ExpiresAt         time.Time `json:"expires_at" gorm:"index"`
+}
+
+// GetResponseCacheEntry returns the entry of the project for the key, expired entries are not returned
+func GetResponseCacheEntry(db *gorm.DB, projectID, key string) (*ResponseCacheEntry, error) {
+	var entry ResponseCacheEntry
+	if err := db.Where("project_id = ? AND key = ? AND expires_at > ?", projectID, key, time.Now()).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// PutResponseCacheEntry creates the entry or replaces the entry of the project for the same key
+func PutResponseCacheEntry(db *gorm.DB, entry *ResponseCacheEntry) error {
+	entry.Identifier = fmt.Sprintf("%s%s", constants.RESPONSE_CACHE_ENTRY_ID_PREFIX, uuid.New().String())
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"model", "response", "thread_execution_id", "expires_at", "updated_at"}),
+	}).Create(entry).Error
+}
+
+// DeleteProjectResponseCacheEntries removes the cached responses of the project and returns how many were removed
+func DeleteProjectResponseCacheEntries(db *gorm.DB, projectID string) (int64, error) {
+	result := db.Unscoped().Where("project_id = ?", projectID).Delete(&ResponseCacheEntry{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteExpiredResponseCacheEntries removes the entries which expired before now
+func DeleteExpiredResponseCacheEntries(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Unscoped().Where("expires_at <= ?", now).Delete(&ResponseCacheEntry{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,234 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ThreadExecutionParamsTemplateRevision is an immutable snapshot of the settings of a template.
+// A revision is created with the template and on every change of the template, revisions are
+// numbered from 1 for each template.
+type ThreadExecutionParamsTemplateRevision struct {
+	Base
+	TemplateID string `json:"template_id" gorm:"uniqueIndex:idx_template_revision"`
+	Revision   int    `json:"revision" gorm:"uniqueIndex:idx_template_revision"`
+	// the user who made the change
+	UserID uint `json:"user_id"`
+	// set when the revision was created by rolling the template back to an earlier revision
+	RolledBackFrom int `json:"rolled_back_from,omitempty"`
+
+	Name                string          `json:"name"`
+	Model               string          `json:"model"`
+	Temperature         float64         `json:"temperature"`
+	Timeout             int             `json:"timeout"`
+	MaxTokens           int             `json:"max_tokens"`
+	MaxCompletionTokens int             `json:"max_completion_tokens"`
+	TopP                float64         `json:"top_p"`
+	MaxOutputTokens     int             `json:"max_output_tokens"`
+	ResponseFormat      json.RawMessage `json:"response_format" gorm:"type:jsonb;default:'{}'"`
+	SystemPrompt        string          `json:"system_prompt"`
+	UseLiteLLM          bool            `json:"use_litellm"`
+	RetryPolicy         RetryPolicy     `json:"retry_policy" gorm:"embedded;embeddedPrefix:retry_"`
+	// re-prompts for responses not matching the response format
+	ResponseFormatRetries int `json:"response_format_retries" gorm:"default:0"`
+}
+
+func newTemplateRevision(template *ThreadExecutionParamsTemplate, revision int, userID uint) *ThreadExecutionParamsTemplateRevision {
+	revisionID := uuid.New().String()
+	return &ThreadExecutionParamsTemplateRevision{
+		Base: Base{
+			Identifier: fmt.Sprintf("%s%s", constants.THREAD_EXECUTION_PARAMS_TEMPLATE_REVISION_ID_PREFIX, revisionID),
+		},
+		TemplateID:            template.Identifier,
+		Revision:              revision,
+		UserID:                userID,
+		Name:                  template.Name,
+		Model:                 template.Model,
+		Temperature:           template.Temperature,
+		Timeout:               template.Timeout,
+		MaxTokens:             template.MaxTokens,
+		MaxCompletionTokens:   template.MaxCompletionTokens,
+		TopP:                  template.TopP,
+		MaxOutputTokens:       template.MaxOutputTokens,
+		ResponseFormat:        template.ResponseFormat,
+		SystemPrompt:          template.SystemPrompt,
+		UseLiteLLM:            template.UseLiteLLM,
+		RetryPolicy:           template.RetryPolicy,
+		ResponseFormatRetries: template.ResponseFormatRetries,
+	}
+}
+
+// Template returns the template as it was at this revision
+func (r *ThreadExecutionParamsTemplateRevision) Template(template *ThreadExecutionParamsTemplate) *ThreadExecutionParamsTemplate {
+	revisionTemplate := *template
+	revisionTemplate.Revision = r.Revision
+	revisionTemplate.Name = r.Name
+	revisionTemplate.Model = r.Model
+	revisionTemplate.Temperature = r.Temperature
+	revisionTemplate.Timeout = r.Timeout
+	revisionTemplate.MaxTokens = r.MaxTokens
+	revisionTemplate.MaxCompletionTokens = r.MaxCompletionTokens
+	revisionTemplate.TopP = r.TopP
+	revisionTemplate.MaxOutputTokens = r.MaxOutputTokens
+	revisionTemplate.ResponseFormat = r.ResponseFormat
+	revisionTemplate.SystemPrompt = r.SystemPrompt
+	revisionTemplate.UseLiteLLM = r.UseLiteLLM
+	revisionTemplate.RetryPolicy = r.RetryPolicy
+	revisionTemplate.ResponseFormatRetries = r.ResponseFormatRetries
+	return &revisionTemplate
+}
+
+// settings returns the columns of the template set by the revision, zero values included
+func (r *ThreadExecutionParamsTemplateRevision) settings() map[string]interface{} {
+	return map[string]interface{}{
+		"name":                         r.Name,
+		"model":                        r.Model,
+		"temperature":                  r.Temperature,
+		"timeout":                      r.Timeout,
+		"max_tokens":                   r.MaxTokens,
+		"max_completion_tokens":        r.MaxCompletionTokens,
+		"top_p":                        r.TopP,
+		"max_output_tokens":            r.MaxOutputTokens,
+		"response_format":              r.ResponseFormat,
+		"system_prompt":                r.SystemPrompt,
+		"use_lite_llm":                 r.UseLiteLLM,
+		"retry_max_attempts":           r.RetryPolicy.MaxAttempts,
+		"retry_initial_backoff":        r.RetryPolicy.InitialBackoff,
+		"retry_max_backoff":            r.RetryPolicy.MaxBackoff,
+		"retry_backoff_multiplier":     r.RetryPolicy.BackoffMultiplier,
+		"retry_jitter":                 r.RetryPolicy.Jitter,
+		"retry_retryable_status_codes": r.RetryPolicy.RetryableStatusCodes,
+		"response_format_retries":      r.ResponseFormatRetries,
+		"revision":                     r.Revision,
+	}
+}
+
+// createTemplateRevision snapshots the template as it is in the transaction and makes it the current revision
+func createTemplateRevision(tx *gorm.DB, templateID string, userID uint, rolledBackFrom int) (*ThreadExecutionParamsTemplateRevision, error) {
+	var template ThreadExecutionParamsTemplate
+	if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("identifier = ?", templateID).First(&template).Error; err != nil {
+		return nil, err
+	}
+
+	revision := newTemplateRevision(&template, template.Revision+1, userID)
+	revision.RolledBackFrom = rolledBackFrom
+	if err := tx.Create(revision).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&ThreadExecutionParamsTemplate{}).Where("id = ?", template.ID).Update("revision", revision.Revision).Error; err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// templateChangedSinceRevision reports whether the settings of the template differ from its current revision
+func templateChangedSinceRevision(tx *gorm.DB, templateID string) (bool, error) {
+	var template ThreadExecutionParamsTemplate
+	if err := tx.Where("identifier = ?", templateID).First(&template).Error; err != nil {
+		return false, err
+	}
+	current, err := GetTemplateRevision(tx, templateID, template.Revision)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	currentSettings, err := json.Marshal(current.settings())
+	if err != nil {
+		return false, err
+	}
+	templateSettings, err := json.Marshal(newTemplateRevision(&template, template.Revision, 0).settings())
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(currentSettings, templateSettings), nil
+}
+
+func GetTemplateRevisions(db *gorm.DB, templateID string) ([]ThreadExecutionParamsTemplateRevision, error) {
+	var revisions []ThreadExecutionParamsTemplateRevision
+	if err := db.Where("template_id = ?", templateID).Order("revision DESC").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func GetTemplateRevision(db *gorm.DB, templateID string, revision int) (*ThreadExecutionParamsTemplateRevision, error) {
+	var templateRevision ThreadExecutionParamsTemplateRevision
+	if err := db.Where("template_id = ? AND revision = ?", templateID, revision).First(&templateRevision).Error; err != nil {
+		return nil, err
+	}
+	return &templateRevision, nil
+}
+
+func GetTemplateRevisionByID(db *gorm.DB, revisionID string) (*ThreadExecutionParamsTemplateRevision, error) {
+	var templateRevision ThreadExecutionParamsTemplateRevision
+	if err := db.Where("identifier = ?", revisionID).First(&templateRevision).Error; err != nil {
+		return nil, err
+	}
+	return &templateRevision, nil
+}
+
+// GetCurrentTemplateRevision returns the revision the template is at
+func GetCurrentTemplateRevision(db *gorm.DB, templateID string) (*ThreadExecutionParamsTemplateRevision, error) {
+	var templateRevision ThreadExecutionParamsTemplateRevision
+	if err := db.Where("template_id = ? AND revision = (?)", templateID,
+		db.Model(&ThreadExecutionParamsTemplate{}).Select("revision").Where("identifier = ?", templateID)).
+		First(&templateRevision).Error; err != nil {
+		return nil, err
+	}
+	return &templateRevision, nil
+}
+
+// RollbackThreadExecutionParamsTemplate restores the settings of an earlier revision of the template.
+// The history is kept, the rollback creates a new revision with the settings of the earlier one.
+func RollbackThreadExecutionParamsTemplate(db *gorm.DB, templateID string, revision int, userID uint) (*ThreadExecutionParamsTemplateRevision, error) {
+	var rolledBack *ThreadExecutionParamsTemplateRevision
+	err := db.Transaction(func(tx *gorm.DB) error {
+		target, err := GetTemplateRevision(tx, templateID, revision)
+		if err != nil {
+			return err
+		}
+		settings := target.settings()
+		delete(settings, "revision")
+		if err := tx.Model(&ThreadExecutionParamsTemplate{}).Where("identifier = ?", templateID).Updates(settings).Error; err != nil {
+			return err
+		}
+		rolledBack, err = createTemplateRevision(tx, templateID, userID, revision)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rolledBack, nil
+}
+
+// CreateMissingTemplateRevisions creates the first revision of the templates created before the revisions existed
+func CreateMissingTemplateRevisions(db *gorm.DB) error {
+	var templateIDs []string
+	if err := db.Model(&ThreadExecutionParamsTemplate{}).Where("revision = 0").Pluck("identifier", &templateIDs).Error; err != nil {
+		return err
+	}
+	for _, templateID := range templateIDs {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var template ThreadExecutionParamsTemplate
+			if err := tx.Where("identifier = ?", templateID).First(&template).Error; err != nil {
+				return err
+			}
+			_, err := createTemplateRevision(tx, templateID, template.UserID, 0)
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to create the first revision of template %s: %w", templateID, err)
+		}
+	}
+	return nil
+}
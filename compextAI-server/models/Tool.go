@@ -1,9 +1,137 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ProjectToolType_HTTP    = "http"
+	ProjectToolType_BUILTIN = "builtin"
+)
 
 type ExecutionTool struct {
 	Name        string          `json:"name"`
 	Description string          `json:"description"`
 	InputSchema json.RawMessage `json:"input_schema"`
 }
+
+// ProjectTool is a tool registered in a project which the server runs itself when the model calls it,
+// either by POSTing the call to an http endpoint or with a built-in implementation.
+type ProjectTool struct {
+	Base
+	UserID      uint            `json:"user_id"`
+	ProjectID   string          `json:"project_id" gorm:"uniqueIndex:idx_project_tool_name"`
+	Name        string          `json:"name" gorm:"uniqueIndex:idx_project_tool_name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema" gorm:"type:jsonb;default:'{}'"`
+	Type        string          `json:"type"`
+	// endpoint the calls of http tools are POSTed to
+	URL string `json:"url"`
+	// used to sign the calls of http tools, only returned when the tool is created
+	Secret string `json:"secret,omitempty"`
+	// timeout of the calls of http tools in seconds, 0 uses the default
+	Timeout int `json:"timeout"`
+	// name of the implementation of built-in tools, see internal/tools
+	Builtin string `json:"builtin"`
+}
+
+// ExecutionTool returns the schema of the tool sent to the model
+func (t *ProjectTool) ExecutionTool() *ExecutionTool {
+	return &ExecutionTool{
+		Name:        t.Name,
+		Description: t.Description,
+		InputSchema: t.InputSchema,
+	}
+}
+
+// ThreadExecutionToolStep records a step of the tool loop of an execution: the model
+// asked for tool calls and the server ran them before calling the model again
+type ThreadExecutionToolStep struct {
+	Step       int    `json:"step"`
+	TemplateID string `json:"template_id"`
+	// content of the model message which requested the calls
+	Content   string                           `json:"content"`
+	ToolCalls []*ThreadExecutionToolCallRecord `json:"tool_calls"`
+}
+
+// ThreadExecutionToolCallRecord records a single call of a tool made by the server
+type ThreadExecutionToolCallRecord struct {
+	ToolCallID string          `json:"tool_call_id"`
+	Name       string          `json:"name"`
+	Input      json.RawMessage `json:"input"`
+	// result sent back to the model, the error message if the call failed
+	Output    string    `json:"output"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// duration of the call in milliseconds
+	Duration int64 `json:"duration"`
+}
+
+func CreateProjectTool(db *gorm.DB, tool *ProjectTool) error {
+	toolID := uuid.New().String()
+	tool.Identifier = fmt.Sprintf("%s%s", constants.PROJECT_TOOL_ID_PREFIX, toolID)
+	return db.Create(tool).Error
+}
+
+func GetProjectTool(db *gorm.DB, toolID string) (*ProjectTool, error) {
+	var tool ProjectTool
+	if err := db.First(&tool, "identifier = ?", toolID).Error; err != nil {
+		return nil, err
+	}
+	return &tool, nil
+}
+
+func GetProjectTools(db *gorm.DB, projectID string) ([]ProjectTool, error) {
+	var tools []ProjectTool
+	if err := db.Where("project_id = ?", projectID).Order("name ASC").Find(&tools).Error; err != nil {
+		return nil, err
+	}
+	return tools, nil
+}
+
+// GetProjectToolsByName returns the tools of the project with the given names, in the order of the names
+func GetProjectToolsByName(db *gorm.DB, projectID string, names []string) ([]*ProjectTool, error) {
+	var tools []*ProjectTool
+	if err := db.Where("project_id = ? AND name IN ?", projectID, names).Find(&tools).Error; err != nil {
+		return nil, err
+	}
+	toolsByName := make(map[string]*ProjectTool, len(tools))
+	for _, tool := range tools {
+		toolsByName[tool.Name] = tool
+	}
+	orderedTools := make([]*ProjectTool, 0, len(names))
+	for _, name := range names {
+		tool, ok := toolsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("tool %s is not registered in the project: %w", name, gorm.ErrRecordNotFound)
+		}
+		orderedTools = append(orderedTools, tool)
+	}
+	return orderedTools, nil
+}
+
+func GetProjectToolsByID(db *gorm.DB, toolIDs []string) ([]*ProjectTool, error) {
+	var tools []*ProjectTool
+	if len(toolIDs) == 0 {
+		return tools, nil
+	}
+	if err := db.Where("identifier IN ?", toolIDs).Find(&tools).Error; err != nil {
+		return nil, err
+	}
+	return tools, nil
+}
+
+func UpdateProjectTool(db *gorm.DB, toolID string, updateData map[string]interface{}) error {
+	return db.Model(&ProjectTool{}).Where("identifier = ?", toolID).Updates(updateData).Error
+}
+
+// DeleteProjectTool deletes the tool for good, so that its name can be registered again
+func DeleteProjectTool(db *gorm.DB, toolID string) error {
+	return db.Unscoped().Delete(&ProjectTool{}, "identifier = ?", toolID).Error
+}
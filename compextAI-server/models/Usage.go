@@ -0,0 +1,76 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	UsageGroupBy_DAY         = "day"
+	UsageGroupBy_MODEL       = "model"
+	UsageGroupBy_ENVIRONMENT = "environment"
+	UsageGroupBy_METADATA    = "metadata"
+)
+
+// UsageRollup is the aggregated usage of a group of thread executions
+type UsageRollup struct {
+	Key              string  `json:"key"`
+	Executions       int64   `json:"executions"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+const usageRollupColumns = "COUNT(*) AS executions, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(cached_tokens), 0) AS cached_tokens, " +
+	"COALESCE(SUM(cost), 0) AS cost"
+
+// GetProjectUsage aggregates the usage of the project executions created in [from, to).
+// metadataKey is used when grouping by metadata, executions without the key are grouped under an empty key.
+func GetProjectUsage(db *gorm.DB, projectID, groupBy, metadataKey string, from, to *time.Time) ([]UsageRollup, error) {
+	query := db.Model(&ThreadExecution{}).Where("project_id = ?", projectID)
+	if from != nil {
+		query = query.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("created_at < ?", *to)
+	}
+
+	switch groupBy {
+	case UsageGroupBy_DAY:
+		query = query.Select("TO_CHAR(DATE_TRUNC('day', created_at), 'YYYY-MM-DD') AS key, " + usageRollupColumns)
+	case UsageGroupBy_MODEL:
+		query = query.Select("model AS key, " + usageRollupColumns)
+	case UsageGroupBy_ENVIRONMENT:
+		query = query.Select("environment AS key, " + usageRollupColumns)
+	case UsageGroupBy_METADATA:
+		query = query.Select("COALESCE(metadata ->> ?, '') AS key, "+usageRollupColumns, metadataKey)
+	default:
+		return nil, fmt.Errorf("invalid group by: %s", groupBy)
+	}
+
+	var rollups []UsageRollup
+	if err := query.Group("1").Order("1").Scan(&rollups).Error; err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// GetThreadUsage aggregates the usage of all the executions of a thread
+func GetThreadUsage(db *gorm.DB, threadID string) (*UsageRollup, error) {
+	rollup := UsageRollup{
+		Key: threadID,
+	}
+	if err := db.Model(&ThreadExecution{}).
+		Select(usageRollupColumns).
+		Where("thread_id = ?", threadID).
+		Scan(&rollup).Error; err != nil {
+		return nil, err
+	}
+	return &rollup, nil
+}
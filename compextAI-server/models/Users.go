@@ -2,29 +2,31 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// User holds the provider keys of the user encrypted, see internal/secrets.
 type User struct {
 	Base
-	Username                  string          `json:"username" gorm:"unique"`
-	Email                     string          `json:"email" gorm:"unique"`
-	Password                  string          `json:"password" gorm:"not null"`
-	APIToken                  string          `json:"api_token" gorm:"unique"`
-	OpenAIKey                 string          `json:"openai_key" gorm:"column:openai_key"`
-	AnthropicKey              string          `json:"anthropic_key" gorm:"column:anthropic_key"`
-	AzureKey                  string          `json:"azure_key" gorm:"column:azure_key"`
+	Username string `json:"username" gorm:"unique"`
+	Email    string `json:"email" gorm:"unique"`
+	Password string `json:"-" gorm:"not null"`
+	// plain text token of the users created before the api tokens, moved to the api tokens on startup
+	LegacyAPIToken            *string         `json:"-" gorm:"column:api_token;unique"`
+	OpenAIKey                 string          `json:"-" gorm:"column:openai_key"`
+	AnthropicKey              string          `json:"-" gorm:"column:anthropic_key"`
+	AzureKey                  string          `json:"-" gorm:"column:azure_key"`
 	AzureEndpoint             string          `json:"azure_endpoint" gorm:"column:azure_endpoint"`
-	GoogleServiceAccountCreds json.RawMessage `json:"google_service_account_creds" gorm:"column:google_service_account_creds; type:jsonb"`
+	GoogleServiceAccountCreds json.RawMessage `json:"-" gorm:"column:google_service_account_creds; type:jsonb"`
+	// consecutive failed logins, reset on a successful login or when the user gets locked
+	FailedLoginAttempts int        `json:"-" gorm:"not null;default:0"`
+	LockedUntil         *time.Time `json:"-"`
 }
 
-func GetUserIDByAPIToken(db *gorm.DB, token string) (uint, error) {
-	var user User
-	if err := db.Where("api_token = ?", token).First(&user).Error; err != nil {
-		return 0, err
-	}
-	return user.ID, nil
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
 }
 
 func CreateUser(db *gorm.DB, user *User) error {
@@ -39,6 +41,14 @@ func GetUserByUsername(db *gorm.DB, username string) (*User, error) {
 	return &user, nil
 }
 
+func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
+	var user User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func GetUserByID(db *gorm.DB, id uint) (*User, error) {
 	var user User
 	if err := db.Where("id = ?", id).First(&user).Error; err != nil {
@@ -47,14 +57,87 @@ func GetUserByID(db *gorm.DB, id uint) (*User, error) {
 	return &user, nil
 }
 
-func UpdateUser(db *gorm.DB, user *User) error {
+// RecordFailedLogin increments the failed login attempts of the user and locks
+// the user until lockedUntil once the attempts reach maxAttempts.
+func RecordFailedLogin(db *gorm.DB, userID uint, maxAttempts int, lockedUntil time.Time) error {
+	return db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": gorm.Expr("CASE WHEN failed_login_attempts + 1 >= ? THEN 0 ELSE failed_login_attempts + 1 END", maxAttempts),
+		"locked_until":          gorm.Expr("CASE WHEN failed_login_attempts + 1 >= ? THEN ?::timestamptz ELSE locked_until END", maxAttempts, lockedUntil),
+	}).Error
+}
+
+func ResetFailedLogins(db *gorm.DB, userID uint) error {
+	return db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
+
+func UpdateUserPassword(db *gorm.DB, userID uint, hashedPassword string) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("password", hashedPassword).Error
+}
+
+// UpdateUserCredentials sets a new password for the user and unlocks it.
+func UpdateUserCredentials(db *gorm.DB, userID uint, hashedPassword string) error {
+	return db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password":              hashedPassword,
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
+
+// GetUsersWithLegacyAPIToken returns the users whose token is still stored in plain text.
+func GetUsersWithLegacyAPIToken(db *gorm.DB) ([]*User, error) {
+	var users []*User
+	if err := db.Where("api_token IS NOT NULL AND api_token <> ''").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func ClearUserLegacyAPIToken(db *gorm.DB, userID uint) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("api_token", nil).Error
+}
+
+// GetUsersWithUnhashedPasswords returns the users whose password is not a bcrypt hash yet.
+func GetUsersWithUnhashedPasswords(db *gorm.DB) ([]*User, error) {
+	var users []*User
+	if err := db.Where("password <> '' AND password NOT LIKE '$2_$%'").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetUsersWithProviderKeys returns the users which have any provider key set.
+func GetUsersWithProviderKeys(db *gorm.DB) ([]*User, error) {
+	var users []*User
+	if err := db.Where("openai_key <> '' OR anthropic_key <> '' OR azure_key <> '' OR google_service_account_creds IS NOT NULL").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UpdateUserProviderKeys stores the provider keys of the user, empty keys are left unchanged.
+func UpdateUserProviderKeys(db *gorm.DB, user *User) error {
 	updateData := make(map[string]interface{})
+	if user.OpenAIKey != "" {
+		updateData["openai_key"] = user.OpenAIKey
+	}
 	if user.AnthropicKey != "" {
 		updateData["anthropic_key"] = user.AnthropicKey
 	}
-	if user.OpenAIKey != "" {
-		updateData["openai_key"] = user.OpenAIKey
+	if user.AzureKey != "" {
+		updateData["azure_key"] = user.AzureKey
+	}
+	if user.AzureEndpoint != "" {
+		updateData["azure_endpoint"] = user.AzureEndpoint
+	}
+	if len(user.GoogleServiceAccountCreds) > 0 {
+		updateData["google_service_account_creds"] = user.GoogleServiceAccountCreds
+	}
+	if len(updateData) == 0 {
+		return nil
 	}
 
-	return db.Model(user).Updates(updateData).Error
+	return db.Model(&User{}).Where("id = ?", user.ID).Updates(updateData).Error
 }
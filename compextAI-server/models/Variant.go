@@ -0,0 +1,91 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TemplateVariant is an arm of an A/B split of execution params, it receives
+// weight / sum of the weights of the executions
+type TemplateVariant struct {
+	Name       string `json:"name"`
+	TemplateID string `json:"template_id"`
+	Weight     int    `json:"weight"`
+}
+
+// TemplateVariants is a list of template variants stored as a json array
+type TemplateVariants []TemplateVariant
+
+func (v TemplateVariants) Value() (driver.Value, error) {
+	if v == nil {
+		return "[]", nil
+	}
+	variantsJson, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(variantsJson), nil
+}
+
+func (v *TemplateVariants) Scan(value interface{}) error {
+	switch val := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(val, v)
+	case string:
+		return json.Unmarshal([]byte(val), v)
+	default:
+		return fmt.Errorf("unsupported type for template variants: %T", value)
+	}
+}
+
+// VariantStats aggregates the executions made with an arm of execution params
+type VariantStats struct {
+	Variant          string `json:"variant"`
+	Executions       int64  `json:"executions"`
+	Completed        int64  `json:"completed"`
+	Failed           int64  `json:"failed"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	// execution times of the completed executions in seconds
+	AvgExecutionTime float64 `json:"avg_execution_time"`
+	P95ExecutionTime float64 `json:"p95_execution_time"`
+	Cost             float64 `json:"cost"`
+}
+
+const variantStatsColumns = "variant, COUNT(*) AS executions, " +
+	"COUNT(*) FILTER (WHERE status = ?) AS completed, " +
+	"COUNT(*) FILTER (WHERE status = ?) AS failed, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(AVG(execution_time) FILTER (WHERE status = ?), 0) AS avg_execution_time, " +
+	"COALESCE(PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY execution_time) FILTER (WHERE status = ?), 0) AS p95_execution_time, " +
+	"COALESCE(SUM(cost), 0) AS cost"
+
+// GetVariantStats aggregates the executions of the execution params created in [from, to) by variant,
+// executions made while the execution params had no variants are grouped under an empty variant.
+func GetVariantStats(db *gorm.DB, threadExecutionParamsID string, from, to *time.Time) ([]VariantStats, error) {
+	query := db.Model(&ThreadExecution{}).
+		Select(variantStatsColumns,
+			ThreadExecutionStatus_COMPLETED, ThreadExecutionStatus_FAILED,
+			ThreadExecutionStatus_COMPLETED, ThreadExecutionStatus_COMPLETED).
+		Where("thread_execution_params_id = ?", threadExecutionParamsID)
+	if from != nil {
+		query = query.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("created_at < ?", *to)
+	}
+
+	var stats []VariantStats
+	if err := query.Group("variant").Order("variant").Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
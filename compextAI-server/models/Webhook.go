@@ -0,0 +1,241 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burnerlee/compextAI/constants"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WebhookEvent_EXECUTION_COMPLETED = "execution.completed"
+	WebhookEvent_EXECUTION_FAILED    = "execution.failed"
+	WebhookEvent_EXECUTION_CANCELLED = "execution.cancelled"
+
+	WebhookDeliveryStatus_PENDING   = "pending"
+	WebhookDeliveryStatus_SUCCEEDED = "succeeded"
+	WebhookDeliveryStatus_FAILED    = "failed"
+)
+
+// Webhook subscribes an endpoint to the events of a project
+type Webhook struct {
+	Base
+	UserID    uint   `json:"user_id"`
+	ProjectID string `json:"project_id" gorm:"index"`
+	URL       string `json:"url"`
+	// used to sign the deliveries, only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+	// events the webhook is subscribed to, all the events if empty
+	Events StringList `json:"events" gorm:"type:jsonb"`
+	Active bool       `json:"active" gorm:"default:true"`
+}
+
+// WebhookDelivery is a signed POST of an event to a webhook or to the callback url of an execution
+type WebhookDelivery struct {
+	Base
+	ProjectID string `json:"project_id" gorm:"index"`
+	// empty for deliveries to the callback url of an execution
+	WebhookID         string          `json:"webhook_id" gorm:"index"`
+	URL               string          `json:"url"`
+	Event             string          `json:"event"`
+	ThreadExecutionID string          `json:"thread_execution_id" gorm:"index"`
+	Payload           json.RawMessage `json:"payload" gorm:"type:jsonb;default:'{}'"`
+	Status            string          `json:"status" gorm:"index"`
+	// delivery this one replays, if any
+	ReplayOf string `json:"replay_of"`
+	// every POST made for this delivery, see WebhookDeliveryAttempt
+	Attempts       json.RawMessage `json:"attempts" gorm:"type:jsonb;default:'[]'"`
+	AttemptCount   int             `json:"attempt_count" gorm:"default:0"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at" gorm:"index"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// WebhookDeliveryAttempt records a single POST of a delivery
+type WebhookDeliveryAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	// duration of the attempt in milliseconds
+	Duration int64 `json:"duration"`
+	// beginning of the response body, to debug failed deliveries
+	Response string `json:"response,omitempty"`
+}
+
+// SubscribesTo reports if the webhook should receive the event
+func (w *Webhook) SubscribesTo(event string) bool {
+	if !w.Active {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func CreateWebhook(db *gorm.DB, webhook *Webhook) error {
+	webhookID := uuid.New().String()
+	webhook.Identifier = fmt.Sprintf("%s%s", constants.WEBHOOK_ID_PREFIX, webhookID)
+	return db.Create(webhook).Error
+}
+
+func GetWebhook(db *gorm.DB, webhookID string) (*Webhook, error) {
+	var webhook Webhook
+	if err := db.First(&webhook, "identifier = ?", webhookID).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func GetProjectWebhooks(db *gorm.DB, projectID string) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := db.Where("project_id = ?", projectID).Order("created_at ASC").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func UpdateWebhook(db *gorm.DB, webhook *Webhook, active *bool) error {
+	var updateData = make(map[string]interface{})
+	if webhook.URL != "" {
+		updateData["url"] = webhook.URL
+	}
+	if webhook.Events != nil {
+		updateData["events"] = webhook.Events
+	}
+	if active != nil {
+		updateData["active"] = *active
+	}
+	return db.Model(&Webhook{}).Where("identifier = ?", webhook.Identifier).Updates(updateData).Error
+}
+
+func GetAllWebhooks(db *gorm.DB) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := db.Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func UpdateWebhookSecret(db *gorm.DB, webhookID, secret string) error {
+	return db.Model(&Webhook{}).Where("identifier = ?", webhookID).Update("secret", secret).Error
+}
+
+func DeleteWebhook(db *gorm.DB, webhookID string) error {
+	return db.Delete(&Webhook{}, "identifier = ?", webhookID).Error
+}
+
+func NewWebhookDeliveryIdentifier() string {
+	deliveryID := uuid.New().String()
+	return fmt.Sprintf("%s%s", constants.WEBHOOK_DELIVERY_ID_PREFIX, deliveryID)
+}
+
+// CreateWebhookDelivery queues a delivery, the identifier is generated unless it is already set
+func CreateWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery) error {
+	if delivery.Identifier == "" {
+		delivery.Identifier = NewWebhookDeliveryIdentifier()
+	}
+	delivery.Status = WebhookDeliveryStatus_PENDING
+	now := time.Now()
+	delivery.NextAttemptAt = &now
+	return db.Create(delivery).Error
+}
+
+func GetWebhookDelivery(db *gorm.DB, deliveryID string) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := db.First(&delivery, "identifier = ?", deliveryID).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func GetProjectWebhookDeliveries(db *gorm.DB, projectID string, searchParamsMap map[string]string, page, limit int) ([]WebhookDelivery, int64, error) {
+	offset := (page - 1) * limit
+	var total int64
+
+	query := db.Model(&WebhookDelivery{}).Where("project_id = ?", projectID)
+
+	allowedFilters := []string{"status", "event", "webhook_id", "thread_execution_id"}
+	for _, key := range allowedFilters {
+		if value, ok := searchParamsMap[key]; ok {
+			query = query.Where(fmt.Sprintf("%s = ?", key), value)
+		}
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var deliveries []WebhookDelivery
+	if err := query.Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// ClaimNextWebhookDelivery picks the oldest pending delivery which is due and pushes its next attempt
+// back by the claim duration, so that it is picked up again if the worker dies while delivering it.
+func ClaimNextWebhookDelivery(db *gorm.DB, claimDuration time.Duration) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryStatus_PENDING, time.Now()).
+			Order("next_attempt_at ASC").
+			First(&delivery).Error; err != nil {
+			return err
+		}
+
+		nextAttemptAt := time.Now().Add(claimDuration)
+		delivery.NextAttemptAt = &nextAttemptAt
+		return tx.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Update("next_attempt_at", nextAttemptAt).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// RecordWebhookDeliveryAttempt appends the attempt to the delivery log and moves the delivery
+// to its next status. nextAttemptAt is ignored unless the status is pending.
+func RecordWebhookDeliveryAttempt(db *gorm.DB, deliveryID string, attempt *WebhookDeliveryAttempt, status string, nextAttemptAt *time.Time) error {
+	attemptJson, err := json.Marshal([]*WebhookDeliveryAttempt{attempt})
+	if err != nil {
+		return err
+	}
+
+	updateData := map[string]interface{}{
+		"attempts":         gorm.Expr("COALESCE(attempts, '[]'::jsonb) || ?::jsonb", string(attemptJson)),
+		"attempt_count":    gorm.Expr("attempt_count + 1"),
+		"status":           status,
+		"last_status_code": attempt.StatusCode,
+		"last_error":       attempt.Error,
+	}
+	switch status {
+	case WebhookDeliveryStatus_PENDING:
+		updateData["next_attempt_at"] = nextAttemptAt
+	case WebhookDeliveryStatus_SUCCEEDED:
+		updateData["delivered_at"] = time.Now()
+		updateData["next_attempt_at"] = nil
+	default:
+		updateData["next_attempt_at"] = nil
+	}
+	return db.Model(&WebhookDelivery{}).Where("identifier = ?", deliveryID).Updates(updateData).Error
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/burnerlee/compextAI/constants"
+)
+
+const (
+	// length in bytes of the random parts of an api token
+	API_TOKEN_PREFIX_LENGTH = 8
+	API_TOKEN_SECRET_LENGTH = 32
+
+	// prefix of the api tokens migrated from the tokens of the users, see LegacyAPITokenPrefix
+	LEGACY_API_TOKEN_PREFIX = "legacy-"
+)
+
+// GenerateAPIToken returns a new api token along with its prefix
+func GenerateAPIToken() (string, string, error) {
+	prefix, err := GenerateRandomString(API_TOKEN_PREFIX_LENGTH)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := GenerateRandomString(API_TOKEN_SECRET_LENGTH)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%s%s_%s", constants.API_TOKEN_KEY_PREFIX, prefix, secret), prefix, nil
+}
+
+// ParseAPIToken returns the prefix of an api token, and false if the token is not an api token
+func ParseAPIToken(token string) (string, bool) {
+	if !strings.HasPrefix(token, constants.API_TOKEN_KEY_PREFIX) {
+		return "", false
+	}
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(token, constants.API_TOKEN_KEY_PREFIX), "_")
+	if !ok || prefix == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// LegacyAPITokenPrefix returns the prefix the token of a user created before the api tokens is
+// stored with. Those tokens have no prefix of their own, it is derived from their hash instead.
+func LegacyAPITokenPrefix(token string) string {
+	return LEGACY_API_TOKEN_PREFIX + HashAPIToken(token)[:16]
+}
+
+// HashAPIToken hashes an api token to be stored. The tokens are random
+// and long enough for a fast hash to be safe, which keeps authentication cheap.
+func HashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAPIToken(t *testing.T) {
+	token, prefix, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("error generating the token: %v", err)
+	}
+	if parsed, ok := ParseAPIToken(token); !ok || parsed != prefix {
+		t.Fatalf("expected the prefix %s, got %s, %t", prefix, parsed, ok)
+	}
+
+	for _, token := range []string{"", "legacy-user-token", "cpx_", "cpx_prefix", "cpx__secret", "prefix_secret"} {
+		if prefix, ok := ParseAPIToken(token); ok {
+			t.Errorf("%q: expected not to be parsed, got the prefix %s", token, prefix)
+		}
+	}
+}
+
+func TestLegacyAPITokenPrefix(t *testing.T) {
+	prefix := LegacyAPITokenPrefix("legacy-user-token")
+	if !strings.HasPrefix(prefix, LEGACY_API_TOKEN_PREFIX) || len(prefix) != len(LEGACY_API_TOKEN_PREFIX)+16 {
+		t.Fatalf("unexpected legacy prefix: %s", prefix)
+	}
+	if LegacyAPITokenPrefix("legacy-user-token") != prefix {
+		t.Fatal("expected the legacy prefix to be stable")
+	}
+	if LegacyAPITokenPrefix("another-user-token") == prefix {
+		t.Fatal("expected the legacy prefixes of different tokens to differ")
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// IsPasswordHashed reports whether the stored password is a bcrypt hash.
+// Users created before the passwords were hashed have them stored as is.
+func IsPasswordHashed(storedPassword string) bool {
+	_, err := bcrypt.Cost([]byte(storedPassword))
+	return err == nil
+}
+
+// CheckPassword reports whether the password matches the stored one, which is
+// either a bcrypt hash or a legacy plain text password.
+func CheckPassword(storedPassword, password string) bool {
+	if storedPassword == "" {
+		return false
+	}
+	if IsPasswordHashed(storedPassword) {
+		return bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(storedPassword), []byte(password)) == 1
+}
@@ -7,13 +7,14 @@ import (
 	"gorm.io/gorm"
 )
 
-func GetProjectIDFromName(db *gorm.DB, name string, userID uint) (string, error) {
+// GetProjectIDFromName returns the id of the project with the name if the user has at least the role on it.
+func GetProjectIDFromName(db *gorm.DB, name string, userID uint, role string) (string, error) {
 	project, err := models.GetProjectByName(db, name, userID)
 	if err != nil {
 		return "", err
 	}
 
-	hasAccess, err := CheckProjectAccess(db, project.Identifier, userID)
+	hasAccess, err := CheckProjectAccess(db, project.Identifier, userID, role)
 	if err != nil {
 		return "", err
 	}
@@ -23,47 +23,76 @@ func GetUserIDFromRequest(r *http.Request) (int, error) {
 	return userIDInt, nil
 }
 
-func CheckThreadAccess(db *gorm.DB, threadID string, userID uint) (bool, error) {
+// CheckProjectAccess reports whether the user has at least the role on the project,
+// through the project members or the members of the organization of the project.
+func CheckProjectAccess(db *gorm.DB, projectID string, userID uint, role string) (bool, error) {
+	project, err := models.GetProject(db, projectID)
+	if err != nil {
+		return false, err
+	}
+
+	projectRole, err := models.GetProjectRole(db, project, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return models.HasRole(projectRole, role), nil
+}
+
+// checkProjectResourceAccess checks the access to a row of a project,
+// rows created without a project are only accessible to their creator.
+func checkProjectResourceAccess(db *gorm.DB, projectID string, ownerID, userID uint, role string) (bool, error) {
+	if projectID == "" {
+		return ownerID == userID, nil
+	}
+	return CheckProjectAccess(db, projectID, userID, role)
+}
+
+func CheckThreadAccess(db *gorm.DB, threadID string, userID uint, role string) (bool, error) {
 	thread, err := models.GetThread(db, threadID)
 	if err != nil {
 		return false, err
 	}
 
-	return thread.UserID == userID, nil
+	return checkProjectResourceAccess(db, thread.ProjectID, thread.UserID, userID, role)
 }
 
-func CheckMessageAccess(db *gorm.DB, messageID string, userID uint) (bool, error) {
+func CheckMessageAccess(db *gorm.DB, messageID string, userID uint, role string) (bool, error) {
 	message, err := models.GetMessage(db, messageID)
 	if err != nil {
 		return false, err
 	}
 
-	return message.Thread.UserID == userID, nil
+	return checkProjectResourceAccess(db, message.Thread.ProjectID, message.Thread.UserID, userID, role)
 }
 
-func CheckThreadExecutionAccess(db *gorm.DB, executionID string, userID uint) (bool, error) {
+func CheckThreadExecutionAccess(db *gorm.DB, executionID string, userID uint, role string) (bool, error) {
 	threadExecution, err := models.GetThreadExecutionByID(db, executionID)
 	if err != nil {
 		return false, err
 	}
 
-	return threadExecution.UserID == userID, nil
+	return checkProjectResourceAccess(db, threadExecution.ProjectID, threadExecution.UserID, userID, role)
 }
 
-func CheckThreadExecutionParamsTemplateAccess(db *gorm.DB, templateID string, userID uint) (bool, error) {
+func CheckThreadExecutionParamsTemplateAccess(db *gorm.DB, templateID string, userID uint, role string) (bool, error) {
 	threadExecutionParamsTemplate, err := models.GetThreadExecutionParamsTemplateByID(db, templateID)
 	if err != nil {
 		return false, err
 	}
 
-	return threadExecutionParamsTemplate.UserID == userID, nil
+	return checkProjectResourceAccess(db, threadExecutionParamsTemplate.ProjectID, threadExecutionParamsTemplate.UserID, userID, role)
 }
 
-func CheckProjectAccess(db *gorm.DB, projectID string, userID uint) (bool, error) {
-	project, err := models.GetProject(db, projectID)
+// CheckOrganizationAccess reports whether the user is a member of the organization with at least the role.
+func CheckOrganizationAccess(db *gorm.DB, organizationID string, userID uint, role string) (bool, error) {
+	member, err := models.GetOrganizationMemberByUserID(db, organizationID, userID)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
 		return false, err
 	}
 
-	return project.UserID == userID, nil
+	return models.HasRole(member.Role, role), nil
 }
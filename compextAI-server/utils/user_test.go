@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/burnerlee/compextAI/internal/testdb"
+	"github.com/burnerlee/compextAI/models"
+	"gorm.io/gorm"
+)
+
+func createTestUser(t *testing.T, db *gorm.DB, username string) uint {
+	t.Helper()
+	user := &models.User{Username: username, Email: fmt.Sprintf("%s@example.com", username), Password: "hash"}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatalf("error creating the user %s: %v", username, err)
+	}
+	return user.ID
+}
+
+func TestCheckProjectAccess(t *testing.T) {
+	db := testdb.Open(t, &models.User{}, &models.Project{}, &models.ProjectMember{}, &models.OrganizationMember{}, &models.Thread{})
+
+	owner := createTestUser(t, db, "owner")
+	projectViewer := createTestUser(t, db, "project-viewer")
+	organizationExecutor := createTestUser(t, db, "organization-executor")
+	// a viewer of the project and an editor of its organization
+	organizationEditor := createTestUser(t, db, "organization-editor")
+	// an editor of the project and a viewer of its organization
+	projectEditor := createTestUser(t, db, "project-editor")
+	otherOrganizationOwner := createTestUser(t, db, "other-organization-owner")
+	stranger := createTestUser(t, db, "stranger")
+
+	project := &models.Project{UserID: owner, Name: "project", OrganizationID: "org_1"}
+	personalProject := &models.Project{UserID: owner, Name: "personal"}
+	for _, p := range []*models.Project{project, personalProject} {
+		if err := models.CreateProject(db, p); err != nil {
+			t.Fatalf("error creating the project: %v", err)
+		}
+	}
+
+	for _, member := range []*models.ProjectMember{
+		{ProjectID: project.Identifier, UserID: projectViewer, Role: models.Role_VIEWER},
+		{ProjectID: project.Identifier, UserID: organizationEditor, Role: models.Role_VIEWER},
+		{ProjectID: project.Identifier, UserID: projectEditor, Role: models.Role_EDITOR},
+	} {
+		if err := models.CreateProjectMember(db, member); err != nil {
+			t.Fatalf("error creating the project member: %v", err)
+		}
+	}
+	for _, member := range []*models.OrganizationMember{
+		{OrganizationID: "org_1", UserID: organizationExecutor, Role: models.Role_EXECUTOR},
+		{OrganizationID: "org_1", UserID: organizationEditor, Role: models.Role_EDITOR},
+		{OrganizationID: "org_1", UserID: projectEditor, Role: models.Role_VIEWER},
+		{OrganizationID: "org_2", UserID: otherOrganizationOwner, Role: models.Role_OWNER},
+	} {
+		if err := models.CreateOrganizationMember(db, member); err != nil {
+			t.Fatalf("error creating the organization member: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		projectID string
+		userID    uint
+		role      string
+		hasAccess bool
+	}{
+		{"creator", project.Identifier, owner, models.Role_OWNER, true},
+		{"project viewer reading", project.Identifier, projectViewer, models.Role_VIEWER, true},
+		{"project viewer executing", project.Identifier, projectViewer, models.Role_EXECUTOR, false},
+		{"organization executor executing", project.Identifier, organizationExecutor, models.Role_EXECUTOR, true},
+		{"organization executor editing", project.Identifier, organizationExecutor, models.Role_EDITOR, false},
+		{"organization role above the project role", project.Identifier, organizationEditor, models.Role_EDITOR, true},
+		{"organization role above the project role, owning", project.Identifier, organizationEditor, models.Role_OWNER, false},
+		{"project role above the organization role", project.Identifier, projectEditor, models.Role_EDITOR, true},
+		{"owner of another organization", project.Identifier, otherOrganizationOwner, models.Role_VIEWER, false},
+		{"stranger", project.Identifier, stranger, models.Role_VIEWER, false},
+		{"creator of a project without organization", personalProject.Identifier, owner, models.Role_OWNER, true},
+		{"organization member on a project without organization", personalProject.Identifier, organizationExecutor, models.Role_VIEWER, false},
+		{"project member on another project", personalProject.Identifier, projectEditor, models.Role_VIEWER, false},
+	}
+	for _, tt := range tests {
+		hasAccess, err := CheckProjectAccess(db, tt.projectID, tt.userID, tt.role)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if hasAccess != tt.hasAccess {
+			t.Errorf("%s: expected access to be %t for the %s role, got %t", tt.name, tt.hasAccess, tt.role, hasAccess)
+		}
+	}
+
+	// the rows created without a project are only accessible to their creator
+	thread := &models.Thread{UserID: projectEditor, Title: "thread"}
+	if err := models.CreateThread(db, thread); err != nil {
+		t.Fatalf("error creating the thread: %v", err)
+	}
+	for userID, expected := range map[uint]bool{projectEditor: true, owner: false} {
+		hasAccess, err := CheckThreadAccess(db, thread.Identifier, userID, models.Role_VIEWER)
+		if err != nil || hasAccess != expected {
+			t.Errorf("user %d: expected access to the thread without project to be %t, got %t, %v", userID, expected, hasAccess, err)
+		}
+	}
+}